@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	thingscloud "github.com/arthursoares/things-cloud-sdk"
+)
+
+// ---------------------------------------------------------------------------
+// Audit log of MCP-initiated writes
+// ---------------------------------------------------------------------------
+
+const (
+	auditDefaultRetention = 90 * 24 * time.Hour
+	auditPageLinkTTL      = time.Hour
+)
+
+// AuditStore records every tool call that committed something to Things
+// Cloud, so writes made through MCP can be told apart from ones made in the
+// apps.
+type AuditStore struct {
+	db        *sqlDB
+	retention time.Duration // entries older than this are deleted; 0 keeps them forever
+}
+
+type auditEntry struct {
+	ID         int64           `json:"id"`
+	CreatedAt  string          `json:"createdAt"`
+	ClientID   string          `json:"clientId,omitempty"`
+	ClientName string          `json:"clientName,omitempty"`
+	Tool       string          `json:"tool"`
+	Arguments  json.RawMessage `json:"arguments"`
+	Payload    json.RawMessage `json:"payload,omitempty"` // one object per commit, keyed by item UUID
+	Items      []string        `json:"items"`
+	HeadIndex  int             `json:"headIndex"`
+	LatencyMS  int64           `json:"latencyMs"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// auditLogOutput is what things_audit_log returns.
+type auditLogOutput struct {
+	Entries []auditEntry `json:"entries"`
+	PageURL string       `json:"pageUrl,omitempty"`
+	Note    string       `json:"note,omitempty"`
+}
+
+type auditFilter struct {
+	From, To *time.Time
+	Tool     string
+	Item     string
+	Limit    int
+}
+
+func (as *AuditStore) Record(email string, e auditEntry) error {
+	_, err := as.db.Exec(
+		`INSERT INTO audit_log (email, client_id, client_name, tool, arguments, payload, items, head_index, latency_ms, error, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		email, e.ClientID, e.ClientName, e.Tool, string(e.Arguments), string(e.Payload),
+		","+strings.Join(e.Items, ",")+",", e.HeadIndex, e.LatencyMS, e.Error, e.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("record audit entry: %w", err)
+	}
+	if as.retention > 0 {
+		cutoff := time.Now().UTC().Add(-as.retention).Format(time.RFC3339)
+		as.db.Exec(`DELETE FROM audit_log WHERE created_at < ?`, cutoff)
+	}
+	return nil
+}
+
+// Query returns the user's newest entries matching f.
+func (as *AuditStore) Query(email string, f auditFilter, withPayload bool) ([]auditEntry, error) {
+	q := `SELECT id, client_id, client_name, tool, arguments, payload, items, head_index, latency_ms, error, created_at
+		FROM audit_log WHERE email = ?`
+	args := []any{email}
+	if f.From != nil {
+		q += ` AND created_at >= ?`
+		args = append(args, f.From.UTC().Format(time.RFC3339))
+	}
+	if f.To != nil {
+		q += ` AND created_at < ?`
+		args = append(args, f.To.UTC().Format(time.RFC3339))
+	}
+	if f.Tool != "" {
+		q += ` AND tool = ?`
+		args = append(args, f.Tool)
+	}
+	if f.Item != "" {
+		q += ` AND (items LIKE ? OR arguments LIKE ?)`
+		args = append(args, "%,"+f.Item+",%", `%"`+f.Item+`"%`)
+	}
+	q += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, f.Limit)
+
+	rows, err := as.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []auditEntry{}
+	for rows.Next() {
+		var e auditEntry
+		var arguments, payload, items string
+		if err := rows.Scan(&e.ID, &e.ClientID, &e.ClientName, &e.Tool, &arguments, &payload, &items, &e.HeadIndex, &e.LatencyMS, &e.Error, &e.CreatedAt); err != nil {
+			continue
+		}
+		e.Arguments = json.RawMessage(arguments)
+		if withPayload && payload != "" {
+			e.Payload = json.RawMessage(payload)
+		}
+		e.Items = []string{}
+		if trimmed := strings.Trim(items, ","); trimmed != "" {
+			e.Items = strings.Split(trimmed, ",")
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// ---------------------------------------------------------------------------
+// Recording
+// ---------------------------------------------------------------------------
+
+type auditRecorderKey struct{}
+
+// auditRecorder collects the commits made while a tool call runs. It rides
+// on the call's context down to writeAndSync.
+type auditRecorder struct {
+	mu        sync.Mutex
+	commits   []json.RawMessage
+	items     []string
+	headIndex int
+}
+
+// recordCommit notes a successful commit on the recorder in ctx, if any.
+func recordCommit(ctx context.Context, items []thingscloud.Identifiable, headIndex int) {
+	rec, ok := ctx.Value(auditRecorderKey{}).(*auditRecorder)
+	if !ok {
+		return
+	}
+	commit := map[string]thingscloud.Identifiable{}
+	for _, item := range items {
+		commit[item.UUID()] = item
+	}
+	raw, err := json.Marshal(commit)
+	if err != nil {
+		raw = []byte(`{}`)
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.commits = append(rec.commits, raw)
+	for _, item := range items {
+		rec.items = appendUnique(rec.items, item.UUID())
+	}
+	rec.headIndex = headIndex
+}
+
+// audited wraps a tool handler so that calls which commit anything to
+// Things Cloud are written to the audit log.
+func (um *UserManager) audited(tool string, next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if um.audit == nil {
+			return next(ctx, req)
+		}
+		rec := &auditRecorder{}
+		start := time.Now()
+		result, err := next(context.WithValue(ctx, auditRecorderKey{}, rec), req)
+		latency := time.Since(start)
+
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		if len(rec.commits) == 0 {
+			return result, err
+		}
+		email, _, credErr := extractCredentials(ctx, um)
+		if credErr != nil {
+			return result, err
+		}
+		entry := auditEntry{
+			CreatedAt: start.UTC().Format(time.RFC3339),
+			Tool:      tool,
+			Items:     rec.items,
+			HeadIndex: rec.headIndex,
+			LatencyMS: latency.Milliseconds(),
+		}
+		if info, ok := ctx.Value(userContextKey).(*UserInfo); ok && info.Token != "" && um.oauth != nil {
+			entry.ClientID, entry.ClientName = um.oauth.bearerClient(info.Token)
+		}
+		entry.Arguments, _ = json.Marshal(req.GetArguments())
+		entry.Payload, _ = json.Marshal(rec.commits)
+		if err != nil {
+			entry.Error = err.Error()
+		} else if result != nil && result.IsError {
+			entry.Error = resultErrorText(result)
+		}
+		if recErr := um.audit.Record(email, entry); recErr != nil {
+			logTools.ErrorContext(ctx, "audit: record failed", "tool", tool, "error", recErr)
+		}
+		return result, err
+	}
+}
+
+func resultErrorText(result *mcp.CallToolResult) string {
+	for _, c := range result.Content {
+		if tc, ok := c.(mcp.TextContent); ok {
+			return tc.Text
+		}
+	}
+	return "error"
+}
+
+// auditTools routes every tool that is not marked read-only through the
+// audit log.
+func (um *UserManager) auditTools(tools []server.ServerTool) []server.ServerTool {
+	for i, st := range tools {
+		if ro := st.Tool.Annotations.ReadOnlyHint; ro != nil && *ro {
+			continue
+		}
+		tools[i].Handler = um.audited(st.Tool.Name, st.Handler)
+	}
+	return tools
+}
+
+// ---------------------------------------------------------------------------
+// Signed page links
+// ---------------------------------------------------------------------------
+
+// auditPageToken signs email and an expiry for the audit log page link.
+func (o *OAuthServer) auditPageToken(email string, expires time.Time) string {
+	return o.signedToken("audit-page", email, expires)
+}
+
+func (o *OAuthServer) verifyAuditPageToken(token string) (string, bool) {
+	return o.verifySignedToken("audit-page", token)
+}
+
+// ---------------------------------------------------------------------------
+// Tool and page
+// ---------------------------------------------------------------------------
+
+// parseAuditFilter reads from/to/tool/item/limit from tool arguments or a
+// page query. A date-only to includes that whole day.
+func parseAuditFilter(get func(string) string) (auditFilter, error) {
+	f := auditFilter{Tool: strings.TrimSpace(get("tool")), Item: strings.TrimSpace(get("item")), Limit: 50}
+	if s := get("from"); s != "" {
+		if f.From = parseDate(s); f.From == nil {
+			return f, fmt.Errorf("invalid from: %s (use YYYY-MM-DD or RFC3339)", s)
+		}
+	}
+	if s := get("to"); s != "" {
+		if f.To = parseDate(s); f.To == nil {
+			return f, fmt.Errorf("invalid to: %s (use YYYY-MM-DD or RFC3339)", s)
+		}
+		if len(s) == len("2006-01-02") {
+			end := f.To.Add(24 * time.Hour)
+			f.To = &end
+		}
+	}
+	if s := get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 500 {
+			return f, fmt.Errorf("limit must be between 1 and 500")
+		}
+		f.Limit = n
+	}
+	return f, nil
+}
+
+func (um *UserManager) handleAuditLog(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	if um.audit == nil {
+		return errResultCode(codeUnavailable, "the audit log is not available on this server"), nil
+	}
+	email, _, err := extractCredentials(ctx, um)
+	if err != nil {
+		return errResultFrom(err), nil
+	}
+	get := func(k string) string {
+		if k == "limit" {
+			if n := req.GetInt("limit", 0); n != 0 {
+				return strconv.Itoa(n)
+			}
+			return ""
+		}
+		return req.GetString(k, "")
+	}
+	f, err := parseAuditFilter(get)
+	if err != nil {
+		return errResultFrom(err), nil
+	}
+	entries, err := um.audit.Query(email, f, req.GetBool("include_payload", false))
+	if err != nil {
+		return errResultFrom(err), nil
+	}
+
+	out := auditLogOutput{Entries: entries}
+	if base := getBaseURLFromContext(ctx); base != "" && um.oauth != nil {
+		q := url.Values{"token": {um.oauth.auditPageToken(email, time.Now().Add(auditPageLinkTTL))}}
+		for _, k := range []string{"from", "to", "tool", "item"} {
+			if v := req.GetString(k, ""); v != "" {
+				q.Set(k, v)
+			}
+		}
+		out.PageURL = base + "/audit?" + q.Encode()
+		out.Note = "The page link is valid for one hour."
+	}
+	return jsonResult(out), nil
+}
+
+func (um *UserManager) handleAuditPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if um.audit == nil || um.oauth == nil {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	email, ok := um.oauth.verifyAuditPageToken(q.Get("token"))
+	if !ok {
+		http.Error(w, "This audit log link is invalid or has expired. Ask for a new one with things_audit_log.", http.StatusForbidden)
+		return
+	}
+	f, err := parseAuditFilter(q.Get)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.Get("limit") == "" {
+		f.Limit = 200
+	}
+	entries, err := um.audit.Query(email, f, true)
+	if err != nil {
+		http.Error(w, "failed to load the audit log", http.StatusInternalServerError)
+		return
+	}
+
+	var rows strings.Builder
+	for _, e := range entries {
+		client := e.ClientName
+		if client == "" {
+			client = e.ClientID
+		}
+		if client == "" {
+			client = "Basic auth"
+		}
+		status := `<span class="ok">ok</span>`
+		if e.Error != "" {
+			status = `<span class="err">` + htmlEscape(e.Error) + `</span>`
+		}
+		fmt.Fprintf(&rows, `<tr><td>%s</td><td>%s</td><td><code>%s</code></td><td>%s</td><td>%d</td><td>%d ms</td><td>%s</td></tr>
+<tr class="detail"><td colspan="7"><details><summary>arguments and payload</summary><pre>%s</pre><pre>%s</pre></details></td></tr>
+`,
+			htmlEscape(e.CreatedAt), htmlEscape(client), htmlEscape(e.Tool),
+			htmlEscape(strings.Join(e.Items, ", ")), e.HeadIndex, e.LatencyMS, status,
+			htmlEscape(indentJSON(e.Arguments)), htmlEscape(indentJSON(e.Payload)))
+	}
+	if len(entries) == 0 {
+		rows.WriteString(`<tr><td colspan="7" class="empty">No writes match these filters.</td></tr>`)
+	}
+
+	page := strings.NewReplacer(
+		"{{email}}", htmlEscape(maskEmail(email)),
+		"{{token}}", htmlEscape(q.Get("token")),
+		"{{from}}", htmlEscape(q.Get("from")),
+		"{{to}}", htmlEscape(q.Get("to")),
+		"{{tool}}", htmlEscape(q.Get("tool")),
+		"{{item}}", htmlEscape(q.Get("item")),
+		"{{rows}}", rows.String(),
+	).Replace(auditPageHTML)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Write([]byte(page))
+}
+
+func indentJSON(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var v any
+	if json.Unmarshal(raw, &v) != nil {
+		return string(raw)
+	}
+	out, _ := json.MarshalIndent(v, "", "  ")
+	return string(out)
+}
+
+const auditPageHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Audit log — Things Cloud MCP</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", sans-serif; margin: 2rem; color: #1d1d1f; }
+h1 { font-size: 1.4rem; margin-bottom: 0.2rem; }
+p.sub { color: #6e6e73; margin-top: 0; }
+form { display: flex; gap: 0.6rem; flex-wrap: wrap; margin: 1.2rem 0; }
+input { padding: 0.35rem 0.5rem; border: 1px solid #d2d2d7; border-radius: 6px; }
+button { padding: 0.35rem 0.9rem; border: 0; border-radius: 6px; background: #1b6ef3; color: #fff; }
+table { border-collapse: collapse; width: 100%; font-size: 0.9rem; }
+th, td { text-align: left; padding: 0.4rem 0.6rem; border-bottom: 1px solid #e5e5ea; vertical-align: top; }
+tr.detail td { border-bottom: 1px solid #d2d2d7; }
+pre { background: #f5f5f7; padding: 0.6rem; overflow-x: auto; font-size: 0.8rem; }
+.ok { color: #1a7f37; } .err { color: #c9372c; } .empty { color: #6e6e73; text-align: center; }
+</style>
+</head>
+<body>
+<h1>Audit log</h1>
+<p class="sub">Writes made through MCP for {{email}}</p>
+<form method="get" action="/audit">
+<input type="hidden" name="token" value="{{token}}">
+<label>From <input type="date" name="from" value="{{from}}"></label>
+<label>To <input type="date" name="to" value="{{to}}"></label>
+<input type="text" name="tool" placeholder="tool, e.g. things_edit_item" value="{{tool}}">
+<input type="text" name="item" placeholder="item UUID" value="{{item}}">
+<button type="submit">Filter</button>
+</form>
+<table>
+<thead><tr><th>Time (UTC)</th><th>Client</th><th>Tool</th><th>Items</th><th>Head index</th><th>Latency</th><th>Result</th></tr></thead>
+<tbody>
+{{rows}}
+</tbody>
+</table>
+</body>
+</html>
+`
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditedWrites(t *testing.T) {
+	um, _, tools, basic := newTestToolServer(t, "audit@example.com", makeTaskItem("t-1", withTitle("Existing")))
+	um.audit = &AuditStore{db: newTestOAuth(t, um).db, retention: auditDefaultRetention}
+	basic = context.WithValue(basic, baseURLContextKey, "https://things.example.com")
+
+	result, _ := tools["things_create_task"].Handler(basic, makeReq(map[string]any{"title": "Audited"}))
+	assertNotError(t, result)
+	created := resultJSON[TaskOutput](t, result)
+	result, _ = tools["things_find_tasks"].Handler(basic, makeReq(nil))
+	assertNotError(t, result)
+	result, _ = tools["things_edit_item"].Handler(basic, makeReq(map[string]any{"uuid": "nope", "title": "x"}))
+	assertIsError(t, result)
+
+	// A Bearer call made by a registered OAuth client.
+	um.oauth.rememberCredentials("audit@example.com", "testpass")
+	um.oauth.store.SaveClient(&OAuthClient{ClientID: "client-1", ClientName: "Claude Desktop"})
+	token, err := um.oauth.createJWT(map[string]any{"sub": "audit@example.com", "client_id": "client-1", "exp": time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bearer := context.WithValue(context.Background(), userContextKey, &UserInfo{Token: token})
+	result, _ = tools["things_edit_item"].Handler(bearer, makeReq(map[string]any{"uuid": "t-1", "status": "completed"}))
+	assertNotError(t, result)
+
+	result, _ = tools["things_audit_log"].Handler(basic, makeReq(map[string]any{"include_payload": true}))
+	assertNotError(t, result)
+	logged := resultJSON[struct {
+		Entries []auditEntry `json:"entries"`
+		PageURL string       `json:"pageUrl"`
+	}](t, result)
+	if len(logged.Entries) != 2 {
+		t.Fatalf("entries: got %+v, want the two successful writes", logged.Entries)
+	}
+	edit, create := logged.Entries[0], logged.Entries[1]
+	if create.Tool != "things_create_task" || create.ClientID != "" || len(create.Items) != 1 || create.Items[0] != created.UUID ||
+		create.HeadIndex != 2 || !strings.Contains(string(create.Arguments), `"Audited"`) || !strings.Contains(string(create.Payload), created.UUID) {
+		t.Errorf("create entry: got %+v", create)
+	}
+	if edit.Tool != "things_edit_item" || edit.ClientID != "client-1" || edit.ClientName != "Claude Desktop" || edit.HeadIndex != 3 {
+		t.Errorf("edit entry: got %+v", edit)
+	}
+
+	for _, tc := range []struct {
+		args map[string]any
+		want int
+	}{
+		{map[string]any{"tool": "things_edit_item"}, 1},
+		{map[string]any{"item": "t-1"}, 1},
+		{map[string]any{"from": "2000-01-01", "to": "2000-01-02"}, 0},
+	} {
+		result, _ = tools["things_audit_log"].Handler(basic, makeReq(tc.args))
+		got := resultJSON[struct {
+			Entries []auditEntry `json:"entries"`
+		}](t, result)
+		if len(got.Entries) != tc.want {
+			t.Errorf("filter %v: got %d entries, want %d", tc.args, len(got.Entries), tc.want)
+		}
+	}
+	result, _ = tools["things_audit_log"].Handler(basic, makeReq(map[string]any{"from": "last week"}))
+	assertIsError(t, result)
+
+	// The signed page shows the same entries.
+	page, err := url.Parse(logged.PageURL)
+	if err != nil || page.Path != "/audit" {
+		t.Fatalf("pageUrl: %q", logged.PageURL)
+	}
+	rec := httptest.NewRecorder()
+	um.handleAuditPage(rec, httptest.NewRequest(http.MethodGet, page.RequestURI()+"&tool=things_create_task", nil))
+	body, _ := io.ReadAll(rec.Body)
+	if rec.Code != http.StatusOK || !strings.Contains(string(body), created.UUID) || strings.Contains(string(body), "Claude Desktop") {
+		t.Errorf("page: %d\n%s", rec.Code, body)
+	}
+}
+
+func TestAuditPageToken(t *testing.T) {
+	um := NewUserManager()
+	um.audit = &AuditStore{db: newTestOAuth(t, um).db}
+
+	token := um.oauth.auditPageToken("a@example.com", time.Now().Add(time.Minute))
+	if email, ok := um.oauth.verifyAuditPageToken(token); !ok || email != "a@example.com" {
+		t.Errorf("verify: got %q %v", email, ok)
+	}
+	for _, bad := range []string{
+		"",
+		um.oauth.auditPageToken("a@example.com", time.Now().Add(-time.Minute)),
+		strings.Replace(token, token[:4], "AAAA", 1),
+	} {
+		if _, ok := um.oauth.verifyAuditPageToken(bad); ok {
+			t.Errorf("token %q should not verify", bad)
+		}
+		rec := httptest.NewRecorder()
+		um.handleAuditPage(rec, httptest.NewRequest(http.MethodGet, "/audit?token="+url.QueryEscape(bad), nil))
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("page with bad token: got %d", rec.Code)
+		}
+	}
+	if _, _, err := um.oauth.ResolveBearer(token); err == nil {
+		t.Error("a page token must not work as an access token")
+	}
+}
+
+func TestAuditRetention(t *testing.T) {
+	um := NewUserManager()
+	as := &AuditStore{db: newTestOAuth(t, um).db, retention: 24 * time.Hour}
+
+	as.Record("a@example.com", auditEntry{Tool: "things_create_task", Arguments: []byte(`{}`), CreatedAt: time.Now().Add(-48 * time.Hour).UTC().Format(time.RFC3339)})
+	as.Record("a@example.com", auditEntry{Tool: "things_edit_item", Arguments: []byte(`{}`), CreatedAt: time.Now().UTC().Format(time.RFC3339)})
+	as.Record("b@example.com", auditEntry{Tool: "things_edit_item", Arguments: []byte(`{}`), CreatedAt: time.Now().UTC().Format(time.RFC3339)})
+
+	entries, err := as.Query("a@example.com", auditFilter{Limit: 10}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Tool != "things_edit_item" {
+		t.Errorf("got %+v", entries)
+	}
+}
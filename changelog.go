@@ -0,0 +1,437 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+
+	memory "github.com/arthursoares/things-cloud-sdk/state/memory"
+)
+
+const (
+	changeLogMaxEvents = 10000
+	changeLogMaxAge    = 30 * 24 * time.Hour
+	checkpointPrefix   = "tc1_"
+)
+
+// ---------------------------------------------------------------------------
+// Change log
+// ---------------------------------------------------------------------------
+
+// changeLog keeps the changes classified while syncing, oldest first. It is
+// rebuilt from the history on every full rebuild and trimmed to the last
+// changeLogMaxAge / changeLogMaxEvents. Everything after from (by time) and
+// from fromIndex on (by server index) is still in the log.
+type changeLog struct {
+	events    []changeEvent
+	from      time.Time
+	fromIndex int
+}
+
+func (l *changeLog) add(events []changeEvent, now time.Time) {
+	l.events = append(l.events, events...)
+	cutoff := now.Add(-changeLogMaxAge)
+	drop := 0
+	for drop < len(l.events) && (len(l.events)-drop > changeLogMaxEvents || l.events[drop].at.Before(cutoff)) {
+		ev := l.events[drop]
+		if ev.ServerIndex >= l.fromIndex {
+			l.fromIndex = ev.ServerIndex + 1
+		}
+		if ev.at.After(l.from) {
+			l.from = ev.at
+		}
+		drop++
+	}
+	if drop > 0 {
+		l.events = append([]changeEvent(nil), l.events[drop:]...)
+	}
+}
+
+// sinceIndex returns the events at or after a server index, and whether the
+// log reaches back that far.
+func (l *changeLog) sinceIndex(index int) ([]changeEvent, bool) {
+	var out []changeEvent
+	for _, ev := range l.events {
+		if ev.ServerIndex >= index {
+			out = append(out, ev)
+		}
+	}
+	return out, index >= l.fromIndex
+}
+
+// sinceTime returns the events dated at or after t, and whether the log
+// reaches back that far.
+func (l *changeLog) sinceTime(t time.Time) ([]changeEvent, bool) {
+	var out []changeEvent
+	for _, ev := range l.events {
+		if !ev.at.Before(t) {
+			out = append(out, ev)
+		}
+	}
+	return out, !t.Before(l.from)
+}
+
+// encodeCheckpoint returns an opaque token for a position in a history.
+func encodeCheckpoint(historyID string, index int) string {
+	return checkpointPrefix + base64.RawURLEncoding.EncodeToString([]byte(historyID+":"+strconv.Itoa(index)))
+}
+
+func decodeCheckpoint(token string) (historyID string, index int, ok bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(token, checkpointPrefix))
+	if err != nil || !strings.HasPrefix(token, checkpointPrefix) {
+		return "", 0, false
+	}
+	id, n, found := strings.Cut(string(raw), ":")
+	index, err = strconv.Atoi(n)
+	if !found || err != nil || index < 0 {
+		return "", 0, false
+	}
+	return id, index, true
+}
+
+// ---------------------------------------------------------------------------
+// Grouping
+// ---------------------------------------------------------------------------
+
+// changelogGroups assigns every change type to a changelog section, in the
+// order the sections are shown.
+var changelogGroups = []struct {
+	name  string
+	types []string
+}{
+	{"created", []string{"TaskCreated", "ProjectCreated", "HeadingCreated", "AreaCreated", "TagCreated", "ChecklistItemCreated"}},
+	{"completed", []string{"TaskCompleted", "TaskCanceled", "ProjectCompleted", "ChecklistItemCompleted"}},
+	{"reopened", []string{"TaskUncompleted", "TaskRestored", "ProjectRestored", "ChecklistItemUncompleted"}},
+	{"moved", []string{"TaskMovedToInbox", "TaskMovedToToday", "TaskMovedToAnytime", "TaskMovedToSomeday", "TaskAssignedToProject", "TaskAssignedToArea"}},
+	{"rescheduled", []string{"TaskMovedToUpcoming", "TaskDeadlineChanged"}},
+	{"renamed", []string{"TaskTitleChanged", "ProjectTitleChanged", "HeadingTitleChanged", "AreaRenamed", "TagRenamed", "ChecklistItemTitleChanged"}},
+	{"edited", []string{"TaskNoteChanged", "TaskTagsChanged", "TagShortcutChanged"}},
+	{"removed", []string{"TaskDeleted", "TaskTrashed", "ProjectDeleted", "ProjectTrashed", "HeadingDeleted", "AreaDeleted", "TagDeleted", "ChecklistItemDeleted"}},
+}
+
+type changelogEntry struct {
+	Type      string `json:"type"`
+	UUID      string `json:"uuid"`
+	Title     string `json:"title,omitempty"`
+	Text      string `json:"text"`
+	Timestamp string `json:"timestamp"`
+}
+
+type changelogGroup struct {
+	Name    string           `json:"name"`
+	Count   int              `json:"count"`
+	Entries []changelogEntry `json:"entries"`
+}
+
+type changelogResult struct {
+	Since      string           `json:"since"`
+	Checkpoint string           `json:"checkpoint"`
+	Complete   bool             `json:"complete"`
+	Note       string           `json:"note,omitempty"`
+	Total      int              `json:"total"`
+	Groups     []changelogGroup `json:"groups"`
+	Changelog  string           `json:"changelog"`
+}
+
+// mergeChanges collapses repeated changes of the same type to the same
+// entity into one, so a task moved twice reads as a single move from where
+// it started to where it ended up. Changes that cancel out are dropped.
+func mergeChanges(events []changeEvent) []changeEvent {
+	index := map[string]int{}
+	var out []changeEvent
+	for _, ev := range events {
+		key := ev.Type + "/" + ev.UUID
+		i, seen := index[key]
+		if !seen {
+			index[key] = len(out)
+			out = append(out, ev)
+			continue
+		}
+		first := out[i]
+		merged := ev
+		merged.Details = map[string]any{}
+		for k, v := range ev.Details {
+			merged.Details[k] = v
+		}
+		for _, k := range []string{"oldTitle", "from", "oldDeadline", "oldProject", "oldArea", "oldShortcut"} {
+			if v, ok := first.Details[k]; ok {
+				merged.Details[k] = v
+			} else {
+				delete(merged.Details, k)
+			}
+		}
+		if ev.Type == "TaskTagsChanged" {
+			added, removed := detailStrings(first.Details, "added"), detailStrings(first.Details, "removed")
+			for _, id := range detailStrings(ev.Details, "added") {
+				if j := indexOf(removed, id); j >= 0 {
+					removed = append(removed[:j], removed[j+1:]...)
+				} else {
+					added = append(added, id)
+				}
+			}
+			for _, id := range detailStrings(ev.Details, "removed") {
+				if j := indexOf(added, id); j >= 0 {
+					added = append(added[:j], added[j+1:]...)
+				} else {
+					removed = append(removed, id)
+				}
+			}
+			merged.Details["added"], merged.Details["removed"] = added, removed
+		}
+		out[i] = merged
+	}
+
+	kept := out[:0]
+	for _, ev := range out {
+		switch {
+		case strings.HasSuffix(ev.Type, "TitleChanged") || strings.HasSuffix(ev.Type, "Renamed"):
+			if ev.Details["oldTitle"] == ev.Title {
+				continue
+			}
+		case ev.Type == "TaskTagsChanged":
+			if len(detailStrings(ev.Details, "added")) == 0 && len(detailStrings(ev.Details, "removed")) == 0 {
+				continue
+			}
+		}
+		kept = append(kept, ev)
+	}
+	return kept
+}
+
+func detailStrings(details map[string]any, key string) []string {
+	v, _ := details[key].([]string)
+	return append([]string(nil), v...)
+}
+
+func indexOf(slice []string, s string) int {
+	for i, v := range slice {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// buildChangelog groups events into sections, capping each at limit entries.
+func buildChangelog(state *memory.State, events []changeEvent, limit int) ([]changelogGroup, int) {
+	merged := mergeChanges(events)
+	var groups []changelogGroup
+	total := 0
+	for _, g := range changelogGroups {
+		group := changelogGroup{Name: g.name, Entries: []changelogEntry{}}
+		for _, ev := range merged {
+			if !containsStr(g.types, ev.Type) {
+				continue
+			}
+			group.Count++
+			if len(group.Entries) < limit {
+				group.Entries = append(group.Entries, changelogEntry{
+					Type:      ev.Type,
+					UUID:      ev.UUID,
+					Title:     ev.Title,
+					Text:      describeChange(state, ev),
+					Timestamp: ev.Timestamp,
+				})
+			}
+		}
+		if group.Count > 0 {
+			groups = append(groups, group)
+			total += group.Count
+		}
+	}
+	return groups, total
+}
+
+var entityNouns = map[string]string{
+	"Task": "task", "Project": "project", "Heading": "heading",
+	"Area": "area", "Tag": "tag", "ChecklistItem": "checklist item",
+}
+
+// describeChange renders one change as a sentence, resolving tag, project
+// and area UUIDs to names where they are still known.
+func describeChange(state *memory.State, ev changeEvent) string {
+	noun := entityNouns[ev.EntityType]
+	title := fmt.Sprintf("%q", ev.Title)
+	str := func(k string) string { s, _ := ev.Details[k].(string); return s }
+	inTask := ""
+	if ref, ok := ev.Details["task"].(Ref); ok && ref.Name != "" {
+		inTask = fmt.Sprintf(" in %q", ref.Name)
+	}
+	from := ""
+	if f := str("from"); f != "" && f != "Unknown" {
+		from = " from " + f
+	}
+
+	switch ev.Type {
+	case "ChecklistItemCreated":
+		return "Added checklist item " + title + inTask
+	case "TaskCompleted", "ProjectCompleted":
+		return "Completed " + noun + " " + title
+	case "TaskCanceled":
+		return "Canceled " + noun + " " + title
+	case "ChecklistItemCompleted":
+		return "Checked off " + title + inTask
+	case "TaskUncompleted":
+		return "Reopened " + noun + " " + title
+	case "ChecklistItemUncompleted":
+		return "Unchecked " + title + inTask
+	case "TaskRestored", "ProjectRestored":
+		return "Restored " + noun + " " + title + " from the trash"
+	case "TaskMovedToInbox", "TaskMovedToToday", "TaskMovedToAnytime", "TaskMovedToSomeday":
+		return "Moved " + title + from + " to " + strings.TrimPrefix(ev.Type, "TaskMovedTo")
+	case "TaskAssignedToProject":
+		return fmt.Sprintf("Moved %s to project %q", title, taskTitle(state, str("project")))
+	case "TaskAssignedToArea":
+		return fmt.Sprintf("Moved %s to area %q", title, areaTitle(state, str("area")))
+	case "TaskMovedToUpcoming":
+		return "Scheduled " + title + " for " + str("scheduledFor")
+	case "TaskDeadlineChanged":
+		switch old, cur := str("oldDeadline"), str("deadline"); {
+		case cur == "":
+			return "Removed the deadline of " + title
+		case old == "":
+			return "Set the deadline of " + title + " to " + cur
+		default:
+			return "Moved the deadline of " + title + " from " + old + " to " + cur
+		}
+	case "TaskNoteChanged":
+		return "Edited the notes of " + title
+	case "TaskTagsChanged":
+		var parts []string
+		if added := detailStrings(ev.Details, "added"); len(added) > 0 {
+			parts = append(parts, "added "+tagNames(state, added))
+		}
+		if removed := detailStrings(ev.Details, "removed"); len(removed) > 0 {
+			parts = append(parts, "removed "+tagNames(state, removed))
+		}
+		return "Tags of " + title + ": " + strings.Join(parts, ", ")
+	case "TagShortcutChanged":
+		return "Changed the shortcut of tag " + title
+	case "TaskTrashed", "ProjectTrashed":
+		return "Moved " + noun + " " + title + " to the trash"
+	}
+	switch {
+	case strings.HasSuffix(ev.Type, "Created"):
+		return "Created " + noun + " " + title
+	case strings.HasSuffix(ev.Type, "Deleted"):
+		return "Deleted " + noun + " " + title
+	case strings.HasSuffix(ev.Type, "TitleChanged"), strings.HasSuffix(ev.Type, "Renamed"):
+		return fmt.Sprintf("Renamed %s %q to %s", noun, str("oldTitle"), title)
+	}
+	return ev.Type + " " + title
+}
+
+func taskTitle(state *memory.State, uuid string) string {
+	if task, ok := state.Tasks[uuid]; ok {
+		return task.Title
+	}
+	return uuid
+}
+
+func areaTitle(state *memory.State, uuid string) string {
+	if area, ok := state.Areas[uuid]; ok {
+		return area.Title
+	}
+	return uuid
+}
+
+func tagNames(state *memory.State, uuids []string) string {
+	names := make([]string, len(uuids))
+	for i, id := range uuids {
+		names[i] = id
+		if tag, ok := state.Tags[id]; ok {
+			names[i] = tag.Title
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func renderChangelogMarkdown(since string, groups []changelogGroup, total int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Changes since %s\n", since)
+	if total == 0 {
+		b.WriteString("\nNothing changed.\n")
+		return b.String()
+	}
+	for _, g := range groups {
+		fmt.Fprintf(&b, "\n## %s (%d)\n\n", strings.ToUpper(g.Name[:1])+g.Name[1:], g.Count)
+		for _, e := range g.Entries {
+			fmt.Fprintf(&b, "- %s\n", e.Text)
+		}
+		if more := g.Count - len(g.Entries); more > 0 {
+			fmt.Fprintf(&b, "- …and %d more\n", more)
+		}
+	}
+	return b.String()
+}
+
+// ---------------------------------------------------------------------------
+// Tool handler
+// ---------------------------------------------------------------------------
+
+func (t *ThingsMCP) handleChangesSince(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	since, err := req.RequireString("since")
+	if err != nil {
+		return errResult("since is required (YYYY-MM-DD, RFC3339 or a checkpoint from a previous call)"), nil
+	}
+	limit := req.GetInt("limit", 50)
+	if limit < 1 {
+		return errResult("limit must be at least 1"), nil
+	}
+	var sinceTime *time.Time
+	historyID, index, isCheckpoint := decodeCheckpoint(since)
+	if !isCheckpoint {
+		if sinceTime = parseDate(since); sinceTime == nil {
+			return errResult(fmt.Sprintf("invalid since: %s (use YYYY-MM-DD, RFC3339 or a checkpoint)", since)), nil
+		}
+	}
+
+	if err := t.syncAndRebuild(); err != nil {
+		return errResultFrom(fmt.Errorf("sync: %w", err)), nil
+	}
+
+	root := t.root()
+	root.mu.RLock()
+	changes := root.changes
+	currentID, currentIndex := root.history.ID, root.history.LoadedServerIndex
+	root.mu.RUnlock()
+	state := t.getState()
+
+	res := changelogResult{Checkpoint: encodeCheckpoint(currentID, currentIndex)}
+	var events []changeEvent
+	if isCheckpoint {
+		if historyID != currentID {
+			return errResult("checkpoint belongs to a different Things history (the account was reset or switched); pass a date instead"), nil
+		}
+		res.Since = since
+		events, res.Complete = changes.sinceIndex(index)
+		if !res.Complete {
+			res.Note = "Changes older than the change log were trimmed; this changelog may be missing some of them."
+		}
+	} else {
+		res.Since = sinceTime.UTC().Format(time.RFC3339)
+		events, res.Complete = changes.sinceTime(*sinceTime)
+		if !res.Complete {
+			res.Note = fmt.Sprintf("The change log only reaches back to %s; earlier changes are not included.", changes.from.UTC().Format(time.RFC3339))
+		}
+	}
+
+	if t.scope != nil {
+		events = t.scope.visibleEvents(events)
+	}
+	res.Groups, res.Total = buildChangelog(state, events, limit)
+	if res.Groups == nil {
+		res.Groups = []changelogGroup{}
+	}
+	label := res.Since
+	if isCheckpoint {
+		label = "the last checkpoint"
+	}
+	res.Changelog = renderChangelogMarkdown(label, res.Groups, res.Total)
+	return jsonResult(res), nil
+}
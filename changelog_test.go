@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	thingscloud "github.com/arthursoares/things-cloud-sdk"
+	memory "github.com/arthursoares/things-cloud-sdk/state/memory"
+)
+
+func withModificationDate(t time.Time) taskOption {
+	return func(p *thingscloud.TaskActionItemPayload) {
+		ts := thingscloud.Timestamp(t)
+		p.ModificationDate = &ts
+	}
+}
+
+func TestChangeLogTrim(t *testing.T) {
+	now := mustTime("2025-06-01")
+	var l changeLog
+	l.add([]changeEvent{
+		{Type: "TaskCreated", ServerIndex: 0, at: now.AddDate(0, -2, 0)},
+		{Type: "TaskCreated", ServerIndex: 1, at: now.AddDate(0, 0, -40)},
+		{Type: "TaskCompleted", ServerIndex: 2, at: now.AddDate(0, 0, -3)},
+		{Type: "TaskTitleChanged", ServerIndex: 3, at: now.AddDate(0, 0, -1)},
+	}, now)
+
+	if len(l.events) != 2 || l.fromIndex != 2 || !l.from.Equal(now.AddDate(0, 0, -40)) {
+		t.Fatalf("after trim: %d events, fromIndex %d, from %v", len(l.events), l.fromIndex, l.from)
+	}
+	if events, complete := l.sinceIndex(3); len(events) != 1 || !complete {
+		t.Errorf("sinceIndex(3): got %d events, complete=%v", len(events), complete)
+	}
+	if _, complete := l.sinceIndex(1); complete {
+		t.Error("sinceIndex(1) should be incomplete")
+	}
+	if events, complete := l.sinceTime(now.AddDate(0, 0, -7)); len(events) != 2 || !complete {
+		t.Errorf("sinceTime(-7d): got %d events, complete=%v", len(events), complete)
+	}
+	if _, complete := l.sinceTime(now.AddDate(0, 0, -50)); complete {
+		t.Error("sinceTime(-50d) should be incomplete")
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	token := encodeCheckpoint("history-1", 1234)
+	id, index, ok := decodeCheckpoint(token)
+	if !ok || id != "history-1" || index != 1234 {
+		t.Errorf("round trip: got %q %d %v", id, index, ok)
+	}
+	for _, bad := range []string{"2025-03-01", "tc1_!!", "tc1_" + "aGlzdG9yeQ", encodeCheckpoint("h", -1)} {
+		if _, _, ok := decodeCheckpoint(bad); ok {
+			t.Errorf("decodeCheckpoint(%q) should fail", bad)
+		}
+	}
+}
+
+func TestBuildChangelog(t *testing.T) {
+	state := memory.NewState()
+	state.Update(makeTagItem("tag-1", "Errand"), makeTaskItem("proj-1", withTitle("Garden"), withTaskType(thingscloud.TaskTypeProject)))
+
+	events := []changeEvent{
+		{Type: "TaskCreated", EntityType: "Task", UUID: "t-1", Title: "Buy seeds"},
+		{Type: "TaskMovedToToday", EntityType: "Task", UUID: "t-1", Title: "Buy seeds", Details: map[string]any{"from": "Inbox"}},
+		{Type: "TaskMovedToToday", EntityType: "Task", UUID: "t-1", Title: "Buy seeds", Details: map[string]any{"from": "Someday"}},
+		{Type: "TaskAssignedToProject", EntityType: "Task", UUID: "t-1", Title: "Buy seeds", Details: map[string]any{"project": "proj-1"}},
+		{Type: "TaskTitleChanged", EntityType: "Task", UUID: "t-2", Title: "Call Bob", Details: map[string]any{"oldTitle": "Call bob"}},
+		{Type: "TaskTitleChanged", EntityType: "Task", UUID: "t-2", Title: "Call Rob", Details: map[string]any{"oldTitle": "Call Bob"}},
+		{Type: "TaskTitleChanged", EntityType: "Task", UUID: "t-3", Title: "Same", Details: map[string]any{"oldTitle": "Other"}},
+		{Type: "TaskTitleChanged", EntityType: "Task", UUID: "t-3", Title: "Other", Details: map[string]any{"oldTitle": "Same"}},
+		{Type: "TaskTagsChanged", EntityType: "Task", UUID: "t-1", Title: "Buy seeds", Details: map[string]any{"added": []string{"tag-1"}}},
+		{Type: "TaskDeadlineChanged", EntityType: "Task", UUID: "t-1", Title: "Buy seeds", Details: map[string]any{"deadline": "2025-04-01"}},
+		{Type: "TaskCompleted", EntityType: "Task", UUID: "t-4", Title: "Water"},
+		{Type: "TaskCompleted", EntityType: "Task", UUID: "t-5", Title: "Weed"},
+		{Type: "ChecklistItemCompleted", EntityType: "ChecklistItem", UUID: "c-1", Title: "Tomatoes", Details: map[string]any{"task": Ref{UUID: "t-1", Name: "Buy seeds"}}},
+	}
+	groups, total := buildChangelog(state, events, 2)
+
+	texts := map[string][]string{}
+	counts := map[string]int{}
+	var names []string
+	for _, g := range groups {
+		names = append(names, g.Name)
+		counts[g.Name] = g.Count
+		for _, e := range g.Entries {
+			texts[g.Name] = append(texts[g.Name], e.Text)
+		}
+	}
+	if got := strings.Join(names, ","); got != "created,completed,moved,rescheduled,renamed,edited" {
+		t.Errorf("groups: got %s", got)
+	}
+	if total != 9 {
+		t.Errorf("total: got %d, want 9", total)
+	}
+	if counts["completed"] != 3 || len(texts["completed"]) != 2 {
+		t.Errorf("completed: count %d, entries %v", counts["completed"], texts["completed"])
+	}
+	for group, want := range map[string][]string{
+		"moved":       {`Moved "Buy seeds" from Inbox to Today`, `Moved "Buy seeds" to project "Garden"`},
+		"rescheduled": {`Set the deadline of "Buy seeds" to 2025-04-01`},
+		"renamed":     {`Renamed task "Call bob" to "Call Rob"`},
+		"edited":      {`Tags of "Buy seeds": added Errand`},
+	} {
+		if strings.Join(texts[group], "|") != strings.Join(want, "|") {
+			t.Errorf("%s: got %q, want %q", group, texts[group], want)
+		}
+	}
+
+	md := renderChangelogMarkdown("2025-03-01", groups, total)
+	for _, want := range []string{"# Changes since 2025-03-01", "## Completed (3)", `- Completed task "Water"`, "…and 1 more"} {
+		if !strings.Contains(md, want) {
+			t.Errorf("markdown missing %q:\n%s", want, md)
+		}
+	}
+}
+
+func TestChangelogGroupsCoverEveryType(t *testing.T) {
+	for _, typ := range changeTypes {
+		n := 0
+		for _, g := range changelogGroups {
+			if containsStr(g.types, typ) {
+				n++
+			}
+		}
+		if n != 1 {
+			t.Errorf("%s is in %d changelog groups, want 1", typ, n)
+		}
+	}
+}
+
+func TestChangesSinceTool(t *testing.T) {
+	now := time.Now().UTC()
+	days := func(n int) time.Time { return now.AddDate(0, 0, -n) }
+	fc := newFakeCloud("test@example.com",
+		makeTaskItem("t-old", withTitle("Ancient"), withModificationDate(days(45))),
+		makeTaskItem("t-1", withTitle("Draft"), withModificationDate(days(5))),
+		makeTaskItem("t-2", withTitle("Pay rent"), withModificationDate(days(4))),
+		makeUpdateItem("t-1", thingscloud.ItemKindTask, map[string]any{"tt": "Final", "md": float64(days(2).Unix())}),
+		makeUpdateItem("t-2", thingscloud.ItemKindTask, map[string]any{"ss": 3, "md": float64(days(1).Unix())}),
+	)
+	defer fc.Close()
+	tm := newTestThingsMCP(t, fc)
+
+	result, _ := tm.handleChangesSince(context.Background(), makeReq(map[string]any{"since": days(3).Format("2006-01-02")}))
+	assertNotError(t, result)
+	res := resultJSON[changelogResult](t, result)
+	if !res.Complete || res.Total != 2 || len(res.Groups) != 2 {
+		t.Fatalf("since 3 days: got %+v", res)
+	}
+	if res.Groups[0].Name != "completed" || res.Groups[1].Entries[0].Text != `Renamed task "Draft" to "Final"` {
+		t.Errorf("groups: got %+v", res.Groups)
+	}
+	if !strings.Contains(res.Changelog, `Completed task "Pay rent"`) {
+		t.Errorf("changelog: got %s", res.Changelog)
+	}
+
+	result, _ = tm.handleChangesSince(context.Background(), makeReq(map[string]any{"since": days(60).Format(time.RFC3339)}))
+	old := resultJSON[changelogResult](t, result)
+	if old.Complete || old.Note == "" || old.Total != 4 {
+		t.Errorf("since 60 days: got complete=%v total=%d note=%q", old.Complete, old.Total, old.Note)
+	}
+
+	result, _ = tm.handleChangesSince(context.Background(), makeReq(map[string]any{"since": res.Checkpoint}))
+	next := resultJSON[changelogResult](t, result)
+	if !next.Complete || next.Total != 0 || !strings.Contains(next.Changelog, "Nothing changed") {
+		t.Errorf("since checkpoint: got %+v", next)
+	}
+
+	for _, since := range []string{"", "yesterday", encodeCheckpoint("other-history", 1)} {
+		result, _ = tm.handleChangesSince(context.Background(), makeReq(map[string]any{"since": since}))
+		assertIsError(t, result)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	thingscloud "github.com/arthursoares/things-cloud-sdk"
+	memory "github.com/arthursoares/things-cloud-sdk/state/memory"
+	thingssync "github.com/arthursoares/things-cloud-sdk/sync"
+)
+
+// ---------------------------------------------------------------------------
+// Change detection on the in-memory state
+// ---------------------------------------------------------------------------
+
+// changeTypes lists every ChangeType() the SDK's sync package can report.
+var changeTypes = []string{
+	"TaskCreated", "TaskDeleted", "TaskCompleted", "TaskUncompleted", "TaskCanceled",
+	"TaskTitleChanged", "TaskNoteChanged", "TaskMovedToInbox", "TaskMovedToToday",
+	"TaskMovedToAnytime", "TaskMovedToSomeday", "TaskMovedToUpcoming",
+	"TaskDeadlineChanged", "TaskAssignedToProject", "TaskAssignedToArea",
+	"TaskTrashed", "TaskRestored", "TaskTagsChanged",
+	"ProjectCreated", "ProjectDeleted", "ProjectCompleted", "ProjectTitleChanged",
+	"ProjectTrashed", "ProjectRestored",
+	"HeadingCreated", "HeadingDeleted", "HeadingTitleChanged",
+	"AreaCreated", "AreaDeleted", "AreaRenamed",
+	"TagCreated", "TagDeleted", "TagRenamed", "TagShortcutChanged",
+	"ChecklistItemCreated", "ChecklistItemDeleted", "ChecklistItemCompleted",
+	"ChecklistItemUncompleted", "ChecklistItemTitleChanged",
+}
+
+// entitySnapshot holds a copy of the entity an item is about to touch.
+// memory.State updates entities in place, so the "before" side of a diff
+// has to be copied out ahead of State.Update.
+type entitySnapshot struct {
+	task      *thingscloud.Task
+	area      *thingscloud.Area
+	tag       *thingscloud.Tag
+	checklist *thingscloud.CheckListItem
+}
+
+func snapshotEntity(state *memory.State, uuid string) entitySnapshot {
+	var s entitySnapshot
+	if task, ok := state.Tasks[uuid]; ok {
+		c := *task
+		s.task = &c
+	}
+	if area, ok := state.Areas[uuid]; ok {
+		c := *area
+		s.area = &c
+	}
+	if tag, ok := state.Tags[uuid]; ok {
+		c := *tag
+		s.tag = &c
+	}
+	if cl, ok := state.CheckListItems[uuid]; ok {
+		c := *cl
+		s.checklist = &c
+	}
+	return s
+}
+
+// changes classifies what happened to the entity using the SDK's detectors.
+func (s entitySnapshot) changes(state *memory.State, uuid string, serverIndex int, ts time.Time) []thingssync.Change {
+	if task, ok := state.Tasks[uuid]; ok || s.task != nil {
+		return thingssync.DetectTaskChanges(s.task, task, serverIndex, ts)
+	}
+	if area, ok := state.Areas[uuid]; ok || s.area != nil {
+		return thingssync.DetectAreaChanges(s.area, area, serverIndex, ts)
+	}
+	if tag, ok := state.Tags[uuid]; ok || s.tag != nil {
+		return thingssync.DetectTagChanges(s.tag, tag, serverIndex, ts)
+	}
+	if cl, ok := state.CheckListItems[uuid]; ok || s.checklist != nil {
+		var task *thingscloud.Task
+		if cl != nil && len(cl.TaskIDs) > 0 {
+			task = state.Tasks[cl.TaskIDs[0]]
+		}
+		return thingssync.DetectChecklistChanges(s.checklist, cl, task, serverIndex, ts)
+	}
+	return nil
+}
+
+// applyTracked applies items to state one at a time and returns what each
+// of them changed. An item is dated by its modification date (md); items
+// without one (deletions, areas, tags) inherit the previous item's date,
+// starting from fallback.
+func applyTracked(state *memory.State, items []thingscloud.Item, fallback time.Time) []changeEvent {
+	events, _ := applyTrackedFrom(state, items, fallback)
+	return events
+}
+
+// applyTrackedFrom is applyTracked that also returns the date of the last
+// item, to continue from with the next batch.
+func applyTrackedFrom(state *memory.State, items []thingscloud.Item, fallback time.Time) ([]changeEvent, time.Time) {
+	var events []changeEvent
+	ts := fallback
+	for _, item := range items {
+		var p struct {
+			ModificationDate *thingscloud.Timestamp `json:"md"`
+		}
+		if json.Unmarshal(item.P, &p) == nil && p.ModificationDate != nil {
+			ts = *p.ModificationDate.Time()
+		}
+		before := snapshotEntity(state, item.UUID)
+		state.Update(item)
+		for _, c := range before.changes(state, item.UUID, item.ServerIndex, ts) {
+			events = append(events, toChangeEvent(c))
+		}
+	}
+	return events, ts
+}
+
+// ---------------------------------------------------------------------------
+// JSON form
+// ---------------------------------------------------------------------------
+
+// changeEvent is the wire form of a thingssync.Change. Entity fields are
+// copied out so the event does not alias state that keeps changing.
+type changeEvent struct {
+	Type        string         `json:"type"`
+	EntityType  string         `json:"entityType"`
+	UUID        string         `json:"uuid"`
+	Title       string         `json:"title,omitempty"`
+	ServerIndex int            `json:"serverIndex"`
+	Timestamp   string         `json:"timestamp"`
+	Details     map[string]any `json:"details,omitempty"`
+	at          time.Time
+}
+
+func toChangeEvent(c thingssync.Change) changeEvent {
+	ev := changeEvent{
+		Type:        c.ChangeType(),
+		EntityType:  c.EntityType(),
+		UUID:        c.EntityUUID(),
+		ServerIndex: c.ServerIndex(),
+		Timestamp:   c.Timestamp().UTC().Format("2006-01-02T15:04:05Z"),
+		at:          c.Timestamp(),
+	}
+	details := map[string]any{}
+	switch v := c.(type) {
+	case thingssync.TaskCreated:
+		ev.Title = v.Task.Title
+	case thingssync.TaskDeleted:
+		ev.Title = v.Task.Title
+	case thingssync.TaskCompleted:
+		ev.Title = v.Task.Title
+	case thingssync.TaskUncompleted:
+		ev.Title = v.Task.Title
+	case thingssync.TaskCanceled:
+		ev.Title = v.Task.Title
+	case thingssync.TaskTitleChanged:
+		ev.Title = v.Task.Title
+		details["oldTitle"] = v.OldTitle
+	case thingssync.TaskNoteChanged:
+		ev.Title = v.Task.Title
+	case thingssync.TaskMovedToInbox:
+		ev.Title = v.Task.Title
+		details["from"] = v.From.String()
+	case thingssync.TaskMovedToToday:
+		ev.Title = v.Task.Title
+		details["from"] = v.From.String()
+	case thingssync.TaskMovedToAnytime:
+		ev.Title = v.Task.Title
+		details["from"] = v.From.String()
+	case thingssync.TaskMovedToSomeday:
+		ev.Title = v.Task.Title
+		details["from"] = v.From.String()
+	case thingssync.TaskMovedToUpcoming:
+		ev.Title = v.Task.Title
+		details["from"] = v.From.String()
+		details["scheduledFor"] = v.ScheduledFor.Format("2006-01-02")
+	case thingssync.TaskDeadlineChanged:
+		ev.Title = v.Task.Title
+		if v.OldDeadline != nil {
+			details["oldDeadline"] = v.OldDeadline.Format("2006-01-02")
+		}
+		if v.Task.DeadlineDate != nil {
+			details["deadline"] = v.Task.DeadlineDate.Format("2006-01-02")
+		}
+	case thingssync.TaskAssignedToProject:
+		ev.Title = v.Task.Title
+		details["project"] = v.Project.UUID
+		if v.OldProject != nil {
+			details["oldProject"] = v.OldProject.UUID
+		}
+	case thingssync.TaskAssignedToArea:
+		ev.Title = v.Task.Title
+		details["area"] = v.Area.UUID
+		if v.OldArea != nil {
+			details["oldArea"] = v.OldArea.UUID
+		}
+	case thingssync.TaskTrashed:
+		ev.Title = v.Task.Title
+	case thingssync.TaskRestored:
+		ev.Title = v.Task.Title
+	case thingssync.TaskTagsChanged:
+		ev.Title = v.Task.Title
+		if len(v.Added) > 0 {
+			details["added"] = v.Added
+		}
+		if len(v.Removed) > 0 {
+			details["removed"] = v.Removed
+		}
+	case thingssync.ProjectCreated:
+		ev.Title = v.Project.Title
+	case thingssync.ProjectDeleted:
+		ev.Title = v.Project.Title
+	case thingssync.ProjectCompleted:
+		ev.Title = v.Project.Title
+	case thingssync.ProjectTitleChanged:
+		ev.Title = v.Project.Title
+		details["oldTitle"] = v.OldTitle
+	case thingssync.ProjectTrashed:
+		ev.Title = v.Project.Title
+	case thingssync.ProjectRestored:
+		ev.Title = v.Project.Title
+	case thingssync.HeadingCreated:
+		ev.Title = v.Heading.Title
+	case thingssync.HeadingDeleted:
+		ev.Title = v.Heading.Title
+	case thingssync.HeadingTitleChanged:
+		ev.Title = v.Heading.Title
+		details["oldTitle"] = v.OldTitle
+	case thingssync.AreaCreated:
+		ev.Title = v.Area.Title
+	case thingssync.AreaDeleted:
+		ev.Title = v.Area.Title
+	case thingssync.AreaRenamed:
+		ev.Title = v.Area.Title
+		details["oldTitle"] = v.OldTitle
+	case thingssync.TagCreated:
+		ev.Title = v.Tag.Title
+	case thingssync.TagDeleted:
+		ev.Title = v.Tag.Title
+	case thingssync.TagRenamed:
+		ev.Title = v.Tag.Title
+		details["oldTitle"] = v.OldTitle
+	case thingssync.TagShortcutChanged:
+		ev.Title = v.Tag.Title
+		details["oldShortcut"] = v.OldShortcut
+	case thingssync.ChecklistItemCreated:
+		ev.Title = v.Item.Title
+		addChecklistTask(details, v.Task)
+	case thingssync.ChecklistItemDeleted:
+		ev.Title = v.Item.Title
+	case thingssync.ChecklistItemCompleted:
+		ev.Title = v.Item.Title
+		addChecklistTask(details, v.Task)
+	case thingssync.ChecklistItemUncompleted:
+		ev.Title = v.Item.Title
+		addChecklistTask(details, v.Task)
+	case thingssync.ChecklistItemTitleChanged:
+		ev.Title = v.Item.Title
+		details["oldTitle"] = v.OldTitle
+	}
+	if len(details) > 0 {
+		ev.Details = details
+	}
+	return ev
+}
+
+func addChecklistTask(details map[string]any, task *thingscloud.Task) {
+	if task != nil {
+		details["task"] = Ref{UUID: task.UUID, Name: task.Title}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	thingscloud "github.com/arthursoares/things-cloud-sdk"
+	memory "github.com/arthursoares/things-cloud-sdk/state/memory"
+)
+
+// makeUpdateItem builds a modification item carrying only the given fields.
+func makeUpdateItem(uuid string, kind thingscloud.ItemKind, fields map[string]any) thingscloud.Item {
+	raw, _ := json.Marshal(fields)
+	return thingscloud.Item{UUID: uuid, P: raw, Kind: kind, Action: thingscloud.ItemActionModified}
+}
+
+// applyAndDiff applies delta to state the way incrementalSync does, as if
+// it were commit 42, and returns the classified changes.
+func applyAndDiff(state *memory.State, delta ...thingscloud.Item) []changeEvent {
+	for i := range delta {
+		delta[i].ServerIndex = 42
+	}
+	return applyTracked(state, delta, mustTime("2025-03-01"))
+}
+
+func eventTypes(events []changeEvent) []string {
+	var out []string
+	for _, ev := range events {
+		out = append(out, ev.Type)
+	}
+	return out
+}
+
+func TestSnapshotChanges(t *testing.T) {
+	state := memory.NewState()
+	state.Update(
+		makeAreaItem("area-1", "Work"),
+		makeTagItem("tag-1", "Urgent"),
+		makeTaskItem("proj-1", withTitle("Launch"), withTaskType(thingscloud.TaskTypeProject)),
+		makeTaskItem("t-1", withTitle("Write spec")),
+		makeChecklistItem("cl-1", "t-1", "Outline"),
+	)
+
+	t.Run("creations", func(t *testing.T) {
+		events := applyAndDiff(state,
+			makeTaskItem("t-2", withTitle("New task")),
+			makeTaskItem("proj-2", withTitle("New project"), withTaskType(thingscloud.TaskTypeProject)),
+			makeAreaItem("area-2", "Home"),
+		)
+		got := eventTypes(events)
+		want := []string{"TaskCreated", "ProjectCreated", "AreaCreated"}
+		if len(got) != len(want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("event %d: got %s, want %s", i, got[i], want[i])
+			}
+		}
+		if events[0].UUID != "t-2" || events[0].Title != "New task" || events[0].ServerIndex != 42 || events[0].Timestamp != "2025-03-01T00:00:00Z" {
+			t.Errorf("TaskCreated event: got %+v", events[0])
+		}
+	})
+
+	t.Run("modifications", func(t *testing.T) {
+		events := applyAndDiff(state,
+			makeUpdateItem("t-1", thingscloud.ItemKindTask, map[string]any{"tt": "Write the spec", "ss": 3}),
+			makeUpdateItem("proj-1", thingscloud.ItemKindTask, map[string]any{"ss": 3}),
+			makeUpdateItem("tag-1", thingscloud.ItemKindTag, map[string]any{"tt": "Now"}),
+			makeUpdateItem("cl-1", thingscloud.ItemKindChecklistItem, map[string]any{"ss": 3}),
+		)
+		byType := map[string]changeEvent{}
+		for _, ev := range events {
+			byType[ev.Type] = ev
+		}
+		if ev, ok := byType["TaskTitleChanged"]; !ok || ev.Details["oldTitle"] != "Write spec" {
+			t.Errorf("TaskTitleChanged: got %+v", ev)
+		}
+		if _, ok := byType["TaskCompleted"]; !ok {
+			t.Errorf("missing TaskCompleted in %v", eventTypes(events))
+		}
+		if ev, ok := byType["ProjectCompleted"]; !ok || ev.EntityType != "Project" {
+			t.Errorf("ProjectCompleted: got %+v", ev)
+		}
+		if ev, ok := byType["TagRenamed"]; !ok || ev.Title != "Now" || ev.Details["oldTitle"] != "Urgent" {
+			t.Errorf("TagRenamed: got %+v", ev)
+		}
+		if ev, ok := byType["ChecklistItemCompleted"]; !ok || ev.Details["task"].(Ref).UUID != "t-1" {
+			t.Errorf("ChecklistItemCompleted: got %+v", ev)
+		}
+	})
+
+	t.Run("move to today and delete", func(t *testing.T) {
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		events := applyAndDiff(state,
+			makeUpdateItem("t-2", thingscloud.ItemKindTask, map[string]any{"st": 1, "sr": today.Unix(), "md": float64(time.Now().Unix())}),
+			thingscloud.Item{UUID: "area-2", Kind: thingscloud.ItemKindArea, Action: thingscloud.ItemActionDeleted, P: json.RawMessage(`{}`)},
+		)
+		got := eventTypes(events)
+		if len(got) != 2 || got[0] != "TaskMovedToToday" || got[1] != "AreaDeleted" {
+			t.Errorf("got %v, want [TaskMovedToToday AreaDeleted]", got)
+		}
+		if events[0].Details["from"] != "Anytime" {
+			t.Errorf("from: got %v", events[0].Details["from"])
+		}
+	})
+
+	t.Run("move to project is dated by md", func(t *testing.T) {
+		events := applyAndDiff(state,
+			makeUpdateItem("t-1", thingscloud.ItemKindTask, map[string]any{"pr": []string{"proj-1"}, "md": 1741000000.5}),
+		)
+		if len(events) != 1 || events[0].Type != "TaskAssignedToProject" || events[0].Details["project"] != "proj-1" {
+			t.Fatalf("got %+v", events)
+		}
+		if events[0].Timestamp != "2025-03-03T11:06:40Z" {
+			t.Errorf("timestamp: got %s", events[0].Timestamp)
+		}
+	})
+
+	t.Run("every reported type is known", func(t *testing.T) {
+		for _, ev := range applyAndDiff(state, makeTaskItem("t-3", withTitle("x"))) {
+			if !containsStr(changeTypes, ev.Type) {
+				t.Errorf("unknown change type %s", ev.Type)
+			}
+		}
+	})
+}
@@ -0,0 +1,923 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mark3labs/mcp-go/mcp"
+
+	thingscloud "github.com/arthursoares/things-cloud-sdk"
+)
+
+// ---------------------------------------------------------------------------
+// Command-line interface
+//
+// The CLI drives the same handlers as the MCP tools: every command builds a
+// CallToolRequest, calls the corresponding handle* method and renders the
+// JSON result either verbatim (--json) or as a human-readable table.
+// ---------------------------------------------------------------------------
+
+// cliConfig is the content of the credentials file.
+type cliConfig struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Endpoint string `json:"endpoint,omitempty"` // defaults to thingscloud.APIEndpoint
+	Proxy    string `json:"proxy,omitempty"`    // optional proxy URL
+}
+
+// defaultCredentialsPath returns $THINGS_CREDENTIALS, falling back to
+// <user config dir>/things-cloud-mcp/credentials.json.
+func defaultCredentialsPath() string {
+	if p := os.Getenv("THINGS_CREDENTIALS"); p != "" {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "credentials.json"
+	}
+	return filepath.Join(dir, "things-cloud-mcp", "credentials.json")
+}
+
+func loadCLIConfig(path string) (*cliConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read credentials: %w", err)
+	}
+	var cfg cliConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse credentials %s: %w", path, err)
+	}
+	if cfg.Email == "" || cfg.Password == "" {
+		return nil, fmt.Errorf("credentials %s: email and password are required", path)
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = thingscloud.APIEndpoint
+	}
+	return &cfg, nil
+}
+
+// cliEnv carries per-invocation options and the lazily connected account.
+type cliEnv struct {
+	stdout      io.Writer
+	stderr      io.Writer
+	credentials string
+	jsonOut     bool
+	verbose     bool
+
+	cfg    *cliConfig
+	things *ThingsMCP
+}
+
+func (e *cliEnv) addCommonFlags(fs *flag.FlagSet) {
+	fs.StringVar(&e.credentials, "credentials", defaultCredentialsPath(), "path to the credentials file")
+	fs.BoolVar(&e.jsonOut, "json", false, "print JSON instead of a table")
+	fs.BoolVar(&e.verbose, "verbose", false, "log sync activity to stderr")
+}
+
+// connect loads the credentials file and performs the initial sync.
+func (e *cliEnv) connect() (*ThingsMCP, error) {
+	if e.things != nil {
+		return e.things, nil
+	}
+	cfg, err := loadCLIConfig(e.credentials)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(e.credentials); err == nil && info.Mode().Perm()&0o077 != 0 {
+		fmt.Fprintf(e.stderr, "warning: %s is readable by other users; chmod 600 it\n", e.credentials)
+	}
+	var proxies *proxyPool
+	if cfg.Proxy != "" {
+		proxy, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %q: %w", cfg.Proxy, err)
+		}
+		if !proxySchemes[proxy.Scheme] {
+			return nil, fmt.Errorf("invalid proxy URL %q: use http, https, socks5 or socks5h", cfg.Proxy)
+		}
+		proxies = newProxyPool([]*url.URL{proxy}, nil)
+	}
+	t, err := newThingsMCP(context.Background(), cfg.Endpoint, cfg.Email, cfg.Password, proxies)
+	if err != nil {
+		return nil, err
+	}
+	e.cfg = cfg
+	e.things = t
+	return t, nil
+}
+
+type cliCommand struct {
+	name    string
+	args    string
+	summary string
+	run     func(e *cliEnv, args []string) error
+}
+
+var cliCommands = []cliCommand{
+	{"list", "[flags]", "List tasks (or projects with --projects)", cliList},
+	{"show", "<uuid>", "Show a task or project with its checklist or headings", cliShow},
+	{"add", "[flags] <title>", "Create a task, project or heading", cliAdd},
+	{"edit", "[flags] <uuid>", "Edit a task or project", cliEdit},
+	{"complete", "<uuid>...", "Mark one or more tasks as completed", cliComplete},
+	{"search", "[flags] <text>", "Find tasks whose title or note contains text", cliSearch},
+	{"export", "[flags]", "Export the account as JSON, Markdown or CSV", cliExport},
+	{"import", "[flags] <file>", "Import a Todoist, TaskPaper or Markdown file", cliImport},
+	{"changes", "[flags] <since>", "Show what changed since a date or checkpoint", cliChanges},
+	{"diagnose", "[flags]", "Run the sync pipeline diagnostic", cliDiagnose},
+}
+
+func cliUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: things-mcp <command> [flags] [args]\n\n")
+	fmt.Fprintf(w, "Run without arguments (or with \"serve\") to start the MCP server.\n\nCommands:\n")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range cliCommands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", c.name, c.args, c.summary)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nCredentials are read from %s\n(override with --credentials or THINGS_CREDENTIALS):\n", defaultCredentialsPath())
+	fmt.Fprintf(w, "  {\"email\": \"you@example.com\", \"password\": \"...\"}\n")
+}
+
+// runCLI executes a CLI command and returns the process exit code:
+// 0 on success, 1 when the command failed, 2 on usage errors.
+func runCLI(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		cliUsage(stdout)
+		return 0
+	}
+	var cmd *cliCommand
+	for i := range cliCommands {
+		if cliCommands[i].name == args[0] {
+			cmd = &cliCommands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(stderr, "unknown command %q\n\n", args[0])
+		cliUsage(stderr)
+		return 2
+	}
+
+	env := &cliEnv{stdout: stdout, stderr: stderr}
+	prevLog := log.Writer()
+	defer log.SetOutput(prevLog)
+	if err := cmd.run(env, args[1:]); err != nil {
+		var ue usageError
+		if errors.As(err, &ue) {
+			fmt.Fprintf(stderr, "%s\nusage: things-mcp %s %s\n", ue, cmd.name, cmd.args)
+			return 2
+		}
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+type usageError string
+
+func (u usageError) Error() string { return string(u) }
+
+// parseCLIFlags parses flags that may appear before, after or between
+// positional arguments and returns the positionals. Everything after "--"
+// is treated as positional.
+func parseCLIFlags(e *cliEnv, fs *flag.FlagSet, args []string) ([]string, error) {
+	fs.SetOutput(e.stderr)
+	var rest []string
+	for i, a := range args {
+		if a == "--" {
+			args, rest = args[:i], args[i+1:]
+			break
+		}
+	}
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	if !e.verbose {
+		log.SetOutput(io.Discard)
+	}
+	return append(positional, rest...), nil
+}
+
+// ---------------------------------------------------------------------------
+// Handler plumbing
+// ---------------------------------------------------------------------------
+
+type toolHandler func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error)
+
+// callTool invokes an MCP handler and returns its JSON text, turning tool
+// error results into Go errors.
+func callTool(fn toolHandler, args map[string]any) (json.RawMessage, error) {
+	res, err := fn(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: args}})
+	if err != nil {
+		return nil, err
+	}
+	var text string
+	for _, c := range res.Content {
+		if tc, ok := c.(mcp.TextContent); ok {
+			text = tc.Text
+			break
+		}
+	}
+	if res.IsError {
+		return nil, errors.New(text)
+	}
+	return json.RawMessage(text), nil
+}
+
+// setArgs copies non-empty flag values into tool arguments.
+func setArgs(args map[string]any, values map[string]string) {
+	for k, v := range values {
+		if v != "" {
+			args[k] = v
+		}
+	}
+}
+
+func (e *cliEnv) printJSON(raw json.RawMessage) error {
+	_, err := fmt.Fprintln(e.stdout, string(raw))
+	return err
+}
+
+// resolveTaskPrefix expands a UUID prefix to the full UUID of a single task.
+func (t *ThingsMCP) resolveTaskPrefix(prefix string) (string, error) {
+	state := t.getState()
+	if _, ok := state.Tasks[prefix]; ok {
+		return prefix, nil
+	}
+	var matches []string
+	for id := range state.Tasks {
+		if strings.HasPrefix(id, prefix) {
+			matches = append(matches, id)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", withCode(codeNotFound, fmt.Errorf("task not found: %s", prefix))
+	case 1:
+		return matches[0], nil
+	default:
+		sort.Strings(matches)
+		return "", fmt.Errorf("ambiguous uuid prefix %s matches %d items (%s, ...)", prefix, len(matches), matches[0])
+	}
+}
+
+// resolveRefs maps a comma-separated list of UUIDs or names to UUIDs.
+func resolveRefs(csv, kind string, valid func(string) error, byName func(string) string) (string, error) {
+	if csv == "" {
+		return "", nil
+	}
+	var ids []string
+	for _, v := range strings.Split(csv, ",") {
+		v = strings.TrimSpace(v)
+		if valid(v) == nil {
+			ids = append(ids, v)
+			continue
+		}
+		id := byName(v)
+		if id == "" {
+			return "", withCode(codeNotFound, fmt.Errorf("%s not found: %s", kind, v))
+		}
+		ids = append(ids, id)
+	}
+	return strings.Join(ids, ","), nil
+}
+
+// resolveContainers turns user-supplied area/project/tag names into UUIDs
+// so the write handlers' validation sees the values it expects.
+func (t *ThingsMCP) resolveContainers(area, project, tags string) (string, string, string, error) {
+	areaUUID, err := resolveRefs(area, "area", t.validateAreaUUID, t.findAreaUUID)
+	if err != nil {
+		return "", "", "", err
+	}
+	projectUUID, err := resolveRefs(project, "project", t.validateProjectUUID, t.findProjectUUID)
+	if err != nil {
+		return "", "", "", err
+	}
+	tagUUIDs, err := resolveRefs(tags, "tag", t.validateTagUUID, t.findTagUUID)
+	if err != nil {
+		return "", "", "", err
+	}
+	return areaUUID, projectUUID, tagUUIDs, nil
+}
+
+// ---------------------------------------------------------------------------
+// Commands
+// ---------------------------------------------------------------------------
+
+func cliList(e *cliEnv, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	e.addCommonFlags(fs)
+	projects := fs.Bool("projects", false, "list projects instead of tasks")
+	inTrash := fs.Bool("trash", false, "include trashed items")
+	filters := map[string]*string{}
+	for _, f := range []struct{ flag, arg, help string }{
+		{"schedule", "schedule", "inbox, today, tonight, anytime, someday or upcoming"},
+		{"status", "status", "pending (default), completed or canceled"},
+		{"area", "area", "area name"},
+		{"project", "project", "project name"},
+		{"tag", "tag", "tag name"},
+		{"scheduled-before", "scheduled_before", "YYYY-MM-DD, exclusive"},
+		{"scheduled-after", "scheduled_after", "YYYY-MM-DD, exclusive"},
+		{"deadline-before", "deadline_before", "YYYY-MM-DD, exclusive"},
+		{"deadline-after", "deadline_after", "YYYY-MM-DD, exclusive"},
+		{"created-before", "created_before", "YYYY-MM-DD or RFC3339, exclusive"},
+		{"created-after", "created_after", "YYYY-MM-DD or RFC3339, exclusive"},
+	} {
+		filters[f.arg] = fs.String(f.flag, "", f.help)
+	}
+	if _, err := parseCLIFlags(e, fs, args); err != nil {
+		return err
+	}
+	t, err := e.connect()
+	if err != nil {
+		return err
+	}
+
+	toolArgs := map[string]any{}
+	for k, v := range filters {
+		setArgs(toolArgs, map[string]string{k: *v})
+	}
+	if *inTrash {
+		toolArgs["in_trash"] = true
+	}
+	handler := t.handleFindTasks
+	if *projects {
+		if _, ok := toolArgs["project"]; ok {
+			return usageError("--project cannot be combined with --projects")
+		}
+		handler = t.handleFindProjects
+	}
+	raw, err := callTool(handler, toolArgs)
+	if err != nil {
+		return err
+	}
+	if e.jsonOut {
+		return e.printJSON(raw)
+	}
+	var tasks []TaskOutput
+	if err := json.Unmarshal(raw, &tasks); err != nil {
+		return err
+	}
+	return printTaskTable(e.stdout, tasks)
+}
+
+func cliSearch(e *cliEnv, args []string) error {
+	fs := flag.NewFlagSet("search", flag.ContinueOnError)
+	e.addCommonFlags(fs)
+	status := fs.String("status", "", "pending (default), completed or canceled")
+	projects := fs.Bool("projects", false, "search projects instead of tasks")
+	positional, err := parseCLIFlags(e, fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		return usageError("search text is required")
+	}
+	t, err := e.connect()
+	if err != nil {
+		return err
+	}
+	toolArgs := map[string]any{"contains_text": strings.Join(positional, " ")}
+	setArgs(toolArgs, map[string]string{"status": *status})
+	handler := t.handleFindTasks
+	if *projects {
+		handler = t.handleFindProjects
+	}
+	raw, err := callTool(handler, toolArgs)
+	if err != nil {
+		return err
+	}
+	if e.jsonOut {
+		return e.printJSON(raw)
+	}
+	var tasks []TaskOutput
+	if err := json.Unmarshal(raw, &tasks); err != nil {
+		return err
+	}
+	return printTaskTable(e.stdout, tasks)
+}
+
+func cliShow(e *cliEnv, args []string) error {
+	fs := flag.NewFlagSet("show", flag.ContinueOnError)
+	e.addCommonFlags(fs)
+	status := fs.String("status", "", "status of project child tasks: pending (default), completed or canceled")
+	positional, err := parseCLIFlags(e, fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError("exactly one uuid is required")
+	}
+	t, err := e.connect()
+	if err != nil {
+		return err
+	}
+	raw, err := callTool(t.handleShowTask, map[string]any{"uuid": positional[0]})
+	if err != nil {
+		return err
+	}
+	var detail TaskDetailOutput
+	if err := json.Unmarshal(raw, &detail); err != nil {
+		return err
+	}
+	if detail.Type != "project" {
+		if e.jsonOut {
+			return e.printJSON(raw)
+		}
+		printTaskDetail(e.stdout, detail.TaskOutput)
+		if len(detail.Checklist) > 0 {
+			fmt.Fprintln(e.stdout, "\nChecklist:")
+			for _, item := range detail.Checklist {
+				mark := " "
+				if item.Status == "completed" {
+					mark = "x"
+				}
+				fmt.Fprintf(e.stdout, "  [%s] %s  (%s)\n", mark, item.Title, item.UUID)
+			}
+		}
+		return nil
+	}
+
+	toolArgs := map[string]any{"uuid": detail.UUID}
+	setArgs(toolArgs, map[string]string{"status": *status})
+	raw, err = callTool(t.handleShowProject, toolArgs)
+	if err != nil {
+		return err
+	}
+	if e.jsonOut {
+		return e.printJSON(raw)
+	}
+	var project struct {
+		TaskOutput
+		Headings []struct {
+			UUID  string       `json:"uuid"`
+			Title string       `json:"title"`
+			Tasks []TaskOutput `json:"tasks"`
+		} `json:"headings"`
+		UnfiledTasks []TaskOutput `json:"unfiledTasks"`
+	}
+	if err := json.Unmarshal(raw, &project); err != nil {
+		return err
+	}
+	printTaskDetail(e.stdout, project.TaskOutput)
+	if len(project.UnfiledTasks) > 0 {
+		fmt.Fprintln(e.stdout)
+		if err := printTaskTable(e.stdout, project.UnfiledTasks); err != nil {
+			return err
+		}
+	}
+	for _, h := range project.Headings {
+		fmt.Fprintf(e.stdout, "\n## %s  (%s)\n", h.Title, h.UUID)
+		if err := printTaskTable(e.stdout, h.Tasks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cliAdd(e *cliEnv, args []string) error {
+	fs := flag.NewFlagSet("add", flag.ContinueOnError)
+	e.addCommonFlags(fs)
+	kind := fs.String("type", "task", "task, project or heading")
+	values := map[string]*string{}
+	for _, f := range []struct{ flag, arg, help string }{
+		{"note", "note", "note text"},
+		{"schedule", "schedule", "today, tonight, anytime, someday, inbox or YYYY-MM-DD"},
+		{"deadline", "deadline", "YYYY-MM-DD"},
+		{"project", "project_uuid", "project name or UUID"},
+		{"heading", "heading_uuid", "heading UUID"},
+		{"area", "area_uuid", "area name or UUID"},
+		{"tags", "tags", "comma-separated tag names or UUIDs"},
+		{"checklist", "checklist", "comma-separated checklist item titles"},
+		{"reminder-date", "reminder_date", "YYYY-MM-DD (requires --reminder-time)"},
+		{"reminder-time", "reminder_time", "HH:MM (requires --reminder-date)"},
+		{"recurrence", "recurrence", "daily, weekly, weekly:mon,wed, monthly, monthly:15, monthly:last, yearly, every N days, every N weeks"},
+	} {
+		values[f.arg] = fs.String(f.flag, "", f.help)
+	}
+	positional, err := parseCLIFlags(e, fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		return usageError("title is required")
+	}
+	t, err := e.connect()
+	if err != nil {
+		return err
+	}
+
+	area, project, tags, err := t.resolveContainers(*values["area_uuid"], *values["project_uuid"], *values["tags"])
+	if err != nil {
+		return err
+	}
+	*values["area_uuid"], *values["project_uuid"], *values["tags"] = area, project, tags
+
+	toolArgs := map[string]any{"title": strings.Join(positional, " ")}
+	for k, v := range values {
+		setArgs(toolArgs, map[string]string{k: *v})
+	}
+	var handler toolHandler
+	switch *kind {
+	case "task":
+		handler = t.handleCreateTask
+	case "project":
+		handler = t.handleCreateProject
+	case "heading":
+		handler = t.handleCreateHeading
+	default:
+		return usageError(fmt.Sprintf("unknown --type %q (use task, project or heading)", *kind))
+	}
+	raw, err := callTool(handler, toolArgs)
+	if err != nil {
+		return err
+	}
+	return e.printStatus(raw)
+}
+
+func cliEdit(e *cliEnv, args []string) error {
+	fs := flag.NewFlagSet("edit", flag.ContinueOnError)
+	e.addCommonFlags(fs)
+	values := map[string]*string{}
+	for _, f := range []struct{ flag, arg, help string }{
+		{"title", "title", "new title"},
+		{"note", "note", "new note (replaces the existing note)"},
+		{"schedule", "schedule", "today, tonight, anytime, someday, inbox or YYYY-MM-DD"},
+		{"deadline", "deadline", "YYYY-MM-DD"},
+		{"project", "project_uuid", "project name or UUID"},
+		{"heading", "heading_uuid", "heading UUID"},
+		{"area", "area_uuid", "area name or UUID"},
+		{"tags", "tags", "comma-separated tag names or UUIDs (replaces existing tags)"},
+		{"status", "status", "pending, completed, canceled, trashed or restored"},
+		{"reminder-date", "reminder_date", "YYYY-MM-DD or none"},
+		{"reminder-time", "reminder_time", "HH:MM"},
+		{"recurrence", "recurrence", "recurrence rule or none"},
+	} {
+		values[f.arg] = fs.String(f.flag, "", f.help)
+	}
+	positional, err := parseCLIFlags(e, fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError("exactly one uuid is required")
+	}
+	t, err := e.connect()
+	if err != nil {
+		return err
+	}
+	taskUUID, err := t.resolveTaskPrefix(positional[0])
+	if err != nil {
+		return err
+	}
+	area, project, tags, err := t.resolveContainers(*values["area_uuid"], *values["project_uuid"], *values["tags"])
+	if err != nil {
+		return err
+	}
+	*values["area_uuid"], *values["project_uuid"], *values["tags"] = area, project, tags
+
+	toolArgs := map[string]any{"uuid": taskUUID}
+	for k, v := range values {
+		setArgs(toolArgs, map[string]string{k: *v})
+	}
+	if len(toolArgs) == 1 {
+		return usageError("nothing to change: pass at least one flag")
+	}
+	raw, err := callTool(t.handleEditTask, toolArgs)
+	if err != nil {
+		return err
+	}
+	return e.printStatus(raw)
+}
+
+func cliComplete(e *cliEnv, args []string) error {
+	fs := flag.NewFlagSet("complete", flag.ContinueOnError)
+	e.addCommonFlags(fs)
+	positional, err := parseCLIFlags(e, fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		return usageError("at least one uuid is required")
+	}
+	t, err := e.connect()
+	if err != nil {
+		return err
+	}
+	for _, prefix := range positional {
+		taskUUID, err := t.resolveTaskPrefix(prefix)
+		if err != nil {
+			return err
+		}
+		raw, err := callTool(t.handleEditTask, map[string]any{"uuid": taskUUID, "status": "completed"})
+		if err != nil {
+			return fmt.Errorf("%s: %w", prefix, err)
+		}
+		if err := e.printStatus(raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cliExport(e *cliEnv, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	e.addCommonFlags(fs)
+	format := fs.String("format", "json", "json, markdown or csv")
+	output := fs.String("output", "", "write the export to this file instead of stdout")
+	completed := fs.Bool("completed", false, "include completed and canceled items")
+	trashed := fs.Bool("trash", false, "include trashed items")
+	filters := map[string]*string{}
+	for _, f := range []struct{ flag, arg, help string }{
+		{"area", "area_uuid", "area name or uuid"},
+		{"project", "project_uuid", "project name or uuid"},
+		{"date-field", "date_field", "created (default), modified, completed, scheduled or deadline"},
+		{"from", "from", "YYYY-MM-DD, inclusive"},
+		{"to", "to", "YYYY-MM-DD, inclusive"},
+	} {
+		filters[f.arg] = fs.String(f.flag, "", f.help)
+	}
+	if _, err := parseCLIFlags(e, fs, args); err != nil {
+		return err
+	}
+	if e.jsonOut {
+		*format = "json"
+	}
+	t, err := e.connect()
+	if err != nil {
+		return err
+	}
+	toolArgs := map[string]any{"format": *format, "include_completed": *completed, "include_trashed": *trashed}
+	values := map[string]string{}
+	for k, v := range filters {
+		values[k] = *v
+	}
+	if values["area_uuid"], values["project_uuid"], _, err = t.resolveContainers(values["area_uuid"], values["project_uuid"], ""); err != nil {
+		return err
+	}
+	setArgs(toolArgs, values)
+
+	raw, err := callTool(t.handleExport, toolArgs)
+	if err != nil {
+		return err
+	}
+	if !strings.HasSuffix(string(raw), "\n") {
+		raw = append(raw, '\n')
+	}
+	if *output != "" {
+		return os.WriteFile(*output, raw, 0o600)
+	}
+	_, err = e.stdout.Write(raw)
+	return err
+}
+
+func cliImport(e *cliEnv, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	e.addCommonFlags(fs)
+	format := fs.String("format", "", "todoist_csv, todoist_json, taskpaper or markdown (default: from the file extension)")
+	project := fs.String("project", "", "project title for Todoist CSV files (default: the file name)")
+	area := fs.String("area", "", "area name or uuid for projects and tasks without one")
+	dryRun := fs.Bool("dry-run", false, "show what would be created without writing")
+	positional, err := parseCLIFlags(e, fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError("exactly one file is required")
+	}
+	path := positional[0]
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if *format == "" {
+		*format = detectImportFormat(path, content)
+	}
+	if *project == "" {
+		*project = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	t, err := e.connect()
+	if err != nil {
+		return err
+	}
+	areaUUID, _, _, err := t.resolveContainers(*area, "", "")
+	if err != nil {
+		return err
+	}
+	toolArgs := map[string]any{"format": *format, "content": string(content), "dry_run": *dryRun}
+	setArgs(toolArgs, map[string]string{"project": *project, "area_uuid": areaUUID})
+	raw, err := callTool(t.handleImport, toolArgs)
+	if err != nil {
+		return err
+	}
+	if e.jsonOut {
+		return e.printJSON(raw)
+	}
+	var res importResult
+	if err := json.Unmarshal(raw, &res); err != nil {
+		return err
+	}
+	verb := "created"
+	if res.Status == "dry_run" {
+		verb = "dry run: would create"
+	}
+	c := res.Created
+	fmt.Fprintf(e.stdout, "%s %d areas, %d projects, %d headings, %d tasks, %d checklist items, %d tags in %d commit(s)\n",
+		verb, c.Areas, c.Projects, c.Headings, c.Tasks, c.ChecklistItems, c.Tags, res.Commits)
+	for _, w := range res.Warnings {
+		fmt.Fprintf(e.stdout, "warning: %s\n", w)
+	}
+	return nil
+}
+
+func cliChanges(e *cliEnv, args []string) error {
+	fs := flag.NewFlagSet("changes", flag.ContinueOnError)
+	e.addCommonFlags(fs)
+	limit := fs.Int("limit", 50, "maximum entries listed per group")
+	positional, err := parseCLIFlags(e, fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError("a date (YYYY-MM-DD) or checkpoint is required")
+	}
+	t, err := e.connect()
+	if err != nil {
+		return err
+	}
+	raw, err := callTool(t.handleChangesSince, map[string]any{"since": positional[0], "limit": *limit})
+	if err != nil {
+		return err
+	}
+	if e.jsonOut {
+		return e.printJSON(raw)
+	}
+	var res changelogResult
+	if err := json.Unmarshal(raw, &res); err != nil {
+		return err
+	}
+	fmt.Fprint(e.stdout, res.Changelog)
+	if res.Note != "" {
+		fmt.Fprintf(e.stdout, "\n%s\n", res.Note)
+	}
+	fmt.Fprintf(e.stdout, "\nCheckpoint: %s\n", res.Checkpoint)
+	return nil
+}
+
+func cliDiagnose(e *cliEnv, args []string) error {
+	fs := flag.NewFlagSet("diagnose", flag.ContinueOnError)
+	e.addCommonFlags(fs)
+	if _, err := parseCLIFlags(e, fs, args); err != nil {
+		return err
+	}
+	t, err := e.connect()
+	if err != nil {
+		return err
+	}
+	report := t.handleDiagnose(context.Background(), e.cfg.Email, e.cfg.Password)
+	if e.jsonOut {
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		return e.printJSON(b)
+	}
+	tw := tabwriter.NewWriter(e.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STEP\tNAME\tSTATUS\tDURATION")
+	for _, s := range report.Steps {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%dms\n", s.Step, s.Name, s.Status, s.DurationMs)
+	}
+	tw.Flush()
+	for _, w := range report.Warnings {
+		fmt.Fprintf(e.stdout, "warning: %s\n", w)
+	}
+	for _, msg := range report.Errors {
+		fmt.Fprintf(e.stdout, "error: %s\n", msg)
+	}
+	if report.Summary.Failed > 0 {
+		return fmt.Errorf("%d of %d diagnostic steps failed", report.Summary.Failed, report.Summary.TotalSteps)
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------------
+// Table rendering
+// ---------------------------------------------------------------------------
+
+func (e *cliEnv) printStatus(raw json.RawMessage) error {
+	if e.jsonOut {
+		return e.printJSON(raw)
+	}
+	var out map[string]string
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return err
+	}
+	line := out["status"] + " " + out["uuid"]
+	if title := out["title"]; title != "" {
+		line += "  " + title
+	}
+	_, err := fmt.Fprintln(e.stdout, line)
+	return err
+}
+
+func printTaskTable(w io.Writer, tasks []TaskOutput) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "UUID\tTITLE\tSTATUS\tSCHEDULE\tDATE\tDEADLINE\tPROJECT\tTAGS")
+	for _, task := range tasks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			task.UUID, task.Title, task.Status, task.Schedule,
+			derefOr(task.ScheduledDate, "-"), derefOr(task.DeadlineDate, "-"),
+			projectOrArea(task), refNames(task.Tags))
+	}
+	return tw.Flush()
+}
+
+func printTaskDetail(w io.Writer, task TaskOutput) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "UUID:\t%s\n", task.UUID)
+	fmt.Fprintf(tw, "Type:\t%s\n", task.Type)
+	fmt.Fprintf(tw, "Title:\t%s\n", task.Title)
+	fmt.Fprintf(tw, "Status:\t%s\n", task.Status)
+	fmt.Fprintf(tw, "Schedule:\t%s\n", task.Schedule)
+	for _, row := range []struct {
+		label string
+		value *string
+	}{
+		{"Scheduled:", task.ScheduledDate},
+		{"Deadline:", task.DeadlineDate},
+		{"Reminder:", task.ReminderTime},
+		{"Created:", task.CreationDate},
+		{"Modified:", task.ModificationDate},
+		{"Completed:", task.CompletionDate},
+	} {
+		if row.value != nil {
+			fmt.Fprintf(tw, "%s\t%s\n", row.label, *row.value)
+		}
+	}
+	if task.IsRecurring {
+		fmt.Fprintf(tw, "Recurring:\tyes\n")
+	}
+	if task.Project != nil {
+		fmt.Fprintf(tw, "Project:\t%s\n", task.Project.Name)
+	}
+	if len(task.Areas) > 0 {
+		fmt.Fprintf(tw, "Area:\t%s\n", refNames(task.Areas))
+	}
+	if len(task.Tags) > 0 {
+		fmt.Fprintf(tw, "Tags:\t%s\n", refNames(task.Tags))
+	}
+	tw.Flush()
+	if task.Note != "" {
+		fmt.Fprintf(w, "\n%s\n", task.Note)
+	}
+}
+
+func derefOr(s *string, def string) string {
+	if s == nil {
+		return def
+	}
+	return *s
+}
+
+func projectOrArea(task TaskOutput) string {
+	if task.Project != nil {
+		return task.Project.Name
+	}
+	if len(task.Areas) > 0 {
+		return task.Areas[0].Name
+	}
+	return "-"
+}
+
+func refNames(refs []Ref) string {
+	if len(refs) == 0 {
+		return "-"
+	}
+	names := make([]string, len(refs))
+	for i, r := range refs {
+		names[i] = r.Name
+	}
+	return strings.Join(names, ",")
+}
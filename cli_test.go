@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	thingscloud "github.com/arthursoares/things-cloud-sdk"
+)
+
+func writeCredentials(t *testing.T, cfg cliConfig) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	b, _ := json.Marshal(cfg)
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseCLIFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		wantPos  []string
+		wantNote string
+		wantJSON bool
+	}{
+		{"flags first", []string{"--note", "n", "Buy milk"}, []string{"Buy milk"}, "n", false},
+		{"flags after positional", []string{"Buy", "milk", "--json", "--note", "n"}, []string{"Buy", "milk"}, "n", true},
+		{"double dash", []string{"--json", "--", "--not-a-flag"}, []string{"--not-a-flag"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &cliEnv{stderr: &bytes.Buffer{}, verbose: true}
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			e.addCommonFlags(fs)
+			note := fs.String("note", "", "")
+			pos, err := parseCLIFlags(e, fs, tt.args)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(pos, tt.wantPos) {
+				t.Errorf("positional: got %q, want %q", pos, tt.wantPos)
+			}
+			if *note != tt.wantNote {
+				t.Errorf("note: got %q, want %q", *note, tt.wantNote)
+			}
+			if e.jsonOut != tt.wantJSON {
+				t.Errorf("json: got %v, want %v", e.jsonOut, tt.wantJSON)
+			}
+		})
+	}
+}
+
+func TestLoadCLIConfig(t *testing.T) {
+	t.Run("defaults endpoint", func(t *testing.T) {
+		path := writeCredentials(t, cliConfig{Email: "a@b.c", Password: "pw"})
+		cfg, err := loadCLIConfig(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.Endpoint != thingscloud.APIEndpoint {
+			t.Errorf("endpoint: got %q, want %q", cfg.Endpoint, thingscloud.APIEndpoint)
+		}
+	})
+	t.Run("missing password", func(t *testing.T) {
+		path := writeCredentials(t, cliConfig{Email: "a@b.c"})
+		if _, err := loadCLIConfig(path); err == nil {
+			t.Error("expected error for missing password")
+		}
+	})
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := loadCLIConfig(filepath.Join(t.TempDir(), "nope.json")); err == nil {
+			t.Error("expected error for missing file")
+		}
+	})
+}
+
+func TestRunCLIUsage(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := runCLI([]string{"bogus"}, &stdout, &stderr); code != 2 {
+		t.Errorf("unknown command: exit code %d, want 2", code)
+	}
+	if code := runCLI([]string{"show"}, &stdout, &stderr); code != 2 {
+		t.Errorf("show without uuid: exit code %d, want 2", code)
+	}
+	stdout.Reset()
+	if code := runCLI([]string{"help"}, &stdout, &stderr); code != 0 {
+		t.Errorf("help: exit code %d, want 0", code)
+	}
+	if !strings.Contains(stdout.String(), "complete") {
+		t.Errorf("usage should list commands, got:\n%s", stdout.String())
+	}
+}
+
+func TestRunCLI(t *testing.T) {
+	fc := newFakeCloud("cli@example.com",
+		makeAreaItem("area-1", "Work"),
+		makeTaskItem("task-1", withTitle("Write report"), withArea("area-1")),
+		makeTaskItem("task-2", withTitle("Buy milk")),
+	)
+	defer fc.Close()
+	creds := writeCredentials(t, cliConfig{Email: fc.email, Password: "testpass", Endpoint: fc.server.URL})
+
+	t.Run("list json", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := runCLI([]string{"list", "--json", "--area", "Work", "--credentials", creds}, &stdout, &stderr)
+		if code != 0 {
+			t.Fatalf("exit code %d, stderr: %s", code, stderr.String())
+		}
+		var tasks []TaskOutput
+		if err := json.Unmarshal(stdout.Bytes(), &tasks); err != nil {
+			t.Fatalf("unmarshal: %v\n%s", err, stdout.String())
+		}
+		if len(tasks) != 1 || tasks[0].UUID != "task-1" {
+			t.Errorf("expected only task-1, got %+v", tasks)
+		}
+	})
+
+	t.Run("search table", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := runCLI([]string{"search", "milk", "--credentials", creds}, &stdout, &stderr)
+		if code != 0 {
+			t.Fatalf("exit code %d, stderr: %s", code, stderr.String())
+		}
+		out := stdout.String()
+		if !strings.HasPrefix(out, "UUID") || !strings.Contains(out, "Buy milk") || strings.Contains(out, "Write report") {
+			t.Errorf("unexpected table:\n%s", out)
+		}
+	})
+
+	t.Run("add resolves area name", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := runCLI([]string{"add", "--area", "Work", "--credentials", creds, "New", "task"}, &stdout, &stderr)
+		if code != 0 {
+			t.Fatalf("exit code %d, stderr: %s", code, stderr.String())
+		}
+		if !strings.HasPrefix(stdout.String(), "created ") {
+			t.Errorf("unexpected output: %s", stdout.String())
+		}
+		commits := fc.getCommitLog()
+		if len(commits) == 0 {
+			t.Fatal("expected a commit")
+		}
+		var body map[string]struct {
+			P map[string]any `json:"p"`
+		}
+		json.Unmarshal(commits[len(commits)-1], &body)
+		for _, env := range body {
+			if env.P["tt"] != "New task" {
+				t.Errorf("tt: got %v, want %q", env.P["tt"], "New task")
+			}
+			if ar, _ := env.P["ar"].([]any); len(ar) != 1 || ar[0] != "area-1" {
+				t.Errorf("ar: got %v, want [area-1]", env.P["ar"])
+			}
+		}
+	})
+
+	t.Run("import dry run", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "errands.taskpaper")
+		os.WriteFile(path, []byte("Errands:\n\t- Post office\n"), 0o600)
+		before := len(fc.getCommitLog())
+		var stdout, stderr bytes.Buffer
+		code := runCLI([]string{"import", "--dry-run", "--credentials", creds, path}, &stdout, &stderr)
+		if code != 0 {
+			t.Fatalf("exit code %d, stderr: %s", code, stderr.String())
+		}
+		if !strings.HasPrefix(stdout.String(), "dry run: would create 0 areas, 1 projects, 0 headings, 1 tasks") {
+			t.Errorf("unexpected output: %s", stdout.String())
+		}
+		if len(fc.getCommitLog()) != before {
+			t.Error("dry run should not write")
+		}
+	})
+
+	t.Run("complete unknown uuid fails", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		if code := runCLI([]string{"complete", "nope", "--credentials", creds}, &stdout, &stderr); code != 1 {
+			t.Errorf("exit code %d, want 1", code)
+		}
+		if !strings.Contains(stderr.String(), "task not found") {
+			t.Errorf("stderr: %s", stderr.String())
+		}
+	})
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Cluster mode
+// ---------------------------------------------------------------------------
+
+// In cluster mode every account is owned by one instance, chosen by
+// rendezvous hashing on the email over the live members, so that only one
+// ThingsMCP per account commits to Things Cloud. Requests for an account
+// that arrive elsewhere are forwarded to its owner. When a member leaves,
+// only the accounts it owned move, each to the member that ranks next for
+// it.
+//
+// Members are listed in CLUSTER_PEERS and checked through /readyz, or,
+// without it, find each other through heartbeats in the storage.
+
+const (
+	// clusterRefreshInterval is how often members are checked or
+	// heartbeats written; a member missing clusterMemberTTL is gone.
+	clusterRefreshInterval = 5 * time.Second
+	clusterMemberTTL       = 3 * clusterRefreshInterval
+	clusterCheckTimeout    = 2 * time.Second
+	// clusterForwardedHeader marks a request forwarded by another member.
+	// The receiver serves it itself even if its view of the members
+	// differs, so requests never bounce between instances.
+	clusterForwardedHeader = "X-Things-Cluster-Forwarded"
+)
+
+type cluster struct {
+	self    string   // URL other members reach this instance at
+	peers   []string // static members, self included; nil discovers them through store
+	store   Storage
+	token   string // value of clusterForwardedHeader
+	client  *http.Client
+	proxies sync.Map // member URL -> *httputil.ReverseProxy
+
+	mu       sync.RWMutex
+	members  []string // live members, sorted; includes self until it leaves
+	leaving  bool
+	onChange func() // called after the members change
+}
+
+// clusterFromEnv configures cluster mode from CLUSTER_SELF and
+// CLUSTER_PEERS, or returns nil when CLUSTER_SELF is unset. secret signs
+// forwarded requests and must be the same on every member, as the JWT
+// secret is.
+func clusterFromEnv(store Storage, secret []byte) (*cluster, error) {
+	self := os.Getenv("CLUSTER_SELF")
+	if self == "" {
+		return nil, nil
+	}
+	var peers []string
+	for _, p := range strings.Split(os.Getenv("CLUSTER_PEERS"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			peers = append(peers, p)
+		}
+	}
+	return newCluster(self, peers, store, secret)
+}
+
+func newCluster(self string, peers []string, store Storage, secret []byte) (*cluster, error) {
+	self, err := normalizeMemberURL(self)
+	if err != nil {
+		return nil, fmt.Errorf("CLUSTER_SELF: %w", err)
+	}
+	c := &cluster{
+		self:    self,
+		store:   store,
+		token:   clusterToken(secret),
+		client:  &http.Client{Timeout: clusterCheckTimeout},
+		members: []string{self},
+	}
+	if len(peers) > 0 {
+		c.peers = []string{self}
+		for _, p := range peers {
+			p, err := normalizeMemberURL(p)
+			if err != nil {
+				return nil, fmt.Errorf("CLUSTER_PEERS: %w", err)
+			}
+			if !slices.Contains(c.peers, p) {
+				c.peers = append(c.peers, p)
+			}
+		}
+		slices.Sort(c.peers)
+	} else if store == nil {
+		return nil, fmt.Errorf("cluster mode needs CLUSTER_PEERS or shared storage")
+	}
+	return c, nil
+}
+
+func normalizeMemberURL(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("%q is not an http(s) URL", raw)
+	}
+	return u.Scheme + "://" + u.Host, nil
+}
+
+func clusterToken(secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("cluster-forward"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// owner returns the member that owns email: the one ranking highest for
+// it. Removing a member only moves the accounts it owned.
+func (c *cluster) owner(email string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return rendezvousOwner(c.members, email)
+}
+
+func rendezvousOwner(members []string, email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	var best string
+	var bestScore uint64
+	for _, m := range members {
+		h := fnv.New64a()
+		h.Write([]byte(m))
+		h.Write([]byte{0})
+		h.Write([]byte(email))
+		// Mix the bits so that the scores of similar URLs do not correlate.
+		score := h.Sum64()
+		score ^= score >> 33
+		score *= 0xff51afd7ed558ccd
+		score ^= score >> 33
+		if best == "" || score > bestScore {
+			best, bestScore = m, score
+		}
+	}
+	return best
+}
+
+// owns reports whether this instance owns email.
+func (c *cluster) owns(email string) bool {
+	return c.owner(email) == c.self
+}
+
+func (c *cluster) liveMembers() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return slices.Clone(c.members)
+}
+
+// setMembers replaces the live members. On a change it calls onChange in
+// the background, unless this instance is leaving and about to drain its
+// calls anyway.
+func (c *cluster) setMembers(members []string) {
+	slices.Sort(members)
+	members = slices.Compact(members)
+	c.mu.Lock()
+	if c.leaving {
+		members = slices.DeleteFunc(members, func(m string) bool { return m == c.self })
+	}
+	changed := !slices.Equal(c.members, members)
+	c.members = members
+	onChange := c.onChange
+	if c.leaving {
+		onChange = nil
+	}
+	c.mu.Unlock()
+	if changed {
+		logServer.Info("cluster members changed", "members", members)
+		if onChange != nil {
+			go onChange()
+		}
+	}
+}
+
+// markDown drops a member that could not be reached until the next
+// refresh finds it alive again.
+func (c *cluster) markDown(member string) {
+	c.setMembers(slices.DeleteFunc(c.liveMembers(), func(m string) bool { return m == member }))
+}
+
+// refresh finds the live members: the peers whose /readyz answers, or
+// the members whose heartbeat in the storage is recent, after writing
+// this instance's own.
+func (c *cluster) refresh(ctx context.Context) {
+	c.mu.RLock()
+	leaving := c.leaving
+	c.mu.RUnlock()
+	if leaving {
+		return
+	}
+	if c.peers == nil {
+		now := time.Now()
+		if err := c.store.Heartbeat(c.self, now); err != nil {
+			logServer.WarnContext(ctx, "cluster heartbeat failed", "error", err)
+		}
+		members, err := c.store.Members(now.Add(-clusterMemberTTL))
+		if err != nil {
+			logServer.WarnContext(ctx, "list cluster members failed", "error", err)
+			return
+		}
+		c.setMembers(append(members, c.self))
+		return
+	}
+	live := make([]string, len(c.peers))
+	var wg sync.WaitGroup
+	for i, p := range c.peers {
+		if p == c.self {
+			live[i] = p
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if c.ready(ctx, p) {
+				live[i] = p
+			}
+		}()
+	}
+	wg.Wait()
+	c.setMembers(slices.DeleteFunc(live, func(m string) bool { return m == "" }))
+}
+
+func (c *cluster) ready(ctx context.Context, member string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, member+"/readyz", nil)
+	if err != nil {
+		return false
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// run refreshes the members every clusterRefreshInterval until stop is
+// done.
+func (c *cluster) run(stop context.Context) {
+	c.refresh(stop)
+	ticker := time.NewTicker(clusterRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop.Done():
+			return
+		case <-ticker.C:
+			c.refresh(stop)
+		}
+	}
+}
+
+// leave hands this instance's accounts to the other members: it stops
+// counting itself as a member and removes its heartbeat, so that the
+// others stop routing to it. Peers listed in CLUSTER_PEERS notice through
+// /readyz, which fails once shutdown begins.
+func (c *cluster) leave() {
+	c.mu.Lock()
+	c.leaving = true
+	c.mu.Unlock()
+	c.setMembers(c.liveMembers())
+	if c.peers == nil {
+		if err := c.store.LeaveCluster(c.self); err != nil {
+			logServer.Warn("leaving the cluster failed", "error", err)
+		}
+	}
+}
+
+// forwarded reports whether r was forwarded by another member.
+func (c *cluster) forwarded(r *http.Request) bool {
+	return hmac.Equal([]byte(r.Header.Get(clusterForwardedHeader)), []byte(c.token))
+}
+
+// forward proxies r to member. The original Host is kept so that URLs
+// derived from it, such as the token issuer, do not change.
+func (c *cluster) forward(w http.ResponseWriter, r *http.Request, member string) {
+	p, ok := c.proxies.Load(member)
+	if !ok {
+		target, _ := url.Parse(member)
+		p, _ = c.proxies.LoadOrStore(member, &httputil.ReverseProxy{
+			Rewrite: func(pr *httputil.ProxyRequest) {
+				pr.SetURL(target)
+				pr.Out.Host = pr.In.Host
+				// Keep the headers set by the load balancer in front:
+				// getBaseURL relies on X-Forwarded-Proto.
+				for _, h := range []string{"X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host"} {
+					if v := pr.In.Header.Values(h); len(v) > 0 {
+						pr.Out.Header[h] = v
+					}
+				}
+				pr.Out.Header.Set(clusterForwardedHeader, c.token)
+				pr.Out.Header.Set(requestIDHeader, requestIDFrom(pr.In.Context()))
+			},
+			FlushInterval: -1,
+			// Counted once the member answers, so a failed forward is
+			// only counted as an error.
+			ModifyResponse: func(*http.Response) error {
+				metrics.clusterForwards.Inc("ok")
+				return nil
+			},
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				metrics.clusterForwards.Inc("error")
+				logServer.WarnContext(r.Context(), "forwarding to cluster member failed", "member", member, "error", err)
+				c.markDown(member)
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "The instance serving this account is unavailable; retry shortly.", http.StatusServiceUnavailable)
+			},
+		})
+	}
+	p.(*httputil.ReverseProxy).ServeHTTP(w, r)
+}
+
+// routeByAccount serves requests for accounts owned by this instance with
+// next and forwards the others to their owner. account names the account
+// a request is for, or "" when it cannot tell; those are served here.
+func (um *UserManager) routeByAccount(account func(*http.Request) string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := um.cluster
+		if c == nil || c.forwarded(r) {
+			next(w, r)
+			return
+		}
+		email := account(r)
+		if email == "" {
+			next(w, r)
+			return
+		}
+		if owner := c.owner(email); owner != "" && owner != c.self {
+			c.forward(w, r, owner)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// mcpAccount returns the account an /mcp request authenticates as.
+func (um *UserManager) mcpAccount(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if token, ok := strings.CutPrefix(auth, "Bearer "); ok && um.oauth != nil {
+		if claims, err := um.oauth.parseJWT(token); err == nil {
+			email, _ := claims["sub"].(string)
+			return email
+		}
+		return ""
+	}
+	if encoded, ok := strings.CutPrefix(auth, "Basic "); ok {
+		if decoded, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+			email, _, _ := strings.Cut(string(decoded), ":")
+			return email
+		}
+	}
+	return ""
+}
+
+// calendarAccount returns the account of a calendar feed URL.
+func (um *UserManager) calendarAccount(r *http.Request) string {
+	token := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/ical/"), ".ics")
+	if um.calendarFeeds == nil || !calendarFeedTokenRe.MatchString(token) {
+		return ""
+	}
+	email, _, _ := um.calendarFeeds.Lookup(token)
+	return email
+}
+
+// ownsAccount reports whether this instance serves email: always outside
+// cluster mode.
+func (um *UserManager) ownsAccount(email string) bool {
+	return um.cluster == nil || um.cluster.owns(email)
+}
+
+// releaseAccounts drops the ThingsMCP of every account now owned by
+// another member, once its call in progress ends, so that a later return
+// of the account starts from a fresh sync.
+func (um *UserManager) releaseAccounts() {
+	um.mu.RLock()
+	var released []string
+	for email := range um.users {
+		if !um.ownsAccount(email) {
+			released = append(released, email)
+		}
+	}
+	um.mu.RUnlock()
+	for _, email := range released {
+		um.mu.Lock()
+		t, ok := um.users[email]
+		if ok {
+			delete(um.users, email)
+		}
+		um.mu.Unlock()
+		if !ok {
+			continue
+		}
+		t.callMu.Lock()
+		t.callMu.Unlock()
+		logServer.Info("released account to another cluster member", "email", email, "owner", um.cluster.owner(email))
+	}
+}
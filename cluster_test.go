@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRendezvousOwnerMovesOnlyTheLeaversAccounts(t *testing.T) {
+	members := []string{"http://a:8080", "http://b:8080", "http://c:8080"}
+	before := map[string]string{}
+	counts := map[string]int{}
+	for i := range 3000 {
+		email := fmt.Sprintf("user%d@example.com", i)
+		before[email] = rendezvousOwner(members, email)
+		counts[before[email]]++
+	}
+	for _, m := range members {
+		if counts[m] < 800 {
+			t.Errorf("%s owns %d of 3000 accounts", m, counts[m])
+		}
+	}
+	if rendezvousOwner(members, " User1@Example.com") != before["user1@example.com"] {
+		t.Error("owner depends on the email's case")
+	}
+
+	// b leaves: its accounts move, nobody else's do.
+	rest := []string{"http://a:8080", "http://c:8080"}
+	for email, owner := range before {
+		after := rendezvousOwner(rest, email)
+		if owner != "http://b:8080" && after != owner {
+			t.Fatalf("%s moved from %s to %s", email, owner, after)
+		}
+	}
+}
+
+// testMember is a cluster member serving /mcp and /readyz. Its /mcp
+// replies with the member that served the request.
+type testMember struct {
+	name   string
+	um     *UserManager
+	server *httptest.Server
+}
+
+func newTestMember(t *testing.T, name string) *testMember {
+	m := &testMember{name: name, um: NewUserManager()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/mcp", m.um.routeByAccount(m.um.mcpAccount, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", m.name, getBaseURL(r))
+	}))
+	mux.HandleFunc("/readyz", m.um.handleReadyz)
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *testMember) call(t *testing.T, email string) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, m.server.URL+"/mcp", nil)
+	req.SetBasicAuth(email, "pw")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestClusterForwardsToTheOwner(t *testing.T) {
+	secret := []byte("shared-secret")
+	a, b := newTestMember(t, "a"), newTestMember(t, "b")
+	for _, m := range []*testMember{a, b} {
+		m.um.oauth = NewOAuthServer(m.um, t.TempDir())
+		t.Cleanup(func() { m.um.oauth.store.Close() })
+		c, err := newCluster(m.server.URL, []string{a.server.URL, b.server.URL}, nil, secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.onChange = m.um.releaseAccounts
+		m.um.cluster = c
+	}
+	for _, m := range []*testMember{a, b} {
+		m.um.cluster.refresh(t.Context())
+		if got := m.um.cluster.liveMembers(); len(got) != 2 {
+			t.Fatalf("%s sees members %v", m.name, got)
+		}
+	}
+
+	// Find an account owned by b.
+	var email string
+	for i := 0; email == ""; i++ {
+		if e := fmt.Sprintf("user%d@example.com", i); a.um.cluster.owner(e) == b.server.URL {
+			email = e
+		}
+	}
+	// The owner sees the Host and scheme the client used.
+	if status, body := a.call(t, email); status != http.StatusOK || body != "b https://"+a.server.Listener.Addr().String() {
+		t.Errorf("via a: %d %q", status, body)
+	}
+	if _, body := b.call(t, email); body != "b https://"+b.server.Listener.Addr().String() {
+		t.Errorf("via b: %q", body)
+	}
+	if got := metrics.clusterForwards.Value("ok"); got == 0 {
+		t.Error("forward not counted")
+	}
+
+	// A forwarded request is served where it lands, even if that member
+	// thinks another one owns the account.
+	req := httptest.NewRequest(http.MethodPost, "/mcp", nil)
+	req.SetBasicAuth(email, "pw")
+	req.Header.Set(clusterForwardedHeader, a.um.cluster.token)
+	rec := httptest.NewRecorder()
+	a.server.Config.Handler.ServeHTTP(rec, req)
+	if body := rec.Body.String(); body[:2] != "a " {
+		t.Errorf("forwarded request: %q", body)
+	}
+
+	// b draining hands its accounts to a: a's next check finds b not
+	// ready.
+	b.um.draining.Store(true)
+	a.um.cluster.refresh(t.Context())
+	if _, body := a.call(t, email); body[:2] != "a " {
+		t.Errorf("after b left: %q", body)
+	}
+	b.um.draining.Store(false)
+	a.um.cluster.refresh(t.Context())
+
+	// A member that stops answering is dropped at the first failed forward,
+	// which is counted as an error only.
+	b.server.Close()
+	ok, failed := metrics.clusterForwards.Value("ok"), metrics.clusterForwards.Value("error")
+	if status, _ := a.call(t, email); status != http.StatusServiceUnavailable {
+		t.Errorf("forward to a dead member: %d", status)
+	}
+	if metrics.clusterForwards.Value("ok") != ok || metrics.clusterForwards.Value("error") != failed+1 {
+		t.Errorf("failed forward counted as ok %v, error %v", metrics.clusterForwards.Value("ok")-ok, metrics.clusterForwards.Value("error")-failed)
+	}
+	if _, body := a.call(t, email); body[:2] != "a " {
+		t.Errorf("after b died: %q", body)
+	}
+}
+
+func TestClusterDiscoveryThroughStorage(t *testing.T) {
+	store, err := openSQLiteStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	a, _ := newCluster("http://a:8080", nil, store, []byte("s"))
+	b, _ := newCluster("http://b:8080/", nil, store, []byte("s"))
+	a.refresh(t.Context())
+	b.refresh(t.Context())
+	a.refresh(t.Context())
+	if got := a.liveMembers(); len(got) != 2 || got[1] != "http://b:8080" {
+		t.Fatalf("a sees %v", got)
+	}
+
+	b.leave()
+	if got := b.liveMembers(); len(got) != 1 || got[0] != "http://a:8080" {
+		t.Errorf("b after leaving sees %v", got)
+	}
+	b.refresh(t.Context()) // no heartbeat once left
+	a.refresh(t.Context())
+	if got := a.liveMembers(); len(got) != 1 {
+		t.Errorf("a after b left sees %v", got)
+	}
+
+	if _, err := newCluster("http://c:8080", nil, nil, []byte("s")); err == nil {
+		t.Error("cluster without peers or storage accepted")
+	}
+	if _, err := newCluster("c:8080", nil, store, []byte("s")); err == nil {
+		t.Error("invalid CLUSTER_SELF accepted")
+	}
+}
+
+func TestClusterReleasesMovedAccounts(t *testing.T) {
+	fc := newFakeCloud("moved@example.com", makeTaskItem("t-1", withTitle("Move")))
+	defer fc.Close()
+	um := NewUserManager()
+	um.users[fc.email] = newTestThingsMCP(t, fc)
+	c, _ := newCluster("http://a:8080", []string{"http://b:8080"}, nil, []byte("s"))
+	um.cluster = c
+	c.members = []string{"http://a:8080"}
+	if !um.ownsAccount(fc.email) {
+		t.Fatal("sole member does not own the account")
+	}
+
+	// The account moves to b only once its call in progress ends.
+	c.members = []string{"http://a:8080", "http://b:8080"}
+	for rendezvousOwner(c.members, fc.email) != c.members[1] {
+		c.members[1] += "0"
+	}
+	end := um.users[fc.email].beginCall(t.Context())
+	released := make(chan struct{})
+	go func() {
+		um.releaseAccounts()
+		close(released)
+	}()
+	select {
+	case <-released:
+		t.Fatal("released during a call")
+	case <-time.After(50 * time.Millisecond):
+	}
+	end()
+	<-released
+	if len(um.users) != 0 {
+		t.Error("moved account still loaded")
+	}
+}
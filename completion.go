@@ -0,0 +1,526 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	thingscloud "github.com/arthursoares/things-cloud-sdk"
+	memory "github.com/arthursoares/things-cloud-sdk/state/memory"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// ---------------------------------------------------------------------------
+// Argument completions
+// ---------------------------------------------------------------------------
+
+// completion/complete names a prompt or a resource, never a tool, so tool
+// arguments are completed when a client names the tool in a ref/prompt
+// reference. Values are UUIDs (or titles, for arguments that take names);
+// the titles they stand for go in _meta.labels, as the result has no other
+// place for them.
+
+// maxCompletions is the most values a completion may return.
+const maxCompletions = 100
+
+type completionKind int
+
+const (
+	completeTask completionKind = iota + 1
+	completeProject
+	completeItem // task or project
+	completeHeading
+	completeArea
+	completeTag
+	completeSchedule
+	completeProjectSchedule
+	completeRecurrence
+)
+
+// completionArg says how to complete an argument.
+type completionArg struct {
+	kind  completionKind
+	names bool // the argument takes titles, not UUIDs
+	list  bool // comma-separated: complete the last element
+}
+
+// completionArgs maps argument names to what they complete to, the same in
+// every tool and prompt.
+var completionArgs = map[string]completionArg{
+	"project_uuid": {kind: completeProject},
+	"heading_uuid": {kind: completeHeading},
+	"area_uuid":    {kind: completeArea},
+	"task_uuid":    {kind: completeTask},
+	"parent_uuid":  {kind: completeTag},
+	"tags":         {kind: completeTag, list: true},
+	"schedule":     {kind: completeSchedule},
+	"recurrence":   {kind: completeRecurrence},
+}
+
+// toolCompletionArgs holds the arguments whose meaning depends on the tool
+// or prompt, such as uuid.
+var toolCompletionArgs = map[string]map[string]completionArg{
+	"things_find_tasks": {
+		"tag":     {kind: completeTag, names: true},
+		"area":    {kind: completeArea, names: true},
+		"project": {kind: completeProject, names: true},
+	},
+	"things_find_projects": {
+		"tag":  {kind: completeTag, names: true},
+		"area": {kind: completeArea, names: true},
+	},
+	"things_create_project":       {"schedule": {kind: completeProjectSchedule}},
+	"things_show_task":            {"uuid": {kind: completeTask}},
+	"things_show_project":         {"uuid": {kind: completeProject}},
+	"things_item_history":         {"uuid": {kind: completeItem}},
+	"things_edit_item":            {"uuid": {kind: completeItem}},
+	"things_debug_raw":            {"uuid": {kind: completeItem}},
+	"things_edit_area":            {"uuid": {kind: completeArea}},
+	"things_delete_area":          {"uuid": {kind: completeArea}},
+	"things_edit_tag":             {"uuid": {kind: completeTag}},
+	"things_delete_tag":           {"uuid": {kind: completeTag}},
+	"things_create_calendar_feed": {"area": {kind: completeArea, list: true}, "tag": {kind: completeTag, list: true}},
+}
+
+func lookupCompletionArg(name, argument string) (completionArg, bool) {
+	if arg, ok := toolCompletionArgs[name][argument]; ok {
+		return arg, true
+	}
+	arg, ok := completionArgs[argument]
+	return arg, ok
+}
+
+// completer answers completion/complete for the prompts and the tools.
+type completer struct {
+	um      *UserManager
+	tools   map[string]mcp.Tool
+	prompts map[string]bool
+
+	// labels holds the titles of the values CompletePromptArgument
+	// returned until labelCompletions sends them. It is keyed by the
+	// request's context, which the server passes to both.
+	labels sync.Map
+}
+
+func newCompleter(um *UserManager, tools []server.ServerTool, prompts []server.ServerPrompt) *completer {
+	c := &completer{um: um, tools: map[string]mcp.Tool{}, prompts: map[string]bool{}}
+	for _, st := range tools {
+		c.tools[st.Tool.Name] = st.Tool
+	}
+	for _, sp := range prompts {
+		c.prompts[sp.Prompt.Name] = true
+	}
+	return c
+}
+
+// completion is one candidate value and what it stands for.
+type completion struct {
+	value string
+	label string
+}
+
+// CompletePromptArgument implements server.PromptCompletionProvider.
+func (c *completer) CompletePromptArgument(ctx context.Context, name string, argument mcp.CompleteArgument, cctx mcp.CompleteContext) (*mcp.Completion, error) {
+	found, err := c.complete(ctx, name, argument, cctx.Arguments)
+	if err != nil {
+		return nil, err
+	}
+	out := &mcp.Completion{Values: []string{}, Total: len(found)}
+	labels := map[string]any{}
+	for i, f := range found {
+		if i == maxCompletions {
+			out.HasMore = true
+			break
+		}
+		out.Values = append(out.Values, f.value)
+		if f.label != "" && f.label != f.value {
+			labels[f.value] = f.label
+		}
+	}
+	if len(labels) > 0 {
+		c.labels.Store(ctx, labels)
+	}
+	return out, nil
+}
+
+func (c *completer) complete(ctx context.Context, name string, argument mcp.CompleteArgument, args map[string]string) ([]completion, error) {
+	tool, isTool := c.tools[name]
+	if !isTool && !c.prompts[name] {
+		return nil, nil
+	}
+	if isTool {
+		if values := toolEnum(tool, argument.Name); values != nil {
+			return matchStrings(values, argument.Value), nil
+		}
+	}
+	arg, ok := lookupCompletionArg(name, argument.Name)
+	if !ok {
+		return nil, nil
+	}
+
+	prefix, value := "", argument.Value
+	if arg.list {
+		if i := strings.LastIndex(value, ","); i >= 0 {
+			prefix, value = value[:i+1], strings.TrimLeft(value[i+1:], " ")
+		}
+	}
+	var found []completion
+	switch arg.kind {
+	case completeSchedule, completeProjectSchedule:
+		found = matchCompletions(scheduleExamples(arg.kind, time.Now()), value)
+	case completeRecurrence:
+		found = matchCompletions(recurrenceExamples(time.Now()), value)
+	default:
+		t, err := c.account(ctx, tool, isTool)
+		if err != nil || t == nil {
+			return nil, err
+		}
+		defer t.beginCall(ctx)()
+		if err := t.syncAndRebuild(); err != nil && t.getState() == nil {
+			return nil, fmt.Errorf("sync: %w", err)
+		}
+		found = matchCompletions(entityCompletions(t.getState(), arg, args), value)
+	}
+	for i := range found {
+		found[i].value = prefix + found[i].value
+	}
+	return found, nil
+}
+
+// account returns the caller's account, confined to the areas its token is
+// limited to, or nil when the token may not read it.
+func (c *completer) account(ctx context.Context, tool mcp.Tool, isTool bool) (*ThingsMCP, error) {
+	t, err := getUserFromContext(ctx, c.um)
+	if err != nil {
+		return nil, err
+	}
+	grant, err := c.um.grantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !containsStr(grant.Scopes, scopeRead) || (isTool && grant.allows(tool) != nil) {
+		return nil, nil
+	}
+	if len(grant.Areas) > 0 {
+		t = t.confinedTo(grant.Areas)
+	}
+	return t, nil
+}
+
+// labelCompletions is an AfterComplete hook that puts the titles of the
+// values in _meta.labels. It must be registered wherever the completer is,
+// to take the titles CompletePromptArgument left for it.
+func (c *completer) labelCompletions(ctx context.Context, _ any, _ *mcp.CompleteRequest, result *mcp.CompleteResult) {
+	labels, ok := c.labels.LoadAndDelete(ctx)
+	if !ok || result == nil {
+		return
+	}
+	result.Meta = &mcp.Meta{AdditionalFields: map[string]any{"labels": labels}}
+}
+
+// toolEnum returns the allowed values of a tool argument, if it has any.
+func toolEnum(tool mcp.Tool, argument string) []string {
+	prop, _ := tool.InputSchema.Properties[argument].(map[string]any)
+	values, _ := prop["enum"].([]string)
+	return values
+}
+
+// entityCompletions lists the open items an argument can refer to, by
+// title.
+func entityCompletions(state *memory.State, arg completionArg, args map[string]string) []completion {
+	var out []completion
+	add := func(uuid, title string) {
+		if arg.names {
+			out = append(out, completion{value: title})
+		} else {
+			out = append(out, completion{value: uuid, label: title})
+		}
+	}
+	switch arg.kind {
+	case completeArea:
+		for _, area := range state.Areas {
+			add(area.UUID, area.Title)
+		}
+	case completeTag:
+		for _, tag := range state.Tags {
+			add(tag.UUID, tag.Title)
+		}
+	default:
+		project := args["project_uuid"]
+		for _, task := range state.Tasks {
+			if task.InTrash || task.Status != thingscloud.TaskStatusPending || !completesTo(arg.kind, task.Type) {
+				continue
+			}
+			if arg.kind == completeHeading && project != "" && !containsStr(task.ParentTaskIDs, project) {
+				continue
+			}
+			add(task.UUID, task.Title)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := strings.ToLower(out[i].label+out[i].value), strings.ToLower(out[j].label+out[j].value)
+		if a != b {
+			return a < b
+		}
+		return out[i].value < out[j].value
+	})
+	return out
+}
+
+func completesTo(kind completionKind, tp thingscloud.TaskType) bool {
+	switch kind {
+	case completeTask:
+		return tp == thingscloud.TaskTypeTask
+	case completeProject:
+		return tp == thingscloud.TaskTypeProject
+	case completeHeading:
+		return tp == thingscloud.TaskTypeHeading
+	case completeItem:
+		return tp == thingscloud.TaskTypeTask || tp == thingscloud.TaskTypeProject
+	}
+	return false
+}
+
+// scheduleExamples returns valid schedule values, with dates for tomorrow
+// and next Monday.
+func scheduleExamples(kind completionKind, now time.Time) []completion {
+	var out []completion
+	if kind == completeSchedule {
+		out = append(out, completion{value: "inbox"})
+	}
+	out = append(out, completion{value: "today"})
+	if kind == completeSchedule {
+		out = append(out, completion{value: "tonight"})
+	}
+	monday := now.AddDate(0, 0, (8-int(now.Weekday()))%7)
+	if monday.YearDay() == now.YearDay() {
+		monday = monday.AddDate(0, 0, 7)
+	}
+	return append(out,
+		completion{value: "anytime"},
+		completion{value: "someday"},
+		completion{value: now.AddDate(0, 0, 1).Format("2006-01-02"), label: "Tomorrow"},
+		completion{value: monday.Format("2006-01-02"), label: "Next Monday"},
+	)
+}
+
+// recurrenceExamples returns one rule of each form parseRecurrence accepts.
+func recurrenceExamples(now time.Time) []completion {
+	weekday := strings.ToLower(now.Weekday().String()[:3])
+	return []completion{
+		{value: "daily", label: "Every day"},
+		{value: "weekly", label: "Every week"},
+		{value: "weekly:" + weekday, label: "Every " + now.Weekday().String()},
+		{value: "weekly:mon,wed,fri", label: "Every Monday, Wednesday and Friday"},
+		{value: "monthly", label: "Every month"},
+		{value: fmt.Sprintf("monthly:%d", now.Day()), label: fmt.Sprintf("Every month on day %d", now.Day())},
+		{value: "monthly:last", label: "Every month on the last day"},
+		{value: "yearly", label: "Every year"},
+		{value: "every 2 days", label: "Every 2 days"},
+		{value: "every 2 weeks", label: "Every 2 weeks"},
+		{value: "none", label: "Stop repeating"},
+	}
+}
+
+func matchStrings(values []string, query string) []completion {
+	var list []completion
+	for _, v := range values {
+		list = append(list, completion{value: v})
+	}
+	return matchCompletions(list, query)
+}
+
+// matchCompletions keeps the candidates that match query and orders them
+// best first: titles or values starting with it, then titles with a word
+// starting with it, then titles containing its letters in order. Equal
+// matches keep their order in list.
+func matchCompletions(list []completion, query string) []completion {
+	q := strings.ToLower(strings.TrimSpace(query))
+	type ranked struct {
+		completion
+		rank int
+	}
+	var matches []ranked
+	for _, c := range list {
+		title := strings.ToLower(c.label)
+		if title == "" {
+			title = strings.ToLower(c.value)
+		}
+		rank := -1
+		switch {
+		case q == "", strings.HasPrefix(title, q), strings.HasPrefix(strings.ToLower(c.value), q):
+			rank = 0
+		case hasWordPrefix(title, q):
+			rank = 1
+		case isSubsequence(q, title):
+			rank = 2
+		}
+		if rank >= 0 {
+			matches = append(matches, ranked{c, rank})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].rank < matches[j].rank })
+	out := make([]completion, len(matches))
+	for i, m := range matches {
+		out[i] = m.completion
+	}
+	return out
+}
+
+func hasWordPrefix(s, prefix string) bool {
+	for _, word := range strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == '-' || r == '/' || r == ':' }) {
+		if strings.HasPrefix(word, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSubsequence reports whether the letters of q appear in s in order.
+func isSubsequence(q, s string) bool {
+	rs := []rune(s)
+	i := 0
+	for _, r := range q {
+		for i < len(rs) && rs[i] != r {
+			i++
+		}
+		if i == len(rs) {
+			return false
+		}
+		i++
+	}
+	return true
+}
+
+// ---------------------------------------------------------------------------
+// Prompts
+// ---------------------------------------------------------------------------
+
+// definePrompts returns prompts for common requests, whose arguments
+// complete like the tools' arguments of the same names.
+func definePrompts(um *UserManager) []server.ServerPrompt {
+	// resolve finds the item an argument names, or says why it cannot.
+	resolve := func(state *memory.State, argument, value string) (string, error) {
+		arg, _ := lookupCompletionArg("", argument)
+		for _, c := range entityCompletions(state, arg, nil) {
+			if c.value == value || strings.EqualFold(c.label, value) {
+				return fmt.Sprintf("%s (%q)", c.value, c.label), nil
+			}
+		}
+		return "", fmt.Errorf("unknown %s: %s", strings.TrimSuffix(argument, "_uuid"), value)
+	}
+	wrap := func(fn func(state *memory.State, args map[string]string) (string, error)) server.PromptHandlerFunc {
+		return func(ctx context.Context, req mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+			t, err := getUserFromContext(ctx, um)
+			if err != nil {
+				return nil, err
+			}
+			grant, err := um.grantFromContext(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if !containsStr(grant.Scopes, scopeRead) {
+				return nil, fmt.Errorf("prompts need the %s scope, which this token was not granted", scopeRead)
+			}
+			if len(grant.Areas) > 0 {
+				t = t.confinedTo(grant.Areas)
+			}
+			defer t.beginCall(ctx)()
+			if err := t.syncAndRebuild(); err != nil {
+				return nil, fmt.Errorf("sync: %w", err)
+			}
+			text, err := fn(t.getState(), req.Params.Arguments)
+			if err != nil {
+				return nil, err
+			}
+			return mcp.NewGetPromptResult(req.Params.Name, []mcp.PromptMessage{
+				mcp.NewPromptMessage(mcp.RoleUser, mcp.NewTextContent(text)),
+			}), nil
+		}
+	}
+
+	return []server.ServerPrompt{
+		{
+			Prompt: mcp.NewPrompt("things_add_task",
+				mcp.WithPromptDescription("Add a task to Things, filed under a project, heading or area, with tags, a schedule and a repeat rule."),
+				mcp.WithArgument("title", mcp.RequiredArgument(), mcp.ArgumentDescription("Task title")),
+				mcp.WithArgument("project_uuid", mcp.ArgumentDescription("Project to add the task to")),
+				mcp.WithArgument("heading_uuid", mcp.ArgumentDescription("Heading within the project")),
+				mcp.WithArgument("area_uuid", mcp.ArgumentDescription("Area to add the task to")),
+				mcp.WithArgument("tags", mcp.ArgumentDescription("Comma-separated tags")),
+				mcp.WithArgument("schedule", mcp.ArgumentDescription("When: today, tonight, anytime, someday, inbox or a date (YYYY-MM-DD)")),
+				mcp.WithArgument("recurrence", mcp.ArgumentDescription("Repeat rule, such as daily or weekly:mon,wed")),
+			),
+			Handler: wrap(func(state *memory.State, args map[string]string) (string, error) {
+				title := strings.TrimSpace(args["title"])
+				if title == "" {
+					return "", fmt.Errorf("title is required")
+				}
+				var lines []string
+				for _, name := range []string{"project_uuid", "heading_uuid", "area_uuid"} {
+					if v := strings.TrimSpace(args[name]); v != "" {
+						item, err := resolve(state, name, v)
+						if err != nil {
+							return "", err
+						}
+						lines = append(lines, fmt.Sprintf("- %s: %s", name, item))
+					}
+				}
+				if v := args["tags"]; strings.TrimSpace(v) != "" {
+					var tags []string
+					for _, tag := range strings.Split(v, ",") {
+						if tag = strings.TrimSpace(tag); tag == "" {
+							continue
+						}
+						item, err := resolve(state, "tags", tag)
+						if err != nil {
+							return "", err
+						}
+						tags = append(tags, item)
+					}
+					lines = append(lines, "- tags: "+strings.Join(tags, ", "))
+				}
+				for _, name := range []string{"schedule", "recurrence"} {
+					if v := strings.TrimSpace(args[name]); v != "" {
+						lines = append(lines, fmt.Sprintf("- %s: %s", name, v))
+					}
+				}
+				text := fmt.Sprintf("Create a task titled %q in Things with things_create_task.", title)
+				if len(lines) > 0 {
+					text += " Pass these arguments (UUIDs, with the titles they stand for in brackets):\n" + strings.Join(lines, "\n")
+				}
+				return text, nil
+			}),
+		},
+		{
+			Prompt: mcp.NewPrompt("things_review_project",
+				mcp.WithPromptDescription("Review a project: what is open, what is overdue and what to do next."),
+				mcp.WithArgument("project_uuid", mcp.RequiredArgument(), mcp.ArgumentDescription("Project to review")),
+			),
+			Handler: wrap(func(state *memory.State, args map[string]string) (string, error) {
+				project, err := resolve(state, "project_uuid", strings.TrimSpace(args["project_uuid"]))
+				if err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("Review the Things project %s. Call things_show_project with its UUID, then summarize the open tasks by heading, point out deadlines that have passed or are close, and suggest the next actions.", project), nil
+			}),
+		},
+		{
+			Prompt: mcp.NewPrompt("things_review_area",
+				mcp.WithPromptDescription("Review an area: its projects and loose tasks, and what needs attention."),
+				mcp.WithArgument("area_uuid", mcp.RequiredArgument(), mcp.ArgumentDescription("Area to review")),
+			),
+			Handler: wrap(func(state *memory.State, args map[string]string) (string, error) {
+				area, err := resolve(state, "area_uuid", strings.TrimSpace(args["area_uuid"]))
+				if err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("Review the Things area %s. Use things_find_projects and things_find_tasks filtered by the area's name to list its projects and tasks, then point out what is overdue, what has stalled and what to do next.", area), nil
+			}),
+		},
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	thingscloud "github.com/arthursoares/things-cloud-sdk"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// completionServer returns a server with the prompts and completions of
+// serve, and a function asking it to complete an argument.
+func completionServer(t *testing.T, um *UserManager, email string) func(ref, name, arg, value string, context map[string]string) (mcp.CompleteResult, bool) {
+	t.Helper()
+	tools, prompts := defineTools(um), definePrompts(um)
+	completions := newCompleter(um, tools, prompts)
+	hooks := &server.Hooks{}
+	hooks.AddAfterComplete(completions.labelCompletions)
+	srv := server.NewMCPServer("test", "1", server.WithCompletions(), server.WithPromptCompletionProvider(completions), server.WithHooks(hooks))
+	srv.AddTools(tools...)
+	srv.AddPrompts(prompts...)
+	ctx := context.WithValue(t.Context(), userContextKey, &UserInfo{Email: email, Password: "testpass"})
+	return func(ref, name, arg, value string, args map[string]string) (mcp.CompleteResult, bool) {
+		msg, _ := json.Marshal(map[string]any{
+			"jsonrpc": "2.0", "id": 1, "method": "completion/complete",
+			"params": map[string]any{
+				"ref":      map[string]any{"type": ref, "name": name},
+				"argument": map[string]any{"name": arg, "value": value},
+				"context":  map[string]any{"arguments": args},
+			},
+		})
+		resp, ok := srv.HandleMessage(ctx, msg).(mcp.JSONRPCResponse)
+		if !ok {
+			return mcp.CompleteResult{}, false
+		}
+		result, ok := resp.Result.(mcp.CompleteResult)
+		return result, ok
+	}
+}
+
+func TestCompletions(t *testing.T) {
+	fc := newFakeCloud("complete@example.com",
+		makeAreaItem("area-work", "Work"),
+		makeAreaItem("area-home", "Home"),
+		makeTagItem("tag-errand", "Errand"),
+		makeTagItem("tag-urgent", "Urgent"),
+		makeTaskItem("proj-launch", withTitle("Product Launch"), withTaskType(thingscloud.TaskTypeProject), withArea("area-work")),
+		makeTaskItem("proj-garden", withTitle("Garden"), withTaskType(thingscloud.TaskTypeProject), withArea("area-home")),
+		makeTaskItem("proj-old", withTitle("Old Launch"), withTaskType(thingscloud.TaskTypeProject), withTrashed()),
+		makeTaskItem("head-docs", withTitle("Docs"), withTaskType(thingscloud.TaskTypeHeading), withParent("proj-launch")),
+		makeTaskItem("head-beds", withTitle("Beds"), withTaskType(thingscloud.TaskTypeHeading), withParent("proj-garden")),
+		makeTaskItem("task-1", withTitle("Write launch post")),
+	)
+	defer fc.Close()
+	um := NewUserManager()
+	um.users[fc.email] = newTestThingsMCP(t, fc)
+	complete := completionServer(t, um, fc.email)
+
+	// Titles match by prefix, then by word, then by letters in order, and
+	// values are UUIDs labelled with the titles.
+	got, ok := complete("ref/prompt", "things_create_task", "project_uuid", "la", nil)
+	if !ok || len(got.Completion.Values) != 1 || got.Completion.Values[0] != "proj-launch" {
+		t.Fatalf("project completions: %+v", got.Completion)
+	}
+	if got.Meta == nil || got.Meta.AdditionalFields["labels"].(map[string]any)["proj-launch"] != "Product Launch" {
+		t.Errorf("labels: %+v", got.Meta)
+	}
+	got, _ = complete("ref/prompt", "things_create_task", "project_uuid", "grd", nil)
+	if len(got.Completion.Values) != 1 || got.Completion.Values[0] != "proj-garden" {
+		t.Errorf("fuzzy project completions: %v", got.Completion.Values)
+	}
+	got, _ = complete("ref/prompt", "things_edit_item", "uuid", "", nil)
+	if len(got.Completion.Values) != 3 || got.Completion.Values[0] != "proj-garden" {
+		t.Errorf("item completions: %v", got.Completion.Values)
+	}
+
+	// Headings narrow to the project already chosen.
+	got, _ = complete("ref/prompt", "things_add_task", "heading_uuid", "", map[string]string{"project_uuid": "proj-garden"})
+	if len(got.Completion.Values) != 1 || got.Completion.Values[0] != "head-beds" {
+		t.Errorf("heading completions: %v", got.Completion.Values)
+	}
+
+	// Lists complete their last element; arguments that take names get titles.
+	got, _ = complete("ref/prompt", "things_create_task", "tags", "tag-errand,ur", nil)
+	if len(got.Completion.Values) != 1 || got.Completion.Values[0] != "tag-errand,tag-urgent" {
+		t.Errorf("tag list completions: %v", got.Completion.Values)
+	}
+	got, _ = complete("ref/prompt", "things_find_tasks", "area", "h", nil)
+	if len(got.Completion.Values) != 1 || got.Completion.Values[0] != "Home" {
+		t.Errorf("area name completions: %v", got.Completion.Values)
+	}
+
+	// Enums complete from the tool, free text from examples.
+	got, _ = complete("ref/prompt", "things_find_tasks", "schedule", "up", nil)
+	if len(got.Completion.Values) != 1 || got.Completion.Values[0] != "upcoming" {
+		t.Errorf("enum completions: %v", got.Completion.Values)
+	}
+	got, _ = complete("ref/prompt", "things_add_task", "recurrence", "weekly", nil)
+	if len(got.Completion.Values) < 3 || got.Completion.Values[0] != "weekly" {
+		t.Errorf("recurrence completions: %v", got.Completion.Values)
+	}
+	got, _ = complete("ref/prompt", "things_edit_item", "schedule", "", nil)
+	if tomorrow := time.Now().AddDate(0, 0, 1).Format("2006-01-02"); !containsStr(got.Completion.Values, tomorrow) || !containsStr(got.Completion.Values, "tonight") {
+		t.Errorf("schedule completions: %v", got.Completion.Values)
+	}
+
+	// Unknown names and arguments complete to nothing.
+	got, ok = complete("ref/prompt", "things_nope", "project_uuid", "", nil)
+	if !ok || len(got.Completion.Values) != 0 {
+		t.Errorf("unknown prompt: %+v", got.Completion)
+	}
+}
+
+func TestCompletionsRespectTokenLimits(t *testing.T) {
+	um, _ := newTestScopeServer(t,
+		makeAreaItem("area-work", "Work"),
+		makeAreaItem("area-home", "Home"),
+		makeTaskItem("proj-launch", withTitle("Launch"), withTaskType(thingscloud.TaskTypeProject), withArea("area-work")),
+		makeTaskItem("proj-garden", withTitle("Garden"), withTaskType(thingscloud.TaskTypeProject), withArea("area-home")),
+	)
+	c := newCompleter(um, defineTools(um), definePrompts(um))
+	arg := mcp.CompleteArgument{Name: "project_uuid"}
+
+	ctx := bearerContext(t, um, scopeRead, "area-work")
+	got, err := c.CompletePromptArgument(ctx, "things_review_project", arg, mcp.CompleteContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Values) != 1 || got.Values[0] != "proj-launch" {
+		t.Errorf("area-limited completions: %v", got.Values)
+	}
+	// The labels computed for the request are sent with its result, without
+	// completing again.
+	var result mcp.CompleteResult
+	c.labelCompletions(t.Context(), nil, nil, &result)
+	if result.Meta != nil {
+		t.Errorf("labels for another request: %+v", result.Meta)
+	}
+	c.labelCompletions(ctx, nil, nil, &result)
+	if result.Meta == nil || result.Meta.AdditionalFields["labels"].(map[string]any)["proj-launch"] != "Launch" {
+		t.Errorf("labels: %+v", result.Meta)
+	}
+	if _, ok := c.labels.Load(ctx); ok {
+		t.Error("labels kept after they were sent")
+	}
+
+	// Without the read scope nothing is listed.
+	got, err = c.CompletePromptArgument(bearerContext(t, um, scopeWrite), "things_create_task", arg, mcp.CompleteContext{})
+	if err != nil || len(got.Values) != 0 {
+		t.Errorf("write-only completions: %v, %v", got.Values, err)
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	thingscloud "github.com/arthursoares/things-cloud-sdk"
+	memory "github.com/arthursoares/things-cloud-sdk/state/memory"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// ---------------------------------------------------------------------------
+// Confirmation of destructive calls
+// ---------------------------------------------------------------------------
+
+const (
+	// confirmDefaultThreshold asks for confirmation whenever a call would
+	// delete, trash or cancel anything at all.
+	confirmDefaultThreshold = 1
+	confirmTokenTTL         = 5 * time.Minute
+)
+
+// ConfirmStore keeps each user's confirmation threshold and the
+// confirmation tokens handed out and not yet used.
+type ConfirmStore struct {
+	db *sqlDB
+
+	mu      sync.Mutex
+	pending map[string]pendingConfirmation // keyed by token
+}
+
+// pendingConfirmation is a previewed call waiting to be repeated with its
+// token. The token only confirms the exact same call.
+type pendingConfirmation struct {
+	email     string
+	tool      string
+	arguments string
+	expires   time.Time
+}
+
+func NewConfirmStore(db *sqlDB) *ConfirmStore {
+	return &ConfirmStore{db: db, pending: make(map[string]pendingConfirmation)}
+}
+
+// Threshold returns how many items a call must affect before it needs
+// confirmation; 0 means never.
+func (cs *ConfirmStore) Threshold(email string) int {
+	n := confirmDefaultThreshold
+	cs.db.QueryRow(`SELECT threshold FROM confirm_settings WHERE email = ?`, email).Scan(&n)
+	return n
+}
+
+func (cs *ConfirmStore) SetThreshold(email string, n int) error {
+	_, err := cs.db.Exec(
+		`INSERT INTO confirm_settings (email, threshold) VALUES (?, ?)
+		ON CONFLICT(email) DO UPDATE SET threshold = excluded.threshold`,
+		email, n,
+	)
+	if err != nil {
+		return fmt.Errorf("store confirmation threshold: %w", err)
+	}
+	return nil
+}
+
+// Issue hands out a token confirming one call, and drops expired ones.
+func (cs *ConfirmStore) Issue(email, tool, arguments string) (string, time.Time) {
+	token := randomString(24)
+	expires := time.Now().Add(confirmTokenTTL)
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	now := time.Now()
+	for k, p := range cs.pending {
+		if now.After(p.expires) {
+			delete(cs.pending, k)
+		}
+	}
+	cs.pending[token] = pendingConfirmation{email: email, tool: tool, arguments: arguments, expires: expires}
+	return token, expires
+}
+
+// Redeem uses up a token. It fails if the token is unknown, expired or was
+// issued for a different call.
+func (cs *ConfirmStore) Redeem(token, email, tool, arguments string) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	p, ok := cs.pending[token]
+	if !ok || p.email != email || time.Now().After(p.expires) {
+		return errors.New("confirm_token is unknown or expired; call again without it to get a new one")
+	}
+	if p.tool != tool || p.arguments != arguments {
+		return errors.New("confirm_token was issued for a different call; repeat the previewed call unchanged")
+	}
+	delete(cs.pending, token)
+	return nil
+}
+
+// confirmationOutput is returned instead of running a destructive call.
+type confirmationOutput struct {
+	Status       string       `json:"status"` // always "confirmation_required"
+	Summary      string       `json:"summary"`
+	Affected     int          `json:"affected"`
+	Diff         []dryRunDiff `json:"diff"`
+	Warnings     []string     `json:"warnings"`
+	ConfirmToken string       `json:"confirmToken"`
+	ExpiresAt    string       `json:"expiresAt"`
+	Next         string       `json:"next"`
+}
+
+// destructiveCall reports whether a call can delete, trash or cancel items.
+// Only these are previewed for confirmation.
+func destructiveCall(tool mcp.Tool, req mcp.CallToolRequest) bool {
+	switch {
+	case serverSideTools[tool.Name]:
+		return false
+	case toolScope(tool) == scopeDelete:
+		return true
+	case tool.Name == "things_edit_item":
+		status := req.GetString("status", "")
+		return status == "canceled" || status == "trashed"
+	}
+	return false
+}
+
+// confirmArguments is the canonical form of a call's arguments that a
+// token is bound to.
+func confirmArguments(req mcp.CallToolRequest) string {
+	args := map[string]any{}
+	for k, v := range req.GetArguments() {
+		if k != "confirm_token" && k != "dry_run" {
+			args[k] = v
+		}
+	}
+	raw, _ := json.Marshal(args)
+	return string(raw)
+}
+
+// confirmTools makes destructive calls two-phase. The first call runs as a
+// dry run; if it would affect at least the user's threshold of items, the
+// user is asked directly when the client supports elicitation, and
+// otherwise the response is an impact summary with a confirmation token.
+// The call only runs when repeated unchanged with that token.
+func (um *UserManager) confirmTools(tools []server.ServerTool) []server.ServerTool {
+	for i := range tools {
+		tool := tools[i].Tool
+		if serverSideTools[tool.Name] || (toolScope(tool) != scopeDelete && tool.Name != "things_edit_item") {
+			continue
+		}
+		mcp.WithString("confirm_token", mcp.Description("Token from a previous confirmation_required response; repeat the same call with it to go ahead"))(&tools[i].Tool)
+		alsoReturns[confirmationOutput]()(&tools[i].Tool)
+		next := tools[i].Handler
+		tools[i].Handler = func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			if um.confirm == nil || req.GetBool("dry_run", false) || !destructiveCall(tool, req) {
+				return next(ctx, req)
+			}
+			email, _, err := extractCredentials(ctx, um)
+			if err != nil {
+				return errResultFrom(err), nil
+			}
+			if token := req.GetString("confirm_token", ""); token != "" {
+				if err := um.confirm.Redeem(token, email, tool.Name, confirmArguments(req)); err != nil {
+					return errResultFrom(err), nil
+				}
+				return next(ctx, req)
+			}
+			threshold := um.confirm.Threshold(email)
+			if threshold == 0 {
+				return next(ctx, req)
+			}
+
+			dr := &dryRun{}
+			result, err := next(context.WithValue(ctx, dryRunKey{}, dr), req)
+			if err != nil || result == nil || result.IsError {
+				return result, err
+			}
+			t, err := getUserFromContext(ctx, um)
+			if err != nil {
+				return errResultFrom(err), nil
+			}
+			before := t.root().getState()
+			preview, after := previewWrites(before, dr.items)
+			affected := destructiveImpact(before, after, preview.Diff)
+			if affected < threshold {
+				return next(ctx, req)
+			}
+			summary := impactSummary(preview, affected)
+
+			switch confirmed, asked := elicitConfirmation(ctx, summary); {
+			case confirmed:
+				return next(ctx, req)
+			case asked:
+				return errResultCode(codeNotConfirmed, "Not confirmed by the user; nothing was changed."), nil
+			}
+			token, expires := um.confirm.Issue(email, tool.Name, confirmArguments(req))
+			return jsonResult(confirmationOutput{
+				Status:       "confirmation_required",
+				Summary:      summary,
+				Affected:     affected,
+				Diff:         preview.Diff,
+				Warnings:     preview.Warnings,
+				ConfirmToken: token,
+				ExpiresAt:    expires.UTC().Format(time.RFC3339),
+				Next:         fmt.Sprintf("Nothing was changed yet. Show the summary to the user and, if they agree, call %s again with the same arguments and confirm_token.", tool.Name),
+			}), nil
+		}
+	}
+	return tools
+}
+
+// destructiveImpact counts the open tasks and projects a change deletes,
+// trashes or cancels, directly or by taking away their area, tag, project
+// or heading, plus the areas, tags and checklist items it deletes.
+func destructiveImpact(before, after *memory.State, diff []dryRunDiff) int {
+	n := 0
+	for _, d := range diff {
+		if d.Action == "delete" && before.Tasks[d.UUID] == nil {
+			n++
+		}
+	}
+	closed := func(state *memory.State, uuid string) bool {
+		task := state.Tasks[uuid]
+		return task == nil || task.InTrash || task.Status == thingscloud.TaskStatusCanceled
+	}
+	gone := func(uuid string) bool { return closed(after, uuid) && !closed(before, uuid) }
+	for uuid, task := range before.Tasks {
+		if !isOpenItem(task) {
+			continue
+		}
+		updated := after.Tasks[uuid]
+		switch {
+		case gone(uuid):
+			n++
+		case projectOf(after, updated) != "" && gone(projectOf(after, updated)):
+			n++
+		case len(updated.ActionGroupIDs) > 0 && gone(updated.ActionGroupIDs[0]):
+			n++
+		default:
+			area := effectiveArea(before, task)
+			lost := area != "" && before.Areas[area] != nil && after.Areas[area] == nil
+			for _, tag := range task.TagIDs {
+				lost = lost || (before.Tags[tag] != nil && after.Tags[tag] == nil)
+			}
+			if lost {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// impactSummary phrases a preview for a person to approve.
+func impactSummary(preview dryRunOutput, affected int) string {
+	var lines []string
+	for _, d := range preview.Diff {
+		lines = append(lines, d.Changes...)
+	}
+	lines = append(lines, preview.Warnings...)
+	noun := "items"
+	if affected == 1 {
+		noun = "item"
+	}
+	return fmt.Sprintf("This affects %d %s:\n- %s", affected, noun, strings.Join(lines, "\n- "))
+}
+
+// elicitConfirmation asks the user to approve a change when the client
+// declared elicitation support. asked is false when the question could not
+// be put, in which case the caller falls back to a confirmation token.
+func elicitConfirmation(ctx context.Context, summary string) (confirmed, asked bool) {
+	srv := server.ServerFromContext(ctx)
+	session, ok := server.ClientSessionFromContext(ctx).(server.SessionWithClientInfo)
+	if srv == nil || !ok || session.GetClientCapabilities().Elicitation == nil {
+		return false, false
+	}
+	result, err := srv.RequestElicitation(ctx, mcp.ElicitationRequest{
+		Params: mcp.ElicitationParams{
+			Message: summary + "\n\nGo ahead?",
+			RequestedSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"confirm": map[string]any{"type": "boolean", "title": "Go ahead", "default": false},
+				},
+				"required": []string{"confirm"},
+			},
+		},
+	})
+	if err != nil {
+		return false, false
+	}
+	if result.Action != mcp.ElicitationResponseActionAccept {
+		return false, true
+	}
+	content, _ := result.Content.(map[string]any)
+	yes, _ := content["confirm"].(bool)
+	return yes, true
+}
+
+// confirmationSettingsOutput is what things_confirmation_settings returns.
+type confirmationSettingsOutput struct {
+	Threshold   int    `json:"threshold"`
+	Description string `json:"description"`
+	SettingsURL string `json:"settingsUrl,omitempty"`
+}
+
+// handleConfirmationSettings only shows the threshold. Changing it is left
+// to the signed-in /apps page, so the agent confirmation is meant to stop
+// cannot turn it off.
+func (um *UserManager) handleConfirmationSettings(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	if um.confirm == nil {
+		return errResultCode(codeUnavailable, "confirmation settings are not available on this server"), nil
+	}
+	email, _, err := extractCredentials(ctx, um)
+	if err != nil {
+		return errResultFrom(err), nil
+	}
+	out := confirmationSettingsOutput{Threshold: um.confirm.Threshold(email)}
+	out.Description = describeThreshold(out.Threshold)
+	if base := getBaseURLFromContext(ctx); base != "" {
+		out.SettingsURL = base + "/apps"
+	}
+	return jsonResult(out), nil
+}
+
+func describeThreshold(n int) string {
+	if n == 0 {
+		return "Destructive calls run without confirmation."
+	}
+	return fmt.Sprintf("Deletes, trashing and canceling that affect %d or more items need confirmation.", n)
+}
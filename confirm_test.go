@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	thingscloud "github.com/arthursoares/things-cloud-sdk"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func newTestConfirmServer(t *testing.T) (*UserManager, *fakeCloud, map[string]server.ServerTool, context.Context) {
+	t.Helper()
+	um, fc, tools, ctx := newTestToolServer(t, "confirm@example.com",
+		makeAreaItem("area-1", "Work"),
+		makeTagItem("tag-1", "Urgent"),
+		makeTagItem("tag-2", "Unused"),
+		makeTaskItem("proj-1", withTitle("Launch"), withTaskType(thingscloud.TaskTypeProject), withArea("area-1")),
+		makeTaskItem("t-1", withTitle("Write spec"), withParent("proj-1"), withTags("tag-1")),
+		makeTaskItem("t-2", withTitle("Email Bob"), withArea("area-1")),
+		makeChecklistItem("cl-1", "t-2", "Draft"),
+	)
+	um.confirm = NewConfirmStore(newTestOAuth(t, um).db)
+	return um, fc, tools, ctx
+}
+
+func TestConfirmDestructiveCalls(t *testing.T) {
+	um, fc, tools, ctx := newTestConfirmServer(t)
+	call := func(name string, args map[string]any) *mcp.CallToolResult {
+		t.Helper()
+		result, err := tools[name].Handler(ctx, makeReq(args))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	result := call("things_delete_area", map[string]any{"uuid": "area-1"})
+	assertNotError(t, result)
+	out := resultJSON[confirmationOutput](t, result)
+	if out.Status != "confirmation_required" || out.ConfirmToken == "" || out.Affected != 4 {
+		t.Fatalf("got %+v", out)
+	}
+	if !strings.Contains(out.Summary, `leaves 1 project and 2 tasks without an area`) {
+		t.Errorf("summary: %s", out.Summary)
+	}
+	if len(fc.getCommitLog()) != 0 {
+		t.Fatal("the first call must not write")
+	}
+
+	assertIsError(t, call("things_delete_area", map[string]any{"uuid": "area-1", "confirm_token": "made-up"}))
+	// A token only confirms the call it was issued for.
+	assertIsError(t, call("things_delete_tag", map[string]any{"uuid": "tag-1", "confirm_token": out.ConfirmToken}))
+	result = call("things_delete_area", map[string]any{"uuid": "area-1", "confirm_token": out.ConfirmToken})
+	assertNotError(t, result)
+	if len(fc.getCommitLog()) != 1 {
+		t.Fatalf("commits: got %d, want 1", len(fc.getCommitLog()))
+	}
+	assertIsError(t, call("things_delete_area", map[string]any{"uuid": "area-1", "confirm_token": out.ConfirmToken}))
+
+	// Trashing asks first; completing does not.
+	result = call("things_edit_item", map[string]any{"uuid": "proj-1", "status": "trashed"})
+	if out := resultJSON[confirmationOutput](t, result); out.Status != "confirmation_required" || out.Affected != 2 {
+		t.Errorf("trash project: %+v", out)
+	}
+	result = call("things_edit_item", map[string]any{"uuid": "t-2", "status": "completed"})
+	if text := resultText(t, result); strings.Contains(text, "confirmation_required") {
+		t.Errorf("completing should not ask: %s", text)
+	}
+	// Dry runs are previews already.
+	result = call("things_delete_checklist_item", map[string]any{"uuid": "cl-1", "dry_run": true})
+	if !resultJSON[dryRunOutput](t, result).DryRun {
+		t.Errorf("dry run: %s", resultText(t, result))
+	}
+
+	// The tool only shows the threshold; apps cannot lower it.
+	result = call("things_confirmation_settings", map[string]any{"threshold": 0})
+	if out := resultJSON[confirmationSettingsOutput](t, result); out.Threshold != 1 || um.confirm.Threshold("confirm@example.com") != 1 {
+		t.Fatalf("threshold changed through the tool: %+v", out)
+	}
+	if toolScope(tools["things_confirmation_settings"].Tool) != scopeRead {
+		t.Error("things_confirmation_settings should be read-only")
+	}
+
+	// With a higher threshold small deletes go straight through.
+	if err := um.confirm.SetThreshold("confirm@example.com", 3); err != nil {
+		t.Fatal(err)
+	}
+	commits := len(fc.getCommitLog())
+	assertNotError(t, call("things_delete_tag", map[string]any{"uuid": "tag-2"}))
+	if len(fc.getCommitLog()) != commits+1 {
+		t.Error("deleting an unused tag should not ask for confirmation")
+	}
+}
+
+// elicitingSession is a client session that answers elicitation requests
+// with a fixed response.
+type elicitingSession struct {
+	answer *mcp.ElicitationResult
+	asked  string
+}
+
+func (s *elicitingSession) SessionID() string { return "session-1" }
+func (s *elicitingSession) NotificationChannel() chan<- mcp.JSONRPCNotification {
+	return make(chan mcp.JSONRPCNotification, 1)
+}
+func (s *elicitingSession) Initialize()                                  {}
+func (s *elicitingSession) Initialized() bool                            { return true }
+func (s *elicitingSession) GetClientInfo() mcp.Implementation            { return mcp.Implementation{} }
+func (s *elicitingSession) SetClientInfo(mcp.Implementation)             {}
+func (s *elicitingSession) SetClientCapabilities(mcp.ClientCapabilities) {}
+func (s *elicitingSession) GetClientCapabilities() mcp.ClientCapabilities {
+	return mcp.ClientCapabilities{Elicitation: &mcp.ElicitationCapability{}}
+}
+func (s *elicitingSession) RequestElicitation(_ context.Context, req mcp.ElicitationRequest) (*mcp.ElicitationResult, error) {
+	s.asked = req.Params.Message
+	return s.answer, nil
+}
+
+func TestConfirmByElicitation(t *testing.T) {
+	um, fc, _, ctx := newTestConfirmServer(t)
+	srv := server.NewMCPServer("test", "1", server.WithElicitation())
+	srv.AddTools(defineTools(um)...)
+
+	callTool := func(session *elicitingSession, name string, args map[string]any) *mcp.CallToolResult {
+		t.Helper()
+		msg, _ := json.Marshal(map[string]any{
+			"jsonrpc": "2.0", "id": 1, "method": "tools/call",
+			"params": map[string]any{"name": name, "arguments": args},
+		})
+		resp, ok := srv.HandleMessage(srv.WithContext(ctx, session), msg).(mcp.JSONRPCResponse)
+		if !ok {
+			t.Fatalf("no result for %s", name)
+		}
+		result, ok := resp.Result.(*mcp.CallToolResult)
+		if !ok {
+			t.Fatalf("unexpected result %T", resp.Result)
+		}
+		return result
+	}
+
+	decline := &elicitingSession{answer: &mcp.ElicitationResult{ElicitationResponse: mcp.ElicitationResponse{Action: mcp.ElicitationResponseActionDecline}}}
+	assertIsError(t, callTool(decline, "things_delete_tag", map[string]any{"uuid": "tag-1"}))
+	if !strings.Contains(decline.asked, `deleting tag "Urgent" removes it from 1 task`) {
+		t.Errorf("question: %q", decline.asked)
+	}
+	if len(fc.getCommitLog()) != 0 {
+		t.Fatal("declined delete was written")
+	}
+
+	accept := &elicitingSession{answer: &mcp.ElicitationResult{ElicitationResponse: mcp.ElicitationResponse{
+		Action: mcp.ElicitationResponseActionAccept, Content: map[string]any{"confirm": true},
+	}}}
+	assertNotError(t, callTool(accept, "things_delete_tag", map[string]any{"uuid": "tag-1"}))
+	if len(fc.getCommitLog()) != 1 {
+		t.Errorf("accepted delete: got %d commits", len(fc.getCommitLog()))
+	}
+}
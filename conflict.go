@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// ---------------------------------------------------------------------------
+// Optimistic concurrency
+// ---------------------------------------------------------------------------
+
+// conflictOutput is returned instead of writing when an item was modified
+// after the modificationDate the caller passed as expected_modified. It is
+// the text of the failed call and the details of its conflict error.
+type conflictOutput struct {
+	Status           string             `json:"status"` // always "conflict"
+	UUID             string             `json:"uuid"`
+	ExpectedModified string             `json:"expectedModified"`
+	ModificationDate string             `json:"modificationDate"`
+	RemoteChanges    []itemHistoryEntry `json:"remoteChanges"`
+	Current          any                `json:"current"`
+	Next             string             `json:"next"`
+}
+
+const expectedModifiedDescription = "The modificationDate you last read for this item. If it has been modified since, e.g. on another device, nothing is written and the response describes the remote changes."
+
+// checkExpectedModified syncs with Things Cloud, bypassing the debounce,
+// and compares the item's modification date with expected, the value the
+// caller last read. It returns a result to send back instead of writing:
+// a conflict, or an error for a malformed value. It returns nil when
+// expected is empty, the item is unchanged, or the item is not found (the
+// handler reports that itself).
+func (t *ThingsMCP) checkExpectedModified(uuid, expected string) *mcp.CallToolResult {
+	if expected == "" {
+		return nil
+	}
+	want, err := time.Parse(time.RFC3339, expected)
+	if err != nil {
+		return errResult(fmt.Sprintf("expected_modified must be a modificationDate as returned by the read tools, e.g. 2025-03-01T09:30:00Z: %q", expected))
+	}
+	want = want.UTC().Truncate(time.Second)
+	if err := t.incrementalSync(); err != nil {
+		return errResultFrom(fmt.Errorf("sync: %w", err))
+	}
+
+	state := t.getState()
+	var modified *time.Time
+	var current any
+	if task, ok := state.Tasks[uuid]; ok {
+		modified, current = task.ModificationDate, t.taskToOutput(task)
+	} else if cl, ok := state.CheckListItems[uuid]; ok {
+		modified, current = cl.ModificationDate, checklistOutput(cl)
+	}
+	if modified == nil || !modified.UTC().Truncate(time.Second).After(want) {
+		return nil
+	}
+
+	out := conflictOutput{
+		Status:           "conflict",
+		UUID:             uuid,
+		ExpectedModified: want.Format(time.RFC3339),
+		ModificationDate: modified.UTC().Format(time.RFC3339),
+		RemoteChanges:    []itemHistoryEntry{},
+		Current:          current,
+		Next:             "Nothing was written. Check the remote changes with the user; to apply the change anyway, repeat the call with expected_modified set to modificationDate.",
+	}
+	root := t.root()
+	root.mu.RLock()
+	for _, ev := range root.timelines.byUUID[uuid] {
+		if ev.at.UTC().Truncate(time.Second).After(want) {
+			out.RemoteChanges = append(out.RemoteChanges, itemHistoryEntry{
+				Timestamp:   ev.Timestamp,
+				ServerIndex: ev.ServerIndex,
+				Type:        ev.Type,
+				Text:        describeChange(state, ev),
+				Details:     ev.Details,
+			})
+		}
+	}
+	root.mu.RUnlock()
+
+	text, _ := json.MarshalIndent(out, "", "  ")
+	result := errorResult(codeConflict, fmt.Sprintf("%s was modified after %s", uuid, out.ExpectedModified), out)
+	result.Content = []mcp.Content{mcp.NewTextContent(string(text))}
+	return result
+}
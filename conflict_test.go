@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	thingscloud "github.com/arthursoares/things-cloud-sdk"
+)
+
+func TestExpectedModified(t *testing.T) {
+	// Recent enough to be within the item timelines.
+	read := time.Now().UTC().Truncate(time.Minute).AddDate(0, 0, -1)
+	_, fc, tools, ctx := newTestToolServer(t, "conflict@example.com",
+		makeTaskItem("t-1", withTitle("Call the bank"), withModificationDate(read)),
+		makeTaskItem("t-2", withTitle("Renew passport"), withModificationDate(read)),
+	)
+
+	result, _ := tools["things_show_task"].Handler(ctx, makeReq(map[string]any{"uuid": "t-1"}))
+	expected := *resultJSON[TaskDetailOutput](t, result).ModificationDate
+	if expected != read.Format(time.RFC3339) {
+		t.Fatalf("modificationDate: %s", expected)
+	}
+
+	// Renamed on the phone a minute later.
+	remote := makeTaskItem("t-1", withTitle("Call the bank about the card"), withModificationDate(read.Add(time.Minute)))
+	remote.Action = thingscloud.ItemActionModified
+	fc.pushRemote(remote)
+
+	result, _ = tools["things_edit_item"].Handler(ctx, makeReq(map[string]any{"uuid": "t-1", "title": "Call the bank today", "expected_modified": expected}))
+	assertIsError(t, result)
+	conflict := resultJSON[conflictOutput](t, result)
+	if conflict.Status != "conflict" || conflict.ModificationDate != read.Add(time.Minute).Format(time.RFC3339) || len(conflict.RemoteChanges) != 1 ||
+		!strings.Contains(conflict.RemoteChanges[0].Text, "Call the bank about the card") {
+		t.Errorf("conflict: %+v", conflict)
+	}
+	if e, ok := result.StructuredContent.(toolError); !ok || e.Code != codeConflict || e.Details.(conflictOutput).UUID != "t-1" {
+		t.Errorf("conflict error: %#v", result.StructuredContent)
+	}
+	if len(fc.getCommitLog()) != 0 {
+		t.Fatal("a conflicting edit was written")
+	}
+
+	// Retrying with the new date goes through and returns the next one.
+	result, _ = tools["things_edit_item"].Handler(ctx, makeReq(map[string]any{"uuid": "t-1", "title": "Call the bank today", "expected_modified": conflict.ModificationDate}))
+	assertNotError(t, result)
+	if out := resultJSON[map[string]string](t, result); out["modificationDate"] == "" {
+		t.Errorf("edit result: %v", out)
+	}
+	// Unchanged items and calls without expected_modified are not affected.
+	result, _ = tools["things_edit_item"].Handler(ctx, makeReq(map[string]any{"uuid": "t-2", "title": "Renew passport now", "expected_modified": expected}))
+	assertNotError(t, result)
+	result, _ = tools["things_edit_item"].Handler(ctx, makeReq(map[string]any{"uuid": "t-2", "expected_modified": "yesterday"}))
+	assertIsError(t, result)
+}
+
+func TestCommitConflict(t *testing.T) {
+	_, fc, tools, ctx := newTestToolServer(t, "race@example.com",
+		makeTaskItem("t-1", withTitle("Call the bank")),
+	)
+
+	// A note added on the phone does not get in the way of a rename.
+	remote := makeTaskItem("t-1", withNote("Account number is on the letter"))
+	remote.Action = thingscloud.ItemActionModified
+	fc.pushRacing(remote)
+	result, _ := tools["things_edit_item"].Handler(ctx, makeReq(map[string]any{"uuid": "t-1", "title": "Call the bank today"}))
+	assertNotError(t, result)
+	if len(fc.getCommitLog()) != 1 {
+		t.Fatalf("commits: got %d, want 1", len(fc.getCommitLog()))
+	}
+
+	// A rename on the phone does.
+	remote = makeTaskItem("t-1", withTitle("Call the bank about the card"))
+	remote.Action = thingscloud.ItemActionModified
+	fc.pushRacing(remote)
+	result, _ = tools["things_edit_item"].Handler(ctx, makeReq(map[string]any{"uuid": "t-1", "title": "Call the bank now"}))
+	assertIsError(t, result)
+	if text := resultText(t, result); !strings.Contains(text, `"Call the bank about the card" changed on another device`) {
+		t.Errorf("error: %s", text)
+	}
+	if len(fc.getCommitLog()) != 1 {
+		t.Error("a conflicting edit was written")
+	}
+	result, _ = tools["things_show_task"].Handler(ctx, makeReq(map[string]any{"uuid": "t-1"}))
+	if title := resultJSON[TaskDetailOutput](t, result).Title; title != "Call the bank about the card" {
+		t.Errorf("state not synced after conflict: %q", title)
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	thingscloud "github.com/arthursoares/things-cloud-sdk"
+	memory "github.com/arthursoares/things-cloud-sdk/state/memory"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// ---------------------------------------------------------------------------
+// Dry runs
+// ---------------------------------------------------------------------------
+
+// serverSideTools change this server's own settings rather than Things, so
+// there is nothing to preview and they get no dry_run flag.
+var serverSideTools = map[string]bool{
+	"things_create_calendar_feed": true,
+	"things_revoke_calendar_feed": true,
+	"things_create_webhook":       true,
+	"things_delete_webhook":       true,
+}
+
+type dryRunKey struct{}
+
+// dryRun collects what writeAndSync would have written during a dry run.
+type dryRun struct {
+	mu    sync.Mutex
+	items []thingscloud.Identifiable
+}
+
+// dryRunFrom returns the dry run in progress for a tool call, or nil when
+// the call should really write.
+func dryRunFrom(ctx context.Context) *dryRun {
+	dr, _ := ctx.Value(dryRunKey{}).(*dryRun)
+	return dr
+}
+
+func (dr *dryRun) add(items []thingscloud.Identifiable) {
+	dr.mu.Lock()
+	dr.items = append(dr.items, items...)
+	dr.mu.Unlock()
+}
+
+// dryRunWrite is one item exactly as it would be sent to Things Cloud.
+type dryRunWrite struct {
+	UUID string          `json:"uuid"`
+	Wire json.RawMessage `json:"wire"`
+}
+
+type fieldDiff struct {
+	Field  string `json:"field"`
+	Before any    `json:"before"`
+	After  any    `json:"after"`
+}
+
+// dryRunDiff is what a dry run would do to one item.
+type dryRunDiff struct {
+	UUID    string      `json:"uuid"`
+	Kind    string      `json:"kind"` // task, project, heading, area, tag or checklist item
+	Title   string      `json:"title"`
+	Action  string      `json:"action"` // create, modify or delete
+	Changes []string    `json:"changes,omitempty"`
+	Fields  []fieldDiff `json:"fields,omitempty"`
+}
+
+type dryRunOutput struct {
+	DryRun   bool            `json:"dryRun"`
+	Result   json.RawMessage `json:"result,omitempty"`
+	Writes   []dryRunWrite   `json:"writes"`
+	Diff     []dryRunDiff    `json:"diff"`
+	Warnings []string        `json:"warnings"`
+}
+
+// dryRunTools adds a dry_run flag to every tool that writes to Things. A
+// dry run goes through the handler's validation and payload building, but
+// writeAndSync records the items instead of sending them; the response
+// shows them with a diff against the current state.
+func (um *UserManager) dryRunTools(tools []server.ServerTool) []server.ServerTool {
+	for i := range tools {
+		if toolScope(tools[i].Tool) == scopeRead || serverSideTools[tools[i].Tool.Name] {
+			continue
+		}
+		if _, ok := tools[i].Tool.InputSchema.Properties["dry_run"]; !ok {
+			mcp.WithBoolean("dry_run", mcp.Description("Validate and build the change without writing it; the response shows the wire payload, a before/after diff and warnings. Default: false"))(&tools[i].Tool)
+		}
+		alsoReturns[dryRunOutput]()(&tools[i].Tool)
+		next := tools[i].Handler
+		tools[i].Handler = func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			if !req.GetBool("dry_run", false) {
+				return next(ctx, req)
+			}
+			dr := &dryRun{}
+			result, err := next(context.WithValue(ctx, dryRunKey{}, dr), req)
+			if err != nil || result == nil || result.IsError {
+				return result, err
+			}
+			t, err := getUserFromContext(ctx, um)
+			if err != nil {
+				return errResultFrom(err), nil
+			}
+			out, _ := previewWrites(t.root().getState(), dr.items)
+			if text := resultContentText(result); json.Valid([]byte(text)) {
+				out.Result = json.RawMessage(text)
+			}
+			return jsonResult(out), nil
+		}
+	}
+	return tools
+}
+
+func resultContentText(r *mcp.CallToolResult) string {
+	if len(r.Content) == 0 {
+		return ""
+	}
+	tc, _ := r.Content[0].(mcp.TextContent)
+	return tc.Text
+}
+
+// previewWrites applies items to a copy of state and describes the
+// difference. The copy is returned too.
+func previewWrites(state *memory.State, items []thingscloud.Identifiable) (dryRunOutput, *memory.State) {
+	out := dryRunOutput{DryRun: true, Writes: []dryRunWrite{}, Diff: []dryRunDiff{}, Warnings: []string{}}
+
+	// Entities are copied before they are touched, since memory.State
+	// updates them in place.
+	after := &memory.State{
+		Areas:          copyMap(state.Areas),
+		Tasks:          copyMap(state.Tasks),
+		Tags:           copyMap(state.Tags),
+		CheckListItems: copyMap(state.CheckListItems),
+	}
+	var wire []thingscloud.Item
+	var order []string
+	seen := map[string]bool{}
+	for _, item := range items {
+		raw, err := json.Marshal(item)
+		if err != nil {
+			continue
+		}
+		out.Writes = append(out.Writes, dryRunWrite{UUID: item.UUID(), Wire: raw})
+		var w thingscloud.Item
+		if json.Unmarshal(raw, &w) != nil {
+			continue
+		}
+		w.UUID = item.UUID()
+		wire = append(wire, w)
+		if !seen[w.UUID] {
+			seen[w.UUID] = true
+			order = append(order, w.UUID)
+			cloneEntity(after, w.UUID)
+		}
+	}
+	events := applyTracked(after, wire, time.Now())
+
+	before := &ThingsMCP{state: state}
+	afterView := &ThingsMCP{state: after}
+	for _, uuid := range order {
+		d := diffEntity(before, afterView, uuid)
+		for _, ev := range events {
+			if ev.UUID == uuid {
+				d.Changes = append(d.Changes, describeChange(after, ev))
+			}
+		}
+		out.Diff = append(out.Diff, d)
+	}
+	out.Warnings = append(out.Warnings, dryRunWarnings(state, after, order)...)
+	return out, after
+}
+
+func copyMap[V any](m map[string]*V) map[string]*V {
+	out := make(map[string]*V, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+func cloneEntity(state *memory.State, uuid string) {
+	if v, ok := state.Tasks[uuid]; ok {
+		c := *v
+		state.Tasks[uuid] = &c
+	}
+	if v, ok := state.Areas[uuid]; ok {
+		c := *v
+		state.Areas[uuid] = &c
+	}
+	if v, ok := state.Tags[uuid]; ok {
+		c := *v
+		state.Tags[uuid] = &c
+	}
+	if v, ok := state.CheckListItems[uuid]; ok {
+		c := *v
+		state.CheckListItems[uuid] = &c
+	}
+}
+
+// entityFields renders an entity as the field map its diff is built from,
+// along with its kind and title.
+func entityFields(t *ThingsMCP, uuid string) (kind, title string, fields map[string]any) {
+	state := t.getState()
+	var v any
+	switch {
+	case state.Tasks[uuid] != nil:
+		task := state.Tasks[uuid]
+		kind, title = taskTypeName(task.Type), task.Title
+		out := t.taskToOutput(task)
+		out.ModificationDate, out.CreationDate = nil, nil
+		v = out
+	case state.Areas[uuid] != nil:
+		kind, title = "area", state.Areas[uuid].Title
+		v = map[string]any{"title": title}
+	case state.Tags[uuid] != nil:
+		tag := state.Tags[uuid]
+		kind, title = "tag", tag.Title
+		v = map[string]any{"title": tag.Title, "shorthand": tag.ShortHand, "parent": tagNames(state, tag.ParentTagIDs)}
+	case state.CheckListItems[uuid] != nil:
+		cl := state.CheckListItems[uuid]
+		kind, title = "checklist item", cl.Title
+		v = map[string]any{"title": cl.Title, "status": statusString(cl.Status), "task": taskTitle(state, firstOf(cl.TaskIDs))}
+	default:
+		return "", "", nil
+	}
+	raw, _ := json.Marshal(v)
+	json.Unmarshal(raw, &fields)
+	delete(fields, "uuid")
+	return kind, title, fields
+}
+
+func diffEntity(before, after *ThingsMCP, uuid string) dryRunDiff {
+	d := dryRunDiff{UUID: uuid}
+	kindB, titleB, b := entityFields(before, uuid)
+	kindA, titleA, a := entityFields(after, uuid)
+	switch {
+	case b == nil && a == nil:
+		d.Action = "modify"
+		return d
+	case b == nil:
+		d.Action, d.Kind, d.Title = "create", kindA, titleA
+	case a == nil:
+		d.Action, d.Kind, d.Title = "delete", kindB, titleB
+		return d
+	default:
+		d.Action, d.Kind, d.Title = "modify", kindA, titleB
+	}
+	keys := map[string]bool{}
+	for k := range b {
+		keys[k] = true
+	}
+	for k := range a {
+		keys[k] = true
+	}
+	var names []string
+	for k := range keys {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		bv, _ := json.Marshal(b[k])
+		av, _ := json.Marshal(a[k])
+		if string(bv) != string(av) {
+			d.Fields = append(d.Fields, fieldDiff{Field: k, Before: b[k], After: a[k]})
+		}
+	}
+	return d
+}
+
+// effectiveArea returns the area a task or project is in, directly or
+// through its project.
+func effectiveArea(state *memory.State, task *thingscloud.Task) string {
+	if len(task.AreaIDs) > 0 {
+		return task.AreaIDs[0]
+	}
+	if project, ok := state.Tasks[projectOf(state, task)]; ok && len(project.AreaIDs) > 0 {
+		return project.AreaIDs[0]
+	}
+	return ""
+}
+
+func isOpenItem(task *thingscloud.Task) bool {
+	return task.Type != thingscloud.TaskTypeHeading && task.Status == thingscloud.TaskStatusPending && !task.InTrash
+}
+
+// countItems phrases a number of projects and tasks, e.g. "1 project and 14 tasks".
+func countItems(projects, tasks int) string {
+	plural := func(n int, noun string) string {
+		if n == 1 {
+			return "1 " + noun
+		}
+		return fmt.Sprintf("%d %ss", n, noun)
+	}
+	switch {
+	case projects == 0:
+		return plural(tasks, "task")
+	case tasks == 0:
+		return plural(projects, "project")
+	}
+	return plural(projects, "project") + " and " + plural(tasks, "task")
+}
+
+// dryRunWarnings points out effects that reach beyond the items written:
+// tasks leaving an area along with their project, areas and tags that
+// are deleted while still in use, and projects closed with open tasks.
+func dryRunWarnings(before, after *memory.State, touched []string) []string {
+	var warnings []string
+	type counts struct{ projects, tasks int }
+	add := func(m map[string]*counts, key string, task *thingscloud.Task) {
+		if m[key] == nil {
+			m[key] = &counts{}
+		}
+		if task.Type == thingscloud.TaskTypeProject {
+			m[key].projects++
+		} else {
+			m[key].tasks++
+		}
+	}
+
+	movedOut := map[string]*counts{}
+	orphaned := map[string]*counts{}
+	untagged := map[string]int{}
+	for uuid, task := range before.Tasks {
+		if !isOpenItem(task) {
+			continue
+		}
+		area := effectiveArea(before, task)
+		if area != "" && after.Areas[area] == nil && before.Areas[area] != nil {
+			add(orphaned, area, task)
+		} else if updated, ok := after.Tasks[uuid]; ok && area != "" && effectiveArea(after, updated) != area {
+			add(movedOut, area, task)
+		}
+		for _, tag := range task.TagIDs {
+			if after.Tags[tag] == nil && before.Tags[tag] != nil {
+				untagged[tag]++
+			}
+		}
+	}
+	for _, area := range sortedKeys(movedOut) {
+		c := movedOut[area]
+		warnings = append(warnings, fmt.Sprintf("this will move %s out of area %q", countItems(c.projects, c.tasks), before.Areas[area].Title))
+	}
+	for _, area := range sortedKeys(orphaned) {
+		c := orphaned[area]
+		warnings = append(warnings, fmt.Sprintf("deleting area %q leaves %s without an area", before.Areas[area].Title, countItems(c.projects, c.tasks)))
+	}
+	for _, tag := range sortedKeys(untagged) {
+		warnings = append(warnings, fmt.Sprintf("deleting tag %q removes it from %s", before.Tags[tag].Title, countItems(0, untagged[tag])))
+	}
+
+	for _, uuid := range touched {
+		project, ok := before.Tasks[uuid]
+		updated := after.Tasks[uuid]
+		if !ok || project.Type != thingscloud.TaskTypeProject || !isOpenItem(project) || (updated != nil && isOpenItem(updated)) {
+			continue
+		}
+		open := 0
+		for _, task := range before.Tasks {
+			if task.UUID != uuid && isOpenItem(task) && projectOf(before, task) == uuid {
+				open++
+			}
+		}
+		if open > 0 {
+			warnings = append(warnings, fmt.Sprintf("project %q still has %s open; this only changes the project itself", project.Title, countItems(0, open)))
+		}
+	}
+	return warnings
+}
+
+// projectOf returns the UUID of the project a task is in, directly or
+// under a heading.
+func projectOf(state *memory.State, task *thingscloud.Task) string {
+	if len(task.ParentTaskIDs) > 0 {
+		return task.ParentTaskIDs[0]
+	}
+	if len(task.ActionGroupIDs) > 0 {
+		if heading, ok := state.Tasks[task.ActionGroupIDs[0]]; ok && len(heading.ParentTaskIDs) > 0 {
+			return heading.ParentTaskIDs[0]
+		}
+	}
+	return ""
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func firstOf(ids []string) string {
+	if len(ids) == 0 {
+		return ""
+	}
+	return ids[0]
+}
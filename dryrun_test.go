@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	thingscloud "github.com/arthursoares/things-cloud-sdk"
+)
+
+func TestDryRun(t *testing.T) {
+	um, fc, tools, ctx := newTestToolServer(t, "dry@example.com",
+		makeAreaItem("area-1", "Work"),
+		makeAreaItem("area-2", "Home"),
+		makeTagItem("tag-1", "Urgent"),
+		makeTaskItem("proj-1", withTitle("Launch"), withTaskType(thingscloud.TaskTypeProject), withArea("area-1")),
+		makeTaskItem("t-1", withTitle("Write spec"), withParent("proj-1"), withTags("tag-1")),
+		makeTaskItem("t-2", withTitle("Book venue"), withParent("proj-1")),
+		makeTaskItem("t-3", withTitle("Email Bob"), withArea("area-1")),
+	)
+	dryRun := func(name string, args map[string]any) dryRunOutput {
+		t.Helper()
+		args["dry_run"] = true
+		result, err := tools[name].Handler(ctx, makeReq(args))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertNotError(t, result)
+		return resultJSON[dryRunOutput](t, result)
+	}
+
+	t.Run("move project", func(t *testing.T) {
+		out := dryRun("things_edit_item", map[string]any{"uuid": "proj-1", "area_uuid": "area-2"})
+		if !out.DryRun || len(out.Writes) != 1 || out.Writes[0].UUID != "proj-1" || !strings.Contains(string(out.Writes[0].Wire), `"area-2"`) {
+			t.Fatalf("writes: %+v", out.Writes)
+		}
+		if len(out.Diff) != 1 || out.Diff[0].Action != "modify" || out.Diff[0].Kind != "project" {
+			t.Fatalf("diff: %+v", out.Diff)
+		}
+		var areas *fieldDiff
+		for i, f := range out.Diff[0].Fields {
+			if f.Field == "areas" {
+				areas = &out.Diff[0].Fields[i]
+			}
+		}
+		if areas == nil || !strings.Contains(mustJSON(areas.Before), "Work") || !strings.Contains(mustJSON(areas.After), "Home") {
+			t.Errorf("areas diff: %+v", out.Diff[0].Fields)
+		}
+		if len(out.Warnings) != 1 || out.Warnings[0] != `this will move 1 project and 2 tasks out of area "Work"` {
+			t.Errorf("warnings: %v", out.Warnings)
+		}
+		var result map[string]any
+		if json.Unmarshal(out.Result, &result) != nil || result["status"] != "updated" {
+			t.Errorf("result: %s", out.Result)
+		}
+	})
+
+	t.Run("create", func(t *testing.T) {
+		out := dryRun("things_create_task", map[string]any{"title": "Order badges", "project_uuid": "proj-1"})
+		if len(out.Diff) != 1 || out.Diff[0].Action != "create" || out.Diff[0].Kind != "task" || out.Diff[0].Title != "Order badges" {
+			t.Fatalf("diff: %+v", out.Diff)
+		}
+		if len(out.Diff[0].Changes) == 0 || !strings.Contains(out.Diff[0].Changes[0], "Order badges") {
+			t.Errorf("changes: %v", out.Diff[0].Changes)
+		}
+	})
+
+	t.Run("deletes in use", func(t *testing.T) {
+		out := dryRun("things_delete_tag", map[string]any{"uuid": "tag-1"})
+		if len(out.Diff) != 1 || out.Diff[0].Action != "delete" || len(out.Warnings) != 1 || out.Warnings[0] != `deleting tag "Urgent" removes it from 1 task` {
+			t.Errorf("delete tag: %+v %v", out.Diff, out.Warnings)
+		}
+		out = dryRun("things_delete_area", map[string]any{"uuid": "area-1"})
+		if len(out.Warnings) != 1 || out.Warnings[0] != `deleting area "Work" leaves 1 project and 3 tasks without an area` {
+			t.Errorf("delete area: %v", out.Warnings)
+		}
+	})
+
+	t.Run("complete project with open tasks", func(t *testing.T) {
+		out := dryRun("things_edit_item", map[string]any{"uuid": "proj-1", "status": "completed"})
+		if len(out.Warnings) != 1 || !strings.Contains(out.Warnings[0], "still has 2 tasks open") {
+			t.Errorf("warnings: %v", out.Warnings)
+		}
+	})
+
+	t.Run("validation still runs", func(t *testing.T) {
+		result, _ := tools["things_edit_item"].Handler(ctx, makeReq(map[string]any{"uuid": "nope", "title": "x", "dry_run": true}))
+		assertIsError(t, result)
+	})
+
+	if commits := fc.getCommitLog(); len(commits) != 0 {
+		t.Errorf("dry runs wrote %d commits", len(commits))
+	}
+	if task := um.users["dry@example.com"].findTask("proj-1"); task.AreaIDs[0] != "area-1" {
+		t.Errorf("state changed: %v", task.AreaIDs)
+	}
+
+	for name, want := range map[string]bool{
+		"things_edit_item":            true,
+		"things_delete_area":          true,
+		"things_find_tasks":           false,
+		"things_create_calendar_feed": false,
+	} {
+		if _, ok := tools[name].Tool.InputSchema.Properties["dry_run"]; ok != want {
+			t.Errorf("%s has dry_run: %v, want %v", name, ok, want)
+		}
+	}
+}
+
+func mustJSON(v any) string {
+	raw, _ := json.Marshal(v)
+	return string(raw)
+}
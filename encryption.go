@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Encryption of stored Things passwords
+// ---------------------------------------------------------------------------
+
+// sealedPrefix marks a password column value as encrypted. Values without it
+// are plaintext rows written before encryption was configured.
+const sealedPrefix = "enc:v1:"
+
+// credentialCipher encrypts the Things passwords kept in oauth.db with
+// envelope encryption: every value gets its own random data key, which is
+// stored next to it wrapped with a key encryption key from the
+// environment. The first key encrypts; the others only decrypt, so that
+// keys can be rotated.
+type credentialCipher struct {
+	keys []credentialKey
+}
+
+type credentialKey struct {
+	id   string // short fingerprint stored with each value
+	aead cipher.AEAD
+}
+
+// loadCredentialCipher reads the key encryption keys from
+// CREDENTIALS_KEY, a comma-separated list, or from the file named by
+// CREDENTIALS_KEY_FILE, one key per line. Keys are 32 random bytes in
+// base64, e.g. from `openssl rand -base64 32`. It returns nil when neither
+// is set.
+func loadCredentialCipher() (*credentialCipher, error) {
+	var raw []string
+	switch {
+	case os.Getenv("CREDENTIALS_KEY") != "":
+		raw = strings.Split(os.Getenv("CREDENTIALS_KEY"), ",")
+	case os.Getenv("CREDENTIALS_KEY_FILE") != "":
+		data, err := os.ReadFile(os.Getenv("CREDENTIALS_KEY_FILE"))
+		if err != nil {
+			return nil, fmt.Errorf("read CREDENTIALS_KEY_FILE: %w", err)
+		}
+		raw = strings.Split(string(data), "\n")
+	default:
+		return nil, nil
+	}
+	var keys [][]byte
+	for _, s := range raw {
+		s = strings.TrimSpace(s)
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(s)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("credentials key %d is not 32 bytes of base64", len(keys)+1)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no credentials key found")
+	}
+	return newCredentialCipher(keys...)
+}
+
+func newCredentialCipher(keys ...[]byte) (*credentialCipher, error) {
+	c := &credentialCipher{}
+	for _, key := range keys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(key)
+		c.keys = append(c.keys, credentialKey{id: hex.EncodeToString(sum[:4]), aead: aead})
+	}
+	return c, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts a password with the current key. The owner's email is
+// bound to the ciphertext, so values cannot be swapped between rows. A nil
+// cipher stores plaintext.
+func (c *credentialCipher) seal(email, password string) string {
+	if c == nil {
+		return password
+	}
+	dataKey := make([]byte, 32)
+	rand.Read(dataKey)
+	data, _ := newAEAD(dataKey)
+	kek := c.keys[0]
+	wrapped := sealWith(kek.aead, dataKey, []byte(kek.id))
+	sealed := sealWith(data, []byte(password), []byte(email))
+	enc := base64.RawStdEncoding
+	return sealedPrefix + kek.id + ":" + enc.EncodeToString(wrapped) + ":" + enc.EncodeToString(sealed)
+}
+
+// open decrypts a stored password. Plaintext values are returned as they
+// are.
+func (c *credentialCipher) open(email, stored string) (string, error) {
+	if !strings.HasPrefix(stored, sealedPrefix) {
+		return stored, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(stored, sealedPrefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("malformed encrypted password")
+	}
+	if c == nil {
+		return "", errors.New("password is encrypted but no CREDENTIALS_KEY is configured")
+	}
+	var kek *credentialKey
+	for i := range c.keys {
+		if c.keys[i].id == parts[0] {
+			kek = &c.keys[i]
+		}
+	}
+	if kek == nil {
+		return "", fmt.Errorf("password is encrypted with key %s, which is not configured", parts[0])
+	}
+	enc := base64.RawStdEncoding
+	wrapped, err1 := enc.DecodeString(parts[1])
+	sealed, err2 := enc.DecodeString(parts[2])
+	if err := errors.Join(err1, err2); err != nil {
+		return "", fmt.Errorf("malformed encrypted password: %w", err)
+	}
+	dataKey, err := openWith(kek.aead, wrapped, []byte(kek.id))
+	if err != nil {
+		return "", fmt.Errorf("unwrap data key with key %s: %w", kek.id, err)
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	password, err := openWith(data, sealed, []byte(email))
+	if err != nil {
+		return "", fmt.Errorf("decrypt password: %w", err)
+	}
+	return string(password), nil
+}
+
+// current reports whether a stored value is in the form seal would write
+// now: encrypted with the current key, or plaintext without a cipher.
+func (c *credentialCipher) current(stored string) bool {
+	if c == nil {
+		return !strings.HasPrefix(stored, sealedPrefix)
+	}
+	return strings.HasPrefix(stored, sealedPrefix+c.keys[0].id+":")
+}
+
+func sealWith(aead cipher.AEAD, plaintext, aad []byte) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+	return aead.Seal(nonce, nonce, plaintext, aad)
+}
+
+func openWith(aead cipher.AEAD, sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], aad)
+}
+
+// migrateCredentials re-encrypts every stored password that is not in the
+// current form: plaintext rows once a key is configured, and rows
+// encrypted with an older key after rotation. It runs in one transaction
+// and fails without changing anything if any row cannot be decrypted, so
+// that a wrong key stops the server instead of losing users.
+func migrateCredentials(db *sqlDB, c *credentialCipher) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	migrated := 0
+	for _, table := range []struct{ name, key string }{
+		{"credentials", "email"},
+		{"refresh_tokens", "token"},
+		{"auth_codes", "code"},
+	} {
+		rows, err := tx.Query(fmt.Sprintf(`SELECT %s, email, password FROM %s`, table.key, table.name))
+		if err != nil {
+			return 0, err
+		}
+		type row struct{ key, email, password string }
+		var stale []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.key, &r.email, &r.password); err != nil {
+				rows.Close()
+				return 0, err
+			}
+			if !c.current(r.password) {
+				stale = append(stale, r)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+		for _, r := range stale {
+			password, err := c.open(r.email, r.password)
+			if err != nil {
+				return 0, fmt.Errorf("%s for %s: %w", table.name, maskEmail(r.email), err)
+			}
+			if _, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET password = ? WHERE %s = ?`, table.name, table.key), c.seal(r.email, password), r.key); err != nil {
+				return 0, err
+			}
+			migrated++
+		}
+	}
+	return migrated, tx.Commit()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestCredentialCipher(t *testing.T) {
+	c, err := newCredentialCipher(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := c.seal("ada@example.com", "hunter2")
+	if !strings.HasPrefix(sealed, sealedPrefix) || strings.Contains(sealed, "hunter2") || sealed == c.seal("ada@example.com", "hunter2") {
+		t.Fatalf("sealed: %s", sealed)
+	}
+	if got, err := c.open("ada@example.com", sealed); err != nil || got != "hunter2" {
+		t.Errorf("open: %q, %v", got, err)
+	}
+	if _, err := c.open("bob@example.com", sealed); err == nil {
+		t.Error("a value moved to another user's row must not decrypt")
+	}
+	other, _ := newCredentialCipher(bytes.Repeat([]byte{2}, 32))
+	if _, err := other.open("ada@example.com", sealed); err == nil || !strings.Contains(err.Error(), "not configured") {
+		t.Errorf("wrong key: %v", err)
+	}
+	var none *credentialCipher
+	if _, err := none.open("ada@example.com", sealed); err == nil {
+		t.Error("no key: expected an error")
+	}
+	if got, _ := none.open("ada@example.com", "plain"); got != "plain" {
+		t.Errorf("plaintext: %q", got)
+	}
+}
+
+func TestCredentialsMigrationAndRotation(t *testing.T) {
+	dir := t.TempDir()
+	key1 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	key2 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+	stored := func(o *OAuthServer) string {
+		var password string
+		o.db.QueryRow(`SELECT password FROM credentials WHERE email = 'ada@example.com'`).Scan(&password)
+		return password
+	}
+
+	// Rows written before a key was configured are plaintext.
+	t.Setenv("CREDENTIALS_KEY", "")
+	o := NewOAuthServer(NewUserManager(), dir)
+	o.rememberCredentials("ada@example.com", "hunter2")
+	o.saveRefreshToken(&RefreshToken{Token: "rt-1", Email: "ada@example.com", Password: "hunter2", ClientID: "c"})
+	o.db.Exec(`UPDATE refresh_tokens SET expires_at = '2999-01-01T00:00:00Z'`)
+	if stored(o) != "hunter2" {
+		t.Fatalf("stored: %q", stored(o))
+	}
+	o.db.Close()
+
+	// Configuring a key encrypts them on the next start.
+	t.Setenv("CREDENTIALS_KEY", key1)
+	o = NewOAuthServer(NewUserManager(), dir)
+	first := stored(o)
+	if !strings.HasPrefix(first, sealedPrefix) {
+		t.Fatalf("not encrypted: %q", first)
+	}
+	var rtPassword string
+	o.db.QueryRow(`SELECT password FROM refresh_tokens WHERE token = 'rt-1'`).Scan(&rtPassword)
+	if !strings.HasPrefix(rtPassword, sealedPrefix) {
+		t.Errorf("refresh token not encrypted: %q", rtPassword)
+	}
+	rt, _ := o.store.RefreshToken("rt-1")
+	rtOpened, _ := o.cipher.open(rt.Email, rt.Password)
+	if password, _ := o.storedPassword("ada@example.com"); password != "hunter2" || rtOpened != "hunter2" {
+		t.Errorf("loaded %q and %q", password, rtOpened)
+	}
+	o.db.Close()
+
+	// A new key first and the old one after re-encrypts with the new key.
+	t.Setenv("CREDENTIALS_KEY", key2+","+key1)
+	o = NewOAuthServer(NewUserManager(), dir)
+	if second := stored(o); second == first || !strings.HasPrefix(second, sealedPrefix) {
+		t.Errorf("not re-encrypted: %q", second)
+	}
+	if password, _ := o.storedPassword("ada@example.com"); password != "hunter2" {
+		t.Errorf("loaded %q", password)
+	}
+
+	// Without the key that wrote them, migration fails and changes nothing.
+	only1, _ := newCredentialCipher(bytes.Repeat([]byte{1}, 32))
+	before := stored(o)
+	if _, err := migrateCredentials(o.db, only1); err == nil {
+		t.Error("migration with the wrong key should fail")
+	}
+	if _, err := migrateCredentials(o.db, nil); err == nil {
+		t.Error("migration without a key should fail")
+	}
+	if stored(o) != before {
+		t.Error("failed migration changed rows")
+	}
+	o.db.Close()
+}
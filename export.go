@@ -0,0 +1,635 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+
+	thingscloud "github.com/arthursoares/things-cloud-sdk"
+	memory "github.com/arthursoares/things-cloud-sdk/state/memory"
+)
+
+// exportSchemaVersion is bumped whenever a field in the JSON export changes
+// meaning or disappears. Adding fields does not require a bump.
+const exportSchemaVersion = 1
+
+// ---------------------------------------------------------------------------
+// Export document
+// ---------------------------------------------------------------------------
+
+type exportDoc struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	ExportedAt    string          `json:"exportedAt"`
+	Tags          []exportTag     `json:"tags"`
+	Areas         []exportArea    `json:"areas"`
+	Projects      []exportProject `json:"projects"` // projects without an area
+	Tasks         []exportTask    `json:"tasks"`    // tasks without an area or project
+}
+
+type exportTag struct {
+	UUID      string `json:"uuid"`
+	Title     string `json:"title"`
+	Shorthand string `json:"shorthand,omitempty"`
+	Parent    string `json:"parent,omitempty"`
+}
+
+type exportArea struct {
+	UUID     string          `json:"uuid"`
+	Title    string          `json:"title"`
+	Tags     []string        `json:"tags,omitempty"`
+	Projects []exportProject `json:"projects"`
+	Tasks    []exportTask    `json:"tasks"`
+}
+
+type exportProject struct {
+	exportTask
+	Headings []exportHeading `json:"headings"`
+	Tasks    []exportTask    `json:"tasks"`
+}
+
+type exportHeading struct {
+	UUID   string       `json:"uuid"`
+	Title  string       `json:"title"`
+	Status string       `json:"status"`
+	Tasks  []exportTask `json:"tasks"`
+}
+
+type exportTask struct {
+	UUID             string            `json:"uuid"`
+	Title            string            `json:"title"`
+	Note             string            `json:"note,omitempty"`
+	Status           string            `json:"status"`
+	Schedule         string            `json:"schedule"`
+	ScheduledDate    string            `json:"scheduledDate,omitempty"`
+	DeadlineDate     string            `json:"deadlineDate,omitempty"`
+	ReminderTime     string            `json:"reminderTime,omitempty"`
+	Recurrence       string            `json:"recurrence,omitempty"`
+	Trashed          bool              `json:"trashed,omitempty"`
+	CreationDate     string            `json:"creationDate,omitempty"`
+	ModificationDate string            `json:"modificationDate,omitempty"`
+	CompletionDate   string            `json:"completionDate,omitempty"`
+	Tags             []string          `json:"tags,omitempty"`
+	Checklist        []ChecklistOutput `json:"checklist,omitempty"`
+}
+
+// exportOptions narrows what ends up in an export. The zero value exports
+// every open, untrashed item in the account.
+type exportOptions struct {
+	AreaUUID         string
+	ProjectUUID      string
+	DateField        string     // created (default), modified, completed, scheduled or deadline
+	From, To         *time.Time // inclusive calendar days, UTC
+	IncludeCompleted bool
+	IncludeTrashed   bool
+}
+
+func (o exportOptions) dated() bool { return o.From != nil || o.To != nil }
+
+// visible reports whether an item passes the status and trash filters.
+func (o exportOptions) visible(task *thingscloud.Task) bool {
+	if task.InTrash && !o.IncludeTrashed {
+		return false
+	}
+	return task.Status == 0 || o.IncludeCompleted
+}
+
+// inRange reports whether the item's configured date falls inside [From, To].
+func (o exportOptions) inRange(task *thingscloud.Task) bool {
+	if !o.dated() {
+		return true
+	}
+	var d *time.Time
+	switch o.DateField {
+	case "modified":
+		d = task.ModificationDate
+	case "completed":
+		d = task.CompletionDate
+	case "scheduled":
+		d = task.ScheduledDate
+		if task.TodayIndexRefDate != nil {
+			d = task.TodayIndexRefDate
+		}
+	case "deadline":
+		d = task.DeadlineDate
+	default:
+		d = &task.CreationDate
+	}
+	if d == nil || d.Year() <= 1970 {
+		return false
+	}
+	if o.From != nil && d.Before(*o.From) {
+		return false
+	}
+	if o.To != nil && !d.Before(o.To.AddDate(0, 0, 1)) {
+		return false
+	}
+	return true
+}
+
+// ---------------------------------------------------------------------------
+// Building
+// ---------------------------------------------------------------------------
+
+// recurrenceOf describes the rule behind a recurring task. Instances carry
+// no rule themselves; it lives on the template they point at via rt.
+func recurrenceOf(task *thingscloud.Task, state *memory.State) string {
+	if task.Repeater != nil {
+		return describeRecurrence(task.Repeater)
+	}
+	for _, id := range task.RecurrenceIDs {
+		if tmpl, ok := state.Tasks[id]; ok && tmpl.Repeater != nil {
+			return describeRecurrence(tmpl.Repeater)
+		}
+	}
+	return ""
+}
+
+func (t *ThingsMCP) exportTaskFrom(task *thingscloud.Task, state *memory.State, checklists map[string][]*thingscloud.CheckListItem) exportTask {
+	out := t.taskToOutput(task)
+	et := exportTask{
+		UUID:             out.UUID,
+		Title:            out.Title,
+		Note:             out.Note,
+		Status:           out.Status,
+		Schedule:         out.Schedule,
+		ScheduledDate:    derefOr(out.ScheduledDate, ""),
+		DeadlineDate:     derefOr(out.DeadlineDate, ""),
+		ReminderTime:     derefOr(out.ReminderTime, ""),
+		Recurrence:       recurrenceOf(task, state),
+		Trashed:          task.InTrash,
+		CreationDate:     derefOr(out.CreationDate, ""),
+		ModificationDate: derefOr(out.ModificationDate, ""),
+		CompletionDate:   derefOr(out.CompletionDate, ""),
+	}
+	for _, tag := range out.Tags {
+		et.Tags = append(et.Tags, tag.Name)
+	}
+	for _, cli := range checklists[task.UUID] {
+		et.Checklist = append(et.Checklist, ChecklistOutput{UUID: cli.UUID, Title: cli.Title, Status: statusString(cli.Status)})
+	}
+	return et
+}
+
+// taskContainer returns the project and heading a task lives in, following
+// agr → heading → pr for tasks placed under a heading.
+func taskContainer(task *thingscloud.Task, state *memory.State) (projectUUID, headingUUID string) {
+	if len(task.ActionGroupIDs) > 0 {
+		if heading, ok := state.Tasks[task.ActionGroupIDs[0]]; ok && len(heading.ParentTaskIDs) > 0 {
+			return heading.ParentTaskIDs[0], heading.UUID
+		}
+	}
+	if len(task.ParentTaskIDs) > 0 {
+		return task.ParentTaskIDs[0], ""
+	}
+	return "", ""
+}
+
+func firstKnownArea(ids []string, state *memory.State) string {
+	for _, id := range ids {
+		if _, ok := state.Areas[id]; ok {
+			return id
+		}
+	}
+	return ""
+}
+
+// buildExport walks the current state into an area → project → heading →
+// task tree. Containers are kept when they pass the status/trash filters;
+// with a date range they are only kept if they match or contain a match.
+func (t *ThingsMCP) buildExport(opts exportOptions) exportDoc {
+	state := t.getState()
+	doc := exportDoc{
+		SchemaVersion: exportSchemaVersion,
+		ExportedAt:    time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+		Tags:          []exportTag{},
+		Areas:         []exportArea{},
+		Projects:      []exportProject{},
+		Tasks:         []exportTask{},
+	}
+
+	for _, tag := range state.Tags {
+		et := exportTag{UUID: tag.UUID, Title: tag.Title, Shorthand: tag.ShortHand}
+		if len(tag.ParentTagIDs) > 0 {
+			if parent, ok := state.Tags[tag.ParentTagIDs[0]]; ok {
+				et.Parent = parent.Title
+			}
+		}
+		doc.Tags = append(doc.Tags, et)
+	}
+	sort.Slice(doc.Tags, func(i, j int) bool { return doc.Tags[i].Title < doc.Tags[j].Title })
+
+	// Pass 1: projects and headings that survive the filters.
+	projects := map[string]*thingscloud.Task{}
+	projectArea := map[string]string{}
+	for _, task := range state.Tasks {
+		if task.Type != thingscloud.TaskTypeProject || !opts.visible(task) {
+			continue
+		}
+		if opts.ProjectUUID != "" && task.UUID != opts.ProjectUUID {
+			continue
+		}
+		areaID := firstKnownArea(task.AreaIDs, state)
+		if opts.AreaUUID != "" && areaID != opts.AreaUUID {
+			continue
+		}
+		projects[task.UUID] = task
+		projectArea[task.UUID] = areaID
+	}
+	headings := map[string]*thingscloud.Task{}
+	headingsByProject := map[string][]*thingscloud.Task{}
+	for _, task := range state.Tasks {
+		if task.Type != thingscloud.TaskTypeHeading || !opts.visible(task) || len(task.ParentTaskIDs) == 0 {
+			continue
+		}
+		pid := task.ParentTaskIDs[0]
+		if _, ok := projects[pid]; !ok {
+			continue
+		}
+		headings[task.UUID] = task
+		headingsByProject[pid] = append(headingsByProject[pid], task)
+	}
+
+	// Pass 2: place every matching task in its container.
+	projectTasks := map[string][]*thingscloud.Task{}
+	headingTasks := map[string][]*thingscloud.Task{}
+	areaTasks := map[string][]*thingscloud.Task{}
+	var looseTasks []*thingscloud.Task
+	for _, task := range state.Tasks {
+		if task.Type != thingscloud.TaskTypeTask || isRecurringTemplate(task) || isUntitledTask(task) {
+			continue
+		}
+		if !opts.visible(task) || !opts.inRange(task) {
+			continue
+		}
+		projectID, headingID := taskContainer(task, state)
+		if projectID != "" {
+			// Tasks of a filtered-out project are dropped with it.
+			if _, ok := projects[projectID]; !ok {
+				continue
+			}
+			if _, ok := headings[headingID]; ok {
+				headingTasks[headingID] = append(headingTasks[headingID], task)
+			} else {
+				projectTasks[projectID] = append(projectTasks[projectID], task)
+			}
+			continue
+		}
+		if opts.ProjectUUID != "" {
+			continue
+		}
+		areaID := firstKnownArea(task.AreaIDs, state)
+		if opts.AreaUUID != "" && areaID != opts.AreaUUID {
+			continue
+		}
+		if areaID != "" {
+			areaTasks[areaID] = append(areaTasks[areaID], task)
+		} else {
+			looseTasks = append(looseTasks, task)
+		}
+	}
+
+	// Checklist items grouped by their task once, in display order.
+	checklists := map[string][]*thingscloud.CheckListItem{}
+	for _, cli := range state.CheckListItems {
+		if len(cli.TaskIDs) > 0 {
+			checklists[cli.TaskIDs[0]] = append(checklists[cli.TaskIDs[0]], cli)
+		}
+	}
+	for _, items := range checklists {
+		sort.Slice(items, func(i, j int) bool { return items[i].Index < items[j].Index })
+	}
+
+	convert := func(tasks []*thingscloud.Task) []exportTask {
+		sortByIndex(tasks)
+		out := make([]exportTask, 0, len(tasks))
+		for _, task := range tasks {
+			out = append(out, t.exportTaskFrom(task, state, checklists))
+		}
+		return out
+	}
+
+	areaProjects := map[string][]exportProject{}
+	var projectList []*thingscloud.Task
+	for _, p := range projects {
+		projectList = append(projectList, p)
+	}
+	sortByIndex(projectList)
+	for _, p := range projectList {
+		ep := exportProject{exportTask: t.exportTaskFrom(p, state, checklists), Headings: []exportHeading{}, Tasks: convert(projectTasks[p.UUID])}
+		hs := headingsByProject[p.UUID]
+		sortByIndex(hs)
+		for _, h := range hs {
+			tasks := convert(headingTasks[h.UUID])
+			if opts.dated() && len(tasks) == 0 {
+				continue
+			}
+			ep.Headings = append(ep.Headings, exportHeading{UUID: h.UUID, Title: h.Title, Status: statusString(h.Status), Tasks: tasks})
+		}
+		if opts.dated() && len(ep.Tasks) == 0 && len(ep.Headings) == 0 && !opts.inRange(p) {
+			continue
+		}
+		if areaID := projectArea[p.UUID]; areaID != "" {
+			areaProjects[areaID] = append(areaProjects[areaID], ep)
+		} else {
+			doc.Projects = append(doc.Projects, ep)
+		}
+	}
+
+	for _, area := range state.Areas {
+		if opts.AreaUUID != "" && area.UUID != opts.AreaUUID {
+			continue
+		}
+		ea := exportArea{UUID: area.UUID, Title: area.Title, Projects: areaProjects[area.UUID], Tasks: convert(areaTasks[area.UUID])}
+		if ea.Projects == nil {
+			ea.Projects = []exportProject{}
+		}
+		if (opts.dated() || opts.ProjectUUID != "") && len(ea.Projects) == 0 && len(ea.Tasks) == 0 {
+			continue
+		}
+		for _, tag := range area.Tags {
+			if tag != nil {
+				ea.Tags = append(ea.Tags, tag.Title)
+			}
+		}
+		doc.Areas = append(doc.Areas, ea)
+	}
+	sort.Slice(doc.Areas, func(i, j int) bool { return doc.Areas[i].Title < doc.Areas[j].Title })
+
+	doc.Tasks = convert(looseTasks)
+	return doc
+}
+
+// ---------------------------------------------------------------------------
+// Markdown
+// ---------------------------------------------------------------------------
+
+func renderExportMarkdown(doc exportDoc) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Things export\n\nExported %s\n", doc.ExportedAt)
+
+	if len(doc.Tags) > 0 {
+		b.WriteString("\n## Tags\n\n")
+		children := map[string][]exportTag{}
+		for _, tag := range doc.Tags {
+			children[tag.Parent] = append(children[tag.Parent], tag)
+		}
+		var walk func(parent string, depth int)
+		walk = func(parent string, depth int) {
+			for _, tag := range children[parent] {
+				line := tag.Title
+				if tag.Shorthand != "" {
+					line += fmt.Sprintf(" (%s)", tag.Shorthand)
+				}
+				fmt.Fprintf(&b, "%s- %s\n", strings.Repeat("  ", depth), line)
+				if depth < 8 {
+					walk(tag.Title, depth+1)
+				}
+			}
+		}
+		walk("", 0)
+	}
+
+	for _, area := range doc.Areas {
+		fmt.Fprintf(&b, "\n## %s\n", area.Title)
+		writeMarkdownTasks(&b, area.Tasks)
+		for _, p := range area.Projects {
+			writeMarkdownProject(&b, p)
+		}
+	}
+	if len(doc.Projects) > 0 || len(doc.Tasks) > 0 {
+		b.WriteString("\n## No Area\n")
+		writeMarkdownTasks(&b, doc.Tasks)
+		for _, p := range doc.Projects {
+			writeMarkdownProject(&b, p)
+		}
+	}
+	return b.String()
+}
+
+func writeMarkdownProject(b *strings.Builder, p exportProject) {
+	title := p.Title
+	if p.Status != "pending" {
+		title += " (" + p.Status + ")"
+	}
+	fmt.Fprintf(b, "\n### %s\n", title)
+	if meta := markdownMeta(p.exportTask); meta != "" {
+		fmt.Fprintf(b, "\n_%s_\n", meta)
+	}
+	if p.Note != "" {
+		b.WriteString("\n")
+		for _, line := range strings.Split(p.Note, "\n") {
+			fmt.Fprintf(b, "> %s\n", line)
+		}
+	}
+	writeMarkdownTasks(b, p.Tasks)
+	for _, h := range p.Headings {
+		fmt.Fprintf(b, "\n#### %s\n", h.Title)
+		writeMarkdownTasks(b, h.Tasks)
+	}
+}
+
+func writeMarkdownTasks(b *strings.Builder, tasks []exportTask) {
+	if len(tasks) == 0 {
+		return
+	}
+	b.WriteString("\n")
+	for _, task := range tasks {
+		line := markdownCheckbox(task.Status) + " " + task.Title
+		if task.Status == "canceled" {
+			line = markdownCheckbox(task.Status) + " ~~" + task.Title + "~~"
+		}
+		if meta := markdownMeta(task); meta != "" {
+			line += " — " + meta
+		}
+		fmt.Fprintf(b, "- %s\n", line)
+		if task.Note != "" {
+			for _, l := range strings.Split(task.Note, "\n") {
+				fmt.Fprintf(b, "  %s\n", l)
+			}
+		}
+		for _, item := range task.Checklist {
+			fmt.Fprintf(b, "  - %s %s\n", markdownCheckbox(item.Status), item.Title)
+		}
+	}
+}
+
+func markdownCheckbox(status string) string {
+	if status == "pending" {
+		return "[ ]"
+	}
+	return "[x]"
+}
+
+func markdownMeta(task exportTask) string {
+	var parts []string
+	if task.ScheduledDate != "" {
+		parts = append(parts, "scheduled "+task.ScheduledDate)
+	} else if task.Schedule == "someday" {
+		parts = append(parts, "someday")
+	}
+	if task.DeadlineDate != "" {
+		parts = append(parts, "deadline "+task.DeadlineDate)
+	}
+	if task.ReminderTime != "" {
+		parts = append(parts, "reminder "+task.ReminderTime)
+	}
+	if task.Recurrence != "" {
+		parts = append(parts, "repeats "+task.Recurrence)
+	}
+	for _, tag := range task.Tags {
+		parts = append(parts, "#"+strings.ReplaceAll(tag, " ", "-"))
+	}
+	if task.Trashed {
+		parts = append(parts, "trashed")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ---------------------------------------------------------------------------
+// CSV
+// ---------------------------------------------------------------------------
+
+var exportCSVHeader = []string{
+	"type", "uuid", "title", "status", "schedule", "scheduled_date", "deadline_date",
+	"reminder_time", "recurrence", "area", "project", "heading", "parent_task", "tags",
+	"trashed", "creation_date", "modification_date", "completion_date", "note",
+}
+
+// renderExportCSV flattens the tree into one row per area, project, heading,
+// task and checklist item; the containing path is spelled out per row.
+func renderExportCSV(doc exportDoc) (string, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	rows := [][]string{exportCSVHeader}
+
+	taskRow := func(kind string, task exportTask, area, project, heading string) []string {
+		trashed := ""
+		if task.Trashed {
+			trashed = "true"
+		}
+		return []string{
+			kind, task.UUID, task.Title, task.Status, task.Schedule, task.ScheduledDate, task.DeadlineDate,
+			task.ReminderTime, task.Recurrence, area, project, heading, "", strings.Join(task.Tags, ";"),
+			trashed, task.CreationDate, task.ModificationDate, task.CompletionDate, task.Note,
+		}
+	}
+	addTasks := func(tasks []exportTask, area, project, heading string) {
+		for _, task := range tasks {
+			rows = append(rows, taskRow("task", task, area, project, heading))
+			for _, item := range task.Checklist {
+				row := make([]string, len(exportCSVHeader))
+				row[0], row[1], row[2], row[3] = "checklist_item", item.UUID, item.Title, item.Status
+				row[9], row[10], row[11], row[12] = area, project, heading, task.Title
+				rows = append(rows, row)
+			}
+		}
+	}
+	addProject := func(p exportProject, area string) {
+		rows = append(rows, taskRow("project", p.exportTask, area, "", ""))
+		addTasks(p.Tasks, area, p.Title, "")
+		for _, h := range p.Headings {
+			row := make([]string, len(exportCSVHeader))
+			row[0], row[1], row[2], row[3] = "heading", h.UUID, h.Title, h.Status
+			row[9], row[10] = area, p.Title
+			rows = append(rows, row)
+			addTasks(h.Tasks, area, p.Title, h.Title)
+		}
+	}
+
+	for _, area := range doc.Areas {
+		row := make([]string, len(exportCSVHeader))
+		row[0], row[1], row[2], row[13] = "area", area.UUID, area.Title, strings.Join(area.Tags, ";")
+		rows = append(rows, row)
+		addTasks(area.Tasks, area.Title, "", "")
+		for _, p := range area.Projects {
+			addProject(p, area.Title)
+		}
+	}
+	for _, p := range doc.Projects {
+		addProject(p, "")
+	}
+	addTasks(doc.Tasks, "", "", "")
+
+	if err := w.WriteAll(rows); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// ---------------------------------------------------------------------------
+// Handler
+// ---------------------------------------------------------------------------
+
+// exportTextOutput is the structured content of a markdown or csv export,
+// whose text is the document itself.
+type exportTextOutput struct {
+	Format  string `json:"format"`
+	Content string `json:"content"`
+}
+
+func (t *ThingsMCP) handleExport(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	format := strings.ToLower(req.GetString("format", "json"))
+	if format != "json" && format != "markdown" && format != "csv" {
+		return errResult(fmt.Sprintf("unsupported format: %s (use json, markdown or csv)", format)), nil
+	}
+	opts := exportOptions{
+		AreaUUID:         req.GetString("area_uuid", ""),
+		ProjectUUID:      req.GetString("project_uuid", ""),
+		DateField:        strings.ToLower(req.GetString("date_field", "created")),
+		IncludeCompleted: req.GetBool("include_completed", false),
+		IncludeTrashed:   req.GetBool("include_trashed", false),
+	}
+	switch opts.DateField {
+	case "created", "modified", "completed", "scheduled", "deadline":
+	default:
+		return errResult(fmt.Sprintf("unsupported date_field: %s (use created, modified, completed, scheduled or deadline)", opts.DateField)), nil
+	}
+	for _, bound := range []struct {
+		name string
+		dst  **time.Time
+	}{{"from", &opts.From}, {"to", &opts.To}} {
+		s := req.GetString(bound.name, "")
+		if s == "" {
+			continue
+		}
+		d, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return errResult(fmt.Sprintf("invalid %s date: %s (use YYYY-MM-DD)", bound.name, s)), nil
+		}
+		*bound.dst = &d
+	}
+
+	if err := t.syncAndRebuild(); err != nil {
+		return errResultFrom(fmt.Errorf("sync: %w", err)), nil
+	}
+	if opts.AreaUUID != "" {
+		if err := t.validateAreaUUID(opts.AreaUUID); err != nil {
+			return errResultFrom(err), nil
+		}
+	}
+	if opts.ProjectUUID != "" {
+		if err := t.validateProjectUUID(opts.ProjectUUID); err != nil {
+			return errResultFrom(err), nil
+		}
+	}
+
+	doc := t.buildExport(opts)
+	switch format {
+	case "markdown":
+		out := renderExportMarkdown(doc)
+		return mcp.NewToolResultStructured(exportTextOutput{Format: format, Content: out}, out), nil
+	case "csv":
+		out, err := renderExportCSV(doc)
+		if err != nil {
+			return errResultCode(codeInternal, fmt.Sprintf("csv: %v", err)), nil
+		}
+		return mcp.NewToolResultStructured(exportTextOutput{Format: format, Content: out}, out), nil
+	}
+	return jsonResult(doc), nil
+}
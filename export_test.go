@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+
+	thingscloud "github.com/arthursoares/things-cloud-sdk"
+)
+
+func withRepeater(rc thingscloud.RepeaterConfiguration) taskOption {
+	return func(p *thingscloud.TaskActionItemPayload) {
+		p.Repeater = &rc
+	}
+}
+
+func withRecurrenceTemplate(uuid string) taskOption {
+	return func(p *thingscloud.TaskActionItemPayload) {
+		ids := []string{uuid}
+		p.RecurrenceTaskIDs = &ids
+	}
+}
+
+func newExportFixture(t *testing.T) *ThingsMCP {
+	t.Helper()
+	wd := time.Monday
+	fc := newFakeCloud("export@example.com",
+		makeAreaItem("area-1", "Work"),
+		makeTagItem("tag-1", "Urgent"),
+		makeTaskItem("proj-1", withTitle("Launch"), withTaskType(thingscloud.TaskTypeProject), withArea("area-1")),
+		makeTaskItem("head-1", withTitle("Phase 1"), withTaskType(thingscloud.TaskTypeHeading), withParent("proj-1")),
+		makeTaskItem("t-head", withTitle("Write spec"), withActionGroup("head-1")),
+		makeTaskItem("t-proj", withTitle("Kickoff"), withParent("proj-1"), withNote("agenda")),
+		makeChecklistItem("cl-1", "t-proj", "Book room"),
+		makeTaskItem("t-area", withTitle("Email boss"), withArea("area-1"), withTags("tag-1")),
+		makeTaskItem("t-loose", withTitle("Buy milk")),
+		makeTaskItem("t-done", withTitle("Old thing"), withStatus(thingscloud.TaskStatusCompleted), withCreationDate(mustTime("2024-01-10"))),
+		makeTaskItem("t-trash", withTitle("Binned"), withTrashed()),
+		makeTaskItem("tmpl", withTitle("Water plants"), withRepeater(thingscloud.RepeaterConfiguration{
+			FrequencyUnit:       thingscloud.FrequencyUnitWeekly,
+			FrequencyAmplitude:  1,
+			DetailConfiguration: []thingscloud.RepeaterDetailConfiguration{{Weekday: &wd}},
+		})),
+		makeTaskItem("inst", withTitle("Water plants"), withRecurrenceTemplate("tmpl")),
+	)
+	t.Cleanup(fc.Close)
+	return newTestThingsMCP(t, fc)
+}
+
+func TestHandleExportJSON(t *testing.T) {
+	tmcp := newExportFixture(t)
+
+	t.Run("tree placement", func(t *testing.T) {
+		result, err := tmcp.handleExport(context.Background(), makeReq(map[string]any{}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertNotError(t, result)
+		doc := resultJSON[exportDoc](t, result)
+
+		if doc.SchemaVersion != exportSchemaVersion {
+			t.Errorf("schemaVersion: got %d, want %d", doc.SchemaVersion, exportSchemaVersion)
+		}
+		if len(doc.Tags) != 1 || doc.Tags[0].Title != "Urgent" {
+			t.Errorf("tags: got %+v", doc.Tags)
+		}
+		if len(doc.Areas) != 1 {
+			t.Fatalf("areas: got %d, want 1", len(doc.Areas))
+		}
+		area := doc.Areas[0]
+		if len(area.Tasks) != 1 || area.Tasks[0].UUID != "t-area" || len(area.Tasks[0].Tags) != 1 || area.Tasks[0].Tags[0] != "Urgent" {
+			t.Errorf("area tasks: got %+v", area.Tasks)
+		}
+		if len(area.Projects) != 1 {
+			t.Fatalf("area projects: got %d, want 1", len(area.Projects))
+		}
+		proj := area.Projects[0]
+		if len(proj.Tasks) != 1 || proj.Tasks[0].UUID != "t-proj" {
+			t.Fatalf("project tasks: got %+v", proj.Tasks)
+		}
+		if len(proj.Tasks[0].Checklist) != 1 || proj.Tasks[0].Checklist[0].Title != "Book room" {
+			t.Errorf("checklist: got %+v", proj.Tasks[0].Checklist)
+		}
+		if len(proj.Headings) != 1 || len(proj.Headings[0].Tasks) != 1 || proj.Headings[0].Tasks[0].UUID != "t-head" {
+			t.Errorf("headings: got %+v", proj.Headings)
+		}
+
+		var loose []string
+		for _, task := range doc.Tasks {
+			loose = append(loose, task.UUID)
+			if task.UUID == "inst" && task.Recurrence != "weekly:mon" {
+				t.Errorf("recurrence: got %q, want %q", task.Recurrence, "weekly:mon")
+			}
+		}
+		if len(loose) != 2 || !containsStr(loose, "t-loose") || !containsStr(loose, "inst") {
+			t.Errorf("loose tasks: got %v, want [t-loose inst]", loose)
+		}
+	})
+
+	t.Run("completed and trashed are opt-in", func(t *testing.T) {
+		result, _ := tmcp.handleExport(context.Background(), makeReq(map[string]any{
+			"include_completed": true,
+			"include_trashed":   true,
+		}))
+		assertNotError(t, result)
+		doc := resultJSON[exportDoc](t, result)
+		var loose []string
+		for _, task := range doc.Tasks {
+			loose = append(loose, task.UUID)
+		}
+		if !containsStr(loose, "t-done") || !containsStr(loose, "t-trash") {
+			t.Errorf("expected t-done and t-trash, got %v", loose)
+		}
+	})
+
+	t.Run("project filter", func(t *testing.T) {
+		result, _ := tmcp.handleExport(context.Background(), makeReq(map[string]any{"project_uuid": "proj-1"}))
+		assertNotError(t, result)
+		doc := resultJSON[exportDoc](t, result)
+		if len(doc.Tasks) != 0 || len(doc.Areas) != 1 || len(doc.Areas[0].Tasks) != 0 || len(doc.Areas[0].Projects) != 1 {
+			t.Errorf("expected only the Launch project, got %+v", doc)
+		}
+	})
+
+	t.Run("date range", func(t *testing.T) {
+		result, _ := tmcp.handleExport(context.Background(), makeReq(map[string]any{
+			"from":              "2024-01-01",
+			"to":                "2024-01-10",
+			"include_completed": true,
+		}))
+		assertNotError(t, result)
+		doc := resultJSON[exportDoc](t, result)
+		if len(doc.Areas) != 0 || len(doc.Tasks) != 1 || doc.Tasks[0].UUID != "t-done" {
+			t.Errorf("expected only t-done, got %+v", doc)
+		}
+	})
+
+	t.Run("invalid arguments", func(t *testing.T) {
+		for _, args := range []map[string]any{
+			{"format": "xml"},
+			{"from": "yesterday"},
+			{"date_field": "due"},
+			{"area_uuid": "nope"},
+		} {
+			result, _ := tmcp.handleExport(context.Background(), makeReq(args))
+			assertIsError(t, result)
+		}
+	})
+}
+
+func TestHandleExportMarkdown(t *testing.T) {
+	tmcp := newExportFixture(t)
+
+	result, _ := tmcp.handleExport(context.Background(), makeReq(map[string]any{"format": "markdown"}))
+	assertNotError(t, result)
+	md := resultText(t, result)
+	for _, want := range []string{
+		"## Work\n",
+		"- [ ] Email boss — #Urgent\n",
+		"### Launch\n",
+		"- [ ] Kickoff\n  agenda\n  - [ ] Book room\n",
+		"#### Phase 1\n\n- [ ] Write spec\n",
+		"## No Area\n",
+		"- [ ] Water plants — repeats weekly:mon\n",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("markdown missing %q:\n%s", want, md)
+		}
+	}
+}
+
+func TestHandleExportCSV(t *testing.T) {
+	tmcp := newExportFixture(t)
+
+	result, _ := tmcp.handleExport(context.Background(), makeReq(map[string]any{"format": "csv"}))
+	assertNotError(t, result)
+	rows, err := csv.NewReader(strings.NewReader(resultText(t, result))).ReadAll()
+	if err != nil {
+		t.Fatalf("parse csv: %v", err)
+	}
+	if strings.Join(rows[0], ",") != strings.Join(exportCSVHeader, ",") {
+		t.Errorf("header: got %v", rows[0])
+	}
+	byUUID := map[string][]string{}
+	for _, row := range rows[1:] {
+		if len(row) != len(exportCSVHeader) {
+			t.Fatalf("row width: got %d, want %d: %v", len(row), len(exportCSVHeader), row)
+		}
+		byUUID[row[1]] = row
+	}
+	if row := byUUID["t-head"]; row == nil || row[9] != "Work" || row[10] != "Launch" || row[11] != "Phase 1" {
+		t.Errorf("t-head row: got %v", row)
+	}
+	if row := byUUID["cl-1"]; row == nil || row[0] != "checklist_item" || row[12] != "Kickoff" {
+		t.Errorf("cl-1 row: got %v", row)
+	}
+	if row := byUUID["head-1"]; row == nil || row[0] != "heading" {
+		t.Errorf("head-1 row: got %v", row)
+	}
+}
@@ -3,6 +3,10 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -1314,3 +1318,41 @@ func TestHandleShowProjectSortByIndex(t *testing.T) {
 		t.Errorf("heading-b task 1: got %s, want task-h1", detail.Headings[1].Tasks[1].UUID)
 	}
 }
+
+// ---------------------------------------------------------------------------
+// Throttling
+// ---------------------------------------------------------------------------
+
+func TestThrottledSyncKeepsState(t *testing.T) {
+	fc := newFakeCloud("throttle@example.com", makeTaskItem("task-1", withTitle("Kept")))
+	defer fc.Close()
+	var throttle atomic.Bool
+	inner := fc.server.Config.Handler
+	fc.server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if throttle.Load() && strings.HasSuffix(r.URL.Path, "/items") {
+			w.Header().Set("Things-Response", "AbusePrevention")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		inner.ServeHTTP(w, r)
+	})
+
+	tm, err := newThingsMCP(t.Context(), fc.server.URL, fc.email, "testpass", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	throttle.Store(true)
+	err = tm.incrementalSync()
+	if err == nil || !strings.Contains(err.Error(), "limiting requests for this account") {
+		t.Fatalf("got %v", err)
+	}
+	// No full rebuild: the state is still there.
+	if tm.findTask("task-1") == nil {
+		t.Error("throttled sync dropped the state")
+	}
+	// Later calls fail without reaching Things Cloud.
+	var throttled *thingscloud.ThrottledError
+	if err := tm.incrementalSync(); !errors.As(err, &throttled) || !throttled.AbusePrevention {
+		t.Errorf("second sync: %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Health checks and graceful shutdown
+// ---------------------------------------------------------------------------
+
+// defaultShutdownTimeout bounds a graceful shutdown unless
+// SHUTDOWN_TIMEOUT says otherwise.
+const defaultShutdownTimeout = 30 * time.Second
+
+// readinessTimeout bounds the database check of /readyz.
+const readinessTimeout = 2 * time.Second
+
+// shutdownTimeout reads SHUTDOWN_TIMEOUT, e.g. "45s".
+func shutdownTimeout() time.Duration {
+	if v := os.Getenv("SHUTDOWN_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		logServer.Warn("ignoring invalid SHUTDOWN_TIMEOUT", "value", v)
+	}
+	return defaultShutdownTimeout
+}
+
+// handleHealthz reports that the process is alive.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte("ok\n"))
+}
+
+// readiness is the body of /readyz.
+type readiness struct {
+	Status string            `json:"status"` // "ready" or "unavailable"
+	Checks map[string]string `json:"checks"`
+}
+
+// handleReadyz reports whether the server can take requests: the storage
+// answers, the JWT secret is loaded, and shutdown has not begun.
+func (um *UserManager) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	out := readiness{Status: "ready", Checks: map[string]string{"database": "ok", "jwt_secret": "ok", "shutdown": "ok"}}
+	fail := func(check, reason string) {
+		out.Status, out.Checks[check] = "unavailable", reason
+	}
+	if o := um.oauth; o == nil {
+		fail("database", "not configured")
+		fail("jwt_secret", "not configured")
+	} else {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+		if err := o.db.PingContext(ctx); err != nil {
+			fail("database", err.Error())
+		}
+		if len(o.jwtSecret) == 0 {
+			fail("jwt_secret", "missing")
+		}
+	}
+	if um.draining.Load() {
+		fail("shutdown", "draining")
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	status := http.StatusOK
+	if out.Status != "ready" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, out)
+}
+
+// shutdown stops the server gracefully within timeout. It fails /readyz
+// so that no new traffic is routed here, stops accepting requests and
+// waits for those in flight, stops the webhook poller, waits for every
+// account's call in progress (and with it any History.Write) to finish
+// while refusing new ones, waits for webhook deliveries and their retries,
+// saves the client usage not yet persisted, and closes the storage. The
+// in-memory Things state is rebuilt from Things Cloud on start, so there
+// is nothing else to keep. The database is closed even when the deadline
+// passes.
+func (um *UserManager) shutdown(srv *http.Server, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	um.draining.Store(true)
+	logServer.Info("shutting down", "timeout", timeout)
+	if um.cluster != nil {
+		um.cluster.leave()
+	}
+
+	var errs []error
+	if srv != nil {
+		if err := srv.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("stop HTTP server: %w", err))
+		}
+	}
+	if um.webhooks != nil {
+		if err := um.webhooks.StopPolling(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("stop webhook poller: %w", err))
+		}
+	}
+	if err := um.drainCalls(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("wait for calls in progress: %w", err))
+	}
+	// Calls that just finished may have queued deliveries.
+	if um.webhooks != nil {
+		if err := um.webhooks.Drain(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("wait for webhook deliveries: %w", err))
+		}
+	}
+	if o := um.oauth; o != nil {
+		if err := o.flushClientUsage(); err != nil {
+			errs = append(errs, fmt.Errorf("save client usage: %w", err))
+		}
+		if err := o.store.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close database: %w", err))
+		}
+	}
+	err := errors.Join(errs...)
+	if err != nil {
+		logServer.Error("shutdown incomplete", "error", err)
+	} else {
+		logServer.Info("shutdown complete")
+	}
+	return err
+}
+
+// drainCalls waits until no account has a call in progress and keeps them
+// from starting new ones, by taking every account's call lock for good.
+func (um *UserManager) drainCalls(ctx context.Context) error {
+	um.mu.RLock()
+	users := make([]*ThingsMCP, 0, len(um.users))
+	for _, t := range um.users {
+		users = append(users, t)
+	}
+	um.mu.RUnlock()
+	for _, t := range users {
+		locked := make(chan struct{})
+		go func() {
+			t.callMu.Lock()
+			close(locked)
+		}()
+		select {
+		case <-locked:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
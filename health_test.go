@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthAndReadiness(t *testing.T) {
+	um := NewUserManager()
+	newTestOAuth(t, um)
+
+	ready := func() (int, readiness) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		um.handleReadyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var out readiness
+		json.Unmarshal(rec.Body.Bytes(), &out)
+		return rec.Code, out
+	}
+	if code, out := ready(); code != http.StatusOK || out.Status != "ready" {
+		t.Fatalf("readyz: %d %+v", code, out)
+	}
+	um.draining.Store(true)
+	if code, out := ready(); code != http.StatusServiceUnavailable || out.Checks["shutdown"] != "draining" || out.Checks["database"] != "ok" {
+		t.Errorf("readyz while draining: %d %+v", code, out)
+	}
+	um.draining.Store(false)
+	um.oauth.db.Close()
+	if code, out := ready(); code != http.StatusServiceUnavailable || out.Checks["database"] == "ok" {
+		t.Errorf("readyz with the database closed: %d %+v", code, out)
+	}
+
+	rec := httptest.NewRecorder()
+	handleHealthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("healthz: %d", rec.Code)
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	fc := newFakeCloud("shutdown@example.com", makeTaskItem("t-1", withTitle("Finish")))
+	defer fc.Close()
+	dataDir := t.TempDir()
+	um := NewUserManager()
+	um.oauth = NewOAuthServer(um, dataDir)
+	um.users[fc.email] = newTestThingsMCP(t, fc)
+	um.oauth.touchClient(fc.email, "client-1")
+	// A later use, not saved yet because of usageSaveInterval.
+	last := time.Now().Add(30 * time.Second).Truncate(time.Second)
+	um.oauth.lastUsed[fc.email+"|client-1"] = last
+
+	// A call in progress holds up the shutdown until it ends.
+	end := um.users[fc.email].beginCall(t.Context())
+	done := make(chan error, 1)
+	go func() { done <- um.shutdown(nil, 5*time.Second) }()
+	select {
+	case err := <-done:
+		t.Fatalf("shutdown did not wait for the call in progress: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if !um.draining.Load() {
+		t.Error("not draining during shutdown")
+	}
+	end()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := um.oauth.db.Ping(); err == nil {
+		t.Error("oauth.db still open")
+	}
+
+	o := NewOAuthServer(NewUserManager(), dataDir)
+	defer o.db.Close()
+	if usage, _ := o.store.ClientUsage(fc.email); !usage["client-1"].Equal(last) {
+		t.Errorf("client usage not flushed: got %v, want %v", usage["client-1"], last)
+	}
+
+	// A call that never ends is cut off at the deadline.
+	um = NewUserManager()
+	um.oauth = o
+	um.users[fc.email] = newTestThingsMCP(t, fc)
+	um.users[fc.email].beginCall(t.Context())
+	if err := um.shutdown(nil, 50*time.Millisecond); err == nil {
+		t.Error("shutdown past the deadline reported no error")
+	}
+}
+
+func TestShutdownWaitsForWebhooks(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	dataDir := t.TempDir()
+	um := NewUserManager()
+	um.oauth = NewOAuthServer(um, dataDir)
+	um.webhooks = NewWebhookStore(um.oauth.db)
+	um.webhooks.client = srv.Client()
+	um.webhooks.backoff = 50 * time.Millisecond
+	um.startPollingWebhooks(time.Hour)
+
+	// A delivery waiting to be retried is finished before the database closes.
+	wh, _ := um.webhooks.Create("hooks@example.com", srv.URL, nil)
+	um.webhooks.Dispatch("hooks@example.com", []changeEvent{{Type: "TaskCompleted", UUID: "t-1"}})
+	if err := um.shutdown(nil, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	um = NewUserManager()
+	um.oauth = NewOAuthServer(um, dataDir)
+	um.webhooks = NewWebhookStore(um.oauth.db)
+	um.webhooks.client = srv.Client()
+	um.webhooks.backoff = time.Hour
+	if attempts, _ := um.webhooks.Deliveries("hooks@example.com", wh.ID, false, 10); calls.Load() != 2 || len(attempts) != 2 || !attempts[0].Delivered {
+		t.Fatalf("deliveries: %d calls, attempts %+v", calls.Load(), attempts)
+	}
+
+	// One that would outlast the deadline is cut short.
+	calls.Store(0)
+	um.webhooks.Dispatch("hooks@example.com", []changeEvent{{Type: "TaskCompleted", UUID: "t-2"}})
+	start := time.Now()
+	if err := um.shutdown(nil, 200*time.Millisecond); err == nil {
+		t.Error("shutdown past the deadline reported no error")
+	}
+	if time.Since(start) > 2*time.Second || calls.Load() != 1 {
+		t.Errorf("abandoned delivery: %d calls, shutdown took %v", calls.Load(), time.Since(start))
+	}
+}
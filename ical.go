@@ -0,0 +1,531 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mark3labs/mcp-go/mcp"
+
+	thingscloud "github.com/arthursoares/things-cloud-sdk"
+	memory "github.com/arthursoares/things-cloud-sdk/state/memory"
+)
+
+// ---------------------------------------------------------------------------
+// Calendar feed tokens
+// ---------------------------------------------------------------------------
+
+// CalendarFeedStore persists the secret tokens behind /ical/{token}.ics URLs.
+// A token maps to an email and the filter the feed was created with; the
+// password comes from the OAuth credentials.
+type CalendarFeedStore struct {
+	db *sqlDB
+}
+
+// calendarFeedTokenRe matches tokens produced by randomString(32).
+var calendarFeedTokenRe = regexp.MustCompile(`^[A-Za-z0-9_-]{43}$`)
+
+// Create stores a new feed. filter is the query string of resolved UUIDs
+// the feed is limited to.
+func (cs *CalendarFeedStore) Create(email, filter string) (string, error) {
+	token := randomString(32)
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err := cs.db.Exec(
+		`INSERT INTO calendar_feeds (token, email, created_at, filter) VALUES (?, ?, ?, ?)`,
+		token, email, now, filter,
+	)
+	if err != nil {
+		return "", fmt.Errorf("store calendar feed: %w", err)
+	}
+	return token, nil
+}
+
+func (cs *CalendarFeedStore) Lookup(token string) (email, filter string, err error) {
+	err = cs.db.QueryRow(`SELECT email, filter FROM calendar_feeds WHERE token = ?`, token).Scan(&email, &filter)
+	return email, filter, err
+}
+
+// Revoke deletes one of the user's feeds, or all of them when token is empty.
+func (cs *CalendarFeedStore) Revoke(email, token string) (int64, error) {
+	var res sql.Result
+	var err error
+	if token == "" {
+		res, err = cs.db.Exec(`DELETE FROM calendar_feeds WHERE email = ?`, email)
+	} else {
+		res, err = cs.db.Exec(`DELETE FROM calendar_feeds WHERE email = ? AND token = ?`, email, token)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("revoke calendar feed: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// feedToken accepts either a bare token or a full feed URL.
+func feedToken(s string) string {
+	s = strings.TrimSpace(s)
+	if u, err := url.Parse(s); err == nil && strings.HasPrefix(u.Path, "/ical/") {
+		s = u.Path
+	}
+	s = strings.TrimPrefix(s, "/ical/")
+	return strings.TrimSuffix(s, ".ics")
+}
+
+// ---------------------------------------------------------------------------
+// Feed filter
+// ---------------------------------------------------------------------------
+
+// icsFilter selects which tasks end up in a feed. Empty lists match all.
+type icsFilter struct {
+	AreaUUIDs []string
+	TagUUIDs  []string
+	// DeadlinesAsEvents renders deadlines as all-day VEVENTs for calendar
+	// apps that ignore VTODO (Google Calendar, Outlook).
+	DeadlinesAsEvents bool
+	// Within is the filter stored with the feed. Query parameters can only
+	// narrow it.
+	Within *icsFilter
+}
+
+// storedICSFilter reads the filter a feed was created with. It holds
+// UUIDs only, so it is not resolved again and survives renames.
+func storedICSFilter(filter string) *icsFilter {
+	q, _ := url.ParseQuery(filter)
+	return &icsFilter{AreaUUIDs: q["area"], TagUUIDs: q["tag"], DeadlinesAsEvents: q.Get("deadlines") == "events"}
+}
+
+// parseICSFilter reads area/tag query parameters, each given as a UUID or
+// name and repeatable or comma-separated.
+func (t *ThingsMCP) parseICSFilter(q url.Values) (icsFilter, error) {
+	f := icsFilter{DeadlinesAsEvents: q.Get("deadlines") == "events"}
+	areas, err := resolveRefs(strings.Join(q["area"], ","), "area", t.validateAreaUUID, t.findAreaUUID)
+	if err != nil {
+		return f, err
+	}
+	tags, err := resolveRefs(strings.Join(q["tag"], ","), "tag", t.validateTagUUID, t.findTagUUID)
+	if err != nil {
+		return f, err
+	}
+	if areas != "" {
+		f.AreaUUIDs = strings.Split(areas, ",")
+	}
+	if tags != "" {
+		f.TagUUIDs = strings.Split(tags, ",")
+	}
+	return f, nil
+}
+
+func (t *ThingsMCP) icsMatches(task *thingscloud.Task, f icsFilter) bool {
+	if f.Within != nil && !t.icsMatches(task, *f.Within) {
+		return false
+	}
+	if len(f.AreaUUIDs) > 0 {
+		ok := false
+		for _, id := range f.AreaUUIDs {
+			if t.taskMatchesArea(task, id) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if len(f.TagUUIDs) > 0 {
+		ok := false
+		for _, id := range f.TagUUIDs {
+			if containsStr(task.TagIDs, id) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// ---------------------------------------------------------------------------
+// RRULE
+// ---------------------------------------------------------------------------
+
+var icsWeekdays = [...]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// repeaterRRULE converts a Things repeat rule into an RFC 5545 RRULE value.
+// Day and Month in the detail configuration are 0-based; -1 means "last".
+func repeaterRRULE(rc *thingscloud.RepeaterConfiguration) string {
+	var freq string
+	switch rc.FrequencyUnit {
+	case thingscloud.FrequencyUnitDaily:
+		freq = "DAILY"
+	case thingscloud.FrequencyUnitWeekly:
+		freq = "WEEKLY"
+	case thingscloud.FrequencyUnitMonthly:
+		freq = "MONTHLY"
+	case thingscloud.FrequencyUnitYearly:
+		freq = "YEARLY"
+	default:
+		return ""
+	}
+	parts := []string{"FREQ=" + freq}
+	if rc.FrequencyAmplitude > 1 {
+		parts = append(parts, fmt.Sprintf("INTERVAL=%d", rc.FrequencyAmplitude))
+	}
+
+	var byDay, byMonthDay, byMonth []string
+	for _, dc := range rc.DetailConfiguration {
+		if dc.Weekday != nil && *dc.Weekday >= 0 && int(*dc.Weekday) < len(icsWeekdays) {
+			wd := icsWeekdays[*dc.Weekday]
+			if dc.MonthOf != nil && *dc.MonthOf != 0 && rc.FrequencyUnit != thingscloud.FrequencyUnitWeekly {
+				wd = fmt.Sprintf("%d%s", *dc.MonthOf, wd)
+			}
+			byDay = appendUnique(byDay, wd)
+		} else if dc.Day != nil && rc.FrequencyUnit != thingscloud.FrequencyUnitDaily {
+			day := *dc.Day + 1
+			if *dc.Day == -1 {
+				day = -1
+			}
+			byMonthDay = appendUnique(byMonthDay, fmt.Sprint(day))
+		}
+		if dc.Month != nil && rc.FrequencyUnit == thingscloud.FrequencyUnitYearly {
+			byMonth = appendUnique(byMonth, fmt.Sprint(*dc.Month+1))
+		}
+	}
+	if len(byMonth) > 0 {
+		parts = append(parts, "BYMONTH="+strings.Join(byMonth, ","))
+	}
+	if len(byMonthDay) > 0 {
+		parts = append(parts, "BYMONTHDAY="+strings.Join(byMonthDay, ","))
+	}
+	if len(byDay) > 0 {
+		parts = append(parts, "BYDAY="+strings.Join(byDay, ","))
+	}
+
+	if !rc.IsNeverending() {
+		if rc.RepeatCount != nil && *rc.RepeatCount > 0 {
+			parts = append(parts, fmt.Sprintf("COUNT=%d", *rc.RepeatCount))
+		} else if rc.LastScheduledAt != nil {
+			parts = append(parts, "UNTIL="+rc.LastScheduledAt.Time().Format("20060102"))
+		}
+	}
+	return strings.Join(parts, ";")
+}
+
+func appendUnique(list []string, s string) []string {
+	if containsStr(list, s) {
+		return list
+	}
+	return append(list, s)
+}
+
+// ---------------------------------------------------------------------------
+// VCALENDAR rendering
+// ---------------------------------------------------------------------------
+
+// icsWriter emits CRLF-terminated content lines folded at 75 octets.
+type icsWriter struct {
+	b strings.Builder
+}
+
+func (w *icsWriter) line(name, value string) {
+	s := name + ":" + value
+	for len(s) > 75 {
+		cut := 75
+		for !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		w.b.WriteString(s[:cut] + "\r\n ")
+		s = s[cut:]
+	}
+	w.b.WriteString(s + "\r\n")
+}
+
+func (w *icsWriter) text(name, value string) {
+	w.line(name, icsEscape(value))
+}
+
+func icsEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// renderICS builds a VCALENDAR from the current state. Scheduled dates become
+// VEVENTs (timed, with an alarm, when the task has a reminder), deadlines
+// become VTODOs, and recurring templates become a single VEVENT with an
+// RRULE; their generated instances are skipped so they don't show twice.
+func (t *ThingsMCP) renderICS(f icsFilter, now time.Time) string {
+	state := t.getState()
+	stamp := now.UTC().Format("20060102T150405Z")
+
+	var tasks []*thingscloud.Task
+	for _, task := range state.Tasks {
+		if task.InTrash || task.Status != thingscloud.TaskStatusPending || task.Type == thingscloud.TaskTypeHeading || isUntitledTask(task) {
+			continue
+		}
+		if instanceOfKnownTemplate(task, state) || !t.icsMatches(task, f) {
+			continue
+		}
+		tasks = append(tasks, task)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].UUID < tasks[j].UUID })
+
+	w := &icsWriter{}
+	w.line("BEGIN", "VCALENDAR")
+	w.line("VERSION", "2.0")
+	w.line("PRODID", "-//Things Cloud MCP//Calendar Feed//EN")
+	w.line("CALSCALE", "GREGORIAN")
+	w.line("METHOD", "PUBLISH")
+	w.text("X-WR-CALNAME", "Things")
+	for _, task := range tasks {
+		if task.Repeater != nil {
+			start := task.ScheduledDate
+			if task.Repeater.FirstScheduledAt != nil {
+				start = task.Repeater.FirstScheduledAt.Time()
+			}
+			if rule := repeaterRRULE(task.Repeater); rule != "" && start != nil && start.Year() > 1970 {
+				w.event(task, state, "repeat", *start, rule, stamp)
+			}
+			continue
+		}
+		date := task.ScheduledDate
+		if task.TodayIndexRefDate != nil {
+			date = task.TodayIndexRefDate
+		}
+		if date != nil && date.Year() > 1970 && task.Schedule != thingscloud.TaskScheduleSomeday {
+			w.event(task, state, "scheduled", *date, "", stamp)
+		}
+		if task.DeadlineDate != nil && task.DeadlineDate.Year() > 1970 {
+			if f.DeadlinesAsEvents {
+				w.event(task, state, "deadline", *task.DeadlineDate, "", stamp)
+			} else {
+				w.todo(task, state, *task.DeadlineDate, stamp)
+			}
+		}
+	}
+	w.line("END", "VCALENDAR")
+	return w.b.String()
+}
+
+func instanceOfKnownTemplate(task *thingscloud.Task, state *memory.State) bool {
+	for _, id := range task.RecurrenceIDs {
+		if _, ok := state.Tasks[id]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// event writes a VEVENT. Tasks with a reminder get a 15-minute slot at the
+// reminder time plus a display alarm; everything else is an all-day event.
+func (w *icsWriter) event(task *thingscloud.Task, state *memory.State, kind string, date time.Time, rrule, stamp string) {
+	summary := task.Title
+	if kind == "deadline" {
+		summary = "Deadline: " + task.Title
+	}
+	w.line("BEGIN", "VEVENT")
+	w.line("UID", task.UUID+"-"+kind+"@thingscloudmcp.com")
+	w.line("DTSTAMP", stamp)
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	timed := kind != "deadline" && task.AlarmTimeOffset != nil
+	if timed {
+		start := day.Add(time.Duration(*task.AlarmTimeOffset) * time.Second)
+		w.line("DTSTART", start.Format("20060102T150405"))
+		w.line("DURATION", "PT15M")
+	} else {
+		w.line("DTSTART;VALUE=DATE", day.Format("20060102"))
+		w.line("DTEND;VALUE=DATE", day.AddDate(0, 0, 1).Format("20060102"))
+		w.line("TRANSP", "TRANSPARENT")
+	}
+	if rrule != "" {
+		w.line("RRULE", rrule)
+	}
+	w.properties(task, state, summary)
+	if timed {
+		w.line("BEGIN", "VALARM")
+		w.line("ACTION", "DISPLAY")
+		w.text("DESCRIPTION", task.Title)
+		w.line("TRIGGER", "PT0M")
+		w.line("END", "VALARM")
+	}
+	w.line("END", "VEVENT")
+}
+
+func (w *icsWriter) todo(task *thingscloud.Task, state *memory.State, due time.Time, stamp string) {
+	w.line("BEGIN", "VTODO")
+	w.line("UID", task.UUID+"-deadline@thingscloudmcp.com")
+	w.line("DTSTAMP", stamp)
+	w.line("DUE;VALUE=DATE", due.Format("20060102"))
+	w.line("STATUS", "NEEDS-ACTION")
+	w.properties(task, state, task.Title)
+	w.line("END", "VTODO")
+}
+
+func (w *icsWriter) properties(task *thingscloud.Task, state *memory.State, summary string) {
+	w.text("SUMMARY", summary)
+	if task.Note != "" {
+		w.text("DESCRIPTION", task.Note)
+	}
+	var tags []string
+	for _, id := range task.TagIDs {
+		if tag, ok := state.Tags[id]; ok {
+			tags = append(tags, icsEscape(tag.Title))
+		}
+	}
+	if len(tags) > 0 {
+		w.line("CATEGORIES", strings.Join(tags, ","))
+	}
+	w.line("URL", "things:///show?id="+task.UUID)
+}
+
+// ---------------------------------------------------------------------------
+// HTTP endpoint and tools
+// ---------------------------------------------------------------------------
+
+// handleCalendarFeed serves GET /ical/{token}.ics. The token is the only
+// credential, so every failure to resolve it is a plain 404.
+func (um *UserManager) handleCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/ical/")
+	token := strings.TrimSuffix(name, ".ics")
+	if token == name || !calendarFeedTokenRe.MatchString(token) || um.calendarFeeds == nil || um.oauth == nil {
+		http.NotFound(w, r)
+		return
+	}
+	email, stored, err := um.calendarFeeds.Lookup(token)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	password, ok := um.oauth.storedPassword(email)
+	if !ok {
+		logServer.WarnContext(r.Context(), "calendar feed has no stored credentials", "email", email)
+		http.NotFound(w, r)
+		return
+	}
+	t, err := um.GetOrCreateUser(r.Context(), email, password)
+	if err != nil {
+		http.Error(w, "Things Cloud unavailable", http.StatusBadGateway)
+		return
+	}
+	if err := t.syncFor(r.Context()); err != nil {
+		http.Error(w, "Things Cloud unavailable", http.StatusBadGateway)
+		return
+	}
+	f, err := t.parseICSFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.Within = storedICSFilter(stored)
+	f.DeadlinesAsEvents = f.DeadlinesAsEvents || f.Within.DeadlinesAsEvents
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="things.ics"`)
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	w.Write([]byte(t.renderICS(f, time.Now())))
+}
+
+// calendarFeedOutput is what things_create_calendar_feed returns.
+type calendarFeedOutput struct {
+	URL   string `json:"url"`
+	Token string `json:"token"`
+	Note  string `json:"note"`
+}
+
+type feedsRevokedOutput struct {
+	Status  string `json:"status"`
+	Revoked int64  `json:"revoked"`
+}
+
+func (um *UserManager) handleCreateCalendarFeed(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	if um.calendarFeeds == nil || um.oauth == nil {
+		return errResultCode(codeUnavailable, "calendar feeds are not available on this server"), nil
+	}
+	email, password, err := extractCredentials(ctx, um)
+	if err != nil {
+		return errResultFrom(err), nil
+	}
+	t, err := getUserFromContext(ctx, um)
+	if err != nil {
+		return errResultFrom(err), nil
+	}
+	if err := t.syncFor(ctx); err != nil {
+		return errResultFrom(fmt.Errorf("sync: %w", err)), nil
+	}
+
+	q := url.Values{}
+	if v := req.GetString("area", ""); v != "" {
+		q.Set("area", v)
+	}
+	if v := req.GetString("tag", ""); v != "" {
+		q.Set("tag", v)
+	}
+	if req.GetBool("deadlines_as_events", false) {
+		q.Set("deadlines", "events")
+	}
+	f, err := t.parseICSFilter(q)
+	if err != nil {
+		return errResultFrom(err), nil
+	}
+	// Store resolved UUIDs so renaming an area doesn't break the feed.
+	q.Del("area")
+	q.Del("tag")
+	for _, id := range f.AreaUUIDs {
+		q.Add("area", id)
+	}
+	for _, id := range f.TagUUIDs {
+		q.Add("tag", id)
+	}
+
+	token, err := um.calendarFeeds.Create(email, q.Encode())
+	if err != nil {
+		return errResultFrom(err), nil
+	}
+	// The feed is fetched without an Authorization header, so Basic-auth
+	// users need their credentials kept the same way OAuth users have.
+	um.oauth.rememberCredentials(email, password)
+
+	feedURL := getBaseURLFromContext(ctx) + "/ical/" + token + ".ics"
+	if len(q) > 0 {
+		feedURL += "?" + q.Encode()
+	}
+	return jsonResult(calendarFeedOutput{
+		URL:   feedURL,
+		Token: token,
+		Note:  "Anyone with this URL can read the matching tasks. Revoke it with things_revoke_calendar_feed.",
+	}), nil
+}
+
+func (um *UserManager) handleRevokeCalendarFeed(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	if um.calendarFeeds == nil {
+		return errResultCode(codeUnavailable, "calendar feeds are not available on this server"), nil
+	}
+	email, _, err := extractCredentials(ctx, um)
+	if err != nil {
+		return errResultFrom(err), nil
+	}
+	token := feedToken(req.GetString("feed", ""))
+	all := req.GetBool("all", false)
+	if token == "" && !all {
+		return errResult("feed (URL or token) is required unless all=true"), nil
+	}
+	if all {
+		token = ""
+	}
+	n, err := um.calendarFeeds.Revoke(email, token)
+	if err != nil {
+		return errResultFrom(err), nil
+	}
+	if n == 0 && !all {
+		return errResultCode(codeNotFound, "calendar feed not found"), nil
+	}
+	return jsonResult(feedsRevokedOutput{Status: "revoked", Revoked: n}), nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	thingscloud "github.com/arthursoares/things-cloud-sdk"
+)
+
+func withAlarm(secs int) taskOption {
+	return func(p *thingscloud.TaskActionItemPayload) {
+		p.AlarmTimeOffset = &secs
+	}
+}
+
+func TestRepeaterRRULE(t *testing.T) {
+	i64 := func(n int64) *int64 { return &n }
+	wd := func(d time.Weekday) *time.Weekday { return &d }
+	until := thingscloud.Timestamp(mustTime("2025-06-30"))
+	never := thingscloud.Timestamp(mustTime("4001-01-01"))
+
+	tests := []struct {
+		name string
+		rc   thingscloud.RepeaterConfiguration
+		want string
+	}{
+		{"daily", thingscloud.RepeaterConfiguration{FrequencyUnit: thingscloud.FrequencyUnitDaily, FrequencyAmplitude: 1, LastScheduledAt: &never}, "FREQ=DAILY"},
+		{"every 3 days", thingscloud.RepeaterConfiguration{FrequencyUnit: thingscloud.FrequencyUnitDaily, FrequencyAmplitude: 3}, "FREQ=DAILY;INTERVAL=3"},
+		{"weekly on days", thingscloud.RepeaterConfiguration{
+			FrequencyUnit: thingscloud.FrequencyUnitWeekly, FrequencyAmplitude: 1,
+			DetailConfiguration: []thingscloud.RepeaterDetailConfiguration{{Weekday: wd(time.Monday)}, {Weekday: wd(time.Wednesday)}},
+		}, "FREQ=WEEKLY;BYDAY=MO,WE"},
+		{"monthly on day", thingscloud.RepeaterConfiguration{
+			FrequencyUnit: thingscloud.FrequencyUnitMonthly, FrequencyAmplitude: 1,
+			DetailConfiguration: []thingscloud.RepeaterDetailConfiguration{{Day: i64(14)}},
+		}, "FREQ=MONTHLY;BYMONTHDAY=15"},
+		{"monthly last day", thingscloud.RepeaterConfiguration{
+			FrequencyUnit: thingscloud.FrequencyUnitMonthly, FrequencyAmplitude: 2,
+			DetailConfiguration: []thingscloud.RepeaterDetailConfiguration{{Day: i64(-1)}},
+		}, "FREQ=MONTHLY;INTERVAL=2;BYMONTHDAY=-1"},
+		{"monthly nth weekday", thingscloud.RepeaterConfiguration{
+			FrequencyUnit: thingscloud.FrequencyUnitMonthly, FrequencyAmplitude: 1,
+			DetailConfiguration: []thingscloud.RepeaterDetailConfiguration{{Weekday: wd(time.Tuesday), MonthOf: i64(2)}},
+		}, "FREQ=MONTHLY;BYDAY=2TU"},
+		{"monthly last weekday", thingscloud.RepeaterConfiguration{
+			FrequencyUnit: thingscloud.FrequencyUnitMonthly, FrequencyAmplitude: 1,
+			DetailConfiguration: []thingscloud.RepeaterDetailConfiguration{{Weekday: wd(time.Friday), MonthOf: i64(-1)}},
+		}, "FREQ=MONTHLY;BYDAY=-1FR"},
+		{"yearly on date", thingscloud.RepeaterConfiguration{
+			FrequencyUnit: thingscloud.FrequencyUnitYearly, FrequencyAmplitude: 1,
+			DetailConfiguration: []thingscloud.RepeaterDetailConfiguration{{Month: i64(2), Day: i64(9)}},
+		}, "FREQ=YEARLY;BYMONTH=3;BYMONTHDAY=10"},
+		{"until", thingscloud.RepeaterConfiguration{FrequencyUnit: thingscloud.FrequencyUnitDaily, FrequencyAmplitude: 1, LastScheduledAt: &until}, "FREQ=DAILY;UNTIL=20250630"},
+		{"count", thingscloud.RepeaterConfiguration{FrequencyUnit: thingscloud.FrequencyUnitDaily, FrequencyAmplitude: 1, RepeatCount: i64(5), LastScheduledAt: &until}, "FREQ=DAILY;COUNT=5"},
+		{"unknown unit", thingscloud.RepeaterConfiguration{FrequencyUnit: 1}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := repeaterRRULE(&tt.rc); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestICSWriterFolding(t *testing.T) {
+	w := &icsWriter{}
+	w.text("SUMMARY", strings.Repeat("é", 50)+", done; ok\nnext")
+	out := w.b.String()
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line longer than 75 octets: %q", line)
+		}
+	}
+	unfolded := strings.ReplaceAll(out, "\r\n ", "")
+	if want := "SUMMARY:" + strings.Repeat("é", 50) + `\, done\; ok\nnext` + "\r\n"; unfolded != want {
+		t.Errorf("unfolded: got %q, want %q", unfolded, want)
+	}
+}
+
+func newICSFixture(t *testing.T) *ThingsMCP {
+	t.Helper()
+	wd := time.Monday
+	first := thingscloud.Timestamp(mustTime("2025-01-06"))
+	fc := newFakeCloud("ical@example.com",
+		makeAreaItem("area-1", "Work"),
+		makeTagItem("tag-1", "Errand"),
+		makeTaskItem("proj-1", withTitle("Launch"), withTaskType(thingscloud.TaskTypeProject), withArea("area-1"), withDeadline(mustTime("2025-03-01"))),
+		makeTaskItem("t-sched", withTitle("Call Bob"), withParent("proj-1"), withScheduledDate(mustTime("2025-02-10")), withAlarm(9*3600+30*60)),
+		makeTaskItem("t-due", withTitle("Buy stamps"), withTags("tag-1"), withScheduledDate(mustTime("2025-02-11")), withDeadline(mustTime("2025-02-12")), withNote("at the post office")),
+		makeTaskItem("t-done", withTitle("Old"), withStatus(thingscloud.TaskStatusCompleted), withDeadline(mustTime("2025-02-01"))),
+		makeTaskItem("t-trash", withTitle("Binned"), withTrashed(), withDeadline(mustTime("2025-02-01"))),
+		makeTaskItem("tmpl", withTitle("Standup"), withArea("area-1"), withRepeater(thingscloud.RepeaterConfiguration{
+			FirstScheduledAt:    &first,
+			FrequencyUnit:       thingscloud.FrequencyUnitWeekly,
+			FrequencyAmplitude:  1,
+			DetailConfiguration: []thingscloud.RepeaterDetailConfiguration{{Weekday: &wd}},
+		})),
+		makeTaskItem("inst", withTitle("Standup"), withRecurrenceTemplate("tmpl"), withScheduledDate(mustTime("2025-02-10"))),
+	)
+	t.Cleanup(fc.Close)
+	return newTestThingsMCP(t, fc)
+}
+
+func TestRenderICS(t *testing.T) {
+	tmcp := newICSFixture(t)
+	now := mustTime("2025-02-01")
+
+	t.Run("all tasks", func(t *testing.T) {
+		ics := tmcp.renderICS(icsFilter{}, now)
+		for _, want := range []string{
+			"BEGIN:VCALENDAR\r\nVERSION:2.0\r\n",
+			"UID:t-sched-scheduled@thingscloudmcp.com\r\nDTSTAMP:20250201T000000Z\r\nDTSTART:20250210T093000\r\nDURATION:PT15M\r\n",
+			"BEGIN:VALARM\r\nACTION:DISPLAY\r\nDESCRIPTION:Call Bob\r\nTRIGGER:PT0M\r\nEND:VALARM\r\n",
+			"UID:t-due-scheduled@thingscloudmcp.com\r\nDTSTAMP:20250201T000000Z\r\nDTSTART;VALUE=DATE:20250211\r\nDTEND;VALUE=DATE:20250212\r\n",
+			"BEGIN:VTODO\r\nUID:t-due-deadline@thingscloudmcp.com\r\nDTSTAMP:20250201T000000Z\r\nDUE;VALUE=DATE:20250212\r\nSTATUS:NEEDS-ACTION\r\nSUMMARY:Buy stamps\r\nDESCRIPTION:at the post office\r\nCATEGORIES:Errand\r\nURL:things:///show?id=t-due\r\n",
+			"UID:proj-1-deadline@thingscloudmcp.com",
+			"UID:tmpl-repeat@thingscloudmcp.com\r\nDTSTAMP:20250201T000000Z\r\nDTSTART;VALUE=DATE:20250106\r\nDTEND;VALUE=DATE:20250107\r\nTRANSP:TRANSPARENT\r\nRRULE:FREQ=WEEKLY;BYDAY=MO\r\n",
+			"END:VCALENDAR\r\n",
+		} {
+			if !strings.Contains(ics, want) {
+				t.Errorf("feed missing %q:\n%s", want, ics)
+			}
+		}
+		for _, absent := range []string{"t-done", "t-trash", "inst-"} {
+			if strings.Contains(ics, absent) {
+				t.Errorf("feed should not contain %q:\n%s", absent, ics)
+			}
+		}
+	})
+
+	t.Run("area filter includes project tasks", func(t *testing.T) {
+		f, err := tmcp.parseICSFilter(url.Values{"area": {"work"}})
+		if err != nil {
+			t.Fatalf("parseICSFilter: %v", err)
+		}
+		ics := tmcp.renderICS(f, now)
+		if !strings.Contains(ics, "t-sched-scheduled") || !strings.Contains(ics, "tmpl-repeat") || strings.Contains(ics, "t-due") {
+			t.Errorf("unexpected area feed:\n%s", ics)
+		}
+	})
+
+	t.Run("tag filter with deadlines as events", func(t *testing.T) {
+		f, err := tmcp.parseICSFilter(url.Values{"tag": {"tag-1"}, "deadlines": {"events"}})
+		if err != nil {
+			t.Fatalf("parseICSFilter: %v", err)
+		}
+		ics := tmcp.renderICS(f, now)
+		if strings.Contains(ics, "VTODO") || !strings.Contains(ics, "SUMMARY:Deadline: Buy stamps") || strings.Contains(ics, "t-sched") {
+			t.Errorf("unexpected tag feed:\n%s", ics)
+		}
+	})
+
+	t.Run("unknown filter", func(t *testing.T) {
+		if _, err := tmcp.parseICSFilter(url.Values{"tag": {"nope"}}); err == nil {
+			t.Error("expected error for unknown tag")
+		}
+	})
+}
+
+func TestCalendarFeedEndpoint(t *testing.T) {
+	tmcp := newICSFixture(t)
+
+	const email = "ical@example.com"
+	um := NewUserManager()
+	um.users[email] = tmcp
+	um.calendarFeeds = &CalendarFeedStore{db: newTestOAuth(t, um).db}
+
+	ctx := context.WithValue(context.Background(), userContextKey, &UserInfo{Email: email, Password: "testpass"})
+	ctx = context.WithValue(ctx, baseURLContextKey, "https://example.test")
+
+	result, _ := um.handleCreateCalendarFeed(ctx, makeReq(map[string]any{"area": "Work"}))
+	assertNotError(t, result)
+	created := resultJSON[map[string]any](t, result)
+	feedURL, _ := created["url"].(string)
+	if !strings.HasPrefix(feedURL, "https://example.test/ical/") || !strings.HasSuffix(feedURL, ".ics?area=area-1") {
+		t.Fatalf("url: got %q", feedURL)
+	}
+	if pw, ok := um.oauth.storedPassword(email); !ok || pw != "testpass" {
+		t.Errorf("credentials not stored for feed")
+	}
+
+	fetch := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		um.handleCalendarFeed(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+	path := strings.TrimPrefix(feedURL, "https://example.test")
+	rec := fetch(path)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/calendar; charset=utf-8" {
+		t.Fatalf("feed: got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "tmpl-repeat") || strings.Contains(body, "t-due") {
+		t.Errorf("feed body not filtered by area:\n%s", body)
+	}
+	// The area is stored with the token: dropping or changing the query
+	// does not widen the feed, but a tag can still narrow it.
+	for _, target := range []string{strings.Split(path, "?")[0], strings.Split(path, "?")[0] + "?tag=tag-1"} {
+		if body := fetch(target).Body.String(); strings.Contains(body, "t-due") {
+			t.Errorf("%s: feed widened past its area:\n%s", target, body)
+		}
+	}
+	if body := fetch(path + "&tag=tag-1").Body.String(); strings.Contains(body, "tmpl-repeat") {
+		t.Errorf("tag did not narrow the feed:\n%s", body)
+	}
+	if rec := fetch("/ical/" + strings.Repeat("x", 43) + ".ics"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown token: got %d, want 404", rec.Code)
+	}
+
+	result, _ = um.handleRevokeCalendarFeed(ctx, makeReq(map[string]any{"feed": feedURL}))
+	assertNotError(t, result)
+	if rec := fetch(path); rec.Code != http.StatusNotFound {
+		t.Errorf("revoked feed: got %d, want 404", rec.Code)
+	}
+	result, _ = um.handleRevokeCalendarFeed(ctx, makeReq(map[string]any{"feed": feedURL}))
+	assertIsError(t, result)
+	result, _ = um.handleRevokeCalendarFeed(ctx, makeReq(map[string]any{}))
+	assertIsError(t, result)
+}
@@ -0,0 +1,1119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+
+	thingscloud "github.com/arthursoares/things-cloud-sdk"
+)
+
+// importChunkSize caps the number of items sent in one History.Write commit.
+const importChunkSize = 100
+
+// ---------------------------------------------------------------------------
+// Import plan
+//
+// Every source format is parsed into the same tree, which is then turned
+// into create payloads. Areas and tags are matched to existing ones by name;
+// everything else is always created.
+// ---------------------------------------------------------------------------
+
+type importPlan struct {
+	Areas    []*importArea    `json:"areas,omitempty"`
+	Projects []*importProject `json:"projects,omitempty"` // projects without an area
+	Tasks    []*importTask    `json:"tasks,omitempty"`    // Inbox tasks
+	Warnings []string         `json:"warnings,omitempty"`
+	skipped  int              // completed items left out
+}
+
+type importArea struct {
+	Title    string           `json:"title"`
+	Projects []*importProject `json:"projects,omitempty"`
+	Tasks    []*importTask    `json:"tasks,omitempty"`
+}
+
+type importProject struct {
+	Title    string           `json:"title"`
+	Note     string           `json:"note,omitempty"`
+	Deadline string           `json:"deadline,omitempty"`
+	Tags     []string         `json:"tags,omitempty"`
+	Headings []*importHeading `json:"headings,omitempty"`
+	Tasks    []*importTask    `json:"tasks,omitempty"`
+}
+
+type importHeading struct {
+	Title string        `json:"title"`
+	Tasks []*importTask `json:"tasks,omitempty"`
+}
+
+type importTask struct {
+	Title     string   `json:"title"`
+	Note      string   `json:"note,omitempty"`
+	Deadline  string   `json:"deadline,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	Checklist []string `json:"checklist,omitempty"`
+}
+
+func (p *importPlan) warnf(format string, args ...any) {
+	p.Warnings = append(p.Warnings, fmt.Sprintf(format, args...))
+}
+
+func (p *importPlan) area(title string) *importArea {
+	for _, a := range p.Areas {
+		if strings.EqualFold(a.Title, title) {
+			return a
+		}
+	}
+	a := &importArea{Title: title}
+	p.Areas = append(p.Areas, a)
+	return a
+}
+
+func (p *importProject) heading(title string) *importHeading {
+	for _, h := range p.Headings {
+		if h.Title == title {
+			return h
+		}
+	}
+	h := &importHeading{Title: title}
+	p.Headings = append(p.Headings, h)
+	return h
+}
+
+func appendNote(note *string, text string) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return
+	}
+	if *note != "" {
+		*note += "\n"
+	}
+	*note += text
+}
+
+func addTag(tags []string, name string) []string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return tags
+	}
+	for _, t := range tags {
+		if strings.EqualFold(t, name) {
+			return tags
+		}
+	}
+	return append(tags, name)
+}
+
+// priorityTag maps a normalised priority (1 = highest) to a tag; the lowest
+// level means "no priority" in every source format and maps to nothing.
+func priorityTag(tags []string, p int) []string {
+	if p >= 1 && p <= 3 {
+		return addTag(tags, fmt.Sprintf("p%d", p))
+	}
+	return tags
+}
+
+// importDate accepts YYYY-MM-DD optionally followed by a time and returns the
+// date part, or "" when the value is not a calendar date (e.g. "every mon").
+func importDate(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 10 && parseDate(s[:10]) != nil {
+		return s[:10]
+	}
+	return ""
+}
+
+// setDeadline records a due date, keeping unparseable ones in the note so
+// nothing is silently lost.
+func (p *importPlan) setDeadline(task *importTask, due string) {
+	due = strings.TrimSpace(due)
+	if due == "" {
+		return
+	}
+	if d := importDate(due); d != "" {
+		task.Deadline = d
+		return
+	}
+	appendNote(&task.Note, "Due: "+due)
+	p.warnf("%q: due date %q is not a calendar date; kept in the note", task.Title, due)
+}
+
+var inlineTagRe = regexp.MustCompile(`(?:^|\s)@([\p{L}\p{N}_\-]+)(?:\(([^)]*)\))?`)
+
+// splitInlineTags removes @tag and @tag(value) tokens from s.
+func splitInlineTags(s string) (string, [][2]string) {
+	var tags [][2]string
+	for _, m := range inlineTagRe.FindAllStringSubmatch(s, -1) {
+		tags = append(tags, [2]string{m[1], m[2]})
+	}
+	return strings.Join(strings.Fields(inlineTagRe.ReplaceAllString(s, " ")), " "), tags
+}
+
+// ---------------------------------------------------------------------------
+// Todoist CSV
+//
+// One file per project with TYPE,CONTENT,DESCRIPTION,PRIORITY,INDENT,...
+// columns. Sections become headings, INDENT > 1 rows become checklist items,
+// "note" rows (comments) are appended to the task's note and @labels in the
+// content become tags. PRIORITY is 1 (highest) to 4 (none).
+// ---------------------------------------------------------------------------
+
+func parseTodoistCSV(r io.Reader, projectTitle string) (*importPlan, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("parse todoist csv: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("parse todoist csv: empty file")
+	}
+	col := map[string]int{}
+	for i, name := range rows[0] {
+		col[strings.ToUpper(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	if _, ok := col["TYPE"]; !ok {
+		return nil, fmt.Errorf("parse todoist csv: missing TYPE column")
+	}
+	if _, ok := col["CONTENT"]; !ok {
+		return nil, fmt.Errorf("parse todoist csv: missing CONTENT column")
+	}
+	field := func(row []string, name string) string {
+		if i, ok := col[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	plan := &importPlan{}
+	project := &importProject{Title: projectTitle}
+	plan.Projects = append(plan.Projects, project)
+	var heading *importHeading
+	var last *importTask
+
+	for _, row := range rows[1:] {
+		content := field(row, "CONTENT")
+		switch strings.ToLower(field(row, "TYPE")) {
+		case "section":
+			heading = project.heading(content)
+			last = nil
+		case "note":
+			if last != nil {
+				appendNote(&last.Note, content)
+			} else {
+				appendNote(&project.Note, content)
+			}
+		case "task":
+			indent, _ := strconv.Atoi(field(row, "INDENT"))
+			title, labels := splitInlineTags(content)
+			if indent > 1 && last != nil {
+				last.Checklist = append(last.Checklist, title)
+				continue
+			}
+			if title == "" {
+				continue
+			}
+			task := &importTask{Title: title, Note: field(row, "DESCRIPTION")}
+			for _, l := range labels {
+				task.Tags = addTag(task.Tags, l[0])
+			}
+			prio, _ := strconv.Atoi(field(row, "PRIORITY"))
+			task.Tags = priorityTag(task.Tags, prio)
+			plan.setDeadline(task, field(row, "DATE"))
+			if heading != nil {
+				heading.Tasks = append(heading.Tasks, task)
+			} else {
+				project.Tasks = append(project.Tasks, task)
+			}
+			last = task
+		}
+	}
+	return plan, nil
+}
+
+// ---------------------------------------------------------------------------
+// Todoist JSON
+//
+// Sync/REST API shaped export: {projects, sections, items|tasks, labels}.
+// Top-level projects that have sub-projects become areas; their descendants
+// become projects in that area. Sub-tasks become checklist items of their
+// top-level task. API priority is 4 (highest) to 1 (none).
+// ---------------------------------------------------------------------------
+
+// todoistID accepts both numeric (v8) and string (v9+) identifiers.
+type todoistID string
+
+func (id *todoistID) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*id = ""
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*id = todoistID(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return err
+	}
+	*id = todoistID(n.String())
+	return nil
+}
+
+type todoistItem struct {
+	ID          todoistID `json:"id"`
+	Content     string    `json:"content"`
+	Description string    `json:"description"`
+	ProjectID   todoistID `json:"project_id"`
+	SectionID   todoistID `json:"section_id"`
+	ParentID    todoistID `json:"parent_id"`
+	Labels      []string  `json:"labels"`
+	Priority    int       `json:"priority"`
+	ChildOrder  int       `json:"child_order"`
+	Checked     bool      `json:"checked"`
+	IsCompleted bool      `json:"is_completed"`
+	Due         *struct {
+		Date   string `json:"date"`
+		String string `json:"string"`
+	} `json:"due"`
+}
+
+type todoistExport struct {
+	Projects []struct {
+		ID           todoistID `json:"id"`
+		Name         string    `json:"name"`
+		ParentID     todoistID `json:"parent_id"`
+		InboxProject bool      `json:"inbox_project"`
+		IsInbox      bool      `json:"is_inbox_project"`
+		ChildOrder   int       `json:"child_order"`
+	} `json:"projects"`
+	Sections []struct {
+		ID           todoistID `json:"id"`
+		Name         string    `json:"name"`
+		ProjectID    todoistID `json:"project_id"`
+		SectionOrder int       `json:"section_order"`
+	} `json:"sections"`
+	Items []todoistItem `json:"items"`
+	Tasks []todoistItem `json:"tasks"`
+}
+
+func parseTodoistJSON(data []byte) (*importPlan, error) {
+	var ex todoistExport
+	if err := json.Unmarshal(data, &ex); err != nil {
+		return nil, fmt.Errorf("parse todoist json: %w", err)
+	}
+	items := append(ex.Items, ex.Tasks...)
+	if len(ex.Projects) == 0 && len(items) == 0 {
+		return nil, fmt.Errorf("parse todoist json: no projects or items found")
+	}
+
+	plan := &importPlan{}
+	parentOf := map[todoistID]todoistID{}
+	hasChildren := map[todoistID]bool{}
+	for _, p := range ex.Projects {
+		if p.ParentID != "" {
+			parentOf[p.ID] = p.ParentID
+			hasChildren[p.ParentID] = true
+		}
+	}
+	rootOf := func(id todoistID) todoistID {
+		for i := 0; i < 32 && parentOf[id] != ""; i++ {
+			id = parentOf[id]
+		}
+		return id
+	}
+
+	sort.SliceStable(ex.Projects, func(i, j int) bool { return ex.Projects[i].ChildOrder < ex.Projects[j].ChildOrder })
+	inbox := map[todoistID]bool{}
+	areas := map[todoistID]*importArea{}
+	projects := map[todoistID]*importProject{}
+	for _, p := range ex.Projects {
+		if p.InboxProject || p.IsInbox {
+			inbox[p.ID] = true
+			continue
+		}
+		root := rootOf(p.ID)
+		if root == p.ID && hasChildren[p.ID] {
+			areas[p.ID] = plan.area(p.Name)
+			continue
+		}
+		proj := &importProject{Title: p.Name}
+		projects[p.ID] = proj
+		if root == p.ID {
+			plan.Projects = append(plan.Projects, proj)
+			continue
+		}
+		if parentOf[p.ID] != root {
+			plan.warnf("project %q is nested more than one level deep; placed directly in its top-level area", p.Name)
+		}
+		// The root may be listed after its children; resolve it lazily.
+		a, ok := areas[root]
+		if !ok {
+			for _, rp := range ex.Projects {
+				if rp.ID == root {
+					a = plan.area(rp.Name)
+					areas[root] = a
+					break
+				}
+			}
+		}
+		if a == nil {
+			plan.Projects = append(plan.Projects, proj)
+			continue
+		}
+		a.Projects = append(a.Projects, proj)
+	}
+
+	sort.SliceStable(ex.Sections, func(i, j int) bool { return ex.Sections[i].SectionOrder < ex.Sections[j].SectionOrder })
+	headings := map[todoistID]*importHeading{}
+	for _, s := range ex.Sections {
+		if proj, ok := projects[s.ProjectID]; ok {
+			headings[s.ID] = proj.heading(s.Name)
+		} else {
+			plan.warnf("section %q is not in an importable project; its tasks are placed without a heading", s.Name)
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool { return items[i].ChildOrder < items[j].ChildOrder })
+	itemParent := map[todoistID]todoistID{}
+	for _, it := range items {
+		if it.ParentID != "" {
+			itemParent[it.ID] = it.ParentID
+		}
+	}
+	done := map[todoistID]bool{}
+	tasks := map[todoistID]*importTask{}
+	for _, it := range items {
+		if it.ParentID != "" {
+			continue
+		}
+		if it.Checked || it.IsCompleted {
+			done[it.ID] = true
+			plan.skipped++
+			continue
+		}
+		title, inline := splitInlineTags(it.Content)
+		task := &importTask{Title: title, Note: it.Description}
+		for _, l := range it.Labels {
+			task.Tags = addTag(task.Tags, l)
+		}
+		for _, l := range inline {
+			task.Tags = addTag(task.Tags, l[0])
+		}
+		if it.Priority > 1 {
+			task.Tags = priorityTag(task.Tags, 5-it.Priority)
+		}
+		if it.Due != nil {
+			due := it.Due.Date
+			if importDate(due) == "" && it.Due.String != "" {
+				due = it.Due.String
+			}
+			plan.setDeadline(task, due)
+		}
+		tasks[it.ID] = task
+
+		switch {
+		case headings[it.SectionID] != nil:
+			h := headings[it.SectionID]
+			h.Tasks = append(h.Tasks, task)
+		case projects[it.ProjectID] != nil:
+			proj := projects[it.ProjectID]
+			proj.Tasks = append(proj.Tasks, task)
+		case areas[it.ProjectID] != nil:
+			a := areas[it.ProjectID]
+			a.Tasks = append(a.Tasks, task)
+		default:
+			plan.Tasks = append(plan.Tasks, task)
+		}
+	}
+	for _, it := range items {
+		if it.ParentID == "" {
+			continue
+		}
+		root := it.ParentID
+		for i := 0; i < 32 && itemParent[root] != ""; i++ {
+			root = itemParent[root]
+		}
+		if done[root] {
+			continue
+		}
+		if it.Checked || it.IsCompleted {
+			plan.skipped++
+			continue
+		}
+		parent, ok := tasks[root]
+		if !ok {
+			plan.warnf("sub-task %q has no parent task; skipped", it.Content)
+			continue
+		}
+		title, _ := splitInlineTags(it.Content)
+		parent.Checklist = append(parent.Checklist, title)
+	}
+	return plan, nil
+}
+
+// ---------------------------------------------------------------------------
+// TaskPaper
+//
+// "Name:" lines are projects (nested ones become headings), "- " lines are
+// tasks (nested tasks become checklist items), anything else is a note on
+// the enclosing item. @due(...) / @deadline(...) set the deadline,
+// @priority(n) maps like Todoist priorities and @done items are skipped.
+// ---------------------------------------------------------------------------
+
+// indentWidth returns the leading whitespace width, counting a tab as four.
+func indentWidth(line string) int {
+	w := 0
+	for _, r := range line {
+		switch r {
+		case '\t':
+			w += 4
+		case ' ':
+			w++
+		default:
+			return w
+		}
+	}
+	return w
+}
+
+func parseTaskPaper(r io.Reader) (*importPlan, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	plan := &importPlan{}
+
+	var project *importProject
+	var heading *importHeading
+	var task *importTask
+	projectIndent, headingIndent, taskIndent := -1, -1, -1
+	skipIndent := -1 // indentation of a skipped @done item; deeper lines are skipped too
+
+	for _, raw := range strings.Split(string(data), "\n") {
+		line := strings.TrimRight(raw, " \t\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		indent := indentWidth(line)
+		text := strings.TrimSpace(line)
+		if skipIndent >= 0 {
+			if indent > skipIndent {
+				continue
+			}
+			skipIndent = -1
+		}
+		// Leaving an item's indentation closes its scope.
+		if project != nil && indent <= projectIndent {
+			project, projectIndent = nil, -1
+			heading, headingIndent = nil, -1
+		}
+		if heading != nil && indent <= headingIndent {
+			heading, headingIndent = nil, -1
+		}
+		if task != nil && indent <= taskIndent {
+			task, taskIndent = nil, -1
+		}
+
+		isTask := strings.HasPrefix(text, "- ")
+		if isTask {
+			text = text[2:]
+		}
+		body, tags := splitInlineTags(text)
+		isProject := !isTask && strings.HasSuffix(body, ":")
+
+		var prio int
+		var due string
+		var names []string
+		isDone := false
+		for _, tag := range tags {
+			switch strings.ToLower(tag[0]) {
+			case "done":
+				isDone = true
+			case "due", "deadline":
+				due = tag[1]
+			case "priority":
+				prio, _ = strconv.Atoi(tag[1])
+			default:
+				names = append(names, tag[0])
+			}
+		}
+		if isDone && (isTask || isProject) {
+			plan.skipped++
+			skipIndent = indent
+			continue
+		}
+
+		switch {
+		case isProject:
+			title := strings.TrimSpace(strings.TrimSuffix(body, ":"))
+			if project == nil {
+				project = &importProject{Title: title}
+				for _, n := range names {
+					project.Tags = addTag(project.Tags, n)
+				}
+				project.Tags = priorityTag(project.Tags, prio)
+				if d := importDate(due); d != "" {
+					project.Deadline = d
+				}
+				plan.Projects = append(plan.Projects, project)
+				projectIndent = indent
+			} else {
+				heading, headingIndent = project.heading(title), indent
+			}
+		case isTask && task != nil && indent > taskIndent:
+			task.Checklist = append(task.Checklist, strings.TrimSpace(body))
+		case isTask:
+			t := &importTask{Title: strings.TrimSpace(body)}
+			for _, n := range names {
+				t.Tags = addTag(t.Tags, n)
+			}
+			t.Tags = priorityTag(t.Tags, prio)
+			plan.setDeadline(t, due)
+			switch {
+			case heading != nil:
+				heading.Tasks = append(heading.Tasks, t)
+			case project != nil:
+				project.Tasks = append(project.Tasks, t)
+			default:
+				plan.Tasks = append(plan.Tasks, t)
+			}
+			task, taskIndent = t, indent
+		default:
+			switch {
+			case task != nil:
+				appendNote(&task.Note, text)
+			case project != nil:
+				appendNote(&project.Note, text)
+			}
+		}
+	}
+	if len(plan.Projects) == 0 && len(plan.Tasks) == 0 {
+		return nil, fmt.Errorf("parse taskpaper: no projects or tasks found")
+	}
+	return plan, nil
+}
+
+// ---------------------------------------------------------------------------
+// Markdown outlines
+//
+// The deepest three heading levels in use map to area, project and heading
+// (two levels: project and heading; one level: project). Top-level bullets
+// are tasks, nested bullets checklist items and indented text the task
+// note. "#tag" words become tags and "due:YYYY-MM-DD" sets the deadline.
+// Documents written by things_export round-trip: their "Tags" section is
+// skipped, "No Area" holds projects without an area and the metadata
+// suffixes are read back.
+// ---------------------------------------------------------------------------
+
+var (
+	mdHeadingRe = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*$`)
+	mdBulletRe  = regexp.MustCompile(`^([-*+]|\d+[.)])\s+(?:\[([ xX])\]\s+)?(.*)$`)
+	mdHashTagRe = regexp.MustCompile(`(?:^|\s)#([\p{L}\p{N}_\-]+)`)
+	mdDueRe     = regexp.MustCompile(`(?:^|\s)(?:due:|📅\s*)(\d{4}-\d{2}-\d{2})`)
+)
+
+// parseExportMeta reads the "scheduled …, deadline …, #tag" list that
+// markdownMeta writes. It reports false, changing nothing, if any part is
+// not one of those forms, so an ordinary " — " in a title is left alone.
+func parseExportMeta(meta string, deadline *string, tags *[]string) bool {
+	parts := strings.Split(meta, ",")
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		known := part == "someday" || part == "trashed" || strings.HasPrefix(part, "#")
+		for _, prefix := range []string{"scheduled ", "deadline ", "reminder ", "repeats "} {
+			known = known || strings.HasPrefix(part, prefix)
+		}
+		if !known {
+			return false
+		}
+	}
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		switch {
+		case strings.HasPrefix(part, "deadline "):
+			*deadline = importDate(strings.TrimPrefix(part, "deadline "))
+		case strings.HasPrefix(part, "#"):
+			*tags = addTag(*tags, strings.TrimPrefix(part, "#"))
+		}
+	}
+	return true
+}
+
+func parseMarkdownTask(text string) *importTask {
+	task := &importTask{}
+	if i := strings.LastIndex(text, " — "); i >= 0 && parseExportMeta(text[i+len(" — "):], &task.Deadline, &task.Tags) {
+		text = text[:i]
+	}
+	if m := mdDueRe.FindStringSubmatch(text); m != nil {
+		task.Deadline = m[1]
+		text = mdDueRe.ReplaceAllString(text, " ")
+	}
+	for _, m := range mdHashTagRe.FindAllStringSubmatch(text, -1) {
+		task.Tags = addTag(task.Tags, m[1])
+	}
+	text = mdHashTagRe.ReplaceAllString(text, " ")
+	text = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(text), "~~"), "~~")
+	task.Title = strings.Join(strings.Fields(text), " ")
+	return task
+}
+
+func parseMarkdownOutline(r io.Reader) (*importPlan, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(data), "\n")
+
+	// Work out which heading levels mean what.
+	used := map[int]bool{}
+	inFence := false
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = !inFence
+		}
+		if m := mdHeadingRe.FindStringSubmatch(line); m != nil && !inFence {
+			used[len(m[1])] = true
+		}
+	}
+	var levels []int
+	for l := range used {
+		levels = append(levels, l)
+	}
+	sort.Ints(levels)
+	role := map[int]string{}
+	roles := []string{"area", "project", "heading"}
+	switch len(levels) {
+	case 0:
+		roles = nil
+	case 1:
+		roles = []string{"project"}
+	case 2:
+		roles = roles[1:]
+	}
+	for i, r := range roles {
+		role[levels[len(levels)-len(roles)+i]] = r
+	}
+
+	plan := &importPlan{}
+	var area *importArea
+	var project *importProject
+	var heading *importHeading
+	var task *importTask
+	taskIndent := -1
+	skipIndent := -1
+	skipSection := false
+	inFence = false
+
+	for _, raw := range lines {
+		line := strings.TrimRight(raw, " \t\r")
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = !inFence
+			continue
+		}
+		if strings.TrimSpace(line) == "" || inFence {
+			continue
+		}
+		if m := mdHeadingRe.FindStringSubmatch(line); m != nil {
+			title := strings.TrimSpace(m[2])
+			task, taskIndent, skipIndent = nil, -1, -1
+			skipSection = false
+			switch role[len(m[1])] {
+			case "area":
+				area, project, heading = nil, nil, nil
+				switch title {
+				case "Tags":
+					skipSection = true
+				case "No Area":
+				default:
+					area = plan.area(title)
+				}
+			case "project":
+				project, heading = &importProject{Title: title}, nil
+				if area != nil {
+					area.Projects = append(area.Projects, project)
+				} else {
+					plan.Projects = append(plan.Projects, project)
+				}
+			case "heading":
+				if project != nil {
+					heading = project.heading(title)
+				} else {
+					plan.warnf("heading %q is not inside a project; its tasks are imported without a heading", title)
+					heading = nil
+				}
+			default:
+				area, project, heading = nil, nil, nil
+			}
+			continue
+		}
+
+		if skipSection {
+			continue
+		}
+		indent := indentWidth(line)
+		text := strings.TrimSpace(line)
+		if skipIndent >= 0 {
+			if indent > skipIndent {
+				continue
+			}
+			skipIndent = -1
+		}
+		m := mdBulletRe.FindStringSubmatch(text)
+		switch {
+		case m != nil && task != nil && indent > taskIndent:
+			if m[2] == "x" || m[2] == "X" {
+				plan.skipped++
+				continue
+			}
+			item := parseMarkdownTask(m[3])
+			task.Checklist = append(task.Checklist, item.Title)
+		case m != nil:
+			if m[2] == "x" || m[2] == "X" {
+				plan.skipped++
+				task, taskIndent, skipIndent = nil, -1, indent
+				continue
+			}
+			t := parseMarkdownTask(m[3])
+			if t.Title == "" {
+				continue
+			}
+			switch {
+			case heading != nil:
+				heading.Tasks = append(heading.Tasks, t)
+			case project != nil:
+				project.Tasks = append(project.Tasks, t)
+			case area != nil:
+				area.Tasks = append(area.Tasks, t)
+			default:
+				plan.Tasks = append(plan.Tasks, t)
+			}
+			task, taskIndent = t, indent
+		case task != nil && indent > taskIndent:
+			appendNote(&task.Note, text)
+		case project != nil && heading == nil && task == nil:
+			if len(text) > 2 && strings.HasPrefix(text, "_") && strings.HasSuffix(text, "_") &&
+				parseExportMeta(text[1:len(text)-1], &project.Deadline, &project.Tags) {
+				continue
+			}
+			appendNote(&project.Note, strings.TrimPrefix(text, "> "))
+		}
+	}
+	if len(plan.Areas) == 0 && len(plan.Projects) == 0 && len(plan.Tasks) == 0 {
+		return nil, fmt.Errorf("parse markdown: no headings or list items found")
+	}
+	return plan, nil
+}
+
+// parseImport dispatches on the source format.
+func parseImport(format, content, projectTitle string) (*importPlan, error) {
+	var plan *importPlan
+	var err error
+	switch format {
+	case "todoist_csv":
+		if projectTitle == "" {
+			projectTitle = "Imported"
+		}
+		plan, err = parseTodoistCSV(strings.NewReader(content), projectTitle)
+	case "todoist_json":
+		plan, err = parseTodoistJSON([]byte(content))
+	case "taskpaper":
+		plan, err = parseTaskPaper(strings.NewReader(content))
+	case "markdown":
+		plan, err = parseMarkdownOutline(strings.NewReader(content))
+	default:
+		return nil, fmt.Errorf("unsupported format: %s (use todoist_csv, todoist_json, taskpaper or markdown)", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if plan.skipped > 0 {
+		plan.warnf("skipped %d completed item(s)", plan.skipped)
+	}
+	return plan, nil
+}
+
+// ---------------------------------------------------------------------------
+// Plan → wire envelopes
+// ---------------------------------------------------------------------------
+
+type importCounts struct {
+	Tags           int `json:"tags"`
+	Areas          int `json:"areas"`
+	Projects       int `json:"projects"`
+	Headings       int `json:"headings"`
+	Tasks          int `json:"tasks"`
+	ChecklistItems int `json:"checklistItems"`
+}
+
+type importResult struct {
+	Status      string       `json:"status"`
+	Created     importCounts `json:"created"`
+	ReusedTags  []string     `json:"reusedTags,omitempty"`
+	ReusedAreas []string     `json:"reusedAreas,omitempty"`
+	Commits     int          `json:"commits"`
+	Plan        *importPlan  `json:"plan,omitempty"`
+	Warnings    []string     `json:"warnings,omitempty"`
+}
+
+type importBuilder struct {
+	t         *ThingsMCP
+	envelopes []thingscloud.Identifiable
+	tags      map[string]string // lower-cased name → uuid
+	result    importResult
+}
+
+func (b *importBuilder) add(kind string, payload any) string {
+	id := generateUUID()
+	b.envelopes = append(b.envelopes, writeEnvelope{id: id, action: 0, kind: kind, payload: payload})
+	return id
+}
+
+func (b *importBuilder) tagUUIDs(names []string) string {
+	var ids []string
+	for _, name := range names {
+		key := strings.ToLower(name)
+		id, ok := b.tags[key]
+		if !ok {
+			if id = b.t.findTagUUID(name); id != "" {
+				b.result.ReusedTags = append(b.result.ReusedTags, name)
+			} else {
+				id = b.add("Tag4", TagCreatePayload{Tt: name, Ix: -1237, Pn: []string{}, Xx: defaultExtension()})
+				b.result.Created.Tags++
+			}
+			b.tags[key] = id
+		}
+		ids = append(ids, id)
+	}
+	return strings.Join(ids, ",")
+}
+
+func (b *importBuilder) task(task *importTask, ix int, container map[string]string) {
+	opts := map[string]string{}
+	for k, v := range container {
+		opts[k] = v
+	}
+	if task.Note != "" {
+		opts["note"] = task.Note
+	}
+	if task.Deadline != "" {
+		opts["deadline"] = task.Deadline
+	}
+	if tags := b.tagUUIDs(task.Tags); tags != "" {
+		opts["tags"] = tags
+	}
+	taskUUID := b.add("Task6", newTaskCreatePayload(task.Title, opts, ix))
+	b.result.Created.Tasks++
+	now := nowTs()
+	for i, item := range task.Checklist {
+		b.add("ChecklistItem3", ChecklistItemCreatePayload{
+			Cd: now, Md: nil, Tt: item, Ss: 0, Sp: nil,
+			Ix: i, Ts: []string{taskUUID}, Lt: false, Xx: defaultExtension(),
+		})
+		b.result.Created.ChecklistItems++
+	}
+}
+
+func (b *importBuilder) project(p *importProject, ix int, areaUUID string) {
+	opts := map[string]string{"type": "project"}
+	if areaUUID != "" {
+		opts["area_uuid"] = areaUUID
+	}
+	if p.Note != "" {
+		opts["note"] = p.Note
+	}
+	if p.Deadline != "" {
+		opts["deadline"] = p.Deadline
+	}
+	if tags := b.tagUUIDs(p.Tags); tags != "" {
+		opts["tags"] = tags
+	}
+	projectUUID := b.add("Task6", newTaskCreatePayload(p.Title, opts, ix))
+	b.result.Created.Projects++
+	for i, task := range p.Tasks {
+		b.task(task, i, map[string]string{"project_uuid": projectUUID})
+	}
+	for i, h := range p.Headings {
+		headingUUID := b.add("Task6", newTaskCreatePayload(h.Title, map[string]string{"type": "heading", "project_uuid": projectUUID}, i))
+		b.result.Created.Headings++
+		for j, task := range h.Tasks {
+			b.task(task, j, map[string]string{"heading_uuid": headingUUID})
+		}
+	}
+}
+
+// buildImport turns a plan into create envelopes in dependency order. New
+// items are indexed so they sort above existing siblings in source order.
+// defaultArea, when set, receives projects and tasks that have no area.
+func (t *ThingsMCP) buildImport(plan *importPlan, defaultArea string) ([]thingscloud.Identifiable, importResult) {
+	b := &importBuilder{t: t, tags: map[string]string{}}
+
+	projects := func(list []*importProject, areaUUID string) {
+		base := t.minProjectIndex(areaUUID) - len(list)
+		for i, p := range list {
+			b.project(p, base+i, areaUUID)
+		}
+	}
+	tasks := func(list []*importTask, container map[string]string) {
+		base := t.minSiblingIndex("", "") - len(list)
+		for i, task := range list {
+			b.task(task, base+i, container)
+		}
+	}
+
+	for _, a := range plan.Areas {
+		areaUUID := t.findAreaUUID(a.Title)
+		if areaUUID != "" {
+			b.result.ReusedAreas = append(b.result.ReusedAreas, a.Title)
+		} else {
+			areaUUID = b.add("Area3", map[string]any{"tt": a.Title, "ix": 0, "tg": []string{}, "xx": defaultExtension()})
+			b.result.Created.Areas++
+		}
+		projects(a.Projects, areaUUID)
+		tasks(a.Tasks, map[string]string{"area_uuid": areaUUID})
+	}
+	projects(plan.Projects, defaultArea)
+	if defaultArea != "" {
+		tasks(plan.Tasks, map[string]string{"area_uuid": defaultArea})
+	} else {
+		tasks(plan.Tasks, map[string]string{"schedule": "inbox"})
+	}
+
+	b.result.Commits = (len(b.envelopes) + importChunkSize - 1) / importChunkSize
+	b.result.Warnings = plan.Warnings
+	return b.envelopes, b.result
+}
+
+// writeChunked commits envelopes in importChunkSize batches. History.Write
+// advances LatestServerIndex after each commit, so the batches chain without
+// re-syncing in between. Each batch is a write of its own as in
+// writeAndSync: a cancelled call sends no further batches. It returns the
+// number of commits that succeeded.
+func (t *ThingsMCP) writeChunked(ctx context.Context, envelopes []thingscloud.Identifiable) (int, error) {
+	if t.scope != nil {
+		if err := t.scope.checkWrite(envelopes); err != nil {
+			return 0, err
+		}
+		return t.scope.root.writeChunked(ctx, envelopes)
+	}
+	if err := t.incrementalSync(); err != nil {
+		return 0, fmt.Errorf("pre-write sync: %w", err)
+	}
+	if dr := dryRunFrom(ctx); dr != nil {
+		dr.add(envelopes)
+		return (len(envelopes) + importChunkSize - 1) / importChunkSize, nil
+	}
+	commits := 0
+	for start := 0; start < len(envelopes); start += importChunkSize {
+		end := start + importChunkSize
+		if end > len(envelopes) {
+			end = len(envelopes)
+		}
+		if err := t.callContext().Err(); err != nil {
+			return commits, fmt.Errorf("nothing more was written: %w", err)
+		}
+		if err := t.history.WriteContext(t.requestContext(), envelopes[start:end]...); err != nil {
+			return commits, t.cloudError(err)
+		}
+		recordCommit(ctx, envelopes[start:end], t.history.LatestServerIndex)
+		commits++
+	}
+	if err := t.incrementalSync(); err != nil {
+		return commits, err
+	}
+	now := time.Now()
+	t.lastWriteAt = now
+	t.lastSyncAt = now
+	return commits, nil
+}
+
+// ---------------------------------------------------------------------------
+// Handler
+// ---------------------------------------------------------------------------
+
+func (t *ThingsMCP) handleImport(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	format, err := req.RequireString("format")
+	if err != nil {
+		return errResult("format is required"), nil
+	}
+	content, err := req.RequireString("content")
+	if err != nil {
+		return errResult("content is required"), nil
+	}
+	plan, err := parseImport(strings.ToLower(format), content, req.GetString("project", ""))
+	if err != nil {
+		return errResultFrom(err), nil
+	}
+
+	if err := t.syncAndRebuild(); err != nil {
+		return errResultFrom(fmt.Errorf("sync: %w", err)), nil
+	}
+	areaUUID := req.GetString("area_uuid", "")
+	if areaUUID != "" {
+		if err := t.validateAreaUUID(areaUUID); err != nil {
+			return errResultFrom(err), nil
+		}
+	}
+
+	envelopes, result := t.buildImport(plan, areaUUID)
+	if len(envelopes) == 0 {
+		return errResult("nothing to import"), nil
+	}
+	// A dry run goes through writeChunked too, so it is refused where the
+	// import would be. The CLI calls this without the dryRunTools
+	// middleware, which would otherwise set up the recording.
+	preview := req.GetBool("dry_run", false)
+	if preview && dryRunFrom(ctx) == nil {
+		ctx = context.WithValue(ctx, dryRunKey{}, &dryRun{})
+	}
+	commits, err := t.writeChunked(ctx, envelopes)
+	if err != nil {
+		if preview {
+			return errResultFrom(fmt.Errorf("import: %w", err)), nil
+		}
+		return errResultFrom(fmt.Errorf("import: %d of %d commits written before failing: %w", commits, result.Commits, err)), nil
+	}
+	if preview {
+		result.Status = "dry_run"
+		result.Plan = plan
+		return jsonResult(result), nil
+	}
+	result.Status = "imported"
+	return jsonResult(result), nil
+}
+
+// detectImportFormat guesses the format from a file name and its content.
+func detectImportFormat(name string, content []byte) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".csv"):
+		return "todoist_csv"
+	case strings.HasSuffix(lower, ".json"):
+		return "todoist_json"
+	case strings.HasSuffix(lower, ".taskpaper"):
+		return "taskpaper"
+	case strings.HasSuffix(lower, ".md"), strings.HasSuffix(lower, ".markdown"):
+		return "markdown"
+	}
+	trimmed := bytes.TrimSpace(content)
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		return "todoist_json"
+	}
+	if bytes.HasPrefix(bytes.ToUpper(trimmed), []byte("TYPE,")) {
+		return "todoist_csv"
+	}
+	return "markdown"
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTodoistCSV(t *testing.T) {
+	in := "TYPE,CONTENT,DESCRIPTION,PRIORITY,INDENT,AUTHOR,RESPONSIBLE,DATE,DATE_LANG,TIMEZONE\n" +
+		"task,Plan trip @travel,Book early,1,1,,,2026-05-01,en,UTC\n" +
+		"task,Passport,,4,2,,,,,\n" +
+		"note,Check visa rules,,,,,,,,\n" +
+		"section,Packing,,,,,,,,\n" +
+		"task,Buy adapter,,4,1,,,every monday,en,UTC\n"
+
+	plan, err := parseTodoistCSV(strings.NewReader(in), "Holiday")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(plan.Projects) != 1 || plan.Projects[0].Title != "Holiday" {
+		t.Fatalf("projects: got %+v", plan.Projects)
+	}
+	proj := plan.Projects[0]
+	if len(proj.Tasks) != 1 {
+		t.Fatalf("project tasks: got %d, want 1", len(proj.Tasks))
+	}
+	trip := proj.Tasks[0]
+	if trip.Title != "Plan trip" || trip.Deadline != "2026-05-01" {
+		t.Errorf("task: got %+v", trip)
+	}
+	if !reflect.DeepEqual(trip.Tags, []string{"travel", "p1"}) {
+		t.Errorf("tags: got %v", trip.Tags)
+	}
+	if !reflect.DeepEqual(trip.Checklist, []string{"Passport"}) {
+		t.Errorf("checklist: got %v", trip.Checklist)
+	}
+	if trip.Note != "Book early\nCheck visa rules" {
+		t.Errorf("note: got %q", trip.Note)
+	}
+	if len(proj.Headings) != 1 || proj.Headings[0].Title != "Packing" || len(proj.Headings[0].Tasks) != 1 {
+		t.Fatalf("headings: got %+v", proj.Headings)
+	}
+	adapter := proj.Headings[0].Tasks[0]
+	if adapter.Deadline != "" || adapter.Note != "Due: every monday" || len(plan.Warnings) != 1 {
+		t.Errorf("recurring due date should be kept in the note with a warning: %+v %v", adapter, plan.Warnings)
+	}
+
+	if _, err := parseTodoistCSV(strings.NewReader("foo,bar\n1,2\n"), "x"); err == nil {
+		t.Error("expected error for missing TYPE column")
+	}
+}
+
+func TestParseTodoistJSON(t *testing.T) {
+	in := `{
+		"projects": [
+			{"id": "1", "name": "Inbox", "inbox_project": true},
+			{"id": "2", "name": "Work"},
+			{"id": "3", "name": "Launch", "parent_id": "2"},
+			{"id": "4", "name": "Reading"}
+		],
+		"sections": [{"id": "10", "name": "Phase 1", "project_id": "3"}],
+		"items": [
+			{"id": 100, "content": "Inbox thing", "project_id": "1"},
+			{"id": 101, "content": "Spec", "project_id": "3", "section_id": "10", "priority": 4, "labels": ["deep"], "due": {"date": "2026-02-03T10:00:00"}},
+			{"id": 102, "content": "Outline", "project_id": "3", "parent_id": "101"},
+			{"id": 103, "content": "Sub-sub", "project_id": "3", "parent_id": "102"},
+			{"id": 104, "content": "Standup", "project_id": "2", "priority": 1},
+			{"id": 105, "content": "Done already", "project_id": "4", "checked": true},
+			{"id": 106, "content": "Dune", "project_id": "4"}
+		]
+	}`
+	plan, err := parseTodoistJSON([]byte(in))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(plan.Tasks) != 1 || plan.Tasks[0].Title != "Inbox thing" {
+		t.Errorf("inbox tasks: got %+v", plan.Tasks)
+	}
+	if len(plan.Areas) != 1 || plan.Areas[0].Title != "Work" {
+		t.Fatalf("areas: got %+v", plan.Areas)
+	}
+	work := plan.Areas[0]
+	if len(work.Tasks) != 1 || work.Tasks[0].Title != "Standup" || len(work.Tasks[0].Tags) != 0 {
+		t.Errorf("area tasks: got %+v", work.Tasks)
+	}
+	if len(work.Projects) != 1 || work.Projects[0].Title != "Launch" || len(work.Projects[0].Headings) != 1 {
+		t.Fatalf("area projects: got %+v", work.Projects)
+	}
+	spec := work.Projects[0].Headings[0].Tasks[0]
+	if spec.Deadline != "2026-02-03" || !reflect.DeepEqual(spec.Tags, []string{"deep", "p1"}) {
+		t.Errorf("spec: got %+v", spec)
+	}
+	if !reflect.DeepEqual(spec.Checklist, []string{"Outline", "Sub-sub"}) {
+		t.Errorf("checklist: got %v", spec.Checklist)
+	}
+	if len(plan.Projects) != 1 || len(plan.Projects[0].Tasks) != 1 || plan.Projects[0].Tasks[0].Title != "Dune" {
+		t.Errorf("projects: got %+v", plan.Projects)
+	}
+	if plan.skipped != 1 {
+		t.Errorf("skipped: got %d, want 1", plan.skipped)
+	}
+}
+
+func TestParseTaskPaper(t *testing.T) {
+	in := "Errand: @home\n" +
+		"\tShort note on the project\n" +
+		"\t- Buy milk @due(2026-03-01) @shop\n" +
+		"\t\t- Oat\n" +
+		"\t\tGet two\n" +
+		"\t- Old @done(2026-01-01)\n" +
+		"\t\t- hidden\n" +
+		"\tLater:\n" +
+		"\t\t- Fix bike @priority(2)\n" +
+		"\t- Back in the project\n" +
+		"- Loose task\n"
+
+	plan, err := parseTaskPaper(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(plan.Projects) != 1 {
+		t.Fatalf("projects: got %d, want 1", len(plan.Projects))
+	}
+	p := plan.Projects[0]
+	if p.Title != "Errand" || p.Note != "Short note on the project" || !reflect.DeepEqual(p.Tags, []string{"home"}) {
+		t.Errorf("project: got %+v", p)
+	}
+	if len(p.Tasks) != 2 || p.Tasks[0].Title != "Buy milk" || p.Tasks[1].Title != "Back in the project" {
+		t.Fatalf("project tasks: got %+v", p.Tasks)
+	}
+	milk := p.Tasks[0]
+	if milk.Deadline != "2026-03-01" || !reflect.DeepEqual(milk.Tags, []string{"shop"}) ||
+		!reflect.DeepEqual(milk.Checklist, []string{"Oat"}) || milk.Note != "Get two" {
+		t.Errorf("milk: got %+v", milk)
+	}
+	if len(p.Headings) != 1 || p.Headings[0].Title != "Later" || len(p.Headings[0].Tasks) != 1 ||
+		!reflect.DeepEqual(p.Headings[0].Tasks[0].Tags, []string{"p2"}) {
+		t.Errorf("headings: got %+v", p.Headings)
+	}
+	if len(plan.Tasks) != 1 || plan.Tasks[0].Title != "Loose task" {
+		t.Errorf("inbox: got %+v", plan.Tasks)
+	}
+	if plan.skipped != 1 {
+		t.Errorf("skipped: got %d, want 1", plan.skipped)
+	}
+}
+
+func TestParseMarkdownOutline(t *testing.T) {
+	t.Run("three levels", func(t *testing.T) {
+		in := "# Work\n" +
+			"- [ ] Area task #admin\n" +
+			"## Launch\n" +
+			"Project note\n" +
+			"- [ ] Kickoff due:2026-04-01\n" +
+			"  - [ ] Book room\n" +
+			"  - [x] Invite\n" +
+			"    agenda\n" +
+			"- [x] Finished\n" +
+			"  - [ ] under finished\n" +
+			"### Phase 1\n" +
+			"* Write spec — not metadata\n"
+		plan, err := parseMarkdownOutline(strings.NewReader(in))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(plan.Areas) != 1 || plan.Areas[0].Title != "Work" {
+			t.Fatalf("areas: got %+v", plan.Areas)
+		}
+		work := plan.Areas[0]
+		if len(work.Tasks) != 1 || work.Tasks[0].Title != "Area task" || !reflect.DeepEqual(work.Tasks[0].Tags, []string{"admin"}) {
+			t.Errorf("area tasks: got %+v", work.Tasks)
+		}
+		if len(work.Projects) != 1 || work.Projects[0].Note != "Project note" {
+			t.Fatalf("projects: got %+v", work.Projects)
+		}
+		launch := work.Projects[0]
+		if len(launch.Tasks) != 1 {
+			t.Fatalf("launch tasks: got %+v", launch.Tasks)
+		}
+		kickoff := launch.Tasks[0]
+		if kickoff.Title != "Kickoff" || kickoff.Deadline != "2026-04-01" ||
+			!reflect.DeepEqual(kickoff.Checklist, []string{"Book room"}) || kickoff.Note != "agenda" {
+			t.Errorf("kickoff: got %+v", kickoff)
+		}
+		if len(launch.Headings) != 1 || len(launch.Headings[0].Tasks) != 1 || launch.Headings[0].Tasks[0].Title != "Write spec — not metadata" {
+			t.Errorf("headings: got %+v", launch.Headings)
+		}
+		if plan.skipped != 2 {
+			t.Errorf("skipped: got %d, want 2", plan.skipped)
+		}
+	})
+
+	t.Run("single level is projects", func(t *testing.T) {
+		plan, err := parseMarkdownOutline(strings.NewReader("## Groceries\n- Milk\n- Eggs\n"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(plan.Areas) != 0 || len(plan.Projects) != 1 || len(plan.Projects[0].Tasks) != 2 {
+			t.Errorf("got %+v", plan)
+		}
+	})
+
+	t.Run("no headings or bullets", func(t *testing.T) {
+		if _, err := parseMarkdownOutline(strings.NewReader("just prose\n")); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestMarkdownExportRoundTrip(t *testing.T) {
+	tmcp := newExportFixture(t)
+
+	md := renderExportMarkdown(tmcp.buildExport(exportOptions{}))
+	plan, err := parseMarkdownOutline(strings.NewReader(md))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(plan.Areas) != 1 || plan.Areas[0].Title != "Work" {
+		t.Fatalf("areas: got %+v", plan.Areas)
+	}
+	work := plan.Areas[0]
+	if len(work.Tasks) != 1 || !reflect.DeepEqual(work.Tasks[0].Tags, []string{"Urgent"}) {
+		t.Errorf("area tasks: got %+v", work.Tasks)
+	}
+	if len(work.Projects) != 1 || len(work.Projects[0].Headings) != 1 || len(work.Projects[0].Tasks) != 1 {
+		t.Fatalf("projects: got %+v", work.Projects)
+	}
+	kickoff := work.Projects[0].Tasks[0]
+	if kickoff.Note != "agenda" || !reflect.DeepEqual(kickoff.Checklist, []string{"Book room"}) {
+		t.Errorf("kickoff: got %+v", kickoff)
+	}
+	var loose []string
+	for _, task := range plan.Tasks {
+		loose = append(loose, task.Title)
+	}
+	if !reflect.DeepEqual(loose, []string{"Buy milk", "Water plants"}) && !reflect.DeepEqual(loose, []string{"Water plants", "Buy milk"}) {
+		t.Errorf("loose tasks: got %v", loose)
+	}
+}
+
+func TestHandleImport(t *testing.T) {
+	fc := newFakeCloud("import@example.com",
+		makeAreaItem("area-1", "Work"),
+		makeTagItem("tag-1", "deep"),
+	)
+	defer fc.Close()
+	tmcp := newTestThingsMCP(t, fc)
+
+	content := "# Work\n## Launch\n- Spec #deep #new\n  - Outline\n### Phase 1\n- Build\n# Home\n- Plants\n"
+
+	t.Run("dry run writes nothing", func(t *testing.T) {
+		before := len(fc.getCommitLog())
+		result, err := tmcp.handleImport(context.Background(), makeReq(map[string]any{
+			"format": "markdown", "content": content, "dry_run": true,
+		}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertNotError(t, result)
+		res := resultJSON[importResult](t, result)
+		if res.Status != "dry_run" || res.Plan == nil {
+			t.Errorf("status/plan: got %q %v", res.Status, res.Plan)
+		}
+		want := importCounts{Tags: 1, Areas: 1, Projects: 1, Headings: 1, Tasks: 3, ChecklistItems: 1}
+		if res.Created != want {
+			t.Errorf("counts: got %+v, want %+v", res.Created, want)
+		}
+		if !reflect.DeepEqual(res.ReusedAreas, []string{"Work"}) || !reflect.DeepEqual(res.ReusedTags, []string{"deep"}) {
+			t.Errorf("reused: areas %v tags %v", res.ReusedAreas, res.ReusedTags)
+		}
+		if got := len(fc.getCommitLog()); got != before {
+			t.Errorf("dry run wrote %d commits", got-before)
+		}
+	})
+
+	t.Run("writes through create payloads", func(t *testing.T) {
+		before := len(fc.getCommitLog())
+		result, _ := tmcp.handleImport(context.Background(), makeReq(map[string]any{"format": "markdown", "content": content}))
+		assertNotError(t, result)
+		res := resultJSON[importResult](t, result)
+		if res.Status != "imported" || res.Commits != 1 {
+			t.Errorf("status/commits: got %q %d", res.Status, res.Commits)
+		}
+		commits := fc.getCommitLog()[before:]
+		if len(commits) != 1 {
+			t.Fatalf("commits: got %d, want 1", len(commits))
+		}
+		var body map[string]struct {
+			E string         `json:"e"`
+			P map[string]any `json:"p"`
+		}
+		if err := json.Unmarshal(commits[0], &body); err != nil {
+			t.Fatal(err)
+		}
+		kinds := map[string]int{}
+		for _, env := range body {
+			kinds[env.E]++
+			if env.E == "Task6" && env.P["tt"] == "Launch" {
+				if ar, _ := env.P["ar"].([]any); len(ar) != 1 || ar[0] != "area-1" {
+					t.Errorf("Launch should reuse area-1, got %v", env.P["ar"])
+				}
+			}
+		}
+		want := map[string]int{"Tag4": 1, "Area3": 1, "Task6": 5, "ChecklistItem3": 1}
+		if !reflect.DeepEqual(kinds, want) {
+			t.Errorf("envelope kinds: got %v, want %v", kinds, want)
+		}
+	})
+
+	t.Run("large imports are chunked", func(t *testing.T) {
+		var b strings.Builder
+		b.WriteString("## Big\n")
+		for i := 0; i < importChunkSize+20; i++ {
+			b.WriteString("- item\n")
+		}
+		before := len(fc.getCommitLog())
+		result, _ := tmcp.handleImport(context.Background(), makeReq(map[string]any{"format": "markdown", "content": b.String()}))
+		assertNotError(t, result)
+		if res := resultJSON[importResult](t, result); res.Commits != 2 {
+			t.Errorf("commits: got %d, want 2", res.Commits)
+		}
+		if got := len(fc.getCommitLog()) - before; got != 2 {
+			t.Errorf("commit log: got %d new commits, want 2", got)
+		}
+	})
+
+	t.Run("cancelled imports write nothing", func(t *testing.T) {
+		plan, err := parseImport("markdown", "- Never\n", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		envelopes, _ := tmcp.buildImport(plan, "")
+		before := len(fc.getCommitLog())
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		end := tmcp.beginCall(ctx)
+		commits, err := tmcp.writeChunked(ctx, envelopes)
+		end()
+		if commits != 0 || !errors.Is(err, context.Canceled) {
+			t.Errorf("cancelled import: %d commits, %v", commits, err)
+		}
+		if got := len(fc.getCommitLog()); got != before {
+			t.Errorf("cancelled import wrote %d commits", got-before)
+		}
+		if tmcp.lastWriteAt.IsZero() || tmcp.lastSyncAt.Before(tmcp.lastWriteAt) {
+			t.Errorf("earlier imports did not record their writes: %v %v", tmcp.lastWriteAt, tmcp.lastSyncAt)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for _, args := range []map[string]any{
+			{"format": "markdown"},
+			{"format": "opml", "content": "x"},
+			{"format": "markdown", "content": "prose only"},
+			{"format": "markdown", "content": content, "area_uuid": "nope"},
+		} {
+			result, _ := tmcp.handleImport(context.Background(), makeReq(args))
+			assertIsError(t, result)
+		}
+	})
+}
+
+func TestDetectImportFormat(t *testing.T) {
+	tests := []struct {
+		name, content, want string
+	}{
+		{"export.csv", "", "todoist_csv"},
+		{"backup.json", "", "todoist_json"},
+		{"todo.taskpaper", "", "taskpaper"},
+		{"notes.md", "", "markdown"},
+		{"stdin", `{"projects": []}`, "todoist_json"},
+		{"stdin", "TYPE,CONTENT\n", "todoist_csv"},
+		{"stdin", "- a\n", "markdown"},
+	}
+	for _, tt := range tests {
+		if got := detectImportFormat(tt.name, []byte(tt.content)); got != tt.want {
+			t.Errorf("detectImportFormat(%q, %q) = %q, want %q", tt.name, tt.content, got, tt.want)
+		}
+	}
+}
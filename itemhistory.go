@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+
+	thingscloud "github.com/arthursoares/things-cloud-sdk"
+)
+
+const (
+	// itemHistoryMaxEvents caps the timeline kept per item. The creation
+	// entry is kept; the oldest changes after it are dropped first.
+	itemHistoryMaxEvents = 200
+	// itemHistoryMaxTotal and itemHistoryMaxAge cap all timelines together.
+	itemHistoryMaxTotal = 50000
+	itemHistoryMaxAge   = 365 * 24 * time.Hour
+)
+
+// itemTimelines indexes classified changes by entity for things_item_history,
+// including deleted items. Checklist item changes are also filed under their
+// task. It is rebuilt from the history on every full rebuild and trimmed to
+// the last itemHistoryMaxAge / itemHistoryMaxTotal; nothing before from is
+// kept.
+type itemTimelines struct {
+	byUUID    map[string][]changeEvent
+	truncated map[string]bool
+	total     int
+	oldest    time.Time
+	from      time.Time
+}
+
+func (tl *itemTimelines) add(events []changeEvent, now time.Time) {
+	if tl.byUUID == nil {
+		tl.byUUID = map[string][]changeEvent{}
+		tl.truncated = map[string]bool{}
+	}
+	for _, ev := range events {
+		tl.append(ev.UUID, ev)
+		if ref, ok := ev.Details["task"].(Ref); ok && ev.EntityType == "ChecklistItem" {
+			tl.append(ref.UUID, ev)
+		}
+	}
+	if tl.total > itemHistoryMaxTotal || tl.oldest.Before(now.Add(-itemHistoryMaxAge)) {
+		tl.trim(now)
+	}
+}
+
+func (tl *itemTimelines) append(uuid string, ev changeEvent) {
+	events := append(tl.byUUID[uuid], ev)
+	if len(events) > itemHistoryMaxEvents {
+		events = append(events[:1], events[2:]...)
+		tl.truncated[uuid] = true
+	} else {
+		tl.total++
+	}
+	tl.byUUID[uuid] = events
+	if tl.total == 1 || ev.at.Before(tl.oldest) {
+		tl.oldest = ev.at
+	}
+}
+
+// trim drops the changes older than itemHistoryMaxAge and, when there are
+// more than itemHistoryMaxTotal, the oldest until a quarter of that is
+// free. It cuts a day deeper than needed so it runs rarely.
+func (tl *itemTimelines) trim(now time.Time) {
+	cutoff := now.Add(-itemHistoryMaxAge + 24*time.Hour)
+	if tl.total > itemHistoryMaxTotal {
+		times := make([]time.Time, 0, tl.total)
+		for _, events := range tl.byUUID {
+			for _, ev := range events {
+				times = append(times, ev.at)
+			}
+		}
+		sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+		if keep := times[len(times)-itemHistoryMaxTotal*3/4]; keep.After(cutoff) {
+			cutoff = keep
+		}
+	}
+	if cutoff.After(tl.from) {
+		tl.from = cutoff
+	}
+	tl.oldest = time.Time{}
+	for uuid, events := range tl.byUUID {
+		drop := 0
+		for drop < len(events) && events[drop].at.Before(cutoff) {
+			drop++
+		}
+		tl.total -= drop
+		if drop == len(events) {
+			delete(tl.byUUID, uuid)
+			delete(tl.truncated, uuid)
+			continue
+		}
+		if drop > 0 {
+			tl.byUUID[uuid] = append([]changeEvent(nil), events[drop:]...)
+			tl.truncated[uuid] = true
+		}
+		if tl.oldest.IsZero() || events[drop].at.Before(tl.oldest) {
+			tl.oldest = events[drop].at
+		}
+	}
+}
+
+type itemHistoryEntry struct {
+	Timestamp   string         `json:"timestamp"`
+	ServerIndex int            `json:"serverIndex"`
+	Type        string         `json:"type"`
+	Text        string         `json:"text"`
+	Details     map[string]any `json:"details,omitempty"`
+	Checklist   string         `json:"checklistItem,omitempty"` // UUID, for checklist item changes
+}
+
+type itemHistoryOutput struct {
+	UUID      string             `json:"uuid"`
+	Title     string             `json:"title"`
+	Type      string             `json:"type"` // task, project or heading
+	Exists    bool               `json:"exists"`
+	Truncated bool               `json:"truncated,omitempty"`
+	Entries   []itemHistoryEntry `json:"entries"`
+}
+
+func (t *ThingsMCP) handleItemHistory(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	uuidPrefix, err := req.RequireString("uuid")
+	if err != nil || uuidPrefix == "" {
+		return errResult("uuid is required"), nil
+	}
+	includeChecklist := req.GetBool("include_checklist", true)
+	if err := t.syncAndRebuild(); err != nil {
+		return errResultFrom(fmt.Errorf("sync: %w", err)), nil
+	}
+
+	state := t.getState()
+	root := t.root()
+	root.mu.RLock()
+	defer root.mu.RUnlock()
+
+	// Prefer a live task; fall back to the timelines so deleted items can
+	// still be looked up, unless the token is limited to areas: deleted
+	// items are in none. A full UUID wins over the items it is a prefix of.
+	var matches []string
+	for _, candidate := range state.Tasks {
+		if strings.HasPrefix(candidate.UUID, uuidPrefix) && !isRecurringTemplate(candidate) {
+			matches = append(matches, candidate.UUID)
+		}
+	}
+	if len(matches) == 0 && t.scope == nil {
+		for id, events := range root.timelines.byUUID {
+			if strings.HasPrefix(id, uuidPrefix) && events[0].EntityType != "ChecklistItem" &&
+				events[0].EntityType != "Area" && events[0].EntityType != "Tag" {
+				matches = append(matches, id)
+			}
+		}
+	}
+	if containsStr(matches, uuidPrefix) {
+		matches = []string{uuidPrefix}
+	}
+	switch len(matches) {
+	case 0:
+		return errResultCode(codeNotFound, fmt.Sprintf("task or project not found: %s", uuidPrefix)), nil
+	case 1:
+	default:
+		sort.Strings(matches)
+		return errResult(fmt.Sprintf("ambiguous uuid prefix %s matches %d items (%s, ...)", uuidPrefix, len(matches), matches[0])), nil
+	}
+	uuid := matches[0]
+	task := state.Tasks[uuid]
+	if task != nil && isRecurringTemplate(task) {
+		task = nil
+	}
+
+	events := root.timelines.byUUID[uuid]
+	out := itemHistoryOutput{
+		UUID:      uuid,
+		Exists:    task != nil,
+		Truncated: root.timelines.truncated[uuid] || task != nil && task.CreationDate.Before(root.timelines.from),
+		Entries:   []itemHistoryEntry{},
+	}
+	if task != nil {
+		out.Title = task.Title
+		out.Type = taskTypeName(task.Type)
+	} else if len(events) > 0 {
+		last := events[len(events)-1]
+		out.Title = last.Title
+		out.Type = strings.ToLower(last.EntityType)
+	}
+
+	for _, ev := range events {
+		entry := itemHistoryEntry{
+			Timestamp:   ev.Timestamp,
+			ServerIndex: ev.ServerIndex,
+			Type:        ev.Type,
+			Text:        describeChange(state, ev),
+			Details:     ev.Details,
+		}
+		if ev.EntityType == "ChecklistItem" {
+			if !includeChecklist {
+				continue
+			}
+			entry.Checklist = ev.UUID
+			entry.Details = nil
+			for k, v := range ev.Details {
+				if k != "task" {
+					if entry.Details == nil {
+						entry.Details = map[string]any{}
+					}
+					entry.Details[k] = v
+				}
+			}
+		}
+		out.Entries = append(out.Entries, entry)
+	}
+	return jsonResult(out), nil
+}
+
+func taskTypeName(tp thingscloud.TaskType) string {
+	switch tp {
+	case thingscloud.TaskTypeProject:
+		return "project"
+	case thingscloud.TaskTypeHeading:
+		return "heading"
+	default:
+		return "task"
+	}
+}
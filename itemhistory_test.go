@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	thingscloud "github.com/arthursoares/things-cloud-sdk"
+)
+
+func TestItemHistory(t *testing.T) {
+	now := time.Now().UTC()
+	days := func(n int) float64 { return float64(now.AddDate(0, 0, -n).Unix()) }
+	midnight := func(n int) int64 {
+		d := now.AddDate(0, 0, -n)
+		return time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, time.UTC).Unix()
+	}
+	fc := newFakeCloud("test@example.com",
+		makeTaskItem("proj-1", withTitle("Launch"), withTaskType(thingscloud.TaskTypeProject), withModificationDate(now.AddDate(0, 0, -20))),
+		makeTaskItem("t-1", withTitle("Draft"), withModificationDate(now.AddDate(0, 0, -10))),
+		makeUpdateItem("t-1", thingscloud.ItemKindTask, map[string]any{"sr": midnight(9), "tir": midnight(9), "md": days(9)}),
+		makeUpdateItem("t-1", thingscloud.ItemKindTask, map[string]any{"st": 2, "sr": nil, "tir": nil, "md": days(8)}),
+		makeUpdateItem("t-1", thingscloud.ItemKindTask, map[string]any{"pr": []string{"proj-1"}, "tt": "Write launch post", "md": days(7)}),
+		makeChecklistItem("cl-1", "t-1", "Outline"),
+		makeUpdateItem("t-1", thingscloud.ItemKindTask, map[string]any{"ss": 3, "md": days(5)}),
+		makeTaskItem("t-10", withTitle("Review")),
+		makeTaskItem("t-11", withTitle("Publish")),
+		makeTaskItem("t-gone", withTitle("Scrapped"), withModificationDate(now.AddDate(0, 0, -4))),
+		thingscloud.Item{UUID: "t-gone", Kind: thingscloud.ItemKindTask, Action: thingscloud.ItemActionDeleted, P: json.RawMessage(`{}`)},
+	)
+	defer fc.Close()
+	tm := newTestThingsMCP(t, fc)
+
+	result, _ := tm.handleItemHistory(context.Background(), makeReq(map[string]any{"uuid": "t-1"}))
+	assertNotError(t, result)
+	out := resultJSON[itemHistoryOutput](t, result)
+	if !out.Exists || out.Title != "Write launch post" || out.Type != "task" {
+		t.Errorf("header: got %+v", out)
+	}
+	want := []struct{ typ, text string }{
+		{"TaskCreated", `Created task "Draft"`},
+		{"TaskMovedToToday", `Moved "Draft" from Anytime to Today`},
+		{"TaskMovedToSomeday", `Moved "Draft" from Today to Someday`},
+		{"TaskTitleChanged", `Renamed task "Draft" to "Write launch post"`},
+		{"TaskAssignedToProject", `Moved "Write launch post" to project "Launch"`},
+		{"ChecklistItemCreated", `Added checklist item "Outline" in "Write launch post"`},
+		{"TaskCompleted", `Completed task "Write launch post"`},
+	}
+	if len(out.Entries) != len(want) {
+		t.Fatalf("entries: got %+v", out.Entries)
+	}
+	for i, w := range want {
+		if e := out.Entries[i]; e.Type != w.typ || e.Text != w.text {
+			t.Errorf("entry %d: got %s %q, want %s %q", i, e.Type, e.Text, w.typ, w.text)
+		}
+	}
+	if e := out.Entries[1]; e.ServerIndex != 2 || e.Timestamp[:10] != now.AddDate(0, 0, -9).Format("2006-01-02") {
+		t.Errorf("entry 1 position: got index %d at %s", e.ServerIndex, e.Timestamp)
+	}
+	if e := out.Entries[5]; e.Checklist != "cl-1" || e.Details != nil {
+		t.Errorf("checklist entry: got %+v", e)
+	}
+
+	result, _ = tm.handleItemHistory(context.Background(), makeReq(map[string]any{"uuid": "t-1", "include_checklist": false}))
+	if out := resultJSON[itemHistoryOutput](t, result); len(out.Entries) != len(want)-1 {
+		t.Errorf("without checklist: got %d entries", len(out.Entries))
+	}
+
+	result, _ = tm.handleItemHistory(context.Background(), makeReq(map[string]any{"uuid": "t-gone"}))
+	gone := resultJSON[itemHistoryOutput](t, result)
+	if gone.Exists || gone.Title != "Scrapped" || len(gone.Entries) != 2 || gone.Entries[1].Type != "TaskDeleted" {
+		t.Errorf("deleted item: got %+v", gone)
+	}
+
+	// A full UUID is not ambiguous, a prefix of several items is.
+	result, _ = tm.handleItemHistory(context.Background(), makeReq(map[string]any{"uuid": "t-10"}))
+	if out := resultJSON[itemHistoryOutput](t, result); out.Title != "Review" {
+		t.Errorf("t-10: got %+v", out)
+	}
+	result, _ = tm.handleItemHistory(context.Background(), makeReq(map[string]any{"uuid": "t-1"}))
+	if out := resultJSON[itemHistoryOutput](t, result); out.UUID != "t-1" {
+		t.Errorf("t-1: got %+v", out)
+	}
+	result, _ = tm.handleItemHistory(context.Background(), makeReq(map[string]any{"uuid": "t-"}))
+	if text := resultText(t, result); !result.IsError || !strings.Contains(text, "ambiguous uuid prefix t- matches 3 items (t-1, ...)") {
+		t.Errorf("ambiguous prefix: %s", text)
+	}
+
+	for _, uuid := range []string{"", "nope", "cl-1"} {
+		result, _ = tm.handleItemHistory(context.Background(), makeReq(map[string]any{"uuid": uuid}))
+		assertIsError(t, result)
+	}
+}
+
+func TestItemTimelinesKeepCreation(t *testing.T) {
+	now := mustTime("2025-06-01")
+	var tl itemTimelines
+	events := []changeEvent{{Type: "TaskCreated", UUID: "t-1", at: now}}
+	for i := 0; i < itemHistoryMaxEvents+5; i++ {
+		events = append(events, changeEvent{Type: "TaskNoteChanged", UUID: "t-1", ServerIndex: i + 1, at: now})
+	}
+	tl.add(events, now)
+	got := tl.byUUID["t-1"]
+	if len(got) != itemHistoryMaxEvents || got[0].Type != "TaskCreated" || got[len(got)-1].ServerIndex != itemHistoryMaxEvents+5 || !tl.truncated["t-1"] {
+		t.Errorf("got %d events, first %s, last index %d", len(got), got[0].Type, got[len(got)-1].ServerIndex)
+	}
+}
+
+func TestItemTimelinesTrim(t *testing.T) {
+	now := mustTime("2025-06-01")
+	var tl itemTimelines
+	tl.add([]changeEvent{
+		{Type: "TaskCreated", UUID: "t-old", at: now.AddDate(-2, 0, 0)},
+		{Type: "TaskCreated", UUID: "t-1", at: now.AddDate(-1, -1, 0)},
+		{Type: "TaskCompleted", UUID: "t-1", at: now.AddDate(0, 0, -3)},
+	}, now)
+	if _, ok := tl.byUUID["t-old"]; ok || len(tl.byUUID["t-1"]) != 1 || !tl.truncated["t-1"] || tl.total != 1 {
+		t.Fatalf("after age trim: %+v, total %d", tl.byUUID, tl.total)
+	}
+
+	var events []changeEvent
+	for i := 0; i <= itemHistoryMaxTotal; i++ {
+		events = append(events, changeEvent{Type: "TaskCreated", UUID: fmt.Sprintf("t-%d", i+2), at: now.Add(time.Duration(i) * time.Second)})
+	}
+	tl.add(events, now.AddDate(0, 0, 1))
+	if tl.total > itemHistoryMaxTotal*3/4 || tl.byUUID["t-1"] != nil || tl.byUUID[fmt.Sprintf("t-%d", itemHistoryMaxTotal+2)] == nil {
+		t.Errorf("after size trim: %d events kept", tl.total)
+	}
+	if !tl.from.After(now) {
+		t.Errorf("from: got %v", tl.from)
+	}
+}
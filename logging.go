@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// ---------------------------------------------------------------------------
+// Structured logging
+// ---------------------------------------------------------------------------
+
+// Each subsystem logs through its own logger, so that its level can be set
+// separately with LOG_LEVEL.
+var (
+	logServer   = newSubsystemLogger("server")
+	logOAuth    = newSubsystemLogger("oauth")
+	logSync     = newSubsystemLogger("sync")
+	logTools    = newSubsystemLogger("tools")
+	logSDK      = newSubsystemLogger("sdk")
+	logWebhooks = newSubsystemLogger("webhooks")
+)
+
+// logSettings is the configuration shared by the subsystem loggers.
+type logSettings struct {
+	output slog.Handler
+	level  slog.Level
+	levels map[string]slog.Level // per subsystem, overriding level
+}
+
+var currentLogSettings atomic.Pointer[logSettings]
+
+func init() {
+	currentLogSettings.Store(&logSettings{output: newLogOutput("text", true), level: slog.LevelInfo})
+}
+
+// configureLogging applies LOG_LEVEL, LOG_FORMAT and LOG_EMAILS.
+// LOG_LEVEL is a default level optionally followed by per-subsystem
+// levels, e.g. "info,sync=debug,sdk=warn". LOG_FORMAT is "json" or
+// "text", defaulting to format. Emails are masked unless LOG_EMAILS is
+// "plain".
+func configureLogging(format string) error {
+	if v := os.Getenv("LOG_FORMAT"); v != "" {
+		format = v
+	}
+	if format != "json" && format != "text" {
+		return fmt.Errorf("LOG_FORMAT must be json or text, not %q", format)
+	}
+	s := &logSettings{
+		output: newLogOutput(format, os.Getenv("LOG_EMAILS") != "plain"),
+		level:  slog.LevelInfo,
+		levels: map[string]slog.Level{},
+	}
+	for _, part := range strings.Split(os.Getenv("LOG_LEVEL"), ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		subsystem, name, found := strings.Cut(part, "=")
+		if !found {
+			subsystem, name = "", part
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			return fmt.Errorf("LOG_LEVEL: %w", err)
+		}
+		if subsystem == "" {
+			s.level = level
+		} else {
+			s.levels[strings.TrimSpace(subsystem)] = level
+		}
+	}
+	currentLogSettings.Store(s)
+	return nil
+}
+
+// newLogOutput returns the handler that formats records. It writes to the
+// standard logger's writer, so that the CLI can silence it with
+// log.SetOutput.
+func newLogOutput(format string, mask bool) slog.Handler {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	if mask {
+		opts.ReplaceAttr = maskEmailsAttr
+	}
+	if format == "json" {
+		return slog.NewJSONHandler(stdLogWriter{}, opts)
+	}
+	return slog.NewTextHandler(stdLogWriter{}, opts)
+}
+
+type stdLogWriter struct{}
+
+func (stdLogWriter) Write(p []byte) (int, error) { return log.Writer().Write(p) }
+
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+
+// maskEmailsAttr masks every email address in a string or error value,
+// including the message and paths such as /account/<email>/... .
+func maskEmailsAttr(_ []string, a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindString:
+		if s := a.Value.String(); strings.Contains(s, "@") {
+			a.Value = slog.StringValue(emailPattern.ReplaceAllStringFunc(s, maskEmail))
+		}
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok && strings.Contains(err.Error(), "@") {
+			a.Value = slog.StringValue(emailPattern.ReplaceAllStringFunc(err.Error(), maskEmail))
+		}
+	}
+	return a
+}
+
+// subsystemHandler adds the subsystem and the request ID from the context
+// to each record and hands it to the current output. Attributes and groups
+// added with WithAttrs and WithGroup are replayed onto the output in order.
+type subsystemHandler struct {
+	subsystem string
+	with      []func(slog.Handler) slog.Handler
+}
+
+func newSubsystemLogger(subsystem string) *slog.Logger {
+	return slog.New(&subsystemHandler{subsystem: subsystem})
+}
+
+func (h *subsystemHandler) Enabled(_ context.Context, level slog.Level) bool {
+	s := currentLogSettings.Load()
+	min, ok := s.levels[h.subsystem]
+	if !ok {
+		min = s.level
+	}
+	return level >= min
+}
+
+func (h *subsystemHandler) Handle(ctx context.Context, r slog.Record) error {
+	attrs := []slog.Attr{slog.String("subsystem", h.subsystem)}
+	if id := requestIDFrom(ctx); id != "" {
+		attrs = append(attrs, slog.String("request_id", id))
+	}
+	out := currentLogSettings.Load().output.WithAttrs(attrs)
+	for _, with := range h.with {
+		out = with(out)
+	}
+	return out.Handle(ctx, r)
+}
+
+func (h *subsystemHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.extend(func(out slog.Handler) slog.Handler { return out.WithAttrs(attrs) })
+}
+
+func (h *subsystemHandler) WithGroup(name string) slog.Handler {
+	return h.extend(func(out slog.Handler) slog.Handler { return out.WithGroup(name) })
+}
+
+func (h *subsystemHandler) extend(with func(slog.Handler) slog.Handler) slog.Handler {
+	return &subsystemHandler{subsystem: h.subsystem, with: append(h.with[:len(h.with):len(h.with)], with)}
+}
+
+// ---------------------------------------------------------------------------
+// Request IDs
+// ---------------------------------------------------------------------------
+
+type requestIDKey struct{}
+
+const requestIDHeader = "X-Request-Id"
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// withRequestID returns ctx carrying the request's ID: the X-Request-Id
+// header when it is a plausible ID, or a new random one. A context that
+// already has an ID keeps it.
+func withRequestID(ctx context.Context, r *http.Request) context.Context {
+	if requestIDFrom(ctx) != "" {
+		return ctx
+	}
+	id := r.Header.Get(requestIDHeader)
+	if !validRequestID.MatchString(id) {
+		id = newRequestID()
+	}
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func requestIDFrom(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestIDs assigns every HTTP request an ID, echoed in the X-Request-Id
+// response header, for its handlers to log.
+func requestIDs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := withRequestID(r.Context(), r)
+		w.Header().Set(requestIDHeader, requestIDFrom(ctx))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// fatal logs msg at error level and exits, like log.Fatal.
+func fatal(l *slog.Logger, msg string, args ...any) {
+	l.Error(msg, args...)
+	os.Exit(1)
+}
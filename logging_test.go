@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// captureLogs configures logging from env and returns the buffer the logs
+// go to. Both are restored when the test ends.
+func captureLogs(t *testing.T, env map[string]string) *bytes.Buffer {
+	t.Helper()
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	prevSettings, prevOutput := currentLogSettings.Load(), log.Writer()
+	t.Cleanup(func() {
+		currentLogSettings.Store(prevSettings)
+		log.SetOutput(prevOutput)
+	})
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	if err := configureLogging("json"); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var r map[string]any
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("log line is not JSON: %q", line)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestLoggingCorrelatesRequests(t *testing.T) {
+	buf := captureLogs(t, map[string]string{"LOG_LEVEL": "info,sdk=debug"})
+	fc := newFakeCloud("logging@example.com", makeTaskItem("t-1", withTitle("Trace")))
+	defer fc.Close()
+	tm, err := newThingsMCP(t.Context(), fc.server.URL, fc.email, "testpass", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	um := NewUserManager()
+	um.users[fc.email] = tm
+	tools := toolsByName(um)
+
+	r := httptest.NewRequest("POST", "/mcp", nil)
+	r.SetBasicAuth(fc.email, "testpass")
+	r.Header.Set(requestIDHeader, "req-42")
+	ctx := um.httpContextFunc(context.Background(), r)
+	buf.Reset()
+	result, err := tools["things_create_task"].Handler(ctx, makeReq(map[string]any{"title": "Logged"}))
+	if err != nil || result.IsError {
+		t.Fatalf("create task: %v %s", err, resultText(t, result))
+	}
+
+	if strings.Contains(buf.String(), fc.email) {
+		t.Errorf("logs contain the plain email:\n%s", buf.String())
+	}
+	seen := map[string]bool{}
+	for _, rec := range logRecords(t, buf) {
+		if rec["request_id"] != "req-42" {
+			t.Errorf("record without the request ID: %v", rec)
+		}
+		seen[rec["subsystem"].(string)+" "+rec["msg"].(string)] = true
+	}
+	for _, want := range []string{"sdk things cloud request", "tools tool call"} {
+		if !seen[want] {
+			t.Errorf("no %q record in %v", want, seen)
+		}
+	}
+	if !strings.Contains(buf.String(), `"path":"/version/1/history/`) {
+		t.Errorf("Things Cloud request paths not logged:\n%s", buf.String())
+	}
+
+	// Without a usable header a request gets an ID of its own.
+	r.Header.Set(requestIDHeader, "not an id\n")
+	if id := requestIDFrom(um.httpContextFunc(context.Background(), r)); id == "" || id == "not an id\n" {
+		t.Errorf("request ID: %q", id)
+	}
+}
+
+func TestLoggingLevelsAndMasking(t *testing.T) {
+	buf := captureLogs(t, map[string]string{"LOG_LEVEL": "warn,oauth=debug"})
+	logOAuth.Debug("tokens issued", "email", "jane@example.com", "error", errors.New("login jane@example.com: denied"))
+	logSync.Info("full rebuild", "email", "jane@example.com")
+	logSync.Warn("sync failed")
+
+	records := logRecords(t, buf)
+	if len(records) != 2 || records[0]["subsystem"] != "oauth" || records[1]["msg"] != "sync failed" {
+		t.Fatalf("records: %v", records)
+	}
+	if records[0]["email"] != "j***@example.com" || records[0]["error"] != "login j***@example.com: denied" {
+		t.Errorf("emails not masked: %v", records[0])
+	}
+
+	buf = captureLogs(t, map[string]string{"LOG_EMAILS": "plain", "LOG_FORMAT": "text", "LOG_LEVEL": ""})
+	logOAuth.Info("tokens issued", "email", "jane@example.com")
+	if !strings.Contains(buf.String(), "email=jane@example.com") || !strings.Contains(buf.String(), "subsystem=oauth") {
+		t.Errorf("text log: %s", buf.String())
+	}
+
+	t.Setenv("LOG_LEVEL", "info,sync=loud")
+	if err := configureLogging("json"); err == nil {
+		t.Error("invalid level accepted")
+	}
+}
@@ -2,21 +2,23 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"hash/crc32"
 	"hash/fnv"
-	"log"
 	"math/big"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
@@ -150,11 +152,15 @@ func parseProxyURLs(raw string) []*url.URL {
 		}
 		u, err := url.Parse(part)
 		if err != nil {
-			log.Printf("Skipping invalid proxy URL %q: %v", part, err)
+			logServer.Warn("skipping invalid proxy URL", "error", err)
 			continue
 		}
 		if u.Scheme == "" {
-			log.Printf("Skipping proxy URL without scheme %q", part)
+			logServer.Warn("skipping proxy URL without scheme", "proxy", u.Redacted())
+			continue
+		}
+		if !proxySchemes[u.Scheme] {
+			logServer.Warn("skipping proxy URL with unsupported scheme", "proxy", u.Redacted())
 			continue
 		}
 		proxies = append(proxies, u)
@@ -316,6 +322,96 @@ func parseWeekdays(s string) ([]map[string]any, error) {
 	return result, nil
 }
 
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// describeRecurrence renders a repeater back into the vocabulary accepted by
+// parseRecurrence. Rules the parser cannot express (every N months, nth
+// weekday of month) get a readable description instead.
+func describeRecurrence(rc *thingscloud.RepeaterConfiguration) string {
+	if rc == nil {
+		return ""
+	}
+	n := rc.FrequencyAmplitude
+	if n < 1 {
+		n = 1
+	}
+	switch rc.FrequencyUnit {
+	case thingscloud.FrequencyUnitDaily:
+		if n == 1 {
+			return "daily"
+		}
+		return fmt.Sprintf("every %d days", n)
+
+	case thingscloud.FrequencyUnitWeekly:
+		var days []string
+		for _, dc := range rc.DetailConfiguration {
+			if dc.Weekday != nil && *dc.Weekday >= 0 && int(*dc.Weekday) < len(weekdayNames) {
+				days = append(days, weekdayNames[*dc.Weekday])
+			}
+		}
+		switch {
+		case n == 1 && len(days) > 0:
+			return "weekly:" + strings.Join(days, ",")
+		case n == 1:
+			return "weekly"
+		case len(days) > 1:
+			return fmt.Sprintf("every %d weeks on %s", n, strings.Join(days, ","))
+		default:
+			return fmt.Sprintf("every %d weeks", n)
+		}
+
+	case thingscloud.FrequencyUnitMonthly:
+		prefix := "monthly"
+		if n > 1 {
+			prefix = fmt.Sprintf("every %d months", n)
+		}
+		if len(rc.DetailConfiguration) == 0 {
+			return prefix
+		}
+		dc := rc.DetailConfiguration[0]
+		if dc.MonthOf != nil && dc.Weekday != nil && int(*dc.Weekday) < len(weekdayNames) {
+			if *dc.MonthOf == -1 {
+				return fmt.Sprintf("%s on the last %s", prefix, weekdayNames[*dc.Weekday])
+			}
+			return fmt.Sprintf("%s on the %s %s", prefix, ordinal(*dc.MonthOf), weekdayNames[*dc.Weekday])
+		}
+		if dc.Day != nil && *dc.Day == -1 {
+			if n > 1 {
+				return prefix + " on the last day"
+			}
+			return "monthly:last"
+		}
+		if dc.Day != nil && *dc.Day > 0 {
+			if n > 1 {
+				return fmt.Sprintf("%s on day %d", prefix, *dc.Day+1)
+			}
+			return fmt.Sprintf("monthly:%d", *dc.Day+1)
+		}
+		return prefix
+
+	case thingscloud.FrequencyUnitYearly:
+		if n == 1 {
+			return "yearly"
+		}
+		return fmt.Sprintf("every %d years", n)
+	}
+	return "custom"
+}
+
+func ordinal(n int64) string {
+	switch {
+	case n%100 >= 11 && n%100 <= 13:
+		return fmt.Sprintf("%dth", n)
+	case n%10 == 1:
+		return fmt.Sprintf("%dst", n)
+	case n%10 == 2:
+		return fmt.Sprintf("%dnd", n)
+	case n%10 == 3:
+		return fmt.Sprintf("%drd", n)
+	}
+	return fmt.Sprintf("%dth", n)
+}
+
 func parseDate(s string) *time.Time {
 	if t, err := time.Parse(time.RFC3339, s); err == nil {
 		return &t
@@ -350,6 +446,9 @@ func offsetToTime(secs int) string {
 
 // minIndexWhere returns the minimum Index among tasks matching the predicate.
 func (t *ThingsMCP) minIndexWhere(match func(*thingscloud.Task) bool) int {
+	if t.scope != nil {
+		return t.scope.root.minIndexWhere(match)
+	}
 	t.mu.RLock()
 	defer t.mu.RUnlock()
 	minIx := 0
@@ -575,9 +674,20 @@ func (u *taskUpdate) Scheduled(sr, tir int64) *taskUpdate {
 	u.fields["tir"] = tir
 	return u
 }
-func (u *taskUpdate) Area(uuid string) *taskUpdate    { u.fields["ar"] = []string{uuid}; return u }
-func (u *taskUpdate) Project(uuid string) *taskUpdate { u.fields["pr"] = []string{uuid}; return u }
 func (u *taskUpdate) Heading(uuid string) *taskUpdate { u.fields["agr"] = []string{uuid}; return u }
+
+// Area and Project also take the task out of its current heading, which
+// belongs to the old project. Heading, called after them, puts it back.
+func (u *taskUpdate) Area(uuid string) *taskUpdate {
+	u.fields["ar"] = []string{uuid}
+	u.fields["agr"] = []string{}
+	return u
+}
+func (u *taskUpdate) Project(uuid string) *taskUpdate {
+	u.fields["pr"] = []string{uuid}
+	u.fields["agr"] = []string{}
+	return u
+}
 func (u *taskUpdate) Tags(uuids []string) *taskUpdate { u.fields["tg"] = uuids; return u }
 func (u *taskUpdate) Schedule(st int, sr, tir any) *taskUpdate {
 	u.fields["st"] = st
@@ -630,9 +740,26 @@ type TaskOutput struct {
 }
 
 type ChecklistOutput struct {
-	UUID   string `json:"uuid"`
-	Title  string `json:"title"`
-	Status string `json:"status"`
+	UUID             string  `json:"uuid"`
+	Title            string  `json:"title"`
+	Status           string  `json:"status"`
+	ModificationDate *string `json:"modificationDate,omitempty"`
+}
+
+func checklistOutput(cli *thingscloud.CheckListItem) ChecklistOutput {
+	out := ChecklistOutput{UUID: cli.UUID, Title: cli.Title, Status: statusString(cli.Status)}
+	out.ModificationDate = modificationDate(cli.ModificationDate)
+	return out
+}
+
+// modificationDate formats a modification date the way read tools return
+// it and expected_modified accepts it.
+func modificationDate(md *time.Time) *string {
+	if md == nil || md.Year() <= 1970 {
+		return nil
+	}
+	s := md.UTC().Format(time.RFC3339)
+	return &s
 }
 
 type TaskDetailOutput struct {
@@ -640,6 +767,61 @@ type TaskDetailOutput struct {
 	Checklist []ChecklistOutput `json:"checklist,omitempty"`
 }
 
+type HeadingWithTasks struct {
+	UUID  string       `json:"uuid"`
+	Title string       `json:"title"`
+	Tasks []TaskOutput `json:"tasks"`
+}
+
+type ProjectDetailOutput struct {
+	TaskOutput
+	Headings     []HeadingWithTasks `json:"headings"`
+	UnfiledTasks []TaskOutput       `json:"unfiledTasks"`
+}
+
+type HeadingOutput struct {
+	UUID  string `json:"uuid"`
+	Title string `json:"title"`
+}
+
+type AreaOutput struct {
+	UUID  string `json:"uuid"`
+	Title string `json:"title"`
+}
+
+type TagOutput struct {
+	UUID      string   `json:"uuid"`
+	Title     string   `json:"title"`
+	Shorthand string   `json:"shorthand,omitempty"`
+	ParentIDs []string `json:"parentIds,omitempty"`
+}
+
+// OverviewArea is an area and its active projects. Projects without an
+// area are listed under "(No Area)" with an empty UUID.
+type OverviewArea struct {
+	UUID     string       `json:"uuid"`
+	Title    string       `json:"title"`
+	Projects []TaskOutput `json:"projects"`
+}
+
+type OverviewOutput struct {
+	Tags          []TagOutput    `json:"tags"`
+	Areas         []OverviewArea `json:"areas"`
+	TodayTasks    []TaskOutput   `json:"todayTasks"`
+	UpcomingTasks []TaskOutput   `json:"upcomingTasks"`
+}
+
+// WriteOutput is what the tools that create, change or delete one item
+// return.
+type WriteOutput struct {
+	Status           string  `json:"status"` // created, updated or deleted
+	UUID             string  `json:"uuid"`
+	Title            string  `json:"title,omitempty"`
+	Name             string  `json:"name,omitempty"`
+	TaskUUID         string  `json:"task_uuid,omitempty"`
+	ModificationDate *string `json:"modificationDate,omitempty"`
+}
+
 func statusString(s thingscloud.TaskStatus) string {
 	switch s {
 	case 3:
@@ -741,10 +923,7 @@ func (t *ThingsMCP) taskToOutput(task *thingscloud.Task) TaskOutput {
 		s := task.CreationDate.UTC().Format(isoFormat)
 		out.CreationDate = &s
 	}
-	if task.ModificationDate != nil && task.ModificationDate.Year() > 1970 {
-		s := task.ModificationDate.UTC().Format(isoFormat)
-		out.ModificationDate = &s
-	}
+	out.ModificationDate = modificationDate(task.ModificationDate)
 	if task.CompletionDate != nil && task.CompletionDate.Year() > 1970 {
 		s := task.CompletionDate.UTC().Format(isoFormat)
 		out.CompletionDate = &s
@@ -796,10 +975,62 @@ type ThingsMCP struct {
 	client      *thingscloud.Client
 	history     *thingscloud.History
 	state       *memory.State
-	proxyURL    *url.URL
+	proxies     *proxyPool
 	mu          sync.RWMutex
 	lastSyncAt  time.Time
 	lastWriteAt time.Time
+	// changes is the recent change log behind things_changes_since,
+	// guarded by mu.
+	changes changeLog
+	// timelines indexes every change by item for things_item_history,
+	// guarded by mu.
+	timelines itemTimelines
+	// onChanges, when set, receives the changes classified on each
+	// incremental sync. Full rebuilds establish a baseline and report none.
+	onChanges func([]changeEvent)
+	// scope, when set, makes this a view confined to some areas; see
+	// confinedTo.
+	scope *areaScope
+	// throttledUntil is the Unix time until which Things Cloud asked this
+	// account's client to hold off; see cloudHooks.
+	throttledUntil atomic.Int64
+	// callMu serializes tool calls and other requests for the account;
+	// callCtx is the context of the one in progress. See beginCall.
+	callMu  sync.Mutex
+	callCtx atomic.Pointer[context.Context]
+}
+
+// beginCall serializes the requests made for the account and passes ctx's
+// values, such as the request ID, to the Things Cloud requests they send.
+// Cancellation is only passed on to reads that can stop halfway (see
+// callContext), so that a disconnecting client cannot cut a commit short.
+// The returned function ends the call; it must not be called again before
+// then, e.g. by a nested handler.
+func (t *ThingsMCP) beginCall(ctx context.Context) func() {
+	root := t.root()
+	root.callMu.Lock()
+	root.callCtx.Store(&ctx)
+	return func() {
+		root.callCtx.Store(nil)
+		root.callMu.Unlock()
+	}
+}
+
+// requestContext returns the context of the call in progress without its
+// cancellation, or the background context outside of one.
+func (t *ThingsMCP) requestContext() context.Context {
+	return context.WithoutCancel(t.callContext())
+}
+
+// callContext returns the context of the call in progress, done when the
+// client cancels the call or goes away, or the background context outside
+// of one. Only work that can be dropped halfway, such as fetching the
+// history for a full rebuild, stops with it.
+func (t *ThingsMCP) callContext() context.Context {
+	if ctx := t.root().callCtx.Load(); ctx != nil {
+		return *ctx
+	}
+	return context.Background()
 }
 
 // bestHistory fetches all history keys for the account and returns the one
@@ -809,16 +1040,16 @@ type ThingsMCP struct {
 func bestHistory(c *thingscloud.Client) (*thingscloud.History, error) {
 	histories, err := c.Histories()
 	if err != nil || len(histories) == 0 {
-		log.Printf("Histories() unavailable, falling back to OwnHistory()")
+		logSync.Warn("histories unavailable, falling back to own history", "error", err)
 		return c.OwnHistory()
 	}
 	if len(histories) == 1 {
 		full, err := c.History(histories[0].ID)
 		if err != nil {
-			log.Printf("Single history metadata fetch failed, using stub: %v", err)
+			logSync.Warn("single history metadata fetch failed, using stub", "history", histories[0].ID, "error", err)
 			return histories[0], nil
 		}
-		log.Printf("Single history found: %s (serverIndex=%d)", full.ID, full.LatestServerIndex)
+		logSync.Debug("single history found", "history", full.ID, "server_index", full.LatestServerIndex)
 		return full, nil
 	}
 	// Multiple histories — pick the one with the highest server index.
@@ -827,51 +1058,61 @@ func bestHistory(c *thingscloud.Client) (*thingscloud.History, error) {
 	for _, h := range histories {
 		full, err := c.History(h.ID)
 		if err != nil {
-			log.Printf("Skipping history %s: %v", h.ID, err)
+			logSync.Warn("skipping history", "history", h.ID, "error", err)
 			continue
 		}
-		log.Printf("History %s: LatestServerIndex=%d", full.ID, full.LatestServerIndex)
+		logSync.Debug("history found", "history", full.ID, "server_index", full.LatestServerIndex)
 		if full.LatestServerIndex > bestIdx {
 			best = full
 			bestIdx = full.LatestServerIndex
 		}
 	}
 	if best == nil {
-		log.Printf("No valid histories found, falling back to OwnHistory()")
+		logSync.Warn("no valid histories found, falling back to own history")
 		return c.OwnHistory()
 	}
-	log.Printf("Selected best history: %s (index=%d, out of %d histories)", best.ID, best.LatestServerIndex, len(histories))
+	logSync.Info("selected best history", "history", best.ID, "server_index", best.LatestServerIndex, "histories", len(histories))
 	return best, nil
 }
 
-// NewThingsMCPForUser creates a ThingsMCP instance for a specific user.
-func NewThingsMCPForUser(email, password string, proxyURL *url.URL) (*ThingsMCP, error) {
-	opts := []thingscloud.ClientOption{}
-	if proxyURL != nil {
-		opts = append(opts, thingscloud.WithProxy(proxyURL))
-	}
+// NewThingsMCPForUser creates a ThingsMCP instance for a specific user,
+// whose requests go through proxies if not nil. Loading the account is a
+// call of its own (see beginCall) made with ctx: it reports progress and
+// stops if ctx is cancelled.
+func NewThingsMCPForUser(ctx context.Context, email, password string, proxies *proxyPool) (*ThingsMCP, error) {
+	return newThingsMCP(ctx, thingscloud.APIEndpoint, email, password, proxies)
+}
+
+// newThingsMCP is NewThingsMCPForUser with a configurable Things Cloud
+// endpoint, used by the CLI and tests.
+func newThingsMCP(ctx context.Context, endpoint, email, password string, proxies *proxyPool) (*ThingsMCP, error) {
+	opts := proxies.clientOptions(email)
 
-	c := thingscloud.New(thingscloud.APIEndpoint, email, password, opts...)
+	t := &ThingsMCP{proxies: proxies}
+	defer t.beginCall(ctx)()
+	opts = append(opts, thingscloud.WithHooks(t.cloudHooks(email)), thingscloud.WithLogger(logSDK))
+	c := thingscloud.New(endpoint, email, password, opts...)
 	if os.Getenv("THINGS_DEBUG") != "" {
 		c.Debug = true
 	}
 
-	log.Printf("Verifying Things Cloud credentials for %s...", email)
+	logSync.Debug("verifying Things Cloud credentials", "email", email)
 	if _, err := c.Verify(); err != nil {
+		if errors.Is(err, thingscloud.ErrUnauthorized) {
+			metrics.loginFailures.Inc("session")
+		}
 		return nil, fmt.Errorf("login: %w", err)
 	}
-	log.Printf("Credentials verified for %s.", email)
-
-	log.Printf("Fetching history for %s...", email)
+	logSync.Debug("credentials verified; fetching history", "email", email)
 	history, err := bestHistory(c)
 	if err != nil {
 		return nil, fmt.Errorf("get history: %w", err)
 	}
-	t := &ThingsMCP{client: c, history: history, proxyURL: proxyURL}
+	t.client, t.history = c, history
 	if err := t.fullRebuild(); err != nil {
 		return nil, err
 	}
-	log.Printf("History ready for %s (id=%s, serverIndex=%d).", email, history.ID, history.LatestServerIndex)
+	logSync.Info("history ready", "email", email, "history", history.ID, "server_index", history.LatestServerIndex)
 
 	return t, nil
 }
@@ -899,18 +1140,27 @@ type UserInfo struct {
 }
 
 type UserManager struct {
-	users     map[string]*ThingsMCP // keyed by email
-	proxyURLs []*url.URL
-	oauth     *OAuthServer          // set after OAuthServer is created
-	diagStore *DiagStore            // set after OAuthServer is created
-	mu        sync.RWMutex
+	users         map[string]*ThingsMCP // keyed by email
+	proxies       *proxyPool
+	oauth         *OAuthServer       // set after OAuthServer is created
+	diagStore     *DiagStore         // set after OAuthServer is created
+	calendarFeeds *CalendarFeedStore // set after OAuthServer is created
+	webhooks      *WebhookStore      // set after OAuthServer is created
+	audit         *AuditStore        // set after OAuthServer is created
+	confirm       *ConfirmStore      // set after OAuthServer is created
+	cluster       *cluster           // nil outside cluster mode; see cluster.go
+	mu            sync.RWMutex
+	// draining is set once shutdown has begun; see shutdown.
+	draining atomic.Bool
 }
 
 func NewUserManager() *UserManager {
 	return &UserManager{users: make(map[string]*ThingsMCP)}
 }
 
-func (um *UserManager) GetOrCreateUser(email, password string) (*ThingsMCP, error) {
+// GetOrCreateUser returns the account's ThingsMCP, loading it with ctx
+// the first time.
+func (um *UserManager) GetOrCreateUser(ctx context.Context, email, password string) (*ThingsMCP, error) {
 	um.mu.RLock()
 	if t, ok := um.users[email]; ok {
 		um.mu.RUnlock()
@@ -919,14 +1169,16 @@ func (um *UserManager) GetOrCreateUser(email, password string) (*ThingsMCP, erro
 	um.mu.RUnlock()
 
 	// Create new user instance (outside lock to avoid blocking other users)
-	proxy := um.proxyForEmail(email)
-	if proxy != nil {
-		log.Printf("User %s -> proxy %s", email, proxy.Host)
+	if proxy := um.proxies.proxyFor(email); proxy != nil {
+		logServer.Info("assigned proxy", "email", email, "proxy", proxy.name)
 	}
-	t, err := NewThingsMCPForUser(email, password, proxy)
+	t, err := NewThingsMCPForUser(ctx, email, password, um.proxies)
 	if err != nil {
 		return nil, err
 	}
+	if um.webhooks != nil {
+		t.onChanges = func(events []changeEvent) { um.webhooks.Dispatch(email, events) }
+	}
 
 	um.mu.Lock()
 	// Double-check after acquiring write lock
@@ -940,17 +1192,9 @@ func (um *UserManager) GetOrCreateUser(email, password string) (*ThingsMCP, erro
 	return t, nil
 }
 
-func (um *UserManager) proxyForEmail(email string) *url.URL {
-	if len(um.proxyURLs) == 0 || email == "" {
-		return nil
-	}
-
-	idx := int(fnv32(email) % uint32(len(um.proxyURLs)))
-	return um.proxyURLs[idx]
-}
-
 // httpContextFunc extracts user identity from the HTTP request and stores it in context.
 func (um *UserManager) httpContextFunc(ctx context.Context, r *http.Request) context.Context {
+	ctx = withRequestID(ctx, r)
 	ctx = context.WithValue(ctx, baseURLContextKey, getBaseURL(r))
 
 	authHeader := r.Header.Get("Authorization")
@@ -987,74 +1231,128 @@ func (um *UserManager) httpContextFunc(ctx context.Context, r *http.Request) con
 func getUserFromContext(ctx context.Context, um *UserManager) (*ThingsMCP, error) {
 	val := ctx.Value(userContextKey)
 	if val == nil {
-		return nil, fmt.Errorf("authentication required: provide Authorization header (Basic or Bearer)")
+		return nil, withCode(codeUnauthenticated, fmt.Errorf("authentication required: provide Authorization header (Basic or Bearer)"))
 	}
 	info, ok := val.(*UserInfo)
 	if !ok {
-		return nil, fmt.Errorf("invalid user context")
+		return nil, withCode(codeUnauthenticated, fmt.Errorf("invalid user context"))
 	}
 
 	// Bearer token path — resolve JWT via OAuthServer
 	if info.Token != "" {
 		if um.oauth == nil {
-			return nil, fmt.Errorf("Bearer token authentication not configured")
+			return nil, withCode(codeUnauthenticated, fmt.Errorf("Bearer token authentication not configured"))
 		}
 		email, password, err := um.oauth.ResolveBearer(info.Token)
 		if err != nil {
-			return nil, fmt.Errorf("Bearer auth failed: %w", err)
+			return nil, withCode(codeUnauthenticated, fmt.Errorf("Bearer auth failed: %w", err))
 		}
-		return um.GetOrCreateUser(email, password)
+		return um.GetOrCreateUser(ctx, email, password)
 	}
 
 	if info.Email == "" || info.Password == "" {
-		return nil, fmt.Errorf("invalid credentials")
+		return nil, withCode(codeUnauthenticated, fmt.Errorf("invalid credentials"))
 	}
 
-	return um.GetOrCreateUser(info.Email, info.Password)
+	return um.GetOrCreateUser(ctx, info.Email, info.Password)
 }
 
+// rebuildBatch is how many items a full rebuild applies between progress
+// reports and checks for cancellation.
+const rebuildBatch = 2000
+
 // fullRebuild fetches ALL items from index 0 and creates a fresh state.
 // Used for initial sync and as fallback when incremental sync fails.
-func (t *ThingsMCP) fullRebuild() error {
+//
+// It reports the pages fetched and items applied as progress, and stops
+// when the call is cancelled (see callContext), keeping the previous state.
+func (t *ThingsMCP) fullRebuild() (err error) {
+	start := time.Now()
+	ctx := t.callContext()
+	progress := progressFrom(ctx)
+	loaded := t.history.LoadedServerIndex
+	defer func() {
+		if err != nil {
+			// Resume from the state kept, not from the pages fetched.
+			t.history.LoadedServerIndex = loaded
+		}
+	}()
 	t.history.LoadedServerIndex = 0
 	startIndex := 0
 	var allItems []thingscloud.Item
-	for {
-		items, hasMore, err := t.history.Items(thingscloud.ItemsOptions{StartIndex: startIndex})
+	defer func() { metrics.observeSync("full", start, len(allItems), err) }()
+	for page := 1; ; page++ {
+		items, hasMore, err := t.history.ItemsContext(ctx, thingscloud.ItemsOptions{StartIndex: startIndex})
 		if err != nil {
-			return fmt.Errorf("fetch items: %w", err)
+			return fmt.Errorf("fetch items: %w", cloudFailure(err))
 		}
 		if len(items) == 0 {
 			break
 		}
 		allItems = append(allItems, items...)
+		// Fetching and applying count as one unit per history entry each.
+		total := float64(2 * t.history.LatestServerIndex)
+		progress.report(float64(t.history.LoadedServerIndex), total,
+			"Fetched page %d of the history (%d of %d entries)", page, t.history.LoadedServerIndex, t.history.LatestServerIndex)
 		if !hasMore {
 			break
 		}
 		startIndex = t.history.LoadedServerIndex
 	}
 
+	// Replaying the history item by item rebuilds the change log too, so
+	// it survives restarts.
 	state := memory.NewState()
-	state.Update(allItems...)
+	var events []changeEvent
+	var ts time.Time
+	for i := 0; i < len(allItems); i += rebuildBatch {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("apply items: %w", err)
+		}
+		batch := allItems[i:min(i+rebuildBatch, len(allItems))]
+		var batchEvents []changeEvent
+		batchEvents, ts = applyTrackedFrom(state, batch, ts)
+		events = append(events, batchEvents...)
+		applied := i + len(batch)
+		progress.report(float64(t.history.LoadedServerIndex+applied), float64(t.history.LoadedServerIndex+len(allItems)),
+			"Applied %d of %d items", applied, len(allItems))
+	}
+	var changes changeLog
+	var timelines itemTimelines
+	now := time.Now()
+	timelines.add(events, now)
+	changes.add(events, now)
 
 	t.mu.Lock()
 	t.state = state
+	t.changes = changes
+	t.timelines = timelines
 	t.mu.Unlock()
 
-	log.Printf("Full rebuild: %d tasks, %d areas, %d tags",
-		len(state.Tasks), len(state.Areas), len(state.Tags))
+	logSync.InfoContext(t.requestContext(), "full rebuild",
+		"items", len(allItems), "tasks", len(state.Tasks), "areas", len(state.Areas), "tags", len(state.Tags))
 	return nil
 }
 
 // incrementalSync fetches only commits newer than LoadedServerIndex
 // and applies them to the existing state.
-func (t *ThingsMCP) incrementalSync() error {
+func (t *ThingsMCP) incrementalSync() (err error) {
+	if t.scope != nil {
+		return t.scope.root.incrementalSync()
+	}
+	start := time.Now()
 	startIndex := t.history.LoadedServerIndex
 	var delta []thingscloud.Item
+	defer func() { metrics.observeSync("incremental", start, len(delta), err) }()
 	for {
-		items, hasMore, err := t.history.Items(thingscloud.ItemsOptions{StartIndex: startIndex})
+		items, hasMore, err := t.history.ItemsContext(t.requestContext(), thingscloud.ItemsOptions{StartIndex: startIndex})
+		if thingscloud.IsTransient(err) {
+			// The client has already retried; a full rebuild would only
+			// add load while Things Cloud is struggling or throttling us.
+			return t.cloudError(err)
+		}
 		if err != nil {
-			log.Printf("Incremental fetch failed at index %d, falling back to full rebuild: %v", startIndex, err)
+			logSync.WarnContext(t.requestContext(), "incremental fetch failed, falling back to full rebuild", "start_index", startIndex, "error", err)
 			return t.fullRebuild()
 		}
 		if len(items) == 0 {
@@ -1071,15 +1369,67 @@ func (t *ThingsMCP) incrementalSync() error {
 		return nil
 	}
 
+	now := time.Now()
 	t.mu.Lock()
-	t.state.Update(delta...)
+	events := applyTracked(t.state, delta, now)
+	t.changes.add(events, now)
+	t.timelines.add(events, now)
 	t.mu.Unlock()
 
-	log.Printf("Incremental sync: applied %d new items", len(delta))
+	logSync.DebugContext(t.requestContext(), "incremental sync", "items", len(delta))
+	if len(events) > 0 && t.onChanges != nil {
+		t.onChanges(events)
+	}
 	return nil
 }
 
+// cloudHooks logs when Things Cloud throttles the account and remembers
+// until when, so tool errors can tell the user how long to wait.
+func (t *ThingsMCP) cloudHooks(email string) thingscloud.Hooks {
+	hooks := metrics.cloudHooks()
+	return thingscloud.Hooks{
+		Request: func(e thingscloud.RequestEvent) {
+			hooks.Request(e)
+			t.proxies.observe(email, e)
+		},
+		Retry: hooks.Retry,
+		Throttled: func(e thingscloud.ThrottleEvent) {
+			hooks.Throttled(e)
+			logSync.Warn("Things Cloud throttled account", "email", email, "path", e.Path, "until", e.Until, "abuse_prevention", e.AbusePrevention)
+			t.throttledUntil.Store(e.Until.Unix())
+		},
+	}
+}
+
+// cloudError explains an error from Things Cloud while the account is
+// throttled, or when another device changed the items being written.
+func (t *ThingsMCP) cloudError(err error) error {
+	var conflict *thingscloud.CommitConflictError
+	if errors.As(err, &conflict) {
+		// Apply the other device's changes so the next read shows them.
+		t.root().incrementalSync()
+		state := t.root().getState()
+		var names []string
+		for _, c := range conflict.Conflicts {
+			name := c.UUID
+			if task, ok := state.Tasks[c.UUID]; ok && task.Title != "" {
+				name = fmt.Sprintf("%q", task.Title)
+			}
+			names = append(names, name)
+		}
+		return fmt.Errorf("%s changed on another device while this was being written; nothing was written. Read the current values and try again: %w", strings.Join(names, ", "), err)
+	}
+	until := time.Unix(t.root().throttledUntil.Load(), 0)
+	if err == nil || !time.Now().Before(until) {
+		return cloudFailure(err)
+	}
+	return withCode(codeThrottled, fmt.Errorf("Things Cloud is limiting requests for this account until %s UTC; please wait until then: %w", until.UTC().Format("15:04"), err))
+}
+
 func (t *ThingsMCP) getState() *memory.State {
+	if t.scope != nil {
+		return t.scope.view()
+	}
 	t.mu.RLock()
 	defer t.mu.RUnlock()
 	return t.state
@@ -1088,9 +1438,16 @@ func (t *ThingsMCP) getState() *memory.State {
 const syncDebounceWindow = 2 * time.Second
 
 // syncAndRebuild checks for new history commits and updates state.
-// Called from wrap() which serializes all MCP tool calls per-user,
-// so t.state and t.history fields are safe to read without a lock here.
+// Callers hold the account's call lock (see beginCall), so t.state and
+// t.history fields are safe to read without a lock here.
 func (t *ThingsMCP) syncAndRebuild() error {
+	if t.scope != nil {
+		return t.scope.root.syncAndRebuild()
+	}
+	// The client has given up on the call; there is no one to read for.
+	if err := t.callContext().Err(); err != nil {
+		return err
+	}
 	// First time (no state built yet) → full rebuild
 	if t.state == nil {
 		return t.fullRebuild()
@@ -1111,14 +1468,37 @@ func (t *ThingsMCP) syncAndRebuild() error {
 	return err
 }
 
-func (t *ThingsMCP) writeAndSync(items ...thingscloud.Identifiable) error {
+// syncFor runs syncAndRebuild as a call of its own (see beginCall), for
+// requests that do not come through a tool handler.
+func (t *ThingsMCP) syncFor(ctx context.Context) error {
+	defer t.beginCall(ctx)()
+	return t.syncAndRebuild()
+}
+
+func (t *ThingsMCP) writeAndSync(ctx context.Context, items ...thingscloud.Identifiable) error {
+	if t.scope != nil {
+		if err := t.scope.checkWrite(items); err != nil {
+			return err
+		}
+		return t.scope.root.writeAndSync(ctx, items...)
+	}
+	// Once the commit is sent it runs to the end; before that a cancelled
+	// call writes nothing.
+	if err := t.callContext().Err(); err != nil {
+		return fmt.Errorf("nothing was written: %w", err)
+	}
 	// Pre-write: sync remote changes and update LatestServerIndex for ancestor-index
 	if err := t.incrementalSync(); err != nil {
 		return fmt.Errorf("pre-write sync: %w", err)
 	}
-	if err := t.history.Write(items...); err != nil {
-		return err
+	if dr := dryRunFrom(ctx); dr != nil {
+		dr.add(items)
+		return nil
 	}
+	if err := t.history.WriteContext(t.requestContext(), items...); err != nil {
+		return t.cloudError(err)
+	}
+	recordCommit(ctx, items, t.history.LatestServerIndex)
 	// Post-write: fetch only our new commit (not full history)
 	if err := t.incrementalSync(); err != nil {
 		return err
@@ -1248,7 +1628,7 @@ func (t *ThingsMCP) validateTaskUUID(uuid string) error {
 			return nil
 		}
 	}
-	return fmt.Errorf("task not found: %s", uuid)
+	return withCode(codeNotFound, fmt.Errorf("task not found: %s", uuid))
 }
 
 func (t *ThingsMCP) validateProjectUUID(uuid string) error {
@@ -1258,7 +1638,7 @@ func (t *ThingsMCP) validateProjectUUID(uuid string) error {
 			return nil
 		}
 	}
-	return fmt.Errorf("project not found: %s", uuid)
+	return withCode(codeNotFound, fmt.Errorf("project not found: %s", uuid))
 }
 
 func (t *ThingsMCP) validateHeadingUUID(uuid string) error {
@@ -1268,7 +1648,7 @@ func (t *ThingsMCP) validateHeadingUUID(uuid string) error {
 			return nil
 		}
 	}
-	return fmt.Errorf("heading not found: %s", uuid)
+	return withCode(codeNotFound, fmt.Errorf("heading not found: %s", uuid))
 }
 
 func (t *ThingsMCP) validateAreaUUID(uuid string) error {
@@ -1278,7 +1658,7 @@ func (t *ThingsMCP) validateAreaUUID(uuid string) error {
 			return nil
 		}
 	}
-	return fmt.Errorf("area not found: %s", uuid)
+	return withCode(codeNotFound, fmt.Errorf("area not found: %s", uuid))
 }
 
 func (t *ThingsMCP) validateTagUUID(uuid string) error {
@@ -1288,7 +1668,7 @@ func (t *ThingsMCP) validateTagUUID(uuid string) error {
 			return nil
 		}
 	}
-	return fmt.Errorf("tag not found: %s", uuid)
+	return withCode(codeNotFound, fmt.Errorf("tag not found: %s", uuid))
 }
 
 func (t *ThingsMCP) validateTagUUIDs(csv string) error {
@@ -1300,7 +1680,7 @@ func (t *ThingsMCP) validateTagUUIDs(csv string) error {
 	for _, id := range strings.Split(csv, ",") {
 		id = strings.TrimSpace(id)
 		if !tagSet[id] {
-			return fmt.Errorf("tag not found: %s", id)
+			return withCode(codeNotFound, fmt.Errorf("tag not found: %s", id))
 		}
 	}
 	return nil
@@ -1335,16 +1715,19 @@ func (t *ThingsMCP) validateOpts(opts map[string]string) error {
 // Tool result helpers
 // ---------------------------------------------------------------------------
 
+// jsonResult returns v as indented JSON text and as structured content.
 func jsonResult(v any) *mcp.CallToolResult {
 	b, err := json.MarshalIndent(v, "", "  ")
 	if err != nil {
-		return mcp.NewToolResultError(fmt.Sprintf("json marshal: %v", err))
+		return errResultCode(codeInternal, fmt.Sprintf("internal: json marshal: %v", err))
 	}
-	return mcp.NewToolResultText(string(b))
+	return mcp.NewToolResultStructured(structuredContent(v), string(b))
 }
 
+// errResult returns a failed call for a bad argument. Use errResultCode
+// for anything else.
 func errResult(msg string) *mcp.CallToolResult {
-	return mcp.NewToolResultError(msg)
+	return errorResult(codeInvalidArgument, msg, nil)
 }
 
 // ---------------------------------------------------------------------------
@@ -1377,9 +1760,19 @@ type diagReport struct {
 	Errors   []string    `json:"errors"`
 }
 
+// diagResponse is what things_diagnose returns: the report and a link to
+// share it.
+type diagResponse struct {
+	*diagReport
+	ShareURL string `json:"shareUrl,omitempty"`
+}
+
+// diagRetention is how long a shared diagnosis report stays available.
+const diagRetention = 7 * 24 * time.Hour
+
 // DiagStore handles persistence of shareable diagnosis reports.
 type DiagStore struct {
-	db *sql.DB
+	store Storage
 }
 
 func (ds *DiagStore) Store(email string, report *diagReport) (string, error) {
@@ -1388,30 +1781,20 @@ func (ds *DiagStore) Store(email string, report *diagReport) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("marshal report: %w", err)
 	}
-	now := time.Now().UTC().Format(time.RFC3339)
-	_, err = ds.db.Exec(
-		`INSERT INTO diagnoses (token, report_json, email, created_at) VALUES (?, ?, ?, ?)`,
-		token, string(reportJSON), maskEmail(email), now,
-	)
-	if err != nil {
+	if err := ds.store.SaveDiagnosis(token, maskEmail(email), string(reportJSON), time.Now()); err != nil {
 		return "", fmt.Errorf("store report: %w", err)
 	}
-	// Lazy cleanup: delete reports older than 7 days
-	cutoff := time.Now().UTC().Add(-7 * 24 * time.Hour).Format(time.RFC3339)
-	ds.db.Exec(`DELETE FROM diagnoses WHERE created_at < ?`, cutoff)
+	// Lazy cleanup: delete reports older than diagRetention
+	ds.store.Expire(time.Now())
 	return token, nil
 }
 
 func (ds *DiagStore) Load(token string) (reportJSON string, email string, createdAt time.Time, err error) {
-	var createdAtStr string
-	err = ds.db.QueryRow(
-		`SELECT report_json, email, created_at FROM diagnoses WHERE token = ?`, token,
-	).Scan(&reportJSON, &email, &createdAtStr)
+	reportJSON, email, createdAt, err = ds.store.Diagnosis(token)
 	if err != nil {
 		return "", "", time.Time{}, err
 	}
-	createdAt, _ = time.Parse(time.RFC3339, createdAtStr)
-	if time.Since(createdAt) > 7*24*time.Hour {
+	if time.Since(createdAt) > diagRetention {
 		return "", "", time.Time{}, fmt.Errorf("report expired")
 	}
 	return reportJSON, email, createdAt, nil
@@ -1436,6 +1819,10 @@ var diagStepDefs = []struct{ num int; name, desc string }{
 }
 
 func addSkippedSteps(report *diagReport, fromStep int) {
+	skipSteps(report, fromStep, "previous step failed")
+}
+
+func skipSteps(report *diagReport, fromStep int, reason string) {
 	for _, sd := range diagStepDefs {
 		if sd.num < fromStep {
 			continue
@@ -1443,32 +1830,44 @@ func addSkippedSteps(report *diagReport, fromStep int) {
 		report.Steps = append(report.Steps, diagStep{
 			Step: sd.num, Name: sd.name, Description: sd.desc,
 			Status: "skipped",
-			Details: map[string]any{"reason": "previous step failed"},
+			Details: map[string]any{"reason": reason},
 		})
 	}
 }
 
+// startDiagStep reports step n as progress before it runs. Once ctx is
+// cancelled it skips step n and the rest instead and returns false.
+func startDiagStep(ctx context.Context, report *diagReport, n int) bool {
+	if ctx.Err() != nil {
+		skipSteps(report, n, "diagnosis cancelled")
+		return false
+	}
+	sd := diagStepDefs[n-1]
+	progressFrom(ctx).report(float64(n-1), float64(len(diagStepDefs)), "Step %d of %d: %s", n, len(diagStepDefs), sd.desc)
+	return true
+}
+
 func extractCredentials(ctx context.Context, um *UserManager) (string, string, error) {
 	val := ctx.Value(userContextKey)
 	if val == nil {
-		return "", "", fmt.Errorf("authentication required: provide Authorization header (Basic or Bearer)")
+		return "", "", withCode(codeUnauthenticated, fmt.Errorf("authentication required: provide Authorization header (Basic or Bearer)"))
 	}
 	info, ok := val.(*UserInfo)
 	if !ok {
-		return "", "", fmt.Errorf("invalid user context")
+		return "", "", withCode(codeUnauthenticated, fmt.Errorf("invalid user context"))
 	}
 	if info.Token != "" {
 		if um.oauth == nil {
-			return "", "", fmt.Errorf("Bearer token authentication not configured")
+			return "", "", withCode(codeUnauthenticated, fmt.Errorf("Bearer token authentication not configured"))
 		}
 		email, password, err := um.oauth.ResolveBearer(info.Token)
 		if err != nil {
-			return "", "", fmt.Errorf("Bearer auth failed: %w", err)
+			return "", "", withCode(codeUnauthenticated, fmt.Errorf("Bearer auth failed: %w", err))
 		}
 		return email, password, nil
 	}
 	if info.Email == "" || info.Password == "" {
-		return "", "", fmt.Errorf("invalid credentials")
+		return "", "", withCode(codeUnauthenticated, fmt.Errorf("invalid credentials"))
 	}
 	return info.Email, info.Password, nil
 }
@@ -1477,10 +1876,21 @@ func extractCredentials(ctx context.Context, um *UserManager) (string, string, e
 // Diagnostic handler: steps 1-3
 // ---------------------------------------------------------------------------
 
-func (t *ThingsMCP) handleDiagnose(email, password string) *diagReport {
+// handleDiagnose runs the diagnostic steps, reporting each as progress.
+// When ctx is cancelled the steps not yet started are skipped.
+func (t *ThingsMCP) handleDiagnose(ctx context.Context, email, password string) *diagReport {
 	report := &diagReport{}
 	var allWarnings []string
 	var allErrors []string
+	finish := func() *diagReport {
+		report.Warnings = allWarnings
+		report.Errors = allErrors
+		report.Summary = buildDiagSummary(report.Steps)
+		return report
+	}
+	if !startDiagStep(ctx, report, 1) {
+		return finish()
+	}
 
 	// Step 1: credential_verification
 	step1 := diagStep{
@@ -1492,11 +1902,7 @@ func (t *ThingsMCP) handleDiagnose(email, password string) *diagReport {
 	step1.Log = append(step1.Log, fmt.Sprintf("Verifying credentials for %s", maskEmail(email)))
 
 	start := time.Now()
-	opts := []thingscloud.ClientOption{}
-	if t.proxyURL != nil {
-		opts = append(opts, thingscloud.WithProxy(t.proxyURL))
-	}
-	client := thingscloud.New(thingscloud.APIEndpoint, email, password, opts...)
+	client := thingscloud.New(thingscloud.APIEndpoint, email, password, t.proxies.clientOptions(email)...)
 	verifyResp, err := client.Verify()
 	step1.DurationMs = time.Since(start).Milliseconds()
 
@@ -1523,6 +1929,9 @@ func (t *ThingsMCP) handleDiagnose(email, password string) *diagReport {
 	step1.Log = append(step1.Log, fmt.Sprintf("Account status: %s", verifyResp.Status))
 	step1.Log = append(step1.Log, fmt.Sprintf("History key from /verify: %s", verifyResp.HistoryKey))
 	report.Steps = append(report.Steps, step1)
+	if !startDiagStep(ctx, report, 2) {
+		return finish()
+	}
 
 	// Step 2: fetch_history — select best history via bestHistory(), then
 	// enumerate all histories for the diagnostic report.
@@ -1663,6 +2072,9 @@ func (t *ThingsMCP) handleDiagnose(email, password string) *diagReport {
 	}
 	step2.Log = append(step2.Log, fmt.Sprintf("Selected history: %s (serverIndex=%d)", history.ID, history.LatestServerIndex))
 	report.Steps = append(report.Steps, step2)
+	if !startDiagStep(ctx, report, 3) {
+		return finish()
+	}
 
 	// Step 3: sync_history
 	step3 := diagStep{
@@ -1700,15 +2112,17 @@ func (t *ThingsMCP) handleDiagnose(email, password string) *diagReport {
 	report.Steps = append(report.Steps, step3)
 
 	// Steps 4-7: delegated
-	t.diagnoseSteps4to7(history, report, &allWarnings, &allErrors)
-
-	report.Warnings = allWarnings
-	report.Errors = allErrors
-	report.Summary = buildDiagSummary(report.Steps)
-	return report
+	t.diagnoseSteps4to7(ctx, history, report, &allWarnings, &allErrors)
+	if ctx.Err() == nil {
+		progressFrom(ctx).report(float64(len(diagStepDefs)), float64(len(diagStepDefs)), "Diagnosis complete")
+	}
+	return finish()
 }
 
-func (t *ThingsMCP) diagnoseSteps4to7(history *thingscloud.History, report *diagReport, warnings *[]string, errors *[]string) {
+func (t *ThingsMCP) diagnoseSteps4to7(ctx context.Context, history *thingscloud.History, report *diagReport, warnings *[]string, errors *[]string) {
+	if !startDiagStep(ctx, report, 4) {
+		return
+	}
 	// Step 4: paginated_fetch
 	step4 := diagStep{
 		Step:        4,
@@ -1733,7 +2147,7 @@ func (t *ThingsMCP) diagnoseSteps4to7(history *thingscloud.History, report *diag
 	start := time.Now()
 	for {
 		pageNum++
-		items, _, err := history.Items(thingscloud.ItemsOptions{StartIndex: startIndex})
+		items, _, err := history.ItemsContext(ctx, thingscloud.ItemsOptions{StartIndex: startIndex})
 		serverIndexAfter := history.LatestServerIndex
 		if err != nil {
 			step4.DurationMs = time.Since(start).Milliseconds()
@@ -1763,6 +2177,10 @@ func (t *ThingsMCP) diagnoseSteps4to7(history *thingscloud.History, report *diag
 		}
 		pages = append(pages, pi)
 		step4.Log = append(step4.Log, fmt.Sprintf("Page %d: startIndex=%d, fetched=%d, serverIndex=%d", pageNum, startIndex, len(items), serverIndexAfter))
+		if serverIndexAfter > 0 {
+			done := min(float64(len(allItems))/float64(serverIndexAfter), 0.99)
+			progressFrom(ctx).report(3+done, float64(len(diagStepDefs)), "Step 4 of %d: fetched page %d (%d items)", len(diagStepDefs), pageNum, len(allItems))
+		}
 		startIndex = serverIndexAfter
 	}
 
@@ -1840,6 +2258,9 @@ func (t *ThingsMCP) diagnoseSteps4to7(history *thingscloud.History, report *diag
 		addSkippedSteps(report, 5)
 		return
 	}
+	if !startDiagStep(ctx, report, 5) {
+		return
+	}
 
 	// Step 5: rebuild_state
 	step5 := diagStep{
@@ -1876,7 +2297,13 @@ func (t *ThingsMCP) diagnoseSteps4to7(history *thingscloud.History, report *diag
 	report.Steps = append(report.Steps, step5)
 
 	// Steps 6-7: delegated
+	if !startDiagStep(ctx, report, 6) {
+		return
+	}
 	t.diagnoseDataIntegrity(state, report, warnings)
+	if !startDiagStep(ctx, report, 7) {
+		return
+	}
 	t.diagnoseQueryTests(report, warnings, errors)
 }
 
@@ -2132,7 +2559,7 @@ func buildDiagSummary(steps []diagStep) diagSummary {
 
 func (t *ThingsMCP) handleFindTasks(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	if err := t.syncAndRebuild(); err != nil {
-		return errResult(fmt.Sprintf("sync: %v", err)), nil
+		return errResultFrom(fmt.Errorf("sync: %w", err)), nil
 	}
 	state := t.getState()
 
@@ -2155,19 +2582,19 @@ func (t *ThingsMCP) handleFindTasks(_ context.Context, req mcp.CallToolRequest)
 	if areaName != "" {
 		areaUUID = t.findAreaUUID(areaName)
 		if areaUUID == "" {
-			return errResult(fmt.Sprintf("area not found: %s", areaName)), nil
+			return errResultCode(codeNotFound, fmt.Sprintf("area not found: %s", areaName)), nil
 		}
 	}
 	if projectName != "" {
 		projectUUID = t.findProjectUUID(projectName)
 		if projectUUID == "" {
-			return errResult(fmt.Sprintf("project not found: %s", projectName)), nil
+			return errResultCode(codeNotFound, fmt.Sprintf("project not found: %s", projectName)), nil
 		}
 	}
 	if tagName != "" {
 		tagUUID = t.findTagUUID(tagName)
 		if tagUUID == "" {
-			return errResult(fmt.Sprintf("tag not found: %s", tagName)), nil
+			return errResultCode(codeNotFound, fmt.Sprintf("tag not found: %s", tagName)), nil
 		}
 	}
 
@@ -2417,7 +2844,7 @@ func (t *ThingsMCP) handleDebugRaw(_ context.Context, req mcp.CallToolRequest) (
 		return errResult("uuid is required"), nil
 	}
 	if err := t.syncAndRebuild(); err != nil {
-		return errResult(fmt.Sprintf("sync: %v", err)), nil
+		return errResultFrom(fmt.Errorf("sync: %w", err)), nil
 	}
 
 	state := t.getState()
@@ -2426,7 +2853,7 @@ func (t *ThingsMCP) handleDebugRaw(_ context.Context, req mcp.CallToolRequest) (
 			return jsonResult(taskToRawWire(task)), nil
 		}
 	}
-	return errResult(fmt.Sprintf("task not found: %s", uuidPrefix)), nil
+	return errResultCode(codeNotFound, fmt.Sprintf("task not found: %s", uuidPrefix)), nil
 }
 
 func (t *ThingsMCP) handleShowTask(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
@@ -2435,30 +2862,26 @@ func (t *ThingsMCP) handleShowTask(_ context.Context, req mcp.CallToolRequest) (
 		return errResult("uuid is required"), nil
 	}
 	if err := t.syncAndRebuild(); err != nil {
-		return errResult(fmt.Sprintf("sync: %v", err)), nil
+		return errResultFrom(fmt.Errorf("sync: %w", err)), nil
 	}
 
 	state := t.getState()
 	for _, task := range state.Tasks {
 		if strings.HasPrefix(task.UUID, uuidPrefix) {
 			if isRecurringTemplate(task) {
-				return errResult(fmt.Sprintf("task not found: %s", uuidPrefix)), nil
+				return errResultCode(codeNotFound, fmt.Sprintf("task not found: %s", uuidPrefix)), nil
 			}
 			out := TaskDetailOutput{TaskOutput: t.taskToOutput(task)}
 			// Add checklist items
 			for _, cli := range state.CheckListItems {
 				if containsStr(cli.TaskIDs, task.UUID) {
-					out.Checklist = append(out.Checklist, ChecklistOutput{
-						UUID:   cli.UUID,
-						Title:  cli.Title,
-						Status: statusString(cli.Status),
-					})
+					out.Checklist = append(out.Checklist, checklistOutput(cli))
 				}
 			}
 			return jsonResult(out), nil
 		}
 	}
-	return errResult(fmt.Sprintf("task not found: %s", uuidPrefix)), nil
+	return errResultCode(codeNotFound, fmt.Sprintf("task not found: %s", uuidPrefix)), nil
 }
 
 func (t *ThingsMCP) handleShowProject(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
@@ -2467,7 +2890,7 @@ func (t *ThingsMCP) handleShowProject(_ context.Context, req mcp.CallToolRequest
 		return errResult("uuid is required"), nil
 	}
 	if err := t.syncAndRebuild(); err != nil {
-		return errResult(fmt.Sprintf("sync: %v", err)), nil
+		return errResultFrom(fmt.Errorf("sync: %w", err)), nil
 	}
 
 	state := t.getState()
@@ -2482,18 +2905,7 @@ func (t *ThingsMCP) handleShowProject(_ context.Context, req mcp.CallToolRequest
 		}
 	}
 	if project == nil {
-		return errResult(fmt.Sprintf("project not found: %s", projectUUID)), nil
-	}
-
-	type HeadingWithTasks struct {
-		UUID  string       `json:"uuid"`
-		Title string       `json:"title"`
-		Tasks []TaskOutput `json:"tasks"`
-	}
-	type ProjectDetailOutput struct {
-		TaskOutput
-		Headings     []HeadingWithTasks `json:"headings"`
-		UnfiledTasks []TaskOutput       `json:"unfiledTasks"`
+		return errResultCode(codeNotFound, fmt.Sprintf("project not found: %s", projectUUID)), nil
 	}
 
 	// Collect headings in this project, sorted by ix
@@ -2587,7 +2999,7 @@ func (t *ThingsMCP) handleShowProject(_ context.Context, req mcp.CallToolRequest
 
 func (t *ThingsMCP) handleFindProjects(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	if err := t.syncAndRebuild(); err != nil {
-		return errResult(fmt.Sprintf("sync: %v", err)), nil
+		return errResultFrom(fmt.Errorf("sync: %w", err)), nil
 	}
 	state := t.getState()
 
@@ -2609,13 +3021,13 @@ func (t *ThingsMCP) handleFindProjects(_ context.Context, req mcp.CallToolReques
 	if areaName != "" {
 		areaUUID = t.findAreaUUID(areaName)
 		if areaUUID == "" {
-			return errResult(fmt.Sprintf("area not found: %s", areaName)), nil
+			return errResultCode(codeNotFound, fmt.Sprintf("area not found: %s", areaName)), nil
 		}
 	}
 	if tagName != "" {
 		tagUUID = t.findTagUUID(tagName)
 		if tagUUID == "" {
-			return errResult(fmt.Sprintf("tag not found: %s", tagName)), nil
+			return errResultCode(codeNotFound, fmt.Sprintf("tag not found: %s", tagName)), nil
 		}
 	}
 
@@ -2774,14 +3186,10 @@ func (t *ThingsMCP) handleFindHeadings(_ context.Context, req mcp.CallToolReques
 		return errResult("project_uuid is required"), nil
 	}
 	if err := t.syncAndRebuild(); err != nil {
-		return errResult(fmt.Sprintf("sync: %v", err)), nil
+		return errResultFrom(fmt.Errorf("sync: %w", err)), nil
 	}
 
 	state := t.getState()
-	type HeadingOutput struct {
-		UUID  string `json:"uuid"`
-		Title string `json:"title"`
-	}
 	var headings []HeadingOutput
 	for _, task := range state.Tasks {
 		if task.Type == thingscloud.TaskTypeHeading && !task.InTrash && containsStr(task.ParentTaskIDs, projectUUID) {
@@ -2796,13 +3204,9 @@ func (t *ThingsMCP) handleFindHeadings(_ context.Context, req mcp.CallToolReques
 
 func (t *ThingsMCP) handleFindAreas(_ context.Context, _ mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	if err := t.syncAndRebuild(); err != nil {
-		return errResult(fmt.Sprintf("sync: %v", err)), nil
+		return errResultFrom(fmt.Errorf("sync: %w", err)), nil
 	}
 	state := t.getState()
-	type AreaOutput struct {
-		UUID  string `json:"uuid"`
-		Title string `json:"title"`
-	}
 	var areas []AreaOutput
 	for _, area := range state.Areas {
 		areas = append(areas, AreaOutput{UUID: area.UUID, Title: area.Title})
@@ -2815,15 +3219,9 @@ func (t *ThingsMCP) handleFindAreas(_ context.Context, _ mcp.CallToolRequest) (*
 
 func (t *ThingsMCP) handleFindTags(_ context.Context, _ mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	if err := t.syncAndRebuild(); err != nil {
-		return errResult(fmt.Sprintf("sync: %v", err)), nil
+		return errResultFrom(fmt.Errorf("sync: %w", err)), nil
 	}
 	state := t.getState()
-	type TagOutput struct {
-		UUID      string   `json:"uuid"`
-		Title     string   `json:"title"`
-		Shorthand string   `json:"shorthand,omitempty"`
-		ParentIDs []string `json:"parentIds,omitempty"`
-	}
 	var tags []TagOutput
 	for _, tag := range state.Tags {
 		tags = append(tags, TagOutput{
@@ -2841,7 +3239,7 @@ func (t *ThingsMCP) handleFindTags(_ context.Context, _ mcp.CallToolRequest) (*m
 
 func (t *ThingsMCP) handleOverview(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	if err := t.syncAndRebuild(); err != nil {
-		return errResult(fmt.Sprintf("sync: %v", err)), nil
+		return errResultFrom(fmt.Errorf("sync: %w", err)), nil
 	}
 	state := t.getState()
 
@@ -2851,15 +3249,9 @@ func (t *ThingsMCP) handleOverview(_ context.Context, req mcp.CallToolRequest) (
 	}
 
 	// --- Tags ---
-	type TagOut struct {
-		UUID      string   `json:"uuid"`
-		Title     string   `json:"title"`
-		Shorthand string   `json:"shorthand,omitempty"`
-		ParentIDs []string `json:"parentIds,omitempty"`
-	}
-	var tags []TagOut
+	var tags []TagOutput
 	for _, tag := range state.Tags {
-		tags = append(tags, TagOut{
+		tags = append(tags, TagOutput{
 			UUID:      tag.UUID,
 			Title:     tag.Title,
 			Shorthand: tag.ShortHand,
@@ -2867,20 +3259,15 @@ func (t *ThingsMCP) handleOverview(_ context.Context, req mcp.CallToolRequest) (
 		})
 	}
 	if tags == nil {
-		tags = []TagOut{}
+		tags = []TagOutput{}
 	}
 
 	// --- Area → Project tree ---
-	type AreaOut struct {
-		UUID     string       `json:"uuid"`
-		Title    string       `json:"title"`
-		Projects []TaskOutput `json:"projects"`
-	}
-	areaMap := make(map[string]*AreaOut)
+	areaMap := make(map[string]*OverviewArea)
 	areaProjects := make(map[string][]*thingscloud.Task) // area UUID → project tasks
 	var areaOrder []string
 	for _, area := range state.Areas {
-		areaMap[area.UUID] = &AreaOut{UUID: area.UUID, Title: area.Title, Projects: []TaskOutput{}}
+		areaMap[area.UUID] = &OverviewArea{UUID: area.UUID, Title: area.Title, Projects: []TaskOutput{}}
 		areaOrder = append(areaOrder, area.UUID)
 	}
 	sort.Slice(areaOrder, func(i, j int) bool {
@@ -2905,7 +3292,7 @@ func (t *ThingsMCP) handleOverview(_ context.Context, req mcp.CallToolRequest) (
 		}
 	}
 
-	var areas []AreaOut
+	var areas []OverviewArea
 	for _, id := range areaOrder {
 		a := areaMap[id]
 		projs := areaProjects[id]
@@ -2917,14 +3304,14 @@ func (t *ThingsMCP) handleOverview(_ context.Context, req mcp.CallToolRequest) (
 	}
 	if len(noAreaProjects) > 0 {
 		sortByIndex(noAreaProjects)
-		noArea := AreaOut{UUID: "", Title: "(No Area)", Projects: make([]TaskOutput, 0, len(noAreaProjects))}
+		noArea := OverviewArea{UUID: "", Title: "(No Area)", Projects: make([]TaskOutput, 0, len(noAreaProjects))}
 		for _, p := range noAreaProjects {
 			noArea.Projects = append(noArea.Projects, t.taskToOutput(p))
 		}
 		areas = append(areas, noArea)
 	}
 	if areas == nil {
-		areas = []AreaOut{}
+		areas = []OverviewArea{}
 	}
 
 	// --- Today tasks ---
@@ -2998,17 +3385,10 @@ func (t *ThingsMCP) handleOverview(_ context.Context, req mcp.CallToolRequest) (
 	}
 
 	// --- Assemble ---
-	result := struct {
-		Tags          []TagOut     `json:"tags"`
-		Areas         []AreaOut    `json:"areas"`
-		TodayTasks    []TaskOutput `json:"todayTasks"`
-		UpcomingTasks []TaskOutput `json:"upcomingTasks"`
-	}{tags, areas, todayTasks, upcomingTasks}
-
-	return jsonResult(result), nil
+	return jsonResult(OverviewOutput{Tags: tags, Areas: areas, TodayTasks: todayTasks, UpcomingTasks: upcomingTasks}), nil
 }
 
-func (t *ThingsMCP) handleCreateTask(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+func (t *ThingsMCP) handleCreateTask(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	title, err := req.RequireString("title")
 	if err != nil {
 		return errResult("title is required"), nil
@@ -3022,13 +3402,13 @@ func (t *ThingsMCP) handleCreateTask(_ context.Context, req mcp.CallToolRequest)
 	}
 
 	if err := t.validateOpts(opts); err != nil {
-		return errResult(err.Error()), nil
+		return errResultFrom(err), nil
 	}
 
 	// Validate recurrence format early
 	if v, ok := opts["recurrence"]; ok && v != "" {
 		if _, err := parseRecurrence(v, time.Now()); err != nil {
-			return errResult(err.Error()), nil
+			return errResultFrom(err), nil
 		}
 	}
 
@@ -3055,13 +3435,13 @@ func (t *ThingsMCP) handleCreateTask(_ context.Context, req mcp.CallToolRequest)
 		}
 	}
 
-	if err := t.writeAndSync(envelopes...); err != nil {
-		return errResult(fmt.Sprintf("create task: %v", err)), nil
+	if err := t.writeAndSync(ctx, envelopes...); err != nil {
+		return errResultFrom(fmt.Errorf("create task: %w", err)), nil
 	}
-	return jsonResult(map[string]string{"status": "created", "uuid": taskUUID, "title": title}), nil
+	return jsonResult(WriteOutput{Status: "created", UUID: taskUUID, Title: title}), nil
 }
 
-func (t *ThingsMCP) handleCreateProject(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+func (t *ThingsMCP) handleCreateProject(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	title, err := req.RequireString("title")
 	if err != nil {
 		return errResult("title is required"), nil
@@ -3075,13 +3455,13 @@ func (t *ThingsMCP) handleCreateProject(_ context.Context, req mcp.CallToolReque
 	}
 
 	if err := t.validateOpts(opts); err != nil {
-		return errResult(err.Error()), nil
+		return errResultFrom(err), nil
 	}
 
 	// Validate recurrence format early
 	if v, ok := opts["recurrence"]; ok && v != "" {
 		if _, err := parseRecurrence(v, time.Now()); err != nil {
-			return errResult(err.Error()), nil
+			return errResultFrom(err), nil
 		}
 	}
 
@@ -3091,13 +3471,13 @@ func (t *ThingsMCP) handleCreateProject(_ context.Context, req mcp.CallToolReque
 	payload := newTaskCreatePayload(title, opts, ix)
 
 	env := writeEnvelope{id: projectUUID, action: 0, kind: "Task6", payload: payload}
-	if err := t.writeAndSync(env); err != nil {
-		return errResult(fmt.Sprintf("create project: %v", err)), nil
+	if err := t.writeAndSync(ctx, env); err != nil {
+		return errResultFrom(fmt.Errorf("create project: %w", err)), nil
 	}
-	return jsonResult(map[string]string{"status": "created", "uuid": projectUUID, "title": title}), nil
+	return jsonResult(WriteOutput{Status: "created", UUID: projectUUID, Title: title}), nil
 }
 
-func (t *ThingsMCP) handleCreateHeading(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+func (t *ThingsMCP) handleCreateHeading(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	title, err := req.RequireString("title")
 	if err != nil {
 		return errResult("title is required"), nil
@@ -3110,7 +3490,7 @@ func (t *ThingsMCP) handleCreateHeading(_ context.Context, req mcp.CallToolReque
 	opts := map[string]string{"type": "heading", "project_uuid": projectUUID}
 
 	if err := t.validateOpts(opts); err != nil {
-		return errResult(err.Error()), nil
+		return errResultFrom(err), nil
 	}
 
 	ix := t.minHeadingIndex(projectUUID) - 1
@@ -3119,13 +3499,13 @@ func (t *ThingsMCP) handleCreateHeading(_ context.Context, req mcp.CallToolReque
 	payload := newTaskCreatePayload(title, opts, ix)
 	env := writeEnvelope{id: headingUUID, action: 0, kind: "Task6", payload: payload}
 
-	if err := t.writeAndSync(env); err != nil {
-		return errResult(fmt.Sprintf("create heading: %v", err)), nil
+	if err := t.writeAndSync(ctx, env); err != nil {
+		return errResultFrom(fmt.Errorf("create heading: %w", err)), nil
 	}
-	return jsonResult(map[string]string{"status": "created", "uuid": headingUUID, "title": title}), nil
+	return jsonResult(WriteOutput{Status: "created", UUID: headingUUID, Title: title}), nil
 }
 
-func (t *ThingsMCP) handleCreateArea(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+func (t *ThingsMCP) handleCreateArea(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	name, err := req.RequireString("name")
 	if err != nil {
 		return errResult("name is required"), nil
@@ -3140,13 +3520,13 @@ func (t *ThingsMCP) handleCreateArea(_ context.Context, req mcp.CallToolRequest)
 	}
 	env := writeEnvelope{id: areaUUID, action: 0, kind: "Area3", payload: payload}
 
-	if err := t.writeAndSync(env); err != nil {
-		return errResult(fmt.Sprintf("create area: %v", err)), nil
+	if err := t.writeAndSync(ctx, env); err != nil {
+		return errResultFrom(fmt.Errorf("create area: %w", err)), nil
 	}
-	return jsonResult(map[string]string{"status": "created", "uuid": areaUUID, "name": name}), nil
+	return jsonResult(WriteOutput{Status: "created", UUID: areaUUID, Name: name}), nil
 }
 
-func (t *ThingsMCP) handleCreateTag(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+func (t *ThingsMCP) handleCreateTag(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	name, err := req.RequireString("name")
 	if err != nil {
 		return errResult("name is required"), nil
@@ -3165,19 +3545,19 @@ func (t *ThingsMCP) handleCreateTag(_ context.Context, req mcp.CallToolRequest)
 	payload := TagCreatePayload{Tt: name, Ix: -1237, Sh: sh, Pn: pn, Xx: defaultExtension()}
 	env := writeEnvelope{id: tagUUID, action: 0, kind: "Tag4", payload: payload}
 
-	if err := t.writeAndSync(env); err != nil {
-		return errResult(fmt.Sprintf("create tag: %v", err)), nil
+	if err := t.writeAndSync(ctx, env); err != nil {
+		return errResultFrom(fmt.Errorf("create tag: %w", err)), nil
 	}
-	return jsonResult(map[string]string{"status": "created", "uuid": tagUUID, "name": name}), nil
+	return jsonResult(WriteOutput{Status: "created", UUID: tagUUID, Name: name}), nil
 }
 
-func (t *ThingsMCP) handleEditArea(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+func (t *ThingsMCP) handleEditArea(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	areaUUID, err := req.RequireString("uuid")
 	if err != nil {
 		return errResult("uuid is required"), nil
 	}
 	if err := t.validateAreaUUID(areaUUID); err != nil {
-		return errResult(err.Error()), nil
+		return errResultFrom(err), nil
 	}
 
 	name, err := req.RequireString("name")
@@ -3188,37 +3568,37 @@ func (t *ThingsMCP) handleEditArea(_ context.Context, req mcp.CallToolRequest) (
 	payload := map[string]any{"tt": name}
 	env := writeEnvelope{id: areaUUID, action: 1, kind: "Area3", payload: payload}
 
-	if err := t.writeAndSync(env); err != nil {
-		return errResult(fmt.Sprintf("edit area: %v", err)), nil
+	if err := t.writeAndSync(ctx, env); err != nil {
+		return errResultFrom(fmt.Errorf("edit area: %w", err)), nil
 	}
-	return jsonResult(map[string]string{"status": "updated", "uuid": areaUUID}), nil
+	return jsonResult(WriteOutput{Status: "updated", UUID: areaUUID}), nil
 }
 
-func (t *ThingsMCP) handleDeleteArea(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+func (t *ThingsMCP) handleDeleteArea(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	areaUUID, err := req.RequireString("uuid")
 	if err != nil {
 		return errResult("uuid is required"), nil
 	}
 	if err := t.validateAreaUUID(areaUUID); err != nil {
-		return errResult(err.Error()), nil
+		return errResultFrom(err), nil
 	}
 
 	payload := map[string]any{}
 	env := writeEnvelope{id: areaUUID, action: 2, kind: "Area3", payload: payload}
 
-	if err := t.writeAndSync(env); err != nil {
-		return errResult(fmt.Sprintf("delete area: %v", err)), nil
+	if err := t.writeAndSync(ctx, env); err != nil {
+		return errResultFrom(fmt.Errorf("delete area: %w", err)), nil
 	}
-	return jsonResult(map[string]string{"status": "deleted", "uuid": areaUUID}), nil
+	return jsonResult(WriteOutput{Status: "deleted", UUID: areaUUID}), nil
 }
 
-func (t *ThingsMCP) handleEditTag(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+func (t *ThingsMCP) handleEditTag(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	tagUUID, err := req.RequireString("uuid")
 	if err != nil {
 		return errResult("uuid is required"), nil
 	}
 	if err := t.validateTagUUID(tagUUID); err != nil {
-		return errResult(err.Error()), nil
+		return errResultFrom(err), nil
 	}
 
 	payload := map[string]any{}
@@ -3238,42 +3618,45 @@ func (t *ThingsMCP) handleEditTag(_ context.Context, req mcp.CallToolRequest) (*
 
 	env := writeEnvelope{id: tagUUID, action: 1, kind: "Tag4", payload: payload}
 
-	if err := t.writeAndSync(env); err != nil {
-		return errResult(fmt.Sprintf("edit tag: %v", err)), nil
+	if err := t.writeAndSync(ctx, env); err != nil {
+		return errResultFrom(fmt.Errorf("edit tag: %w", err)), nil
 	}
-	return jsonResult(map[string]string{"status": "updated", "uuid": tagUUID}), nil
+	return jsonResult(WriteOutput{Status: "updated", UUID: tagUUID}), nil
 }
 
-func (t *ThingsMCP) handleDeleteTag(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+func (t *ThingsMCP) handleDeleteTag(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	tagUUID, err := req.RequireString("uuid")
 	if err != nil {
 		return errResult("uuid is required"), nil
 	}
 	if err := t.validateTagUUID(tagUUID); err != nil {
-		return errResult(err.Error()), nil
+		return errResultFrom(err), nil
 	}
 
 	payload := map[string]any{}
 	env := writeEnvelope{id: tagUUID, action: 2, kind: "Tag4", payload: payload}
 
-	if err := t.writeAndSync(env); err != nil {
-		return errResult(fmt.Sprintf("delete tag: %v", err)), nil
+	if err := t.writeAndSync(ctx, env); err != nil {
+		return errResultFrom(fmt.Errorf("delete tag: %w", err)), nil
 	}
-	return jsonResult(map[string]string{"status": "deleted", "uuid": tagUUID}), nil
+	return jsonResult(WriteOutput{Status: "deleted", UUID: tagUUID}), nil
 }
 
-func (t *ThingsMCP) handleEditTask(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+func (t *ThingsMCP) handleEditTask(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	taskUUID, err := req.RequireString("uuid")
 	if err != nil {
 		return errResult("uuid is required"), nil
 	}
 
 	if err := t.validateTaskUUID(taskUUID); err != nil {
-		return errResult(err.Error()), nil
+		return errResultFrom(err), nil
+	}
+	if conflict := t.checkExpectedModified(taskUUID, req.GetString("expected_modified", "")); conflict != nil {
+		return conflict, nil
 	}
 
 	if err := t.syncAndRebuild(); err != nil {
-		return errResult(fmt.Sprintf("sync: %v", err)), nil
+		return errResultFrom(fmt.Errorf("sync: %w", err)), nil
 	}
 	editTarget := t.findTask(taskUUID)
 	if editTarget != nil && isRecurringTemplate(editTarget) {
@@ -3288,7 +3671,7 @@ func (t *ThingsMCP) handleEditTask(_ context.Context, req mcp.CallToolRequest) (
 		}
 	}
 	if err := t.validateOpts(editOpts); err != nil {
-		return errResult(err.Error()), nil
+		return errResultFrom(err), nil
 	}
 
 	u := newTaskUpdate()
@@ -3361,7 +3744,7 @@ func (t *ThingsMCP) handleEditTask(_ context.Context, req mcp.CallToolRequest) (
 	}
 	if v := req.GetString("recurrence", ""); v != "" {
 		if editTarget == nil {
-			return errResult(fmt.Sprintf("task not found: %s", taskUUID)), nil
+			return errResultCode(codeNotFound, fmt.Sprintf("task not found: %s", taskUUID)), nil
 		}
 
 		hasExistingTemplate := len(editTarget.RecurrenceIDs) > 0
@@ -3394,7 +3777,7 @@ func (t *ThingsMCP) handleEditTask(_ context.Context, req mcp.CallToolRequest) (
 
 			rr, err := parseRecurrence(v, recRef)
 			if err != nil {
-				return errResult(err.Error()), nil
+				return errResultFrom(err), nil
 			}
 			if rr == nil {
 				return errResult("invalid recurrence"), nil
@@ -3403,7 +3786,7 @@ func (t *ThingsMCP) handleEditTask(_ context.Context, req mcp.CallToolRequest) (
 			// Compute next occurrence for template tir
 			var rc thingscloud.RepeaterConfiguration
 			if err := json.Unmarshal(*rr, &rc); err != nil {
-				return errResult(fmt.Sprintf("internal: parse recurrence: %v", err)), nil
+				return errResultCode(codeInternal, fmt.Sprintf("internal: parse recurrence: %v", err)), nil
 			}
 			nextDate := rc.NextOccurrenceAfter(recRef)
 			if nextDate.IsZero() {
@@ -3443,10 +3826,14 @@ func (t *ThingsMCP) handleEditTask(_ context.Context, req mcp.CallToolRequest) (
 	}
 
 	envelopes = append(envelopes, writeEnvelope{id: taskUUID, action: 1, kind: "Task6", payload: u.build()})
-	if err := t.writeAndSync(envelopes...); err != nil {
-		return errResult(fmt.Sprintf("edit task: %v", err)), nil
+	if err := t.writeAndSync(ctx, envelopes...); err != nil {
+		return errResultFrom(fmt.Errorf("edit task: %w", err)), nil
+	}
+	out := WriteOutput{Status: "updated", UUID: taskUUID}
+	if task := t.findTask(taskUUID); task != nil && dryRunFrom(ctx) == nil {
+		out.ModificationDate = modificationDate(task.ModificationDate)
 	}
-	return jsonResult(map[string]string{"status": "updated", "uuid": taskUUID}), nil
+	return jsonResult(out), nil
 }
 
 
@@ -3455,7 +3842,7 @@ func (t *ThingsMCP) handleEditTask(_ context.Context, req mcp.CallToolRequest) (
 // Checklist item operations
 // ---------------------------------------------------------------------------
 
-func (t *ThingsMCP) handleAddChecklistItem(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+func (t *ThingsMCP) handleAddChecklistItem(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	taskUUID, err := req.RequireString("task_uuid")
 	if err != nil {
 		return errResult("task_uuid is required"), nil
@@ -3466,7 +3853,7 @@ func (t *ThingsMCP) handleAddChecklistItem(_ context.Context, req mcp.CallToolRe
 	}
 
 	if err := t.validateTaskUUID(taskUUID); err != nil {
-		return errResult(err.Error()), nil
+		return errResultFrom(err), nil
 	}
 
 	ix := req.GetInt("index", 0)
@@ -3478,17 +3865,20 @@ func (t *ThingsMCP) handleAddChecklistItem(_ context.Context, req mcp.CallToolRe
 	}
 	env := writeEnvelope{id: itemUUID, action: 0, kind: "ChecklistItem3", payload: payload}
 
-	if err := t.writeAndSync(env); err != nil {
-		return errResult(fmt.Sprintf("add checklist item: %v", err)), nil
+	if err := t.writeAndSync(ctx, env); err != nil {
+		return errResultFrom(fmt.Errorf("add checklist item: %w", err)), nil
 	}
-	return jsonResult(map[string]string{"status": "created", "uuid": itemUUID, "task_uuid": taskUUID}), nil
+	return jsonResult(WriteOutput{Status: "created", UUID: itemUUID, TaskUUID: taskUUID}), nil
 }
 
-func (t *ThingsMCP) handleEditChecklistItem(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+func (t *ThingsMCP) handleEditChecklistItem(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	itemUUID, err := req.RequireString("uuid")
 	if err != nil {
 		return errResult("uuid is required"), nil
 	}
+	if conflict := t.checkExpectedModified(itemUUID, req.GetString("expected_modified", "")); conflict != nil {
+		return conflict, nil
+	}
 
 	payload := map[string]any{"md": nowTs()}
 	if v := req.GetString("title", ""); v != "" {
@@ -3508,23 +3898,26 @@ func (t *ThingsMCP) handleEditChecklistItem(_ context.Context, req mcp.CallToolR
 	}
 
 	env := writeEnvelope{id: itemUUID, action: 1, kind: "ChecklistItem3", payload: payload}
-	if err := t.writeAndSync(env); err != nil {
-		return errResult(fmt.Sprintf("edit checklist item: %v", err)), nil
+	if err := t.writeAndSync(ctx, env); err != nil {
+		return errResultFrom(fmt.Errorf("edit checklist item: %w", err)), nil
 	}
-	return jsonResult(map[string]string{"status": "updated", "uuid": itemUUID}), nil
+	return jsonResult(WriteOutput{Status: "updated", UUID: itemUUID}), nil
 }
 
-func (t *ThingsMCP) handleDeleteChecklistItem(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+func (t *ThingsMCP) handleDeleteChecklistItem(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	itemUUID, err := req.RequireString("uuid")
 	if err != nil {
 		return errResult("uuid is required"), nil
 	}
+	if conflict := t.checkExpectedModified(itemUUID, req.GetString("expected_modified", "")); conflict != nil {
+		return conflict, nil
+	}
 
 	env := writeEnvelope{id: itemUUID, action: 2, kind: "ChecklistItem3", payload: map[string]any{}}
-	if err := t.writeAndSync(env); err != nil {
-		return errResult(fmt.Sprintf("delete checklist item: %v", err)), nil
+	if err := t.writeAndSync(ctx, env); err != nil {
+		return errResultFrom(fmt.Errorf("delete checklist item: %w", err)), nil
 	}
-	return jsonResult(map[string]string{"status": "deleted", "uuid": itemUUID}), nil
+	return jsonResult(WriteOutput{Status: "deleted", UUID: itemUUID}), nil
 }
 
 // ---------------------------------------------------------------------------
@@ -3538,21 +3931,31 @@ func (t *ThingsMCP) handleDeleteChecklistItem(_ context.Context, req mcp.CallToo
 
 func defineTools(um *UserManager) []server.ServerTool {
 	// wrap creates a handler closure that extracts ThingsMCP from context via UserManager.
+	// Tokens limited to areas get a view confined to them.
 	wrap := func(fn func(t *ThingsMCP, ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error)) server.ToolHandlerFunc {
 		return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 			t, err := getUserFromContext(ctx, um)
 			if err != nil {
-				return errResult(err.Error()), nil
+				return errResultFrom(err), nil
 			}
+			grant, err := um.grantFromContext(ctx)
+			if err != nil {
+				return errResultFrom(err), nil
+			}
+			if len(grant.Areas) > 0 {
+				t = t.confinedTo(grant.Areas)
+			}
+			defer t.beginCall(ctx)()
 			return fn(t, ctx, req)
 		}
 	}
 
-	return []server.ServerTool{
+	return instrumentTools(progressTools(um.scopedTools(um.auditTools(um.confirmTools(um.dryRunTools([]server.ServerTool{
 		// --- Read tools ---
 		{
 			Tool: mcp.NewTool("things_find_tasks",
 				mcp.WithDescription("List tasks from Things 3 with optional filters. Returns an array of task objects, each containing uuid, title, status (pending/completed/canceled), schedule (inbox/today/tonight/anytime/someday/upcoming), and optional fields: note, scheduledDate, deadlineDate, reminderTime, recurrence, areas, project, tags. Use things_show_task to see full details including checklist items. Default: only pending (active) tasks. Use status parameter to query completed or canceled tasks. Note: schedule=today includes both regular and tonight tasks; use schedule=tonight to filter only tonight tasks."),
+				outputSchema[listOutput[TaskOutput]](),
 				mcp.WithReadOnlyHintAnnotation(true),
 				mcp.WithDestructiveHintAnnotation(false),
 				mcp.WithIdempotentHintAnnotation(true),
@@ -3578,6 +3981,7 @@ func defineTools(um *UserManager) []server.ServerTool {
 		{
 			Tool: mcp.NewTool("things_show_task",
 				mcp.WithDescription("Show full details of a single Things 3 task, including its checklist items. Returns a task object with uuid, title, status, schedule, note, dates, areas, project, tags, and a checklist array (each with uuid, title, status). Accepts a UUID prefix for convenience. Use things_find_tasks to search for tasks and obtain UUIDs."),
+				outputSchema[TaskDetailOutput](),
 				mcp.WithReadOnlyHintAnnotation(true),
 				mcp.WithDestructiveHintAnnotation(false),
 				mcp.WithIdempotentHintAnnotation(true),
@@ -3591,6 +3995,7 @@ func defineTools(um *UserManager) []server.ServerTool {
 		{
 			Tool: mcp.NewTool("things_show_project",
 				mcp.WithDescription("Show full details of a Things 3 project, including its headings and tasks grouped by heading. Returns the project info plus a headings array (each with uuid, title, and nested tasks) and an unfiledTasks array for tasks not under any heading. Use things_find_projects to search for projects and obtain UUIDs."),
+				outputSchema[ProjectDetailOutput](),
 				mcp.WithReadOnlyHintAnnotation(true),
 				mcp.WithDestructiveHintAnnotation(false),
 				mcp.WithIdempotentHintAnnotation(true),
@@ -3605,6 +4010,7 @@ func defineTools(um *UserManager) []server.ServerTool {
 		{
 			Tool: mcp.NewTool("things_find_projects",
 				mcp.WithDescription("List projects from Things 3 with optional filters. Returns an array of project objects, each containing uuid, title, status (pending/completed/canceled), schedule (inbox/today/tonight/anytime/someday/upcoming), and optional fields: note, scheduledDate, deadlineDate, areas, tags. Use things_show_project to see full details including headings and child tasks. Default: only pending (active) projects. Use status parameter to query completed or canceled projects."),
+				outputSchema[listOutput[TaskOutput]](),
 				mcp.WithReadOnlyHintAnnotation(true),
 				mcp.WithDestructiveHintAnnotation(false),
 				mcp.WithIdempotentHintAnnotation(true),
@@ -3629,6 +4035,7 @@ func defineTools(um *UserManager) []server.ServerTool {
 		{
 			Tool: mcp.NewTool("things_find_headings",
 				mcp.WithDescription("List all headings within a Things 3 project. Returns an array of heading objects, each containing uuid and title. Use things_show_project to also see the tasks grouped under each heading. Use things_find_projects to find the project UUID."),
+				outputSchema[listOutput[HeadingOutput]](),
 				mcp.WithReadOnlyHintAnnotation(true),
 				mcp.WithDestructiveHintAnnotation(false),
 				mcp.WithIdempotentHintAnnotation(true),
@@ -3642,6 +4049,7 @@ func defineTools(um *UserManager) []server.ServerTool {
 		{
 			Tool: mcp.NewTool("things_find_areas",
 				mcp.WithDescription("List all areas in Things 3. Areas are top-level organizational containers for projects and tasks. Returns an array of area objects, each containing uuid and title."),
+				outputSchema[listOutput[AreaOutput]](),
 				mcp.WithReadOnlyHintAnnotation(true),
 				mcp.WithDestructiveHintAnnotation(false),
 				mcp.WithIdempotentHintAnnotation(true),
@@ -3654,6 +4062,7 @@ func defineTools(um *UserManager) []server.ServerTool {
 		{
 			Tool: mcp.NewTool("things_find_tags",
 				mcp.WithDescription("List all tags in Things 3. Returns an array of tag objects, each containing uuid, title, and optional fields: shorthand (abbreviation) and parentIds (for nested tags)."),
+				outputSchema[listOutput[TagOutput]](),
 				mcp.WithReadOnlyHintAnnotation(true),
 				mcp.WithDestructiveHintAnnotation(false),
 				mcp.WithIdempotentHintAnnotation(true),
@@ -3666,6 +4075,7 @@ func defineTools(um *UserManager) []server.ServerTool {
 		{
 			Tool: mcp.NewTool("things_overview",
 				mcp.WithDescription("Returns a comprehensive snapshot of the user's task landscape in a single call. Includes all tags, the full area-to-project hierarchy (with IDs), today's items (tasks and projects), and upcoming scheduled/due items within a configurable lookahead window. Each item has a type field ('task' or 'project') to distinguish them. Use this as the first call in a session to orient yourself before drilling into specific tasks or projects."),
+				outputSchema[OverviewOutput](),
 				mcp.WithReadOnlyHintAnnotation(true),
 				mcp.WithDestructiveHintAnnotation(false),
 				mcp.WithIdempotentHintAnnotation(true),
@@ -3676,11 +4086,64 @@ func defineTools(um *UserManager) []server.ServerTool {
 				return t.handleOverview(ctx, req)
 			}),
 		},
+		{
+			Tool: mcp.NewTool("things_export",
+				mcp.WithDescription("Export the whole account (or a filtered slice of it) as a backup. Covers areas, projects, headings, tasks, checklist items, tags, notes and recurrence rules. Formats: json (versioned nested schema: areas → projects → headings → tasks, plus projects and tasks without an area), markdown (nested checklist document) or csv (one flat row per item with area/project/heading columns). Completed, canceled and trashed items are excluded unless requested."),
+				outputSchema[exportDoc](),
+				alsoReturns[exportTextOutput](),
+				mcp.WithReadOnlyHintAnnotation(true),
+				mcp.WithDestructiveHintAnnotation(false),
+				mcp.WithIdempotentHintAnnotation(true),
+				mcp.WithOpenWorldHintAnnotation(false),
+				mcp.WithString("format", mcp.Description("Output format: json (default), markdown or csv")),
+				mcp.WithString("area_uuid", mcp.Description("Only export this area. Use things_find_areas to find area UUIDs.")),
+				mcp.WithString("project_uuid", mcp.Description("Only export this project. Use things_find_projects to find project UUIDs.")),
+				mcp.WithString("date_field", mcp.Description("Date that from/to apply to: created (default), modified, completed, scheduled or deadline")),
+				mcp.WithString("from", mcp.Description("Only include tasks whose date_field is on or after this day (YYYY-MM-DD)")),
+				mcp.WithString("to", mcp.Description("Only include tasks whose date_field is on or before this day (YYYY-MM-DD)")),
+				mcp.WithBoolean("include_completed", mcp.Description("Include completed and canceled items. Default: false")),
+				mcp.WithBoolean("include_trashed", mcp.Description("Include items in the trash. Default: false")),
+			),
+			Handler: wrap(func(t *ThingsMCP, ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+				return t.handleExport(ctx, req)
+			}),
+		},
+		{
+			Tool: mcp.NewTool("things_changes_since",
+				mcp.WithDescription("Summarize what changed in Things since a date or a checkpoint from a previous call, as a changelog grouped into created, completed, reopened, moved, rescheduled, renamed, edited and removed. Repeated changes to the same item are collapsed (a task moved twice shows one move from where it started to where it ended up). Returns the groups with one human-readable line per change, the same changelog as Markdown, and a checkpoint to pass as since next time to get only newer changes. Covers roughly the last 30 days; complete=false means older changes were trimmed."),
+				outputSchema[changelogResult](),
+				mcp.WithReadOnlyHintAnnotation(true),
+				mcp.WithDestructiveHintAnnotation(false),
+				mcp.WithIdempotentHintAnnotation(true),
+				mcp.WithOpenWorldHintAnnotation(false),
+				mcp.WithString("since", mcp.Required(), mcp.Description("YYYY-MM-DD, RFC3339 (e.g. 2025-03-01T09:00:00+01:00) or the checkpoint returned by a previous call")),
+				mcp.WithNumber("limit", mcp.Description("Maximum entries listed per group (counts always cover everything). Default: 50")),
+			),
+			Handler: wrap(func(t *ThingsMCP, ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+				return t.handleChangesSince(ctx, req)
+			}),
+		},
+		{
+			Tool: mcp.NewTool("things_item_history",
+				mcp.WithDescription("Show the life of a task or project as a chronological timeline reconstructed from the Things history: created, moved between Inbox/Today/Anytime/Someday, rescheduled, moved between projects and areas, retitled, tagged, completed, reopened, trashed. Each entry has a timestamp (the item's modification date), the server index of the change, a change type and a human-readable line. Also works for deleted items. Checklist item changes are included unless include_checklist is false. Things Cloud does not record which device made a change."),
+				outputSchema[itemHistoryOutput](),
+				mcp.WithReadOnlyHintAnnotation(true),
+				mcp.WithDestructiveHintAnnotation(false),
+				mcp.WithIdempotentHintAnnotation(true),
+				mcp.WithOpenWorldHintAnnotation(false),
+				mcp.WithString("uuid", mcp.Required(), mcp.Description("UUID (or unique prefix) of the task or project")),
+				mcp.WithBoolean("include_checklist", mcp.Description("Include changes to the task's checklist items. Default: true")),
+			),
+			Handler: wrap(func(t *ThingsMCP, ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+				return t.handleItemHistory(ctx, req)
+			}),
+		},
 
 		// --- Create tools ---
 		{
 			Tool: mcp.NewTool("things_create_task",
 				mcp.WithDescription("Create a new task in Things 3. Returns {status: \"created\", uuid, title}. The task is placed in Inbox by default; set schedule or project_uuid/area_uuid to organize it. Use things_find_projects and things_find_areas first to get valid UUIDs for assignment. When recurrence is set, an isRecurring flag appears in output."),
+				outputSchema[WriteOutput](),
 				mcp.WithDestructiveHintAnnotation(false),
 				mcp.WithOpenWorldHintAnnotation(false),
 				mcp.WithString("title", mcp.Required(), mcp.Description("Task title")),
@@ -3703,6 +4166,7 @@ func defineTools(um *UserManager) []server.ServerTool {
 		{
 			Tool: mcp.NewTool("things_create_heading",
 				mcp.WithDescription("Create a new heading within a Things 3 project. Headings are section dividers used to group tasks inside a project. Returns {status: \"created\", uuid, title}."),
+				outputSchema[WriteOutput](),
 				mcp.WithDestructiveHintAnnotation(false),
 				mcp.WithOpenWorldHintAnnotation(false),
 				mcp.WithString("title", mcp.Required(), mcp.Description("Heading title")),
@@ -3715,6 +4179,7 @@ func defineTools(um *UserManager) []server.ServerTool {
 		{
 			Tool: mcp.NewTool("things_create_project",
 				mcp.WithDescription("Create a new project in Things 3. Projects are containers that hold tasks and headings. Returns {status: \"created\", uuid, title}. Defaults to Anytime schedule."),
+				outputSchema[WriteOutput](),
 				mcp.WithDestructiveHintAnnotation(false),
 				mcp.WithOpenWorldHintAnnotation(false),
 				mcp.WithString("title", mcp.Required(), mcp.Description("Project title")),
@@ -3734,6 +4199,7 @@ func defineTools(um *UserManager) []server.ServerTool {
 		{
 			Tool: mcp.NewTool("things_create_area",
 				mcp.WithDescription("Create a new area in Things 3. Areas are top-level organizational containers (e.g. \"Work\", \"Personal\") that group projects and tasks. Returns {status: \"created\", uuid, name}."),
+				outputSchema[WriteOutput](),
 				mcp.WithDestructiveHintAnnotation(false),
 				mcp.WithOpenWorldHintAnnotation(false),
 				mcp.WithString("name", mcp.Required(), mcp.Description("Area name")),
@@ -3745,6 +4211,7 @@ func defineTools(um *UserManager) []server.ServerTool {
 		{
 			Tool: mcp.NewTool("things_create_tag",
 				mcp.WithDescription("Create a new tag in Things 3. Tags can be applied to tasks and projects for cross-cutting categorization. Tags support nesting via parent_uuid. Returns {status: \"created\", uuid, name}."),
+				outputSchema[WriteOutput](),
 				mcp.WithDestructiveHintAnnotation(false),
 				mcp.WithOpenWorldHintAnnotation(false),
 				mcp.WithString("name", mcp.Required(), mcp.Description("Tag name")),
@@ -3755,11 +4222,28 @@ func defineTools(um *UserManager) []server.ServerTool {
 				return t.handleCreateTag(ctx, req)
 			}),
 		},
+		{
+			Tool: mcp.NewTool("things_import",
+				mcp.WithDescription("Import tasks from another app. Supported formats: todoist_csv (one project per file), todoist_json (Sync/REST API export), taskpaper and markdown (indented checklists under headings). Todoist projects, sections, labels, due dates, priorities and sub-tasks become areas/projects, headings, tags, deadlines, p1–p3 tags and checklist items. Existing areas and tags are reused by name; everything else is created. Completed items are skipped. Use dry_run first to review the plan. Returns {status, created: counts, commits, warnings}."),
+				outputSchema[importResult](),
+				mcp.WithDestructiveHintAnnotation(false),
+				mcp.WithOpenWorldHintAnnotation(false),
+				mcp.WithString("format", mcp.Required(), mcp.Description("Source format: todoist_csv, todoist_json, taskpaper or markdown")),
+				mcp.WithString("content", mcp.Required(), mcp.Description("Full contents of the file to import")),
+				mcp.WithString("project", mcp.Description("Project title for todoist_csv imports, which contain a single project without its name. Default: Imported")),
+				mcp.WithString("area_uuid", mcp.Description("Area for imported projects and tasks that have no area of their own; without it loose tasks go to the Inbox. Use things_find_areas to find area UUIDs.")),
+				mcp.WithBoolean("dry_run", mcp.Description("Parse and plan without writing anything; the response includes the full plan. Default: false")),
+			),
+			Handler: wrap(func(t *ThingsMCP, ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+				return t.handleImport(ctx, req)
+			}),
+		},
 
 		// --- Area/Tag edit & delete tools ---
 		{
 			Tool: mcp.NewTool("things_edit_area",
 				mcp.WithDescription("Rename an existing area in Things 3. Returns {status: \"updated\", uuid}."),
+				outputSchema[WriteOutput](),
 				mcp.WithDestructiveHintAnnotation(false),
 				mcp.WithIdempotentHintAnnotation(true),
 				mcp.WithOpenWorldHintAnnotation(false),
@@ -3773,6 +4257,7 @@ func defineTools(um *UserManager) []server.ServerTool {
 		{
 			Tool: mcp.NewTool("things_delete_area",
 				mcp.WithDescription("Permanently delete an area from Things 3. Tasks and projects in this area will become unassigned. This action cannot be undone. Returns {status: \"deleted\", uuid}."),
+				outputSchema[WriteOutput](),
 				mcp.WithDestructiveHintAnnotation(true),
 				mcp.WithIdempotentHintAnnotation(true),
 				mcp.WithOpenWorldHintAnnotation(false),
@@ -3785,6 +4270,7 @@ func defineTools(um *UserManager) []server.ServerTool {
 		{
 			Tool: mcp.NewTool("things_edit_tag",
 				mcp.WithDescription("Edit an existing tag in Things 3. Only provided fields are updated; omitted fields remain unchanged. Returns {status: \"updated\", uuid}."),
+				outputSchema[WriteOutput](),
 				mcp.WithDestructiveHintAnnotation(false),
 				mcp.WithIdempotentHintAnnotation(true),
 				mcp.WithOpenWorldHintAnnotation(false),
@@ -3800,6 +4286,7 @@ func defineTools(um *UserManager) []server.ServerTool {
 		{
 			Tool: mcp.NewTool("things_delete_tag",
 				mcp.WithDescription("Permanently delete a tag from Things 3. The tag will be removed from all tasks and projects that use it. This action cannot be undone. Returns {status: \"deleted\", uuid}."),
+				outputSchema[WriteOutput](),
 				mcp.WithDestructiveHintAnnotation(true),
 				mcp.WithIdempotentHintAnnotation(true),
 				mcp.WithOpenWorldHintAnnotation(false),
@@ -3813,7 +4300,8 @@ func defineTools(um *UserManager) []server.ServerTool {
 		// --- Modify tools ---
 		{
 			Tool: mcp.NewTool("things_edit_item",
-				mcp.WithDescription("Edit an existing task or project in Things 3. Only provided fields are updated; omitted fields remain unchanged. Can also change status to complete, cancel, trash, or restore items. Completing a recurring task completes only the current instance; the next instance appears automatically. Set recurrence=none to permanently stop a recurring task. Returns {status: \"updated\", uuid}."),
+				mcp.WithDescription("Edit an existing task or project in Things 3. Only provided fields are updated; omitted fields remain unchanged. Can also change status to complete, cancel, trash, or restore items. Completing a recurring task completes only the current instance; the next instance appears automatically. Set recurrence=none to permanently stop a recurring task. Returns {status: \"updated\", uuid, modificationDate}. Pass expected_modified to refuse the edit if the item changed since you read it."),
+				outputSchema[WriteOutput](),
 				mcp.WithDestructiveHintAnnotation(false),
 				mcp.WithIdempotentHintAnnotation(true),
 				mcp.WithOpenWorldHintAnnotation(false),
@@ -3830,6 +4318,7 @@ func defineTools(um *UserManager) []server.ServerTool {
 				mcp.WithString("reminder_time", mcp.Description("Reminder time in HH:MM 24-hour format (e.g. 09:00, 14:30). Must be used together with reminder_date.")),
 				mcp.WithString("recurrence", mcp.Description("Recurrence rule: daily, weekly, weekly:mon,wed, monthly, monthly:15, monthly:last, yearly, every N days, every N weeks. Use \"none\" to clear.")),
 				mcp.WithString("status", mcp.Description("Set item status: pending, completed, canceled, trashed (move to trash), restored (restore from trash)"), mcp.Enum("pending", "completed", "canceled", "trashed", "restored")),
+				mcp.WithString("expected_modified", mcp.Description(expectedModifiedDescription)),
 			),
 			Handler: wrap(func(t *ThingsMCP, ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 				return t.handleEditTask(ctx, req)
@@ -3840,6 +4329,7 @@ func defineTools(um *UserManager) []server.ServerTool {
 		{
 			Tool: mcp.NewTool("things_add_checklist_item",
 				mcp.WithDescription("Add a checklist item to an existing Things 3 task. Checklist items are sub-steps within a task. Returns {status: \"created\", uuid, task_uuid}. Use things_show_task to see existing checklist items."),
+				outputSchema[WriteOutput](),
 				mcp.WithDestructiveHintAnnotation(false),
 				mcp.WithOpenWorldHintAnnotation(false),
 				mcp.WithString("task_uuid", mcp.Required(), mcp.Description("UUID of the parent task to add the checklist item to")),
@@ -3853,6 +4343,7 @@ func defineTools(um *UserManager) []server.ServerTool {
 		{
 			Tool: mcp.NewTool("things_edit_checklist_item",
 				mcp.WithDescription("Edit an existing checklist item in Things 3. Only provided fields are updated; omitted fields remain unchanged. Returns {status: \"updated\", uuid}. Use things_show_task to find checklist item UUIDs."),
+				outputSchema[WriteOutput](),
 				mcp.WithDestructiveHintAnnotation(false),
 				mcp.WithIdempotentHintAnnotation(true),
 				mcp.WithOpenWorldHintAnnotation(false),
@@ -3860,6 +4351,7 @@ func defineTools(um *UserManager) []server.ServerTool {
 				mcp.WithString("title", mcp.Description("New checklist item title")),
 				mcp.WithNumber("index", mcp.Description("New sort position within the checklist")),
 				mcp.WithBoolean("completed", mcp.Description("Set true to mark as completed, false to mark as pending")),
+				mcp.WithString("expected_modified", mcp.Description(expectedModifiedDescription)),
 			),
 			Handler: wrap(func(t *ThingsMCP, ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 				return t.handleEditChecklistItem(ctx, req)
@@ -3868,10 +4360,12 @@ func defineTools(um *UserManager) []server.ServerTool {
 		{
 			Tool: mcp.NewTool("things_delete_checklist_item",
 				mcp.WithDescription("Permanently delete a checklist item from a Things 3 task. This action cannot be undone. Returns {status: \"deleted\", uuid}. Use things_show_task to find checklist item UUIDs."),
+				outputSchema[WriteOutput](),
 				mcp.WithDestructiveHintAnnotation(true),
 				mcp.WithIdempotentHintAnnotation(true),
 				mcp.WithOpenWorldHintAnnotation(false),
 				mcp.WithString("uuid", mcp.Required(), mcp.Description("UUID of the checklist item to delete. Use things_show_task to find checklist item UUIDs.")),
+				mcp.WithString("expected_modified", mcp.Description(expectedModifiedDescription)),
 			),
 			Handler: wrap(func(t *ThingsMCP, ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 				return t.handleDeleteChecklistItem(ctx, req)
@@ -3882,6 +4376,7 @@ func defineTools(um *UserManager) []server.ServerTool {
 		{
 			Tool: mcp.NewTool("things_debug_raw",
 				mcp.WithDescription("Show all raw wire-format fields of a task or project. Returns every field using Things Cloud's abbreviated keys (tt, tp, ss, st, sr, tir, dd, sp, cd, md, sb, ix, ti, do, tr, ar, pr, agr, tg, rt, dl, nt, ato). Useful for debugging sync issues or inspecting internal state. Accepts a UUID prefix."),
+				outputSchema[map[string]any](),
 				mcp.WithReadOnlyHintAnnotation(true),
 				mcp.WithDestructiveHintAnnotation(false),
 				mcp.WithIdempotentHintAnnotation(true),
@@ -3897,6 +4392,7 @@ func defineTools(um *UserManager) []server.ServerTool {
 		{
 			Tool: mcp.NewTool("things_diagnose",
 				mcp.WithDescription("Run a full diagnostic of the Things Cloud sync pipeline. Tests credentials, fetches history, paginates through all items, rebuilds state, checks data integrity, and runs query tests. Returns a detailed step-by-step report with logs, timing, and any warnings or errors. Use this to debug sync issues like missing or stale tasks."),
+				outputSchema[diagResponse](),
 				mcp.WithReadOnlyHintAnnotation(true),
 				mcp.WithDestructiveHintAnnotation(false),
 				mcp.WithIdempotentHintAnnotation(true),
@@ -3905,19 +4401,15 @@ func defineTools(um *UserManager) []server.ServerTool {
 			Handler: func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 				email, password, err := extractCredentials(ctx, um)
 				if err != nil {
-					return errResult(err.Error()), nil
+					return errResultFrom(err), nil
 				}
 				t, err := getUserFromContext(ctx, um)
 				if err != nil {
-					return errResult(err.Error()), nil
+					return errResultFrom(err), nil
 				}
-				report := t.handleDiagnose(email, password)
+				report := t.handleDiagnose(ctx, email, password)
 
 				// Store report and generate shareable URL
-				type diagResponse struct {
-					*diagReport
-					ShareURL string `json:"shareUrl,omitempty"`
-				}
 				resp := diagResponse{diagReport: report}
 				if um.diagStore != nil {
 					if token, storeErr := um.diagStore.Store(email, report); storeErr == nil {
@@ -3929,7 +4421,112 @@ func defineTools(um *UserManager) []server.ServerTool {
 				return jsonResult(resp), nil
 			},
 		},
-	}
+
+		// --- Calendar feed tools ---
+		{
+			Tool: mcp.NewTool("things_create_calendar_feed",
+				mcp.WithDescription("Create a secret iCalendar (.ics) feed URL for subscribing to Things in a calendar app. Scheduled dates and reminders appear as events, deadlines as to-dos (or all-day events with deadlines_as_events), and repeating tasks as recurring events. Anyone with the URL can read the matching tasks, and the server keeps your Things credentials to serve it."),
+				outputSchema[calendarFeedOutput](),
+				mcp.WithDestructiveHintAnnotation(false),
+				mcp.WithIdempotentHintAnnotation(false),
+				mcp.WithOpenWorldHintAnnotation(false),
+				mcp.WithString("area", mcp.Description("Only include tasks in this area (UUID or name, comma-separated for several)")),
+				mcp.WithString("tag", mcp.Description("Only include tasks with this tag (UUID or name, comma-separated for several)")),
+				mcp.WithBoolean("deadlines_as_events", mcp.Description("Render deadlines as all-day events for calendars that ignore to-dos, such as Google Calendar")),
+			),
+			Handler: um.handleCreateCalendarFeed,
+		},
+		{
+			Tool: mcp.NewTool("things_revoke_calendar_feed",
+				mcp.WithDescription("Revoke a calendar feed URL created with things_create_calendar_feed, or all of your feeds."),
+				outputSchema[feedsRevokedOutput](),
+				mcp.WithDestructiveHintAnnotation(true),
+				mcp.WithIdempotentHintAnnotation(true),
+				mcp.WithOpenWorldHintAnnotation(false),
+				mcp.WithString("feed", mcp.Description("Feed URL or token to revoke")),
+				mcp.WithBoolean("all", mcp.Description("Revoke every feed for your account")),
+			),
+			Handler: um.handleRevokeCalendarFeed,
+		},
+
+		// --- Webhook tools ---
+		{
+			Tool: mcp.NewTool("things_create_webhook",
+				mcp.WithDescription("Subscribe an HTTPS URL to changes in Things, such as tasks being created or completed. Changes are detected on each sync (at least once a minute) and POSTed as JSON signed with HMAC-SHA256 in the X-Things-Signature-256 header. Failed deliveries are retried with exponential backoff. Returns the webhook including its signing secret, which is shown only once."),
+				outputSchema[webhookCreatedOutput](),
+				mcp.WithDestructiveHintAnnotation(false),
+				mcp.WithIdempotentHintAnnotation(false),
+				mcp.WithOpenWorldHintAnnotation(true),
+				mcp.WithString("url", mcp.Required(), mcp.Description("https:// endpoint to deliver events to")),
+				mcp.WithString("events", mcp.Description("Comma-separated change types to deliver, e.g. TaskCompleted,TaskCreated,ProjectCompleted,TaskMovedToToday. Omit for all.")),
+			),
+			Handler: um.handleCreateWebhook,
+		},
+		{
+			Tool: mcp.NewTool("things_list_webhooks",
+				mcp.WithDescription("List your webhook subscriptions."),
+				outputSchema[listOutput[webhook]](),
+				mcp.WithReadOnlyHintAnnotation(true),
+				mcp.WithDestructiveHintAnnotation(false),
+				mcp.WithIdempotentHintAnnotation(true),
+				mcp.WithOpenWorldHintAnnotation(false),
+			),
+			Handler: um.handleListWebhooks,
+		},
+		{
+			Tool: mcp.NewTool("things_delete_webhook",
+				mcp.WithDescription("Delete a webhook subscription."),
+				outputSchema[webhookDeletedOutput](),
+				mcp.WithDestructiveHintAnnotation(true),
+				mcp.WithIdempotentHintAnnotation(true),
+				mcp.WithOpenWorldHintAnnotation(false),
+				mcp.WithString("id", mcp.Required(), mcp.Description("Webhook ID from things_list_webhooks")),
+			),
+			Handler: um.handleDeleteWebhook,
+		},
+		{
+			Tool: mcp.NewTool("things_webhook_deliveries",
+				mcp.WithDescription("Show recent webhook delivery attempts, newest first, with HTTP status and error. Use this to find out why an automation did not fire. Attempts are kept for 7 days."),
+				outputSchema[listOutput[webhookDelivery]](),
+				mcp.WithReadOnlyHintAnnotation(true),
+				mcp.WithDestructiveHintAnnotation(false),
+				mcp.WithIdempotentHintAnnotation(true),
+				mcp.WithOpenWorldHintAnnotation(false),
+				mcp.WithString("webhook_id", mcp.Description("Only show attempts for this webhook")),
+				mcp.WithBoolean("failed_only", mcp.Description("Only show failed attempts")),
+				mcp.WithNumber("limit", mcp.Description("Maximum attempts to return (default 50, max 500)")),
+			),
+			Handler: um.handleWebhookDeliveries,
+		},
+
+		// --- Audit log ---
+		{
+			Tool: mcp.NewTool("things_audit_log",
+				mcp.WithDescription("List writes made to your Things account through this MCP server, newest first: when, which OAuth client, which tool with what arguments, the items it touched, the server head index after the commit, latency and any error. Use it to tell whether a change came from the AI or from a person (changes made in the Things apps are not in this log). Also returns a link to the same log as a web page, valid for one hour."),
+				outputSchema[auditLogOutput](),
+				mcp.WithReadOnlyHintAnnotation(true),
+				mcp.WithDestructiveHintAnnotation(false),
+				mcp.WithIdempotentHintAnnotation(true),
+				mcp.WithOpenWorldHintAnnotation(false),
+				mcp.WithString("from", mcp.Description("Only entries on or after this day/time (YYYY-MM-DD or RFC3339)")),
+				mcp.WithString("to", mcp.Description("Only entries before this time, or on or before this day (YYYY-MM-DD or RFC3339)")),
+				mcp.WithString("tool", mcp.Description("Only entries for this tool, e.g. things_edit_item")),
+				mcp.WithString("item", mcp.Description("Only entries that touched or referenced this item UUID")),
+				mcp.WithNumber("limit", mcp.Description("Maximum entries to return (1-500). Default: 50")),
+				mcp.WithBoolean("include_payload", mcp.Description("Include the wire payload that was committed. Default: false")),
+			),
+			Handler: um.handleAuditLog,
+		},
+		{
+			Tool: mcp.NewTool("things_confirmation_settings",
+				mcp.WithDescription("Show when destructive calls (deleting areas, tags and checklist items, trashing or canceling items) need confirmation. Such a call first returns an impact summary and a confirm_token, and only runs when repeated with the token. The threshold is the number of affected items at which this starts, counting items that lose their area, tag or project along the way; 0 means confirmation is off. Only the account owner can change it, on the server's /apps page."),
+				outputSchema[confirmationSettingsOutput](),
+				mcp.WithReadOnlyHintAnnotation(true),
+				mcp.WithOpenWorldHintAnnotation(false),
+			),
+			Handler: um.handleConfirmationSettings,
+		},
+	}))))))
 }
 
 // ---------------------------------------------------------------------------
@@ -3946,13 +4543,30 @@ func serveDiagReportPage(w http.ResponseWriter, reportJSON string) {
 // ---------------------------------------------------------------------------
 
 func main() {
-	log.SetFlags(log.Ltime | log.Lmsgprefix)
-	log.SetPrefix("[things-mcp] ")
+	// Any argument other than "serve" selects the command-line interface,
+	// which logs in text unless LOG_FORMAT says otherwise; the server logs
+	// JSON.
+	cli := len(os.Args) > 1 && os.Args[1] != "serve"
+	format := "json"
+	if cli {
+		format = "text"
+	}
+	if err := configureLogging(format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if cli {
+		os.Exit(runCLI(os.Args[1:], os.Stdout, os.Stderr))
+	}
+	serve()
+}
+
+// serve runs the MCP HTTP server until it exits.
+func serve() {
 	proxyURLs := parseProxyURLs(os.Getenv("PROXY_URLS"))
-	log.Printf("Loaded %d proxy URLs", len(proxyURLs))
+	logServer.Info("loaded proxy URLs", "count", len(proxyURLs))
 
 	um := NewUserManager()
-	um.proxyURLs = proxyURLs
 
 	// Initialize OAuth server with persistent state
 	dataDir := os.Getenv("DATA_DIR")
@@ -3961,9 +4575,60 @@ func main() {
 	}
 	oauth := NewOAuthServer(um, dataDir)
 	um.oauth = oauth
-	um.diagStore = &DiagStore{db: oauth.db}
+	um.diagStore = &DiagStore{store: oauth.store}
+	um.calendarFeeds = &CalendarFeedStore{db: oauth.db}
+	um.webhooks = NewWebhookStore(oauth.db)
+	um.audit = &AuditStore{db: oauth.db, retention: auditDefaultRetention}
+	um.confirm = NewConfirmStore(oauth.db)
+	um.proxies = newProxyPool(proxyURLs, oauth.db)
+	if v := os.Getenv("AUDIT_RETENTION"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			um.audit.retention = d
+		}
+	}
+	pollInterval := time.Minute
+	if v := os.Getenv("WEBHOOK_POLL_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			pollInterval = d
+		}
+	}
+	// SIGINT and SIGTERM start a graceful shutdown.
+	stop, stopped := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopped()
+	if pollInterval > 0 {
+		um.startPollingWebhooks(pollInterval)
+	}
+	if um.proxies != nil {
+		checkInterval := 30 * time.Second
+		if v := os.Getenv("PROXY_CHECK_INTERVAL"); v != "" {
+			if d, err := time.ParseDuration(v); err == nil {
+				checkInterval = d
+			}
+		}
+		um.proxies.addGauges(metrics)
+		if checkInterval > 0 {
+			go um.proxies.run(stop, checkInterval)
+		}
+	}
+	cl, err := clusterFromEnv(oauth.store, oauth.jwtSecret)
+	if err != nil {
+		fatal(logServer, "invalid cluster configuration", "error", err)
+	}
+	if cl != nil {
+		um.cluster = cl
+		cl.onChange = um.releaseAccounts
+		metrics.addGauge("things_mcp_cluster_members", "Live members of the cluster as seen by this instance.", nil, func(emit func(float64, ...string)) {
+			emit(float64(len(cl.liveMembers())))
+		})
+		logServer.Info("cluster mode", "self", cl.self, "peers", cl.peers)
+		go cl.run(stop)
+	}
+
+	tools, prompts := defineTools(um), definePrompts(um)
+	completions := newCompleter(um, tools, prompts)
 
 	hooks := &server.Hooks{}
+	hooks.AddAfterComplete(completions.labelCompletions)
 	hooks.AddAfterInitialize(func(ctx context.Context, id any, message *mcp.InitializeRequest, result *mcp.InitializeResult) {
 		result.ServerInfo.Icons = []mcp.Icon{
 			{Src: "https://thingscloudmcp.com/favicon.svg", MIMEType: "image/svg+xml"},
@@ -3974,6 +4639,11 @@ func main() {
 		"Things Cloud MCP",
 		"1.3.2",
 		server.WithToolCapabilities(false),
+		server.WithToolFilter(um.filterTools),
+		server.WithPromptCapabilities(false),
+		server.WithCompletions(),
+		server.WithPromptCompletionProvider(completions),
+		server.WithElicitation(),
 		server.WithHooks(hooks),
 		server.WithInstructions("Things Cloud MCP server for managing Things 3 tasks, projects, areas, and tags. "+
 			"Use list_tasks with filters (area, project, status, tag) to find tasks. "+
@@ -3984,7 +4654,8 @@ func main() {
 			"Use move_item to reorganize tasks between projects and areas. "+
 			"All changes sync to Things 3 apps (Mac, iPhone, iPad) in real-time via Things Cloud."),
 	)
-	mcpServer.AddTools(defineTools(um)...)
+	mcpServer.AddTools(tools...)
+	mcpServer.AddPrompts(prompts...)
 
 	streamServer := server.NewStreamableHTTPServer(mcpServer,
 		server.WithEndpointPath("/mcp"),
@@ -4015,7 +4686,9 @@ func main() {
 	})
 
 	// Wrap /mcp handler with 401 WWW-Authenticate for unauthenticated requests
-	mux.HandleFunc("/mcp", func(w http.ResponseWriter, r *http.Request) {
+	// In cluster mode, requests for accounts owned by another instance are
+	// forwarded to it.
+	mux.HandleFunc("/mcp", um.routeByAccount(um.mcpAccount, func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
 			base := getBaseURL(r)
@@ -4024,7 +4697,7 @@ func main() {
 			return
 		}
 		streamServer.ServeHTTP(w, r)
-	})
+	}))
 
 	// OAuth 2.1 routes (path-aware per RFC 9728: client appends resource path)
 	mux.HandleFunc("/.well-known/oauth-protected-resource", oauth.handleProtectedResourceMetadata)
@@ -4034,6 +4707,11 @@ func main() {
 	mux.HandleFunc("/register", oauth.handleRegister)
 	mux.HandleFunc("/authorize", oauth.handleAuthorize)
 	mux.HandleFunc("/token", oauth.handleToken)
+	mux.HandleFunc("/revoke", oauth.handleRevoke)
+	mux.HandleFunc("/introspect", oauth.handleIntrospect)
+	mux.HandleFunc("/apps", oauth.handleAppsPage)
+	metrics.addUserGauges(um)
+	mux.HandleFunc("/metrics", metrics.handler(os.Getenv("METRICS_TOKEN")))
 
 	mux.HandleFunc("/docs", handleDocsPage)
 	mux.HandleFunc("/how-it-works", handleHowItWorksPage)
@@ -4071,20 +4749,32 @@ func main() {
 		}
 		serveDiagReportPage(w, reportJSON)
 	})
+	mux.HandleFunc("/ical/", um.routeByAccount(um.calendarAccount, um.handleCalendarFeed))
+	mux.HandleFunc("/audit", um.handleAuditPage)
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/readyz", um.handleReadyz)
 
 	httpServer := &http.Server{
 		Addr:              addr,
-		Handler:           mux,
+		Handler:           requestIDs(mux),
 		ReadHeaderTimeout: 30 * time.Second,
 		WriteTimeout:      5 * time.Minute,
 		IdleTimeout:       120 * time.Second,
 	}
 
-	log.Printf("Things Cloud MCP server listening on %s", addr)
-	log.Printf("  Landing page: http://localhost%s/", addr)
-	log.Printf("  MCP endpoint: http://localhost%s/mcp", addr)
-	log.Printf("  OAuth metadata: http://localhost%s/.well-known/oauth-authorization-server", addr)
-	if err := httpServer.ListenAndServe(); err != nil {
-		log.Fatalf("Server error: %v", err)
+	logServer.Info("Things Cloud MCP server listening", "addr", addr,
+		"landing_page", "http://localhost"+addr+"/",
+		"mcp_endpoint", "http://localhost"+addr+"/mcp",
+		"oauth_metadata", "http://localhost"+addr+"/.well-known/oauth-authorization-server")
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- httpServer.ListenAndServe() }()
+	select {
+	case err := <-serveErr:
+		fatal(logServer, "server error", "error", err)
+	case <-stop.Done():
+	}
+	stopped()
+	if err := um.shutdown(httpServer, shutdownTimeout()); err != nil {
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	thingscloud "github.com/arthursoares/things-cloud-sdk"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// ---------------------------------------------------------------------------
+// Prometheus metrics
+// ---------------------------------------------------------------------------
+
+// metrics holds the server's process-wide metrics, exposed on /metrics in
+// the Prometheus text format.
+var metrics = newServerMetrics()
+
+type serverMetrics struct {
+	toolCalls       *counterVec
+	toolDuration    *histogramVec
+	syncDuration    *histogramVec
+	syncItems       *counterVec
+	syncErrors      *counterVec
+	cloudRequests   *counterVec
+	cloudDuration   *histogramVec
+	cloudRetries    *counterVec
+	cloudThrottled  *counterVec
+	oauthGrants     *counterVec
+	loginFailures   *counterVec
+	clusterForwards *counterVec
+
+	// Per proxy of PROXY_URLS; see proxy.go.
+	proxyRequests      *counterVec
+	proxyDuration      *histogramVec
+	proxyCheckDuration *histogramVec
+	proxyCheckFailures *counterVec
+	proxyFailovers     *counterVec
+
+	mu     sync.Mutex
+	gauges []gaugeFunc
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		toolCalls:       newCounterVec("things_mcp_tool_calls_total", "Tool calls by tool and outcome (ok, error, failed).", "tool", "outcome"),
+		toolDuration:    newHistogramVec("things_mcp_tool_call_duration_seconds", "Tool call latency.", defaultBuckets, "tool"),
+		syncDuration:    newHistogramVec("things_mcp_sync_duration_seconds", "Duration of syncs with Things Cloud by kind (incremental, full).", syncBuckets, "kind"),
+		syncItems:       newCounterVec("things_mcp_sync_items_total", "History items applied by syncs.", "kind"),
+		syncErrors:      newCounterVec("things_mcp_sync_errors_total", "Syncs that failed.", "kind"),
+		cloudRequests:   newCounterVec("things_cloud_requests_total", "Requests to Things Cloud by method and status code (error when no response).", "method", "status"),
+		cloudDuration:   newHistogramVec("things_cloud_request_duration_seconds", "Latency of requests to Things Cloud.", defaultBuckets, "method"),
+		cloudRetries:    newCounterVec("things_cloud_retries_total", "Requests to Things Cloud that were retried.", "method"),
+		cloudThrottled:  newCounterVec("things_cloud_throttled_total", "429 responses from Things Cloud.", "abuse_prevention"),
+		oauthGrants:     newCounterVec("things_mcp_oauth_grants_total", "OAuth tokens issued by grant type.", "grant_type"),
+		loginFailures:   newCounterVec("things_mcp_login_failures_total", "Rejected Things Cloud credentials by where they were entered (authorize, apps, session).", "source"),
+		clusterForwards: newCounterVec("things_mcp_cluster_forwards_total", "Requests forwarded to the cluster member owning the account, by outcome (ok, error).", "outcome"),
+
+		proxyRequests:      newCounterVec("things_cloud_proxy_requests_total", "Requests to Things Cloud by proxy and outcome (ok, error when no response).", "proxy", "outcome"),
+		proxyDuration:      newHistogramVec("things_cloud_proxy_request_duration_seconds", "Latency of requests to Things Cloud by proxy.", defaultBuckets, "proxy"),
+		proxyCheckDuration: newHistogramVec("things_cloud_proxy_check_duration_seconds", "Latency of proxy health checks.", defaultBuckets, "proxy"),
+		proxyCheckFailures: newCounterVec("things_cloud_proxy_check_failures_total", "Failed proxy health checks.", "proxy"),
+		proxyFailovers:     newCounterVec("things_cloud_proxy_failovers_total", "Accounts moved off a proxy that was down.", "proxy"),
+	}
+}
+
+var (
+	defaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	syncBuckets    = []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120}
+)
+
+// addGauge registers a gauge whose values are read at scrape time.
+func (m *serverMetrics) addGauge(name, help string, labels []string, collect func(emit func(value float64, labelValues ...string))) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gauges = append(m.gauges, gaugeFunc{name: name, help: help, labels: labels, collect: collect})
+}
+
+// observeSync records a sync of kind "incremental" or "full".
+func (m *serverMetrics) observeSync(kind string, start time.Time, items int, err error) {
+	m.syncDuration.Observe(time.Since(start).Seconds(), kind)
+	m.syncItems.Add(float64(items), kind)
+	if err != nil {
+		m.syncErrors.Inc(kind)
+	}
+}
+
+// cloudHooks feeds requests to Things Cloud into the metrics.
+func (m *serverMetrics) cloudHooks() thingscloud.Hooks {
+	return thingscloud.Hooks{
+		Request: func(e thingscloud.RequestEvent) {
+			status := "error"
+			if e.StatusCode != 0 {
+				status = strconv.Itoa(e.StatusCode)
+			}
+			m.cloudRequests.Inc(e.Method, status)
+			m.cloudDuration.Observe(e.Duration.Seconds(), e.Method)
+		},
+		Retry: func(e thingscloud.RetryEvent) {
+			m.cloudRetries.Inc(e.Method)
+		},
+		Throttled: func(e thingscloud.ThrottleEvent) {
+			m.cloudThrottled.Inc(strconv.FormatBool(e.AbusePrevention))
+		},
+	}
+}
+
+// instrumentTools counts, times and logs the calls of every tool.
+func instrumentTools(tools []server.ServerTool) []server.ServerTool {
+	for i := range tools {
+		name, next := tools[i].Tool.Name, tools[i].Handler
+		tools[i].Handler = func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			start := time.Now()
+			result, err := next(ctx, req)
+			outcome := "ok"
+			if err != nil {
+				outcome = "failed"
+			} else if result != nil && result.IsError {
+				outcome = "error"
+			}
+			duration := time.Since(start)
+			metrics.toolCalls.Inc(name, outcome)
+			metrics.toolDuration.Observe(duration.Seconds(), name)
+			args := []any{"tool", name, "outcome", outcome, "duration", duration}
+			if err != nil {
+				args = append(args, "error", err)
+			}
+			logTools.InfoContext(ctx, "tool call", args...)
+			return result, err
+		}
+	}
+	return tools
+}
+
+// addUserGauges reports the resident users and the size of their state.
+func (m *serverMetrics) addUserGauges(um *UserManager) {
+	m.addGauge("things_mcp_resident_users", "Users with state loaded in memory.", nil, func(emit func(float64, ...string)) {
+		um.mu.RLock()
+		defer um.mu.RUnlock()
+		emit(float64(len(um.users)))
+	})
+	m.addGauge("things_mcp_state_items", "Items held in memory across all users, by kind.", []string{"kind"}, func(emit func(float64, ...string)) {
+		um.mu.RLock()
+		users := make([]*ThingsMCP, 0, len(um.users))
+		for _, t := range um.users {
+			users = append(users, t)
+		}
+		um.mu.RUnlock()
+		var tasks, areas, tags, checklist int
+		for _, t := range users {
+			state := t.getState()
+			if state == nil {
+				continue
+			}
+			tasks += len(state.Tasks)
+			areas += len(state.Areas)
+			tags += len(state.Tags)
+			checklist += len(state.CheckListItems)
+		}
+		emit(float64(tasks), "tasks")
+		emit(float64(areas), "areas")
+		emit(float64(tags), "tags")
+		emit(float64(checklist), "checklist_items")
+	})
+}
+
+// handler serves the metrics. When token is set, requests must carry it as
+// a Bearer token.
+func (m *serverMetrics) handler(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if token != "" {
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		var b strings.Builder
+		for _, c := range []*counterVec{m.toolCalls, m.syncItems, m.syncErrors, m.cloudRequests, m.cloudRetries, m.cloudThrottled, m.oauthGrants, m.loginFailures, m.clusterForwards, m.proxyRequests, m.proxyCheckFailures, m.proxyFailovers} {
+			c.write(&b)
+		}
+		for _, h := range []*histogramVec{m.toolDuration, m.syncDuration, m.cloudDuration, m.proxyDuration, m.proxyCheckDuration} {
+			h.write(&b)
+		}
+		m.mu.Lock()
+		gauges := append([]gaugeFunc(nil), m.gauges...)
+		m.mu.Unlock()
+		for _, g := range gauges {
+			g.write(&b)
+		}
+		w.Write([]byte(b.String()))
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Metric types
+// ---------------------------------------------------------------------------
+
+// The metric types below implement just enough of the Prometheus data
+// model and text format for this server.
+
+type counterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64 // keyed by joined label values
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+func (c *counterVec) Inc(labelValues ...string) { c.Add(1, labelValues...) }
+
+func (c *counterVec) Add(v float64, labelValues ...string) {
+	c.mu.Lock()
+	c.values[strings.Join(labelValues, "\xff")] += v
+	c.mu.Unlock()
+}
+
+// Value returns the current count for the given label values.
+func (c *counterVec) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[strings.Join(labelValues, "\xff")]
+}
+
+func (c *counterVec) write(b *strings.Builder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(b, "%s%s %s\n", c.name, formatLabels(c.labels, key, ""), formatValue(c.values[key]))
+	}
+}
+
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogram)}
+}
+
+func (h *histogramVec) Observe(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *histogramVec) write(b *strings.Builder) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(b, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, formatValue(le)), cumulative)
+		}
+		fmt.Fprintf(b, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, "+Inf"), s.count)
+		fmt.Fprintf(b, "%s_sum%s %s\n", h.name, formatLabels(h.labels, key, ""), formatValue(s.sum))
+		fmt.Fprintf(b, "%s_count%s %d\n", h.name, formatLabels(h.labels, key, ""), s.count)
+	}
+}
+
+type gaugeFunc struct {
+	name, help string
+	labels     []string
+	collect    func(emit func(value float64, labelValues ...string))
+}
+
+func (g gaugeFunc) write(b *strings.Builder) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+	g.collect(func(v float64, labelValues ...string) {
+		fmt.Fprintf(b, "%s%s %s\n", g.name, formatLabels(g.labels, strings.Join(labelValues, "\xff"), ""), formatValue(v))
+	})
+}
+
+// formatLabels renders {a="x",b="y"} from label names and joined values,
+// adding le for histogram buckets.
+func formatLabels(names []string, key, le string) string {
+	var pairs []string
+	if len(names) > 0 {
+		for i, v := range strings.Split(key, "\xff") {
+			if i < len(names) {
+				pairs = append(pairs, names[i]+`="`+escapeLabel(v)+`"`)
+			}
+		}
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(v)
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	fc := newFakeCloud("metrics@example.com", makeTaskItem("t-1", withTitle("Measure")))
+	defer fc.Close()
+	tm, err := newThingsMCP(t.Context(), fc.server.URL, fc.email, "testpass", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	um := NewUserManager()
+	um.users[fc.email] = tm
+	m := newServerMetrics()
+	m.addUserGauges(um)
+
+	tools := toolsByName(um)
+	ctx := context.WithValue(context.Background(), userContextKey, &UserInfo{Email: fc.email, Password: "testpass"})
+	calls := metrics.toolCalls.Value("things_show_task", "error")
+	tools["things_show_task"].Handler(ctx, makeReq(map[string]any{"uuid": "missing"}))
+	if got := metrics.toolCalls.Value("things_show_task", "error"); got != calls+1 {
+		t.Errorf("tool errors: got %v, want %v", got, calls+1)
+	}
+	if metrics.cloudRequests.Value("GET", "200") == 0 || metrics.syncItems.Value("full") == 0 {
+		t.Error("Things Cloud requests and syncs were not counted")
+	}
+
+	h := metrics.handler("s3cret")
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("without token: got %d", rec.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec = httptest.NewRecorder()
+	h(rec, req)
+	for _, want := range []string{
+		"# TYPE things_mcp_tool_calls_total counter",
+		`things_mcp_tool_call_duration_seconds_bucket{tool="things_show_task",le="+Inf"}`,
+		`things_mcp_sync_duration_seconds_count{kind="full"}`,
+		`things_cloud_requests_total{method="GET",status="200"}`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	m.handler("")(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		"things_mcp_resident_users 1\n",
+		`things_mcp_state_items{kind="tasks"} 1` + "\n",
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("gauges missing %q:\n%s", want, rec.Body.String())
+		}
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	h := newHistogramVec("h", "test", []float64{1, 5}, "k")
+	for _, v := range []float64{0.5, 1, 3, 9} {
+		h.Observe(v, `a"b`)
+	}
+	var b strings.Builder
+	h.write(&b)
+	for _, want := range []string{
+		`h_bucket{k="a\"b",le="1"} 2`,
+		`h_bucket{k="a\"b",le="5"} 3`,
+		`h_bucket{k="a\"b",le="+Inf"} 4`,
+		`h_sum{k="a\"b"} 13.5`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("missing %q in\n%s", want, b.String())
+		}
+	}
+}
@@ -1,23 +1,22 @@
 package main
 
 import (
+	"bytes"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
-	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"net/url"
 	"os"
-	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
 	thingscloud "github.com/arthursoares/things-cloud-sdk"
-	_ "modernc.org/sqlite"
 )
 
 // ---------------------------------------------------------------------------
@@ -25,14 +24,15 @@ import (
 // ---------------------------------------------------------------------------
 
 type OAuthServer struct {
-	um            *UserManager
-	jwtSecret     []byte
-	clients       map[string]*OAuthClient    // client_id -> client
-	authCodes     map[string]*AuthCode        // code -> auth code data
-	refreshTokens map[string]*RefreshToken    // token -> refresh data
-	credentials   map[string]string           // email -> password (from successful authorizations)
-	db            *sql.DB                     // SQLite database
-	mu            sync.RWMutex
+	um         *UserManager
+	jwtSecret  []byte
+	store      Storage              // clients, codes, tokens, credentials and revocations
+	db         *sqlDB               // store's database, shared with the other stores
+	cipher     *credentialCipher    // encrypts stored passwords; nil stores plaintext
+	lastUsed   map[string]time.Time // email|client_id -> last use of its tokens here
+	usageSaved map[string]time.Time // email|client_id -> last time lastUsed was persisted
+	logins     loginThrottle        // sign-in attempts on /apps
+	mu         sync.RWMutex
 }
 
 type OAuthClient struct {
@@ -52,6 +52,8 @@ type AuthCode struct {
 	Email         string
 	Password      string
 	CodeChallenge string
+	Scope         string   // space-separated granted scopes
+	Areas         []string // area UUIDs the token is limited to, if any
 	ExpiresAt     time.Time
 	Used          bool
 }
@@ -61,129 +63,71 @@ type RefreshToken struct {
 	Email     string
 	Password  string
 	ClientID  string
+	Scope     string
+	Areas     []string
+	GrantID   string // shared by the tokens of one authorization, across refreshes
 	ExpiresAt time.Time
 }
 
-// NewOAuthServer creates a new OAuth server, loading persisted state from dataDir.
+// NewOAuthServer creates a new OAuth server on the storage openStorage
+// picks for dataDir. Its state lives in the storage alone, so servers
+// sharing it share sessions.
 // JWT secret priority: JWT_SECRET env var > DB > generate new.
+// Stored Things passwords are encrypted when CREDENTIALS_KEY or
+// CREDENTIALS_KEY_FILE is set; it refuses to start if any of them cannot be
+// decrypted with the configured keys.
 func NewOAuthServer(um *UserManager, dataDir string) *OAuthServer {
-	if err := os.MkdirAll(dataDir, 0700); err != nil {
-		log.Fatalf("Failed to create data directory %s: %v", dataDir, err)
+	store, err := openStorage(dataDir)
+	if err != nil {
+		fatal(logOAuth, "failed to open storage", "error", err)
 	}
 
-	dbPath := filepath.Join(dataDir, "oauth.db")
-	db, err := sql.Open("sqlite", dbPath+"?_pragma=journal_mode(WAL)")
+	cipher, err := loadCredentialCipher()
 	if err != nil {
-		log.Fatalf("Failed to open SQLite database: %v", err)
-	}
-
-	// Create tables
-	for _, ddl := range []string{
-		`CREATE TABLE IF NOT EXISTS kv (key TEXT PRIMARY KEY, value TEXT NOT NULL)`,
-		`CREATE TABLE IF NOT EXISTS clients (
-			client_id TEXT PRIMARY KEY, client_secret TEXT DEFAULT '',
-			client_name TEXT NOT NULL, redirect_uris TEXT NOT NULL,
-			grant_types TEXT NOT NULL, response_types TEXT NOT NULL,
-			created_at TEXT NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS refresh_tokens (
-			token TEXT PRIMARY KEY, email TEXT NOT NULL, password TEXT NOT NULL,
-			client_id TEXT NOT NULL, expires_at TEXT NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS credentials (email TEXT PRIMARY KEY, password TEXT NOT NULL)`,
-		`CREATE TABLE IF NOT EXISTS diagnoses (
-			token TEXT PRIMARY KEY, report_json TEXT NOT NULL,
-			email TEXT NOT NULL, created_at TEXT NOT NULL
-		)`,
-	} {
-		if _, err := db.Exec(ddl); err != nil {
-			log.Fatalf("Failed to create table: %v", err)
-		}
+		fatal(logOAuth, "failed to load credentials key", "error", err)
+	}
+	if cipher == nil {
+		logOAuth.Warn("CREDENTIALS_KEY is not set; Things passwords are stored unencrypted")
+	}
+	if n, err := migrateCredentials(store.DB(), cipher); err != nil {
+		fatal(logOAuth, "refusing to start: stored passwords cannot be decrypted with the configured keys. Set CREDENTIALS_KEY to the key they were encrypted with; when rotating, list the new key first and the old one after it.", "error", err)
+	} else if n > 0 {
+		logOAuth.Info("re-encrypted stored passwords", "count", n)
 	}
 
 	o := &OAuthServer{
-		um:            um,
-		db:            db,
-		clients:       make(map[string]*OAuthClient),
-		authCodes:     make(map[string]*AuthCode),
-		refreshTokens: make(map[string]*RefreshToken),
-		credentials:   make(map[string]string),
+		um:         um,
+		store:      store,
+		db:         store.DB(),
+		cipher:     cipher,
+		lastUsed:   make(map[string]time.Time),
+		usageSaved: make(map[string]time.Time),
 	}
 
-	// Load JWT secret: env > DB > generate
+	// Load JWT secret: env > DB > generate. A generated secret is only
+	// stored if no other server stored one first.
 	if envSecret := os.Getenv("JWT_SECRET"); envSecret != "" {
 		o.jwtSecret = []byte(envSecret)
+		store.Set("jwt_secret", base64.RawURLEncoding.EncodeToString(o.jwtSecret))
 	} else {
-		var dbSecret string
-		if err := db.QueryRow(`SELECT value FROM kv WHERE key='jwt_secret'`).Scan(&dbSecret); err == nil {
-			if secret, err := base64.RawURLEncoding.DecodeString(dbSecret); err == nil {
-				o.jwtSecret = secret
-			}
+		secret := make([]byte, 32)
+		rand.Read(secret)
+		stored, err := store.SetDefault("jwt_secret", base64.RawURLEncoding.EncodeToString(secret))
+		if err != nil {
+			fatal(logOAuth, "failed to load JWT secret", "error", err)
 		}
-	}
-	if len(o.jwtSecret) == 0 {
-		o.jwtSecret = make([]byte, 32)
-		rand.Read(o.jwtSecret)
-		log.Printf("Generated new JWT secret (will be persisted)")
-	}
-	db.Exec(`INSERT OR REPLACE INTO kv (key, value) VALUES ('jwt_secret', ?)`,
-		base64.RawURLEncoding.EncodeToString(o.jwtSecret))
-
-	// Load clients
-	rows, err := db.Query(`SELECT client_id, client_secret, client_name, redirect_uris, grant_types, response_types, created_at FROM clients`)
-	if err == nil {
-		defer rows.Close()
-		for rows.Next() {
-			var c OAuthClient
-			var redirectURIs, grantTypes, responseTypes, createdAt string
-			if err := rows.Scan(&c.ClientID, &c.ClientSecret, &c.ClientName, &redirectURIs, &grantTypes, &responseTypes, &createdAt); err != nil {
-				continue
-			}
-			json.Unmarshal([]byte(redirectURIs), &c.RedirectURIs)
-			json.Unmarshal([]byte(grantTypes), &c.GrantTypes)
-			json.Unmarshal([]byte(responseTypes), &c.ResponseTypes)
-			c.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
-			o.clients[c.ClientID] = &c
+		if o.jwtSecret, err = base64.RawURLEncoding.DecodeString(stored); err != nil || len(o.jwtSecret) == 0 {
+			o.jwtSecret = secret
+			store.Set("jwt_secret", base64.RawURLEncoding.EncodeToString(secret))
 		}
-	}
-
-	// Load refresh tokens (skip expired)
-	now := time.Now()
-	rows2, err := db.Query(`SELECT token, email, password, client_id, expires_at FROM refresh_tokens`)
-	if err == nil {
-		defer rows2.Close()
-		for rows2.Next() {
-			var rt RefreshToken
-			var expiresAt string
-			if err := rows2.Scan(&rt.Token, &rt.Email, &rt.Password, &rt.ClientID, &expiresAt); err != nil {
-				continue
-			}
-			rt.ExpiresAt, _ = time.Parse(time.RFC3339, expiresAt)
-			if now.After(rt.ExpiresAt) {
-				continue
-			}
-			o.refreshTokens[rt.Token] = &rt
+		if bytes.Equal(o.jwtSecret, secret) {
+			logOAuth.Info("generated new JWT secret (persisted)")
 		}
 	}
-	// Clean expired from DB
-	db.Exec(`DELETE FROM refresh_tokens WHERE expires_at < ?`, now.Format(time.RFC3339))
-
-	// Load credentials
-	rows3, err := db.Query(`SELECT email, password FROM credentials`)
-	if err == nil {
-		defer rows3.Close()
-		for rows3.Next() {
-			var email, password string
-			if err := rows3.Scan(&email, &password); err != nil {
-				continue
-			}
-			o.credentials[email] = password
-		}
-	}
-
-	log.Printf("Loaded OAuth state: %d clients, %d refresh tokens, %d users",
-		len(o.clients), len(o.refreshTokens), len(o.credentials))
 
+	if err := store.Expire(time.Now()); err != nil {
+		logOAuth.Warn("failed to delete expired OAuth state", "error", err)
+	}
 	return o
 }
 
@@ -282,6 +226,9 @@ func (o *OAuthServer) parseJWT(tokenStr string) (map[string]any, error) {
 			return nil, fmt.Errorf("JWT expired")
 		}
 	}
+	if o.isRevoked(claims) {
+		return nil, fmt.Errorf("JWT revoked")
+	}
 
 	return claims, nil
 }
@@ -298,16 +245,77 @@ func (o *OAuthServer) ResolveBearer(token string) (string, string, error) {
 		return "", "", fmt.Errorf("invalid token: missing subject")
 	}
 
-	o.mu.RLock()
-	password, ok := o.credentials[email]
-	o.mu.RUnlock()
+	password, ok := o.storedPassword(email)
 	if !ok {
 		return "", "", fmt.Errorf("no credentials found for user")
 	}
+	clientID, _ := claims["client_id"].(string)
+	o.touchClient(email, clientID)
 
 	return email, password, nil
 }
 
+// rememberCredentials stores a user's Things password so that requests
+// carrying no Authorization header (calendar feeds) can act on their behalf.
+func (o *OAuthServer) rememberCredentials(email, password string) {
+	o.saveCredentials(email, password)
+}
+
+// saveCredentials persists a user's Things password, encrypted if a key is
+// configured.
+func (o *OAuthServer) saveCredentials(email, password string) {
+	if err := o.store.SaveCredentials(email, o.cipher.seal(email, password)); err != nil {
+		logOAuth.Error("failed to save credentials", "email", email, "error", err)
+	}
+}
+
+func (o *OAuthServer) storedPassword(email string) (string, bool) {
+	sealed, err := o.store.Credentials(email)
+	if err != nil {
+		if !errors.Is(err, errNotFound) {
+			logOAuth.Error("failed to load credentials", "email", email, "error", err)
+		}
+		return "", false
+	}
+	password, err := o.cipher.open(email, sealed)
+	if err != nil {
+		logOAuth.Error("cannot decrypt credentials", "email", email, "error", err)
+		return "", false
+	}
+	return password, true
+}
+
+// client returns a registered client, or nil.
+func (o *OAuthServer) client(clientID string) *OAuthClient {
+	c, err := o.store.Client(clientID)
+	if err != nil {
+		if !errors.Is(err, errNotFound) {
+			logOAuth.Error("failed to load client", "client_id", clientID, "error", err)
+		}
+		return nil
+	}
+	return c
+}
+
+// clientName returns the name of a registered client, or "".
+func (o *OAuthServer) clientName(clientID string) string {
+	if c := o.client(clientID); c != nil {
+		return c.ClientName
+	}
+	return ""
+}
+
+// bearerClient returns the OAuth client a Bearer token was issued to.
+// Tokens issued before client_id was added to the claims return "".
+func (o *OAuthServer) bearerClient(token string) (clientID, clientName string) {
+	claims, err := o.parseJWT(token)
+	if err != nil {
+		return "", ""
+	}
+	clientID, _ = claims["client_id"].(string)
+	return clientID, o.clientName(clientID)
+}
+
 // ---------------------------------------------------------------------------
 // Discovery endpoints
 // ---------------------------------------------------------------------------
@@ -321,7 +329,7 @@ func (o *OAuthServer) handleProtectedResourceMetadata(w http.ResponseWriter, r *
 	writeJSON(w, http.StatusOK, map[string]any{
 		"resource":                base,
 		"authorization_servers":   []string{base},
-		"scopes_supported":       []string{"things:manage"},
+		"scopes_supported":         []string{scopeRead, scopeWrite, scopeDelete, scopeManage},
 		"bearer_methods_supported": []string{"header"},
 	})
 }
@@ -333,15 +341,19 @@ func (o *OAuthServer) handleAuthServerMetadata(w http.ResponseWriter, r *http.Re
 	}
 	base := getBaseURL(r)
 	writeJSON(w, http.StatusOK, map[string]any{
-		"issuer":                                base,
-		"authorization_endpoint":                base + "/authorize",
-		"token_endpoint":                        base + "/token",
-		"registration_endpoint":                 base + "/register",
-		"scopes_supported":                      []string{"things:manage"},
-		"response_types_supported":              []string{"code"},
-		"grant_types_supported":                 []string{"authorization_code", "refresh_token"},
-		"token_endpoint_auth_methods_supported": []string{"none", "client_secret_basic"},
-		"code_challenge_methods_supported":      []string{"S256"},
+		"issuer":                                        base,
+		"authorization_endpoint":                        base + "/authorize",
+		"token_endpoint":                                base + "/token",
+		"registration_endpoint":                         base + "/register",
+		"revocation_endpoint":                           base + "/revoke",
+		"introspection_endpoint":                        base + "/introspect",
+		"scopes_supported":                              []string{scopeRead, scopeWrite, scopeDelete, scopeManage},
+		"response_types_supported":                      []string{"code"},
+		"grant_types_supported":                         []string{"authorization_code", "refresh_token"},
+		"token_endpoint_auth_methods_supported":         []string{"none", "client_secret_basic"},
+		"revocation_endpoint_auth_methods_supported":    []string{"none", "client_secret_basic", "client_secret_post"},
+		"introspection_endpoint_auth_methods_supported": []string{"none", "client_secret_basic", "client_secret_post"},
+		"code_challenge_methods_supported":              []string{"S256"},
 	})
 }
 
@@ -392,19 +404,13 @@ func (o *OAuthServer) handleRegister(w http.ResponseWriter, r *http.Request) {
 		CreatedAt:     time.Now(),
 	}
 
-	o.mu.Lock()
-	o.clients[clientID] = client
-	o.mu.Unlock()
-
-	redirectURIsJSON, _ := json.Marshal(client.RedirectURIs)
-	grantTypesJSON, _ := json.Marshal(client.GrantTypes)
-	responseTypesJSON, _ := json.Marshal(client.ResponseTypes)
-	o.db.Exec(`INSERT INTO clients VALUES(?,?,?,?,?,?,?)`,
-		clientID, client.ClientSecret, client.ClientName,
-		string(redirectURIsJSON), string(grantTypesJSON), string(responseTypesJSON),
-		client.CreatedAt.Format(time.RFC3339))
+	if err := o.store.SaveClient(client); err != nil {
+		logOAuth.ErrorContext(r.Context(), "failed to save client", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "server_error", "failed to register client")
+		return
+	}
 
-	log.Printf("OAuth: registered client %q (id=%s)", req.ClientName, clientID)
+	logOAuth.InfoContext(r.Context(), "registered client", "client_name", req.ClientName, "client_id", clientID)
 
 	writeJSON(w, http.StatusCreated, map[string]any{
 		"client_id":      clientID,
@@ -452,11 +458,13 @@ func (o *OAuthServer) handleAuthorizeGet(w http.ResponseWriter, r *http.Request)
 		o.renderLoginPage(w, "", "PKCE required: code_challenge and code_challenge_method=S256.", q.Encode())
 		return
 	}
+	if _, err := parseScopes(q.Get("scope")); err != nil {
+		o.renderLoginPage(w, "", "Invalid scope: "+err.Error()+".", q.Encode())
+		return
+	}
 
-	o.mu.RLock()
-	client, ok := o.clients[clientID]
-	o.mu.RUnlock()
-	if !ok {
+	client := o.client(clientID)
+	if client == nil {
 		o.renderLoginPage(w, "", "Unknown client_id.", q.Encode())
 		return
 	}
@@ -494,35 +502,38 @@ func (o *OAuthServer) handleAuthorizePost(w http.ResponseWriter, r *http.Request
 	password := r.PostFormValue("password")
 
 	if email == "" || password == "" {
-		o.mu.RLock()
-		client := o.clients[clientID]
-		o.mu.RUnlock()
-		clientName := ""
-		if client != nil {
-			clientName = client.ClientName
-		}
-		o.renderLoginPage(w, clientName, "Email and password are required.", q.Encode())
+		o.renderLoginPage(w, o.clientName(clientID), "Email and password are required.", q.Encode())
 		return
 	}
 
 	// Verify Things Cloud credentials
-	var opts []thingscloud.ClientOption
-	if proxy := o.um.proxyForEmail(email); proxy != nil {
-		opts = append(opts, thingscloud.WithProxy(proxy))
-	}
-	c := thingscloud.New(thingscloud.APIEndpoint, email, password, opts...)
+	c := thingscloud.New(thingscloud.APIEndpoint, email, password, o.um.proxies.clientOptions(email)...)
 	if _, err := c.Verify(); err != nil {
-		o.mu.RLock()
-		client := o.clients[clientID]
-		o.mu.RUnlock()
-		clientName := ""
-		if client != nil {
-			clientName = client.ClientName
-		}
-		o.renderLoginPage(w, clientName, "Invalid Things Cloud credentials.", q.Encode())
+		metrics.loginFailures.Inc("authorize")
+		o.renderLoginPage(w, o.clientName(clientID), "Invalid Things Cloud credentials.", q.Encode())
 		return
 	}
 
+	scopes, err := parseScopes(q.Get("scope"))
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	// An area restriction is resolved against the account now, so the
+	// token carries stable UUIDs rather than names.
+	var areas []string
+	if list := strings.TrimSpace(r.PostFormValue("areas")); list != "" {
+		t, err := o.um.GetOrCreateUser(r.Context(), email, password)
+		if err == nil {
+			areas, err = resolveAreas(t.getState(), list)
+		}
+		if err != nil {
+			o.renderLoginPage(w, o.clientName(clientID), "Areas: "+err.Error()+".", q.Encode())
+			return
+		}
+	}
+
 	// Generate auth code
 	code := randomString(32)
 	authCode := &AuthCode{
@@ -532,18 +543,25 @@ func (o *OAuthServer) handleAuthorizePost(w http.ResponseWriter, r *http.Request
 		Email:         email,
 		Password:      password,
 		CodeChallenge: codeChallenge,
+		Scope:         strings.Join(scopes, " "),
+		Areas:         areas,
 		ExpiresAt:     time.Now().Add(10 * time.Minute),
 		Used:          false,
 	}
 
-	o.mu.Lock()
-	o.authCodes[code] = authCode
-	o.credentials[email] = password
-	o.mu.Unlock()
-
-	o.db.Exec(`INSERT OR REPLACE INTO credentials (email, password) VALUES (?, ?)`, email, password)
+	sealed := *authCode
+	sealed.Password = o.cipher.seal(email, password)
+	if err := o.store.SaveAuthCode(&sealed); err != nil {
+		logOAuth.ErrorContext(r.Context(), "failed to save auth code", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	o.saveCredentials(email, password)
+	if err := o.store.Expire(time.Now()); err != nil {
+		logOAuth.WarnContext(r.Context(), "failed to delete expired OAuth state", "error", err)
+	}
 
-	log.Printf("OAuth: auth code issued for %s (client=%s)", email, clientID)
+	logOAuth.InfoContext(r.Context(), "auth code issued", "email", email, "client_id", clientID)
 
 	// Redirect to client
 	sep := "?"
@@ -662,60 +680,63 @@ func (o *OAuthServer) handleAuthCodeGrant(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	o.mu.Lock()
-	ac, ok := o.authCodes[code]
-	if !ok {
-		o.mu.Unlock()
+	ac, err := o.store.AuthCode(code)
+	if errors.Is(err, errNotFound) {
 		writeJSONError(w, http.StatusBadRequest, "invalid_grant", "unknown authorization code")
 		return
 	}
+	if err != nil {
+		logOAuth.ErrorContext(r.Context(), "failed to load auth code", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "server_error", "failed to load authorization code")
+		return
+	}
 	if ac.Used {
-		o.mu.Unlock()
 		writeJSONError(w, http.StatusBadRequest, "invalid_grant", "authorization code already used")
 		return
 	}
 	if time.Now().After(ac.ExpiresAt) {
-		o.mu.Unlock()
 		writeJSONError(w, http.StatusBadRequest, "invalid_grant", "authorization code expired")
 		return
 	}
 	if ac.ClientID != clientID {
-		o.mu.Unlock()
 		writeJSONError(w, http.StatusBadRequest, "invalid_grant", "client_id mismatch")
 		return
 	}
 	if ac.RedirectURI != redirectURI {
-		o.mu.Unlock()
 		writeJSONError(w, http.StatusBadRequest, "invalid_grant", "redirect_uri mismatch")
 		return
 	}
 
 	// Verify PKCE
 	if !verifyPKCE(codeVerifier, ac.CodeChallenge) {
-		o.mu.Unlock()
 		writeJSONError(w, http.StatusBadRequest, "invalid_grant", "PKCE verification failed")
 		return
 	}
 
-	ac.Used = true
+	// Another server sharing the storage may redeem the code at the same
+	// time; only one of them marks it used.
+	if used, err := o.store.UseAuthCode(code); err != nil || !used {
+		writeJSONError(w, http.StatusBadRequest, "invalid_grant", "authorization code already used")
+		return
+	}
 	email := ac.Email
-	password := ac.Password
+	password, err := o.cipher.open(email, ac.Password)
+	if err != nil {
+		logOAuth.ErrorContext(r.Context(), "cannot decrypt auth code", "email", email, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "server_error", "failed to load authorization code")
+		return
+	}
+	scope, areas := ac.Scope, ac.Areas
 
 	// Store credentials for Bearer token resolution
-	o.credentials[email] = password
-	o.mu.Unlock()
-
-	o.db.Exec(`INSERT OR REPLACE INTO credentials (email, password) VALUES (?, ?)`, email, password)
+	o.saveCredentials(email, password)
 
 	// Generate tokens
 	base := getBaseURL(r)
-	accessToken, err := o.createJWT(map[string]any{
-		"sub":   email,
-		"iss":   base,
-		"exp":   time.Now().Add(1 * time.Hour).Unix(),
-		"iat":   time.Now().Unix(),
-		"scope": "things:manage",
-	})
+	grantID := randomString(16)
+	claims := accessClaims(base, email, clientID, scope, areas)
+	claims["gid"] = grantID
+	accessToken, err := o.createJWT(claims)
 	if err != nil {
 		writeJSONError(w, http.StatusInternalServerError, "server_error", "failed to create access token")
 		return
@@ -727,16 +748,20 @@ func (o *OAuthServer) handleAuthCodeGrant(w http.ResponseWriter, r *http.Request
 		Email:     email,
 		Password:  password,
 		ClientID:  clientID,
+		Scope:     scope,
+		Areas:     areas,
+		GrantID:   grantID,
 		ExpiresAt: time.Now().Add(30 * 24 * time.Hour), // 30 days
 	}
-	o.mu.Lock()
-	o.refreshTokens[refreshTok] = rt
-	o.mu.Unlock()
-
-	o.db.Exec(`INSERT INTO refresh_tokens VALUES(?,?,?,?,?)`,
-		rt.Token, rt.Email, rt.Password, rt.ClientID, rt.ExpiresAt.Format(time.RFC3339))
+	if err := o.saveRefreshToken(rt); err != nil {
+		logOAuth.ErrorContext(r.Context(), "failed to save refresh token", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "server_error", "failed to create refresh token")
+		return
+	}
 
-	log.Printf("OAuth: tokens issued for %s", email)
+	o.touchClient(email, clientID)
+	metrics.oauthGrants.Inc("authorization_code")
+	logOAuth.InfoContext(r.Context(), "tokens issued", "email", email, "client_id", clientID)
 
 	w.Header().Set("Cache-Control", "no-store")
 	writeJSON(w, http.StatusOK, map[string]any{
@@ -744,7 +769,7 @@ func (o *OAuthServer) handleAuthCodeGrant(w http.ResponseWriter, r *http.Request
 		"token_type":    "Bearer",
 		"expires_in":    3600,
 		"refresh_token": refreshTok,
-		"scope":         "things:manage",
+		"scope":         scope,
 	})
 }
 
@@ -755,44 +780,56 @@ func (o *OAuthServer) handleRefreshTokenGrant(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	o.mu.Lock()
-	rt, ok := o.refreshTokens[refreshTok]
-	if !ok {
-		o.mu.Unlock()
+	rt, err := o.store.RefreshToken(refreshTok)
+	if errors.Is(err, errNotFound) {
 		writeJSONError(w, http.StatusBadRequest, "invalid_grant", "unknown refresh token")
 		return
 	}
+	if err != nil {
+		logOAuth.ErrorContext(r.Context(), "failed to load refresh token", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "server_error", "failed to load refresh token")
+		return
+	}
 	if time.Now().After(rt.ExpiresAt) {
-		delete(o.refreshTokens, refreshTok)
-		o.mu.Unlock()
-		o.db.Exec(`DELETE FROM refresh_tokens WHERE token=?`, refreshTok)
+		o.store.DeleteRefreshToken(refreshTok)
 		writeJSONError(w, http.StatusBadRequest, "invalid_grant", "refresh token expired")
 		return
 	}
 
 	email := rt.Email
-	password := rt.Password
+	password, err := o.cipher.open(email, rt.Password)
+	if err != nil {
+		logOAuth.ErrorContext(r.Context(), "cannot decrypt refresh token", "email", email, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "server_error", "failed to load refresh token")
+		return
+	}
 	clientID := rt.ClientID
+	scope, areas := rt.Scope, rt.Areas
+	if scope == "" {
+		// Issued before scopes were recorded.
+		scope = strings.Join(allScopes, " ")
+	}
+	grantID := rt.GrantID
+	if grantID == "" {
+		// Issued before grants were recorded.
+		grantID = randomString(16)
+	}
 
-	// Delete old refresh token
-	delete(o.refreshTokens, refreshTok)
+	// Delete old refresh token. Of concurrent refreshes, on this server
+	// or another, only the one that deletes it goes on.
+	if deleted, err := o.store.DeleteRefreshToken(refreshTok); err != nil || !deleted {
+		writeJSONError(w, http.StatusBadRequest, "invalid_grant", "unknown refresh token")
+		return
+	}
 
 	// Store credentials
-	o.credentials[email] = password
-	o.mu.Unlock()
-
-	o.db.Exec(`DELETE FROM refresh_tokens WHERE token=?`, refreshTok)
-	o.db.Exec(`INSERT OR REPLACE INTO credentials (email, password) VALUES (?, ?)`, email, password)
+	o.saveCredentials(email, password)
 
 	// Generate new tokens
 	base := getBaseURL(r)
-	accessToken, err := o.createJWT(map[string]any{
-		"sub":   email,
-		"iss":   base,
-		"exp":   time.Now().Add(1 * time.Hour).Unix(),
-		"iat":   time.Now().Unix(),
-		"scope": "things:manage",
-	})
+	claims := accessClaims(base, email, clientID, scope, areas)
+	claims["gid"] = grantID
+	accessToken, err := o.createJWT(claims)
 	if err != nil {
 		writeJSONError(w, http.StatusInternalServerError, "server_error", "failed to create access token")
 		return
@@ -804,16 +841,20 @@ func (o *OAuthServer) handleRefreshTokenGrant(w http.ResponseWriter, r *http.Req
 		Email:     email,
 		Password:  password,
 		ClientID:  clientID,
+		Scope:     scope,
+		Areas:     areas,
+		GrantID:   grantID,
 		ExpiresAt: time.Now().Add(30 * 24 * time.Hour),
 	}
-	o.mu.Lock()
-	o.refreshTokens[newRefreshTok] = newRT
-	o.mu.Unlock()
-
-	o.db.Exec(`INSERT INTO refresh_tokens VALUES(?,?,?,?,?)`,
-		newRT.Token, newRT.Email, newRT.Password, newRT.ClientID, newRT.ExpiresAt.Format(time.RFC3339))
+	if err := o.saveRefreshToken(newRT); err != nil {
+		logOAuth.ErrorContext(r.Context(), "failed to save refresh token", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "server_error", "failed to create refresh token")
+		return
+	}
 
-	log.Printf("OAuth: tokens refreshed for %s", email)
+	o.touchClient(email, clientID)
+	metrics.oauthGrants.Inc("refresh_token")
+	logOAuth.InfoContext(r.Context(), "tokens refreshed", "email", email, "client_id", clientID)
 
 	w.Header().Set("Cache-Control", "no-store")
 	writeJSON(w, http.StatusOK, map[string]any{
@@ -821,10 +862,35 @@ func (o *OAuthServer) handleRefreshTokenGrant(w http.ResponseWriter, r *http.Req
 		"token_type":    "Bearer",
 		"expires_in":    3600,
 		"refresh_token": newRefreshTok,
-		"scope":         "things:manage",
+		"scope":         scope,
 	})
 }
 
+// accessClaims builds the claims of an access token. Tokens limited to
+// areas carry their UUIDs in an "areas" claim.
+func accessClaims(issuer, email, clientID, scope string, areas []string) map[string]any {
+	claims := map[string]any{
+		"sub":       email,
+		"iss":       issuer,
+		"exp":       time.Now().Add(accessTokenTTL).Unix(),
+		"iat":       time.Now().Unix(),
+		"jti":       randomString(16),
+		"scope":     scope,
+		"client_id": clientID,
+	}
+	if len(areas) > 0 {
+		claims["areas"] = areas
+	}
+	return claims
+}
+
+// saveRefreshToken stores rt with its password encrypted.
+func (o *OAuthServer) saveRefreshToken(rt *RefreshToken) error {
+	sealed := *rt
+	sealed.Password = o.cipher.seal(rt.Email, rt.Password)
+	return o.store.SaveRefreshToken(&sealed)
+}
+
 // ---------------------------------------------------------------------------
 // Login page HTML
 // ---------------------------------------------------------------------------
@@ -937,6 +1003,18 @@ var authorizePageHTML = `<!DOCTYPE html>
 .auth-btn:hover{
   background:var(--blue-hover);
 }
+.auth-scopes{
+  margin:0 0 20px;
+  padding-left:20px;
+  font-size:13px;
+  color:var(--text-secondary);
+  line-height:1.6;
+}
+.auth-hint{
+  font-size:12px;
+  color:var(--text-secondary);
+  margin-top:4px;
+}
 </style>
 </head>
 <body>
@@ -948,6 +1026,7 @@ var authorizePageHTML = `<!DOCTYPE html>
 </svg>` + `</div>
     <div class="auth-title">Sign in with Things Cloud</div>
     <div class="auth-subtitle">{{subtitle}}</div>
+    {{scopes}}
     {{error}}
     <form method="POST" action="/authorize?{{query}}">
       <div class="auth-field">
@@ -958,6 +1037,11 @@ var authorizePageHTML = `<!DOCTYPE html>
         <label for="password">Password</label>
         <input type="password" id="password" name="password" required autocomplete="current-password">
       </div>
+      <div class="auth-field">
+        <label for="areas">Limit to areas (optional)</label>
+        <input type="text" id="areas" name="areas" autocomplete="off">
+        <div class="auth-hint">Comma-separated area names. Leave empty to allow the whole account.</div>
+      </div>
       <button type="submit" class="auth-btn">Authorize</button>
     </form>
     <p style="margin-top:20px;font-size:11px;color:var(--text-secondary);text-align:center;line-height:1.5">Not affiliated with Cultured Code. Built through reverse engineering with <a href="https://github.com/arthursoares/things-cloud-sdk" target="_blank" rel="noopener" style="color:var(--blue);text-decoration:none">Things Cloud SDK</a>.</p>
@@ -977,11 +1061,23 @@ func (o *OAuthServer) renderLoginPage(w http.ResponseWriter, clientName, errMsg,
 		errorHTML = `<div class="auth-error">` + htmlEscape(errMsg) + `</div>`
 	}
 
-	html := strings.Replace(
-		strings.Replace(
-			strings.Replace(authorizePageHTML, "{{subtitle}}", subtitle, 1),
-			"{{error}}", errorHTML, 1),
-		"{{query}}", queryString, 1)
+	scopesHTML := ""
+	if q, err := url.ParseQuery(queryString); err == nil {
+		if scopes, err := parseScopes(q.Get("scope")); err == nil {
+			scopesHTML = `<ul class="auth-scopes">`
+			for _, sc := range scopes {
+				scopesHTML += "<li>" + htmlEscape(scopeDescriptions[sc]) + "</li>"
+			}
+			scopesHTML += "</ul>"
+		}
+	}
+
+	html := strings.NewReplacer(
+		"{{subtitle}}", subtitle,
+		"{{scopes}}", scopesHTML,
+		"{{error}}", errorHTML,
+		"{{query}}", queryString,
+	).Replace(authorizePageHTML)
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.Write([]byte(html))
 }
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+
+	thingscloud "github.com/arthursoares/things-cloud-sdk"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// ---------------------------------------------------------------------------
+// Structured output
+// ---------------------------------------------------------------------------
+
+// Every tool declares an output schema generated from its Go output type
+// and returns the value as structuredContent next to the JSON text. MCP
+// wants an object there, so lists are sent as {"items": [...]}; the text
+// keeps the bare list.
+
+// listOutput is the structured content of a tool that returns a list.
+type listOutput[T any] struct {
+	Items []T `json:"items"`
+}
+
+// structuredContent returns v as the structured content of a result.
+func structuredContent(v any) any {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8 || rv.Kind() == reflect.Array {
+		return map[string]any{"items": v}
+	}
+	return v
+}
+
+// outputSchema declares a tool's output schema, generated from T. Nil
+// slices encode as null, so arrays may be null too.
+func outputSchema[T any]() mcp.ToolOption {
+	return func(tool *mcp.Tool) {
+		mcp.WithOutputSchema[T]()(tool)
+		for _, prop := range tool.OutputSchema.Properties {
+			allowNullArrays(prop)
+		}
+	}
+}
+
+func allowNullArrays(schema any) {
+	m, ok := schema.(map[string]any)
+	if !ok {
+		return
+	}
+	if m["type"] == "array" {
+		m["type"] = []any{"array", "null"}
+		allowNullArrays(m["items"])
+	}
+	if props, ok := m["properties"].(map[string]any); ok {
+		for _, prop := range props {
+			allowNullArrays(prop)
+		}
+	}
+}
+
+// alsoReturns widens a tool's output schema for tools, or middlewares,
+// that may answer with a T instead, such as a dry run. The result then has
+// one of several shapes, so none of their fields is required.
+func alsoReturns[T any]() mcp.ToolOption {
+	return func(tool *mcp.Tool) {
+		var alt mcp.Tool
+		outputSchema[T]()(&alt)
+		if tool.OutputSchema.Properties == nil {
+			tool.OutputSchema.Type = "object"
+			tool.OutputSchema.Properties = map[string]any{}
+		}
+		for name, prop := range alt.OutputSchema.Properties {
+			if _, ok := tool.OutputSchema.Properties[name]; !ok {
+				tool.OutputSchema.Properties[name] = prop
+			}
+		}
+		tool.OutputSchema.Required = nil
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Tool errors
+// ---------------------------------------------------------------------------
+
+// toolError is the structured content of a failed call.
+type toolError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+// Error codes, the code of a toolError.
+const (
+	codeInvalidArgument = "invalid_argument"
+	codeNotFound        = "not_found"
+	codeUnauthenticated = "unauthenticated"
+	codeForbidden       = "forbidden"
+	codeConflict        = "conflict"
+	codeThrottled       = "throttled"
+	codeCancelled       = "cancelled"
+	codeNotConfirmed    = "not_confirmed"
+	codeUnavailable     = "unavailable"
+	codeThingsCloud     = "things_cloud_error"
+	codeInternal        = "internal"
+)
+
+// codedError is an error that carries its code from where it was made.
+type codedError struct {
+	code string
+	err  error
+}
+
+func (e *codedError) Error() string { return e.err.Error() }
+func (e *codedError) Unwrap() error { return e.err }
+
+// withCode attaches a code to err, so wrapping it later keeps the code.
+func withCode(code string, err error) error {
+	return &codedError{code: code, err: err}
+}
+
+// errorCodeOf classifies err by the errors in its chain. An error none of
+// them explains is taken to be the caller's.
+func errorCodeOf(err error) string {
+	var coded *codedError
+	var throttled *thingscloud.ThrottledError
+	var conflict *thingscloud.CommitConflictError
+	var apiErr *thingscloud.APIError
+	var urlErr *url.Error
+	switch {
+	case errors.As(err, &coded):
+		return coded.code
+	case errors.Is(err, thingscloud.ErrUnauthorized):
+		return codeUnauthenticated
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return codeCancelled
+	case errors.As(err, &throttled):
+		return codeThrottled
+	case errors.As(err, &conflict):
+		return codeConflict
+	case errors.As(err, &apiErr):
+		switch {
+		case apiErr.StatusCode == http.StatusUnauthorized, apiErr.StatusCode == http.StatusForbidden:
+			return codeUnauthenticated // a wrong Things password
+		case apiErr.StatusCode == http.StatusTooManyRequests:
+			return codeThrottled
+		}
+		return codeThingsCloud
+	case errors.As(err, &urlErr):
+		return codeThingsCloud
+	}
+	return codeInvalidArgument
+}
+
+// cloudFailure codes err, returned while talking to Things Cloud, as a
+// Things Cloud failure unless its chain already says what went wrong.
+func cloudFailure(err error) error {
+	if err == nil || errorCodeOf(err) != codeInvalidArgument {
+		return err
+	}
+	return withCode(codeThingsCloud, err)
+}
+
+// errorResult returns a failed call with msg as its text.
+func errorResult(code, msg string, details any) *mcp.CallToolResult {
+	result := mcp.NewToolResultError(msg)
+	result.StructuredContent = toolError{Code: code, Message: msg, Details: details}
+	return result
+}
+
+// errResultCode returns a failed call whose code is known where it fails.
+func errResultCode(code, msg string) *mcp.CallToolResult {
+	return errorResult(code, msg, nil)
+}
+
+// errResultFrom returns a failed call for err, coded by errorCodeOf.
+func errResultFrom(err error) *mcp.CallToolResult {
+	return errorResult(errorCodeOf(err), err.Error(), nil)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	thingscloud "github.com/arthursoares/things-cloud-sdk"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// checkSchema reports where value does not match schema. Beyond the JSON
+// Schema rules it checks, every field must be declared, so output types and
+// schemas cannot drift apart.
+func checkSchema(path string, schema, value any) []string {
+	s, ok := schema.(map[string]any)
+	if !ok {
+		return nil // true: anything goes
+	}
+	types := map[string]bool{}
+	switch tp := s["type"].(type) {
+	case string:
+		types[tp] = true
+	case []any:
+		for _, x := range tp {
+			types[x.(string)] = true
+		}
+	}
+	var kind string
+	switch value.(type) {
+	case nil:
+		kind = "null"
+	case bool:
+		kind = "boolean"
+	case float64:
+		kind = "number"
+		if types["integer"] && value.(float64) == float64(int64(value.(float64))) {
+			kind = "integer"
+		}
+	case string:
+		kind = "string"
+	case []any:
+		kind = "array"
+	case map[string]any:
+		kind = "object"
+	}
+	if len(types) > 0 && !types[kind] {
+		return []string{fmt.Sprintf("%s: %s, want %v", path, kind, s["type"])}
+	}
+	var problems []string
+	switch v := value.(type) {
+	case []any:
+		for i, item := range v {
+			problems = append(problems, checkSchema(fmt.Sprintf("%s[%d]", path, i), s["items"], item)...)
+		}
+	case map[string]any:
+		props, _ := s["properties"].(map[string]any)
+		required, _ := s["required"].([]any)
+		for _, name := range required {
+			if _, ok := v[name.(string)]; !ok {
+				problems = append(problems, fmt.Sprintf("%s.%s: missing", path, name))
+			}
+		}
+		if len(props) == 0 {
+			break // a free-form object
+		}
+		for name, field := range v {
+			prop, ok := props[name]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s.%s: not in the schema", path, name))
+				continue
+			}
+			problems = append(problems, checkSchema(path+"."+name, prop, field)...)
+		}
+	}
+	return problems
+}
+
+func TestToolsDeclareOutputSchemas(t *testing.T) {
+	for _, st := range defineTools(NewUserManager()) {
+		if st.Tool.OutputSchema.Type != "object" || len(st.Tool.OutputSchema.Properties) == 0 && st.Tool.Name != "things_debug_raw" {
+			t.Errorf("%s: output schema %+v", st.Tool.Name, st.Tool.OutputSchema)
+		}
+	}
+}
+
+func TestStructuredContentMatchesSchemas(t *testing.T) {
+	_, _, tools, ctx := newTestToolServer(t, "structured@example.com",
+		makeAreaItem("area-1", "Work"),
+		makeTagItem("tag-1", "Errand"),
+		makeTaskItem("proj-1", withTitle("Launch"), withTaskType(thingscloud.TaskTypeProject), withArea("area-1")),
+		makeTaskItem("head-1", withTitle("Docs"), withTaskType(thingscloud.TaskTypeHeading), withParent("proj-1")),
+		makeTaskItem("task-1", withTitle("Write"), withParent("proj-1"), withActionGroup("head-1"), withTags("tag-1"),
+			withSchedule(1), withScheduledDate(time.Now())),
+		makeChecklistItem("cl-1", "task-1", "Outline"),
+	)
+	srv := server.NewMCPServer("test", "1")
+	for _, st := range tools {
+		srv.AddTool(st.Tool, st.Handler)
+	}
+	call := func(name string, args map[string]any) map[string]any {
+		t.Helper()
+		msg, _ := json.Marshal(map[string]any{
+			"jsonrpc": "2.0", "id": 1, "method": "tools/call",
+			"params": map[string]any{"name": name, "arguments": args},
+		})
+		raw, _ := json.Marshal(srv.HandleMessage(ctx, msg))
+		var resp struct {
+			Result map[string]any `json:"result"`
+		}
+		json.Unmarshal(raw, &resp)
+		return resp.Result
+	}
+
+	for _, c := range []struct {
+		tool string
+		args map[string]any
+	}{
+		{"things_find_tasks", map[string]any{}},
+		{"things_show_task", map[string]any{"uuid": "task-1"}},
+		{"things_show_project", map[string]any{"uuid": "proj-1"}},
+		{"things_find_projects", map[string]any{}},
+		{"things_find_headings", map[string]any{"project_uuid": "proj-1"}},
+		{"things_find_areas", map[string]any{}},
+		{"things_find_tags", map[string]any{}},
+		{"things_overview", map[string]any{}},
+		{"things_export", map[string]any{}},
+		{"things_export", map[string]any{"format": "markdown"}},
+		{"things_item_history", map[string]any{"uuid": "task-1"}},
+		{"things_changes_since", map[string]any{"since": "2020-01-01"}},
+		{"things_debug_raw", map[string]any{"uuid": "task-1"}},
+		{"things_create_task", map[string]any{"title": "Preview", "dry_run": true}},
+		{"things_create_task", map[string]any{"title": "New", "project_uuid": "proj-1"}},
+		{"things_edit_item", map[string]any{"uuid": "task-1", "title": "Rewrite"}},
+		{"things_add_checklist_item", map[string]any{"task_uuid": "task-1", "title": "Review"}},
+		{"things_import", map[string]any{"format": "markdown", "content": "- [ ] Imported", "dry_run": true}},
+	} {
+		result := call(c.tool, c.args)
+		if result["isError"] == true {
+			t.Errorf("%s %v: %v", c.tool, c.args, result["content"])
+			continue
+		}
+		structured, ok := result["structuredContent"]
+		if !ok {
+			t.Errorf("%s %v: no structured content", c.tool, c.args)
+			continue
+		}
+		var schema map[string]any
+		b, _ := json.Marshal(tools[c.tool].Tool.OutputSchema)
+		json.Unmarshal(b, &schema)
+		for _, p := range checkSchema(c.tool, schema, structured) {
+			t.Errorf("%v: %s", c.args, p)
+		}
+	}
+
+	// Lists keep the bare list as text.
+	result := call("things_find_areas", map[string]any{})
+	text := result["content"].([]any)[0].(map[string]any)["text"].(string)
+	var areas []AreaOutput
+	if err := json.Unmarshal([]byte(text), &areas); err != nil || len(areas) != 1 {
+		t.Errorf("areas text: %s", text)
+	}
+
+	// Errors carry a code and the message.
+	result = call("things_show_task", map[string]any{"uuid": "nope"})
+	if e, _ := result["structuredContent"].(map[string]any); result["isError"] != true || e["code"] != codeNotFound || e["message"] != "task not found: nope" {
+		t.Errorf("error result: %v", result)
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	fc := newFakeCloud("codes@example.com")
+	defer fc.Close()
+	tm := newTestThingsMCP(t, fc)
+	tm.throttledUntil.Store(time.Now().Add(time.Hour).Unix())
+	_, unknownArea := resolveAreas(tm.getState(), "Garden")
+	_, badToken := (&UserManager{}).grantFromContext(context.WithValue(context.Background(), userContextKey, &UserInfo{Token: "x"}))
+	unavailable, _ := (&UserManager{}).handleCreateWebhook(context.Background(), makeReq(nil))
+
+	// Codes come from where the error is made, never from its wording.
+	for _, c := range []struct {
+		name   string
+		result *mcp.CallToolResult
+		want   string
+	}{
+		{"bad argument", errResult("uuid is required"), codeInvalidArgument},
+		{"uncoded", errResultFrom(errors.New("task not found: abc")), codeInvalidArgument},
+		{"not found", errResultFrom(fmt.Errorf("edit task: %w", tm.validateTaskUUID("abc"))), codeNotFound},
+		{"unknown area", errResultFrom(unknownArea), codeNotFound},
+		{"bad token", errResultFrom(badToken), codeUnauthenticated},
+		{"unavailable", unavailable, codeUnavailable},
+		{"throttled", errResultFrom(fmt.Errorf("sync: %w", tm.cloudError(errors.New("503 Service Unavailable")))), codeThrottled},
+		{"cloud failure", errResultFrom(fmt.Errorf("sync: %w", cloudFailure(errors.New("unexpected EOF")))), codeThingsCloud},
+	} {
+		if e := c.result.StructuredContent.(toolError); e.Code != c.want {
+			t.Errorf("%s: %q got %s, want %s", c.name, e.Message, e.Code, c.want)
+		}
+	}
+}
+
+func TestErrorCodesFromErrors(t *testing.T) {
+	// Things Cloud's answers while loading an account.
+	status := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Count(r.URL.Path, "/") > 4 || !strings.HasPrefix(r.URL.Path, "/version/1/account/") {
+			w.WriteHeader(status["history"])
+			return
+		}
+		if code := status["account"]; code != http.StatusOK {
+			w.WriteHeader(code)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"status": "SYAccountStatusActive"})
+	}))
+	defer srv.Close()
+	load := func(account, history int) error {
+		status["account"], status["history"] = account, history
+		_, err := newThingsMCP(t.Context(), srv.URL, "codes@example.com", "wrong", nil)
+		if err == nil {
+			t.Fatal("loading the account should fail")
+		}
+		return err
+	}
+
+	for _, c := range []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"wrong password", load(http.StatusUnauthorized, http.StatusOK), codeUnauthenticated},
+		{"outage", load(http.StatusOK, http.StatusServiceUnavailable), codeThingsCloud},
+		{"abuse prevention", fmt.Errorf("login: %w", &thingscloud.ThrottledError{AbusePrevention: true, Until: time.Now().Add(time.Hour)}), codeThrottled},
+		{"rate limit", fmt.Errorf("sync: %w", &thingscloud.APIError{StatusCode: http.StatusTooManyRequests, Status: "429 Too Many Requests"}), codeThrottled},
+		{"commit conflict", fmt.Errorf("edit task: %w", &thingscloud.CommitConflictError{}), codeConflict},
+		{"cancelled", fmt.Errorf("create task: %w", context.Canceled), codeCancelled},
+		{"scope", fmt.Errorf("edit task: %w", tokenGrant{Scopes: []string{scopeRead}}.allows(mcp.NewTool("things_edit_item"))), codeForbidden},
+	} {
+		result := errResultFrom(c.err)
+		if e := result.StructuredContent.(toolError); e.Code != c.want || e.Message != c.err.Error() {
+			t.Errorf("%s: %q got %s, want %s", c.name, c.err, e.Code, c.want)
+		}
+	}
+}
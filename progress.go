@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// ---------------------------------------------------------------------------
+// Progress notifications
+// ---------------------------------------------------------------------------
+
+// progressReporter sends notifications/progress for a tool call whose
+// client passed a progress token. A nil reporter ignores reports, so
+// long operations report unconditionally.
+type progressReporter struct {
+	ctx   context.Context
+	srv   *server.MCPServer
+	token mcp.ProgressToken
+
+	mu   sync.Mutex
+	last float64
+}
+
+const progressContextKey contextKey = "progress"
+
+// progressTools gives every tool called with a progress token a reporter,
+// found with progressFrom.
+func progressTools(tools []server.ServerTool) []server.ServerTool {
+	for i := range tools {
+		next := tools[i].Handler
+		tools[i].Handler = func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return next(contextWithProgress(ctx, req), req)
+		}
+	}
+	return tools
+}
+
+func contextWithProgress(ctx context.Context, req mcp.CallToolRequest) context.Context {
+	srv := server.ServerFromContext(ctx)
+	if srv == nil || req.Params.Meta == nil || req.Params.Meta.ProgressToken == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, progressContextKey, &progressReporter{ctx: ctx, srv: srv, token: req.Params.Meta.ProgressToken, last: -1})
+}
+
+// progressFrom returns the reporter of the call ctx belongs to, or nil.
+func progressFrom(ctx context.Context) *progressReporter {
+	p, _ := ctx.Value(progressContextKey).(*progressReporter)
+	return p
+}
+
+// report sends progress out of total, or of an unknown total when total
+// is 0. Progress must increase, so reports that do not are dropped.
+func (p *progressReporter) report(progress, total float64, format string, args ...any) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if progress <= p.last {
+		return
+	}
+	p.last = progress
+	params := map[string]any{
+		"progressToken": p.token,
+		"progress":      progress,
+		"message":       fmt.Sprintf(format, args...),
+	}
+	if total > 0 {
+		params["total"] = total
+	}
+	if err := p.srv.SendNotificationToClient(p.ctx, "notifications/progress", params); err != nil {
+		logTools.DebugContext(p.ctx, "progress notification not sent", "error", err)
+	}
+}
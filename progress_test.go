@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// notifyingSession is a client session that keeps the notifications sent
+// to it.
+type notifyingSession struct {
+	notifications chan mcp.JSONRPCNotification
+}
+
+func (s *notifyingSession) SessionID() string { return "session-1" }
+func (s *notifyingSession) NotificationChannel() chan<- mcp.JSONRPCNotification {
+	return s.notifications
+}
+func (s *notifyingSession) Initialize()       {}
+func (s *notifyingSession) Initialized() bool { return true }
+
+func TestProgressOfFirstSync(t *testing.T) {
+	fc := newFakeCloud("progress@example.com",
+		makeTaskItem("t-1", withTitle("One")),
+		makeTaskItem("t-2", withTitle("Two")),
+	)
+	defer fc.Close()
+	um := NewUserManager()
+	tm := newTestThingsMCP(t, fc)
+	tm.state = nil // not loaded yet: the next read rebuilds it
+	um.users[fc.email] = tm
+	srv := server.NewMCPServer("test", "1")
+	srv.AddTools(defineTools(um)...)
+
+	session := &notifyingSession{notifications: make(chan mcp.JSONRPCNotification, 100)}
+	ctx := context.WithValue(context.Background(), userContextKey, &UserInfo{Email: fc.email, Password: "testpass"})
+	msg, _ := json.Marshal(map[string]any{
+		"jsonrpc": "2.0", "id": 1, "method": "tools/call",
+		"params": map[string]any{"name": "things_find_tasks", "arguments": map[string]any{}, "_meta": map[string]any{"progressToken": "tok-1"}},
+	})
+	if _, ok := srv.HandleMessage(srv.WithContext(ctx, session), msg).(mcp.JSONRPCResponse); !ok {
+		t.Fatal("no result")
+	}
+	close(session.notifications)
+
+	var messages []string
+	last := -1.0
+	for n := range session.notifications {
+		if n.Method != "notifications/progress" {
+			continue
+		}
+		params := n.Params.AdditionalFields
+		if params["progressToken"] != "tok-1" {
+			t.Errorf("progress token %v", params["progressToken"])
+		}
+		progress := params["progress"].(float64)
+		if progress <= last {
+			t.Errorf("progress %v after %v", progress, last)
+		}
+		last = progress
+		messages = append(messages, params["message"].(string))
+	}
+	if len(messages) != 2 || !strings.HasPrefix(messages[0], "Fetched page 1") || messages[1] != "Applied 2 of 2 items" {
+		t.Errorf("progress messages: %q", messages)
+	}
+
+	// Without a progress token nothing is sent.
+	session.notifications = make(chan mcp.JSONRPCNotification, 100)
+	tm.state = nil
+	msg, _ = json.Marshal(map[string]any{
+		"jsonrpc": "2.0", "id": 2, "method": "tools/call",
+		"params": map[string]any{"name": "things_find_tasks", "arguments": map[string]any{}},
+	})
+	srv.HandleMessage(srv.WithContext(ctx, session), msg)
+	if len(session.notifications) != 0 {
+		t.Errorf("%d notifications without a progress token", len(session.notifications))
+	}
+}
+
+func TestCancelledCalls(t *testing.T) {
+	fc := newFakeCloud("cancel@example.com", makeTaskItem("t-1", withTitle("Keep")))
+	defer fc.Close()
+	tm := newTestThingsMCP(t, fc)
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	// A cancelled full rebuild keeps the state and where it was loaded to.
+	state, loaded := tm.getState(), tm.history.LoadedServerIndex
+	end := tm.beginCall(ctx)
+	err := tm.fullRebuild()
+	end()
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("rebuild: %v", err)
+	}
+	if tm.getState() != state || tm.history.LoadedServerIndex != loaded {
+		t.Error("cancelled rebuild changed the state")
+	}
+
+	// A cancelled write sends nothing.
+	end = tm.beginCall(ctx)
+	result, _ := tm.handleCreateTask(ctx, makeReq(map[string]any{"title": "Never"}))
+	end()
+	assertIsError(t, result)
+	if len(fc.getCommitLog()) != 0 {
+		t.Error("cancelled call wrote")
+	}
+
+	// Commits that started are not cut short.
+	end = tm.beginCall(ctx)
+	if tm.requestContext().Err() != nil {
+		t.Error("request context is cancelled")
+	}
+	end()
+
+	report := tm.handleDiagnose(ctx, fc.email, "testpass")
+	if len(report.Steps) != 7 || report.Steps[0].Status != "skipped" || report.Summary.Skipped != 7 {
+		t.Errorf("cancelled diagnosis: %+v", report.Summary)
+	}
+}
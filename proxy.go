@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	thingscloud "github.com/arthursoares/things-cloud-sdk"
+)
+
+// ---------------------------------------------------------------------------
+// Proxy pool
+// ---------------------------------------------------------------------------
+
+// Each account's requests to Things Cloud go through one proxy of
+// PROXY_URLS, at first the one its email hashes to. The pool checks every
+// proxy periodically, and as soon as a request through one fails without
+// a response. Accounts on a proxy that fails its check move to the next
+// healthy one and stay there, also once the first recovers, so that an
+// account does not flap between proxies. Moves are stored in the database
+// so that they survive restarts and hold on every cluster member.
+
+const (
+	proxyCheckTimeout = 5 * time.Second
+	// proxyMaxFailures is how many checks in a row a proxy fails before
+	// its accounts move; one failure may be a blip.
+	proxyMaxFailures = 2
+)
+
+// proxySchemes are the proxy URL schemes net/http supports.
+var proxySchemes = map[string]bool{"http": true, "https": true, "socks5": true, "socks5h": true}
+
+type poolProxy struct {
+	url *url.URL
+	// name identifies the proxy in metrics, logs and the database,
+	// without its credentials.
+	name     string
+	healthy  atomic.Bool
+	failures atomic.Int32 // checks failed in a row
+	checking atomic.Bool
+	client   *http.Client
+}
+
+type proxyPool struct {
+	proxies []*poolProxy
+	db      *sqlDB // nil keeps moves in memory only
+	// target is the URL the checks request; any response counts as
+	// healthy.
+	target string
+
+	mu       sync.Mutex
+	assigned map[string]*poolProxy // by email; loaded from db on first use
+}
+
+// newProxyPool returns a pool of urls, or nil when there are none. All
+// proxies start healthy.
+func newProxyPool(urls []*url.URL, db *sqlDB) *proxyPool {
+	if len(urls) == 0 {
+		return nil
+	}
+	p := &proxyPool{db: db, target: thingscloud.APIEndpoint, assigned: map[string]*poolProxy{}}
+	for _, u := range urls {
+		pp := &poolProxy{
+			url:    u,
+			name:   u.Scheme + "://" + u.Host,
+			client: &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(u)}, Timeout: proxyCheckTimeout},
+		}
+		pp.healthy.Store(true)
+		p.proxies = append(p.proxies, pp)
+	}
+	return p
+}
+
+// proxyFor returns the proxy email's requests go through: its stored
+// assignment, or the proxy its email hashes to, unless that one is down,
+// in which case the account moves to the next healthy proxy. When none is
+// healthy the account stays where it is.
+func (p *proxyPool) proxyFor(email string) *poolProxy {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	current, ok := p.assigned[email]
+	if !ok {
+		current = p.stored(email)
+		if current == nil {
+			current = p.proxies[int(fnv32(email)%uint32(len(p.proxies)))]
+		}
+		p.assigned[email] = current
+	}
+	if current.healthy.Load() {
+		return current
+	}
+	start := 0
+	for i, pp := range p.proxies {
+		if pp == current {
+			start = i
+		}
+	}
+	for i := 1; i < len(p.proxies); i++ {
+		next := p.proxies[(start+i)%len(p.proxies)]
+		if !next.healthy.Load() {
+			continue
+		}
+		p.assigned[email] = next
+		p.store(email, next)
+		metrics.proxyFailovers.Inc(current.name)
+		logServer.Warn("moved account to another proxy", "email", email, "from", current.name, "to", next.name)
+		return next
+	}
+	return current
+}
+
+// stored returns the proxy email was moved to, if it is still in the
+// pool.
+func (p *proxyPool) stored(email string) *poolProxy {
+	if p.db == nil {
+		return nil
+	}
+	var name string
+	err := p.db.QueryRow(`SELECT proxy FROM proxy_assignments WHERE email = ?`, email).Scan(&name)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logServer.Warn("load proxy assignment failed", "email", email, "error", err)
+		}
+		return nil
+	}
+	for _, pp := range p.proxies {
+		if pp.name == name {
+			return pp
+		}
+	}
+	return nil
+}
+
+func (p *proxyPool) store(email string, pp *poolProxy) {
+	if p.db == nil {
+		return
+	}
+	_, err := p.db.Exec(
+		`INSERT INTO proxy_assignments (email, proxy, assigned_at) VALUES (?, ?, ?)
+		ON CONFLICT (email) DO UPDATE SET proxy = excluded.proxy, assigned_at = excluded.assigned_at`,
+		email, pp.name, time.Now().Unix(),
+	)
+	if err != nil {
+		logServer.Warn("store proxy assignment failed", "email", email, "error", err)
+	}
+}
+
+// clientOptions routes email's requests through the pool, so that they
+// follow the account when it moves. It returns nil without a pool.
+func (p *proxyPool) clientOptions(email string) []thingscloud.ClientOption {
+	if p == nil {
+		return nil
+	}
+	return []thingscloud.ClientOption{thingscloud.WithProxyFunc(func(*http.Request) (*url.URL, error) {
+		return p.proxyFor(email).url, nil
+	})}
+}
+
+// observe records a request for email in its proxy's metrics. A request
+// that got no response has the proxy checked at once.
+func (p *proxyPool) observe(email string, e thingscloud.RequestEvent) {
+	if p == nil {
+		return
+	}
+	pp := p.proxyFor(email)
+	outcome := "ok"
+	if e.StatusCode == 0 {
+		outcome = "error"
+		go p.checkProxy(context.Background(), pp)
+	}
+	metrics.proxyRequests.Inc(pp.name, outcome)
+	metrics.proxyDuration.Observe(e.Duration.Seconds(), pp.name)
+}
+
+// check checks every proxy.
+func (p *proxyPool) check(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, pp := range p.proxies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.checkProxy(ctx, pp)
+		}()
+	}
+	wg.Wait()
+}
+
+// checkProxy requests the target through pp, unless a check of pp is
+// already running, and marks pp down after proxyMaxFailures failures in a
+// row, or up after a success.
+func (p *proxyPool) checkProxy(ctx context.Context, pp *poolProxy) {
+	if !pp.checking.CompareAndSwap(false, true) {
+		return
+	}
+	defer pp.checking.Store(false)
+	start := time.Now()
+	err := func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, p.target, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := pp.client.Do(req)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}()
+	metrics.proxyCheckDuration.Observe(time.Since(start).Seconds(), pp.name)
+	if err == nil {
+		pp.failures.Store(0)
+		if !pp.healthy.Swap(true) {
+			logServer.Info("proxy is healthy again", "proxy", pp.name)
+		}
+		return
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	metrics.proxyCheckFailures.Inc(pp.name)
+	if pp.failures.Add(1) >= proxyMaxFailures && pp.healthy.Swap(false) {
+		logServer.Warn("proxy is down", "proxy", pp.name, "error", err)
+	}
+}
+
+// run checks the proxies every interval until stop is done.
+func (p *proxyPool) run(stop context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop.Done():
+			return
+		case <-ticker.C:
+			p.check(stop)
+		}
+	}
+}
+
+// addGauges exposes which proxies are healthy and how many loaded
+// accounts each serves.
+func (p *proxyPool) addGauges(m *serverMetrics) {
+	m.addGauge("things_cloud_proxy_up", "Whether a proxy passed its last health checks.", []string{"proxy"}, func(emit func(float64, ...string)) {
+		for _, pp := range p.proxies {
+			up := 0.0
+			if pp.healthy.Load() {
+				up = 1
+			}
+			emit(up, pp.name)
+		}
+	})
+	m.addGauge("things_cloud_proxy_accounts", "Accounts assigned to a proxy since the server started.", []string{"proxy"}, func(emit func(float64, ...string)) {
+		counts := map[*poolProxy]int{}
+		p.mu.Lock()
+		for _, pp := range p.assigned {
+			counts[pp]++
+		}
+		p.mu.Unlock()
+		for _, pp := range p.proxies {
+			emit(float64(counts[pp]), pp.name)
+		}
+	})
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+)
+
+// forwardProxy is an HTTP proxy that counts the requests it forwards.
+func forwardProxy(t *testing.T) (*url.URL, *atomic.Int32) {
+	t.Helper()
+	var n atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n.Add(1)
+		r.RequestURI = ""
+		resp, err := http.DefaultTransport.RoundTrip(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		for k, v := range resp.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	t.Cleanup(s.Close)
+	u, _ := url.Parse(s.URL)
+	return u, &n
+}
+
+func TestProxyPoolFailover(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+	up, _ := forwardProxy(t)
+	down, _ := forwardProxy(t)
+	store, err := openSQLiteStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	urls := []*url.URL{up, down}
+	p := newProxyPool(urls, store.DB())
+	p.target = target.URL
+
+	// Accounts start on the proxy their email hashes to.
+	var email string
+	for i := 0; email == ""; i++ {
+		if e := fmt.Sprintf("user%d@example.com", i); fnv32(e)%2 == 1 {
+			email = e
+		}
+	}
+	if got := p.proxyFor(email); got.url != down {
+		t.Fatalf("starts on %s", got.name)
+	}
+
+	// One failed check is not enough to move accounts; two are.
+	proxyDown := p.proxies[1]
+	proxyDown.client.Transport = &http.Transport{Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: "127.0.0.1:1"})}
+	p.check(t.Context())
+	if !proxyDown.healthy.Load() {
+		t.Fatal("down after one failed check")
+	}
+	p.check(t.Context())
+	if proxyDown.healthy.Load() || !p.proxies[0].healthy.Load() {
+		t.Fatal("health checks did not mark the dead proxy down")
+	}
+	if got := p.proxyFor(email); got.url != up {
+		t.Fatalf("did not fail over: %s", got.name)
+	}
+	if got := metrics.proxyFailovers.Value(proxyDown.name); got == 0 {
+		t.Error("failover not counted")
+	}
+
+	// The move sticks once the proxy recovers, and across restarts.
+	proxyDown.client.Transport = &http.Transport{Proxy: http.ProxyURL(down)}
+	p.check(t.Context())
+	if !proxyDown.healthy.Load() {
+		t.Fatal("recovered proxy still down")
+	}
+	if got := p.proxyFor(email); got.url != up {
+		t.Errorf("moved back to %s", got.name)
+	}
+	if got := newProxyPool(urls, store.DB()).proxyFor(email); got.url != up {
+		t.Errorf("after restart on %s", got.name)
+	}
+
+	// With every proxy down, accounts stay where they are.
+	for _, pp := range p.proxies {
+		pp.healthy.Store(false)
+	}
+	if got := p.proxyFor(email); got.url != up {
+		t.Errorf("with all down on %s", got.name)
+	}
+
+	var nilPool *proxyPool
+	if nilPool.proxyFor(email) != nil || nilPool.clientOptions(email) != nil {
+		t.Error("nil pool routes through a proxy")
+	}
+}
+
+func TestProxyPoolRoutesThingsCloudRequests(t *testing.T) {
+	fc := newFakeCloud("proxied@example.com", makeTaskItem("t-1", withTitle("Through")))
+	defer fc.Close()
+	first, firstN := forwardProxy(t)
+	second, secondN := forwardProxy(t)
+	p := newProxyPool([]*url.URL{first, second}, nil)
+	p.target = fc.server.URL
+	tm, err := newThingsMCP(t.Context(), fc.server.URL, fc.email, "testpass", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	on, other, onN, otherN := p.proxies[0], p.proxies[1], firstN, secondN
+	if p.proxyFor(fc.email) != on {
+		on, other, onN, otherN = other, on, secondN, firstN
+	}
+	if onN.Load() == 0 || otherN.Load() != 0 {
+		t.Fatalf("requests through the assigned proxy: %d, the other: %d", onN.Load(), otherN.Load())
+	}
+	if metrics.proxyRequests.Value(on.name, "ok") == 0 {
+		t.Error("proxied requests not counted")
+	}
+
+	// A session in progress follows its account to the next proxy.
+	on.healthy.Store(false)
+	before := otherN.Load()
+	tm.incrementalSync()
+	if otherN.Load() == before {
+		t.Error("sync did not go through the new proxy")
+	}
+}
+
+func TestParseProxyURLs(t *testing.T) {
+	got := parseProxyURLs("http://a:3128, socks5://user:pw@b:1080,ftp://c:21,d:80,socks5h://e:1080")
+	var names []string
+	for _, u := range got {
+		names = append(names, u.Scheme+"://"+u.Host)
+	}
+	if len(names) != 3 || names[0] != "http://a:3128" || names[1] != "socks5://b:1080" || names[2] != "socks5h://e:1080" {
+		t.Errorf("parsed %v", names)
+	}
+	if got[1].User.Username() != "user" {
+		t.Error("credentials dropped")
+	}
+}
@@ -444,3 +444,59 @@ func TestOffsetToTime(t *testing.T) {
 		})
 	}
 }
+
+// ---------------------------------------------------------------------------
+// describeRecurrence
+// ---------------------------------------------------------------------------
+
+func TestDescribeRecurrence(t *testing.T) {
+	ref := time.Date(2025, 3, 15, 0, 0, 0, 0, time.UTC) // Saturday
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"daily", "daily"},
+		{"every 3 days", "every 3 days"},
+		{"weekly", "weekly:sat"},
+		{"weekly:mon,wed", "weekly:mon,wed"},
+		{"every 2 weeks", "every 2 weeks"},
+		{"monthly", "monthly"},
+		{"monthly:15", "monthly:15"},
+		{"monthly:last", "monthly:last"},
+		{"yearly", "yearly"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			rr, err := parseRecurrence(tt.in, ref)
+			if err != nil {
+				t.Fatalf("parseRecurrence: %v", err)
+			}
+			var rc thingscloud.RepeaterConfiguration
+			if err := json.Unmarshal(*rr, &rc); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if got := describeRecurrence(&rc); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("nth weekday of month", func(t *testing.T) {
+		wd, wdo := time.Tuesday, int64(2)
+		rc := thingscloud.RepeaterConfiguration{
+			FrequencyUnit:       thingscloud.FrequencyUnitMonthly,
+			FrequencyAmplitude:  1,
+			DetailConfiguration: []thingscloud.RepeaterDetailConfiguration{{Weekday: &wd, MonthOf: &wdo}},
+		}
+		if got := describeRecurrence(&rc); got != "monthly on the 2nd tue" {
+			t.Errorf("got %q", got)
+		}
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		if got := describeRecurrence(nil); got != "" {
+			t.Errorf("got %q, want empty", got)
+		}
+	})
+}
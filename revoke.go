@@ -0,0 +1,676 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	thingscloud "github.com/arthursoares/things-cloud-sdk"
+)
+
+// ---------------------------------------------------------------------------
+// Token revocation, introspection and connected apps
+// ---------------------------------------------------------------------------
+
+const (
+	accessTokenTTL = time.Hour
+	// usageSaveInterval limits how often a client's last use is written to
+	// the database; the in-memory time is always current.
+	usageSaveInterval = time.Minute
+	appsSessionTTL    = 15 * time.Minute
+	appsSessionCookie = "apps_session"
+
+	// Sign-ins on /apps check credentials with Things Cloud, so each client
+	// IP and each email gets loginAttempts per loginWindow. After
+	// loginFreeFailures failures in a row it waits loginBaseDelay, doubling
+	// with each further failure up to loginMaxDelay.
+	loginAttempts     = 10
+	loginWindow       = time.Minute
+	loginFreeFailures = 3
+	loginBaseDelay    = 30 * time.Second
+	loginMaxDelay     = time.Hour
+	loginMaxKeys      = 10000
+)
+
+// Access tokens are stateless JWTs, so revoking one adds a key to a
+// denylist instead. Each key records when it was revoked, and matches the
+// tokens issued up to then:
+//
+//	jti:<jti>                 a single access token
+//	grant:<gid>               every access token of one authorization
+//	client:<email>|<clientID> every access token a client holds for a user
+//
+// Keys are kept until the last token they could match has expired.
+func revocationKeys(claims map[string]any) []string {
+	var keys []string
+	if jti, _ := claims["jti"].(string); jti != "" {
+		keys = append(keys, "jti:"+jti)
+	}
+	if gid, _ := claims["gid"].(string); gid != "" {
+		keys = append(keys, "grant:"+gid)
+	}
+	sub, _ := claims["sub"].(string)
+	clientID, _ := claims["client_id"].(string)
+	return append(keys, "client:"+sub+"|"+clientID)
+}
+
+// isRevoked reports whether an access token was revoked after it was
+// issued. It fails closed when the storage cannot tell.
+func (o *OAuthServer) isRevoked(claims map[string]any) bool {
+	iat, _ := claims["iat"].(float64)
+	at, err := o.store.RevokedAt(revocationKeys(claims))
+	if err != nil {
+		logOAuth.Error("failed to check revocations", "error", err)
+		return true
+	}
+	return !at.IsZero() && int64(iat) <= at.Unix()
+}
+
+func (o *OAuthServer) revoke(key string) {
+	if err := o.store.Revoke(key, time.Now()); err != nil {
+		logOAuth.Error("failed to save revocation", "key", key, "error", err)
+	}
+}
+
+// touchClient records that a client used a user's tokens.
+func (o *OAuthServer) touchClient(email, clientID string) {
+	if clientID == "" {
+		return
+	}
+	key := email + "|" + clientID
+	now := time.Now()
+	o.mu.Lock()
+	saved := o.usageSaved[key]
+	o.lastUsed[key] = now
+	save := now.Sub(saved) >= usageSaveInterval
+	if save {
+		o.usageSaved[key] = now
+	}
+	o.mu.Unlock()
+	if save {
+		o.store.SaveClientUsage(email, clientID, now)
+	}
+}
+
+// flushClientUsage saves the last uses that touchClient held back because
+// of usageSaveInterval, e.g. before shutting down.
+func (o *OAuthServer) flushClientUsage() error {
+	type usage struct {
+		email, clientID string
+		at              time.Time
+	}
+	var pending []usage
+	o.mu.Lock()
+	for key, at := range o.lastUsed {
+		if at.After(o.usageSaved[key]) {
+			i := strings.LastIndex(key, "|")
+			pending = append(pending, usage{key[:i], key[i+1:], at})
+			o.usageSaved[key] = at
+		}
+	}
+	o.mu.Unlock()
+	var errs []error
+	for _, u := range pending {
+		errs = append(errs, o.store.SaveClientUsage(u.email, u.clientID, u.at))
+	}
+	return errors.Join(errs...)
+}
+
+// revokeClient signs a client out of a user's account: its refresh tokens
+// are deleted and its access tokens stop working.
+func (o *OAuthServer) revokeClient(email, clientID string) {
+	o.mu.Lock()
+	delete(o.lastUsed, email+"|"+clientID)
+	delete(o.usageSaved, email+"|"+clientID)
+	o.mu.Unlock()
+	if err := errors.Join(o.store.DeleteRefreshTokens(email, clientID), o.store.DeleteClientUsage(email, clientID)); err != nil {
+		logOAuth.Error("failed to delete client tokens", "email", email, "client_id", clientID, "error", err)
+	}
+	o.revoke("client:" + email + "|" + clientID)
+	logOAuth.Info("revoked client", "email", email, "client_id", clientID)
+}
+
+// authenticateClient identifies the client calling /revoke or /introspect,
+// with client_secret_basic or, for public clients, client_id alone.
+func (o *OAuthServer) authenticateClient(r *http.Request) (*OAuthClient, bool) {
+	clientID, secret, basic := r.BasicAuth()
+	if !basic {
+		clientID, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	client := o.client(clientID)
+	if client == nil || (client.ClientSecret != "" && !hmac.Equal([]byte(secret), []byte(client.ClientSecret))) {
+		return nil, false
+	}
+	return client, true
+}
+
+// handleRevoke implements RFC 7009. Unknown tokens and tokens issued to
+// other clients are ignored, as the RFC asks, so the response says nothing
+// about them.
+func (o *OAuthServer) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "invalid form body")
+		return
+	}
+	client, ok := o.authenticateClient(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "invalid_client", "unknown client or wrong client secret")
+		return
+	}
+	token := r.PostFormValue("token")
+	if token == "" {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "missing token")
+		return
+	}
+
+	rt, err := o.store.RefreshToken(token)
+	isRefresh := err == nil
+	switch {
+	case isRefresh && rt.ClientID == client.ClientID:
+		o.store.DeleteRefreshToken(token)
+		if rt.GrantID != "" {
+			o.revoke("grant:" + rt.GrantID)
+		}
+		logOAuth.InfoContext(r.Context(), "refresh token revoked", "email", rt.Email, "client_id", client.ClientID)
+	case !isRefresh:
+		if claims, err := o.parseJWT(token); err == nil && claims["client_id"] == client.ClientID {
+			if jti, _ := claims["jti"].(string); jti != "" {
+				o.revoke("jti:" + jti)
+			}
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleIntrospect implements RFC 7662. A client can only introspect its
+// own tokens; anything else is reported as inactive.
+func (o *OAuthServer) handleIntrospect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "invalid form body")
+		return
+	}
+	client, ok := o.authenticateClient(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "invalid_client", "unknown client or wrong client secret")
+		return
+	}
+	token := r.PostFormValue("token")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if rt, err := o.store.RefreshToken(token); err == nil {
+		if rt.ClientID != client.ClientID || time.Now().After(rt.ExpiresAt) {
+			writeJSON(w, http.StatusOK, map[string]any{"active": false})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"active":     true,
+			"token_type": "refresh_token",
+			"scope":      rt.Scope,
+			"client_id":  rt.ClientID,
+			"username":   rt.Email,
+			"sub":        rt.Email,
+			"exp":        rt.ExpiresAt.Unix(),
+		})
+		return
+	}
+
+	claims, err := o.parseJWT(token)
+	if err != nil || claims["client_id"] != client.ClientID {
+		writeJSON(w, http.StatusOK, map[string]any{"active": false})
+		return
+	}
+	out := map[string]any{"active": true, "token_type": "Bearer", "username": claims["sub"]}
+	for _, k := range []string{"scope", "client_id", "sub", "iss", "exp", "iat", "jti", "areas"} {
+		if v, ok := claims[k]; ok {
+			out[k] = v
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// ---------------------------------------------------------------------------
+// Connected apps page
+// ---------------------------------------------------------------------------
+
+// connectedApp is a client holding tokens for a user.
+type connectedApp struct {
+	ClientID   string
+	ClientName string
+	Scope      string
+	Areas      []string
+	LastUsed   time.Time // zero if not used since it was recorded
+	Expires    time.Time // when its last refresh token expires
+}
+
+// connectedApps lists the clients that hold a refresh token for email, or
+// an access token that may still be valid, most recently used first.
+func (o *OAuthServer) connectedApps(email string) []connectedApp {
+	// Usage is saved at most every usageSaveInterval, and by every server
+	// sharing the storage; this server's own record may be more recent.
+	usage, err := o.store.ClientUsage(email)
+	if err != nil {
+		logOAuth.Error("failed to load client usage", "email", email, "error", err)
+		usage = map[string]time.Time{}
+	}
+	prefix := email + "|"
+	o.mu.RLock()
+	for key, at := range o.lastUsed {
+		// A client missing from the storage was signed out, maybe by
+		// another server.
+		if clientID, ok := strings.CutPrefix(key, prefix); ok && !usage[clientID].IsZero() && at.After(usage[clientID]) {
+			usage[clientID] = at
+		}
+	}
+	o.mu.RUnlock()
+
+	apps := map[string]*connectedApp{}
+	get := func(clientID string) *connectedApp {
+		app, ok := apps[clientID]
+		if !ok {
+			app = &connectedApp{ClientID: clientID, ClientName: o.clientName(clientID), LastUsed: usage[clientID]}
+			apps[clientID] = app
+		}
+		return app
+	}
+	now := time.Now()
+	tokens, err := o.store.RefreshTokens(email)
+	if err != nil {
+		logOAuth.Error("failed to load refresh tokens", "email", email, "error", err)
+	}
+	for _, rt := range tokens {
+		if now.After(rt.ExpiresAt) {
+			continue
+		}
+		app := get(rt.ClientID)
+		if rt.ExpiresAt.After(app.Expires) {
+			app.Expires, app.Scope, app.Areas = rt.ExpiresAt, rt.Scope, rt.Areas
+		}
+	}
+	for clientID, at := range usage {
+		if now.Sub(at) < accessTokenTTL {
+			get(clientID)
+		}
+	}
+
+	out := make([]connectedApp, 0, len(apps))
+	for _, app := range apps {
+		out = append(out, *app)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].LastUsed.Equal(out[j].LastUsed) {
+			return out[i].LastUsed.After(out[j].LastUsed)
+		}
+		return out[i].ClientID < out[j].ClientID
+	})
+	return out
+}
+
+// signedToken signs email and an expiry with the server's JWT secret. The
+// purpose is part of the signature, so a token made for one page never
+// verifies for another, nor as an access token.
+func (o *OAuthServer) signedToken(purpose, email string, expires time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(email + "|" + strconv.FormatInt(expires.Unix(), 10)))
+	mac := hmac.New(sha256.New, o.jwtSecret)
+	mac.Write([]byte(purpose + ":" + payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (o *OAuthServer) verifySignedToken(purpose, token string) (string, bool) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", false
+	}
+	mac := hmac.New(sha256.New, o.jwtSecret)
+	mac.Write([]byte(purpose + ":" + payload))
+	if !hmac.Equal([]byte(sig), []byte(base64.RawURLEncoding.EncodeToString(mac.Sum(nil)))) {
+		return "", false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", false
+	}
+	email, exp, ok := strings.Cut(string(raw), "|")
+	expUnix, err := strconv.ParseInt(exp, 10, 64)
+	if !ok || err != nil || time.Now().Unix() > expUnix {
+		return "", false
+	}
+	return email, true
+}
+
+// checkPassword verifies Things credentials, first against the stored
+// password and otherwise with Things Cloud.
+func (o *OAuthServer) checkPassword(email, password string) bool {
+	if stored, ok := o.storedPassword(email); ok && hmac.Equal([]byte(stored), []byte(password)) {
+		return true
+	}
+	_, err := thingscloud.New(thingscloud.APIEndpoint, email, password, o.um.proxies.clientOptions(email)...).Verify()
+	return err == nil
+}
+
+// loginThrottle limits sign-in attempts by key, e.g. "ip:1.2.3.4" or
+// "email:a@example.com". The zero value is ready to use.
+type loginThrottle struct {
+	mu      sync.Mutex
+	records map[string]*loginRecord
+}
+
+type loginRecord struct {
+	window   time.Time // start of the current loginWindow
+	attempts int       // attempts in the window
+	failures int       // failures in a row
+	until    time.Time // no attempts before this
+}
+
+// allow counts an attempt for every key and returns zero, or how long to
+// wait when any of them is over its limit.
+func (lt *loginThrottle) allow(now time.Time, keys ...string) time.Duration {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	if lt.records == nil {
+		lt.records = map[string]*loginRecord{}
+	}
+	if len(lt.records) > loginMaxKeys {
+		for key, rec := range lt.records {
+			if now.After(rec.until) && now.Sub(rec.window) >= loginWindow {
+				delete(lt.records, key)
+			}
+		}
+	}
+	var wait time.Duration
+	for _, key := range keys {
+		rec := lt.records[key]
+		if rec == nil {
+			rec = &loginRecord{window: now}
+			lt.records[key] = rec
+		}
+		if now.Sub(rec.window) >= loginWindow {
+			rec.window, rec.attempts = now, 0
+		}
+		if d := rec.until.Sub(now); d > wait {
+			wait = d
+		}
+		if d := rec.window.Add(loginWindow).Sub(now); rec.attempts >= loginAttempts && d > wait {
+			wait = d
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+	for _, key := range keys {
+		lt.records[key].attempts++
+	}
+	return 0
+}
+
+// fail records a failed attempt and backs off after loginFreeFailures.
+func (lt *loginThrottle) fail(now time.Time, keys ...string) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	for _, key := range keys {
+		rec := lt.records[key]
+		if rec == nil {
+			continue
+		}
+		rec.failures++
+		if n := rec.failures - loginFreeFailures; n > 0 {
+			delay := loginMaxDelay
+			if n <= 7 {
+				delay = min(loginBaseDelay<<(n-1), loginMaxDelay)
+			}
+			rec.until = now.Add(delay)
+		}
+	}
+}
+
+// succeed clears the failures of every key.
+func (lt *loginThrottle) succeed(keys ...string) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	for _, key := range keys {
+		if rec := lt.records[key]; rec != nil {
+			rec.failures, rec.until = 0, time.Time{}
+		}
+	}
+}
+
+// clientIP is the address the request came from: the last hop the load
+// balancer in front added to X-Forwarded-For, or the peer address.
+func clientIP(r *http.Request) string {
+	if v := r.Header.Values("X-Forwarded-For"); len(v) > 0 {
+		hops := strings.Split(v[len(v)-1], ",")
+		if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// handleAppsPage lets users sign in with their Things credentials, see
+// which clients hold tokens for their account, and revoke them.
+func (o *OAuthServer) handleAppsPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Frame-Options", "DENY")
+
+	session := ""
+	if c, err := r.Cookie(appsSessionCookie); err == nil {
+		session = c.Value
+	}
+	email, signedIn := o.verifySignedToken("apps-page", session)
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		switch r.PostFormValue("action") {
+		case "login":
+			email = strings.TrimSpace(r.PostFormValue("email"))
+			keys := []string{"ip:" + clientIP(r), "email:" + strings.ToLower(email)}
+			now := time.Now()
+			if wait := o.logins.allow(now, keys...); wait > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+				o.renderAppsLogin(w, http.StatusTooManyRequests, "Too many sign-in attempts; try again later.")
+				return
+			}
+			if email == "" || !o.checkPassword(email, r.PostFormValue("password")) {
+				o.logins.fail(now, keys...)
+				metrics.loginFailures.Inc("apps")
+				o.renderAppsLogin(w, http.StatusUnauthorized, "Invalid Things Cloud credentials.")
+				return
+			}
+			o.logins.succeed(keys...)
+			session = o.signedToken("apps-page", email, time.Now().Add(appsSessionTTL))
+			http.SetCookie(w, &http.Cookie{
+				Name: appsSessionCookie, Value: session, Path: "/apps",
+				MaxAge: int(appsSessionTTL.Seconds()), HttpOnly: true, Secure: r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+				SameSite: http.SameSiteStrictMode,
+			})
+			http.Redirect(w, r, "/apps", http.StatusSeeOther)
+			return
+		case "logout":
+			http.SetCookie(w, &http.Cookie{Name: appsSessionCookie, Path: "/apps", MaxAge: -1})
+			http.Redirect(w, r, "/apps", http.StatusSeeOther)
+			return
+		case "revoke":
+			// The form echoes the session, which a cross-site page cannot read.
+			if !signedIn || !hmac.Equal([]byte(r.PostFormValue("session")), []byte(session)) {
+				http.Error(w, "Your session has expired; sign in again.", http.StatusForbidden)
+				return
+			}
+			o.revokeClient(email, r.PostFormValue("client_id"))
+			http.Redirect(w, r, "/apps", http.StatusSeeOther)
+			return
+		case "confirmation":
+			if !signedIn || !hmac.Equal([]byte(r.PostFormValue("session")), []byte(session)) {
+				http.Error(w, "Your session has expired; sign in again.", http.StatusForbidden)
+				return
+			}
+			cs := o.confirmStore()
+			n, err := strconv.Atoi(strings.TrimSpace(r.PostFormValue("threshold")))
+			if cs == nil || err != nil || n < 0 {
+				http.Error(w, "The threshold must be 0 or a positive number.", http.StatusBadRequest)
+				return
+			}
+			if err := cs.SetThreshold(email, n); err != nil {
+				http.Error(w, "Failed to save the threshold.", http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(w, r, "/apps", http.StatusSeeOther)
+			return
+		}
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !signedIn {
+		o.renderAppsLogin(w, http.StatusOK, "")
+		return
+	}
+	var rows strings.Builder
+	for _, app := range o.connectedApps(email) {
+		name := app.ClientName
+		if name == "" {
+			name = app.ClientID
+		}
+		lastUsed, expires := "not recently", "—"
+		if !app.LastUsed.IsZero() {
+			lastUsed = app.LastUsed.UTC().Format("2006-01-02 15:04")
+		}
+		if !app.Expires.IsZero() {
+			expires = app.Expires.UTC().Format("2006-01-02")
+		}
+		access := app.Scope
+		if access == "" {
+			access = strings.Join(allScopes, " ")
+		}
+		if len(app.Areas) > 0 {
+			access += fmt.Sprintf(" (%d areas)", len(app.Areas))
+		}
+		fmt.Fprintf(&rows, `<tr><td>%s</td><td><code>%s</code></td><td>%s</td><td>%s</td><td><form method="post" action="/apps"><input type="hidden" name="action" value="revoke"><input type="hidden" name="session" value="%s"><input type="hidden" name="client_id" value="%s"><button type="submit">Revoke</button></form></td></tr>
+`, htmlEscape(name), htmlEscape(access), htmlEscape(lastUsed), htmlEscape(expires), htmlEscape(session), htmlEscape(app.ClientID))
+	}
+	if rows.Len() == 0 {
+		rows.WriteString(`<tr><td colspan="5" class="empty">No apps are connected to your account.</td></tr>`)
+	}
+	confirmation := ""
+	if cs := o.confirmStore(); cs != nil {
+		n := cs.Threshold(email)
+		confirmation = fmt.Sprintf(`<h2>Confirmation</h2>
+<p class="sub">%s Apps cannot change this.</p>
+<form method="post" action="/apps"><input type="hidden" name="action" value="confirmation"><input type="hidden" name="session" value="%s"><label>Confirm calls affecting at least <input type="number" name="threshold" min="0" value="%d"> items (0 turns confirmation off)</label> <button type="submit">Save</button></form>
+`, htmlEscape(describeThreshold(n)), htmlEscape(session), n)
+	}
+	page := strings.NewReplacer(
+		"{{email}}", htmlEscape(maskEmail(email)),
+		"{{rows}}", rows.String(),
+		"{{confirmation}}", confirmation,
+	).Replace(appsPageHTML)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(page))
+}
+
+// confirmStore returns the store of confirmation thresholds, or nil when
+// the server has none.
+func (o *OAuthServer) confirmStore() *ConfirmStore {
+	if o.um == nil {
+		return nil
+	}
+	return o.um.confirm
+}
+
+func (o *OAuthServer) renderAppsLogin(w http.ResponseWriter, status int, errMsg string) {
+	errorHTML := ""
+	if errMsg != "" {
+		errorHTML = `<p class="err">` + htmlEscape(errMsg) + `</p>`
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write([]byte(strings.Replace(appsLoginHTML, "{{error}}", errorHTML, 1)))
+}
+
+const appsPageStyle = `<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", sans-serif; margin: 2rem; color: #1d1d1f; }
+h1 { font-size: 1.4rem; margin-bottom: 0.2rem; }
+h2 { font-size: 1.1rem; margin: 1.6rem 0 0.2rem; }
+p.sub { color: #6e6e73; margin-top: 0; }
+input { padding: 0.35rem 0.5rem; border: 1px solid #d2d2d7; border-radius: 6px; }
+button { padding: 0.35rem 0.9rem; border: 0; border-radius: 6px; background: #1b6ef3; color: #fff; }
+table { border-collapse: collapse; width: 100%; font-size: 0.9rem; }
+th, td { text-align: left; padding: 0.4rem 0.6rem; border-bottom: 1px solid #e5e5ea; vertical-align: middle; }
+form.login { display: flex; flex-direction: column; gap: 0.6rem; max-width: 20rem; }
+.err { color: #c9372c; } .empty { color: #6e6e73; text-align: center; }
+</style>`
+
+const appsLoginHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Connected apps — Things Cloud MCP</title>
+` + appsPageStyle + `
+</head>
+<body>
+<h1>Connected apps</h1>
+<p class="sub">Sign in with your Things Cloud account to see and revoke the apps that can access it, and to choose when destructive calls need your confirmation.</p>
+{{error}}
+<form class="login" method="post" action="/apps">
+<input type="hidden" name="action" value="login">
+<input type="email" name="email" placeholder="Email" required autocomplete="email" autofocus>
+<input type="password" name="password" placeholder="Password" required autocomplete="current-password">
+<button type="submit">Sign in</button>
+</form>
+</body>
+</html>
+`
+
+const appsPageHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Connected apps — Things Cloud MCP</title>
+` + appsPageStyle + `
+</head>
+<body>
+<h1>Connected apps</h1>
+<p class="sub">Apps holding tokens for {{email}}. Revoking one signs it out at once; it has to be authorized again to reconnect.</p>
+<table>
+<thead><tr><th>App</th><th>Access</th><th>Last used (UTC)</th><th>Expires</th><th></th></tr></thead>
+<tbody>
+{{rows}}
+</tbody>
+</table>
+{{confirmation}}<form method="post" action="/apps"><input type="hidden" name="action" value="logout"><p><button type="submit">Sign out</button></p></form>
+</body>
+</html>
+`
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestRevokeServer returns an OAuth server with a registered client and
+// a helper that posts a form to one of its endpoints.
+func newTestRevokeServer(t *testing.T) (*OAuthServer, func(http.HandlerFunc, url.Values) (int, map[string]any)) {
+	t.Helper()
+	o := NewOAuthServer(NewUserManager(), t.TempDir())
+	t.Cleanup(func() { o.db.Close() })
+	o.store.SaveClient(&OAuthClient{ClientID: "client-1", ClientName: "Claude"})
+	o.store.SaveClient(&OAuthClient{ClientID: "client-2", ClientName: "Other", ClientSecret: "s3cret"})
+	return o, func(h http.HandlerFunc, form url.Values) (int, map[string]any) {
+		t.Helper()
+		return postForm(h, form)
+	}
+}
+
+// postForm posts a form to h and decodes the JSON response.
+func postForm(h http.HandlerFunc, form url.Values) (int, map[string]any) {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	var resp map[string]any
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return rec.Code, resp
+}
+
+// authorize runs an authorization code grant for client-1.
+func authorize(t *testing.T, o *OAuthServer, post func(http.HandlerFunc, url.Values) (int, map[string]any)) (access, refresh string) {
+	t.Helper()
+	verifier := "verifier-0123456789"
+	sum := sha256.Sum256([]byte(verifier))
+	code := randomString(8)
+	o.store.SaveAuthCode(&AuthCode{
+		Code: code, ClientID: "client-1", RedirectURI: "https://client.example.com/cb",
+		Email: "a@example.com", Password: "pw", Scope: "things:read",
+		CodeChallenge: base64.RawURLEncoding.EncodeToString(sum[:]),
+		ExpiresAt:     time.Now().Add(time.Minute),
+	})
+	status, resp := post(o.handleToken, url.Values{
+		"grant_type": {"authorization_code"}, "code": {code}, "client_id": {"client-1"},
+		"redirect_uri": {"https://client.example.com/cb"}, "code_verifier": {verifier},
+	})
+	if status != http.StatusOK {
+		t.Fatalf("token: %d %v", status, resp)
+	}
+	return resp["access_token"].(string), resp["refresh_token"].(string)
+}
+
+func TestRevokeAndIntrospect(t *testing.T) {
+	o, post := newTestRevokeServer(t)
+	access, refresh := authorize(t, o, post)
+
+	_, resp := post(o.handleIntrospect, url.Values{"token": {access}, "client_id": {"client-1"}})
+	if resp["active"] != true || resp["username"] != "a@example.com" || resp["scope"] != "things:read" || resp["token_type"] != "Bearer" {
+		t.Errorf("introspect access token: %v", resp)
+	}
+	_, resp = post(o.handleIntrospect, url.Values{"token": {refresh}, "client_id": {"client-1"}})
+	if resp["active"] != true || resp["token_type"] != "refresh_token" {
+		t.Errorf("introspect refresh token: %v", resp)
+	}
+	// Other clients learn nothing about the token, and must authenticate.
+	_, resp = post(o.handleIntrospect, url.Values{"token": {access}, "client_id": {"client-2"}, "client_secret": {"s3cret"}})
+	if resp["active"] != false {
+		t.Errorf("introspect as another client: %v", resp)
+	}
+	if status, _ := post(o.handleIntrospect, url.Values{"token": {access}, "client_id": {"client-2"}}); status != http.StatusUnauthorized {
+		t.Errorf("missing secret: got %d", status)
+	}
+
+	// Revoking the access token stops it at once; the grant lives on.
+	if status, _ := post(o.handleRevoke, url.Values{"token": {access}, "client_id": {"client-1"}}); status != http.StatusOK {
+		t.Fatalf("revoke: %d", status)
+	}
+	if _, _, err := o.ResolveBearer(access); err == nil {
+		t.Error("revoked access token still resolves")
+	}
+	if _, resp = post(o.handleIntrospect, url.Values{"token": {access}, "client_id": {"client-1"}}); resp["active"] != false {
+		t.Errorf("introspect revoked token: %v", resp)
+	}
+	status, resp := post(o.handleToken, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refresh}})
+	if status != http.StatusOK {
+		t.Fatalf("refresh: %d %v", status, resp)
+	}
+	access, refresh = resp["access_token"].(string), resp["refresh_token"].(string)
+	if _, _, err := o.ResolveBearer(access); err != nil {
+		t.Fatalf("refreshed access token: %v", err)
+	}
+
+	// Revoking the refresh token ends the grant, including its access tokens.
+	post(o.handleRevoke, url.Values{"token": {refresh}, "client_id": {"client-2"}, "client_secret": {"s3cret"}})
+	if _, _, err := o.ResolveBearer(access); err != nil {
+		t.Error("another client revoked client-1's token")
+	}
+	post(o.handleRevoke, url.Values{"token": {refresh}, "client_id": {"client-1"}})
+	if status, _ := post(o.handleToken, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refresh}}); status != http.StatusBadRequest {
+		t.Errorf("refresh after revoke: got %d", status)
+	}
+	if _, _, err := o.ResolveBearer(access); err == nil {
+		t.Error("access token of a revoked grant still resolves")
+	}
+	if status, _ := post(o.handleRevoke, url.Values{"token": {"unknown"}, "client_id": {"client-1"}}); status != http.StatusOK {
+		t.Errorf("unknown token: got %d", status)
+	}
+
+	rec := httptest.NewRecorder()
+	o.handleAuthServerMetadata(rec, httptest.NewRequest(http.MethodGet, "/.well-known/oauth-authorization-server", nil))
+	if !strings.Contains(rec.Body.String(), `"revocation_endpoint":"https://example.com/revoke"`) || !strings.Contains(rec.Body.String(), `"introspection_endpoint"`) {
+		t.Errorf("metadata: %s", rec.Body.String())
+	}
+}
+
+func TestConnectedAppsPage(t *testing.T) {
+	o, post := newTestRevokeServer(t)
+	access, _ := authorize(t, o, post)
+	if _, _, err := o.ResolveBearer(access); err != nil {
+		t.Fatal(err)
+	}
+
+	page := func(method string, form url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, "/apps", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		o.handleAppsPage(rec, req)
+		return rec
+	}
+	if rec := page(http.MethodGet, nil, nil); !strings.Contains(rec.Body.String(), `name="password"`) {
+		t.Fatalf("signed out page: %s", rec.Body.String())
+	}
+	rec := page(http.MethodPost, url.Values{"action": {"login"}, "email": {"a@example.com"}, "password": {"pw"}}, nil)
+	cookies := rec.Result().Cookies()
+	if rec.Code != http.StatusSeeOther || len(cookies) != 1 {
+		t.Fatalf("login: %d %v", rec.Code, cookies)
+	}
+	session := cookies[0]
+
+	body := page(http.MethodGet, nil, session).Body.String()
+	if !strings.Contains(body, "<td>Claude</td>") || !strings.Contains(body, "things:read") || strings.Contains(body, "not recently") {
+		t.Fatalf("apps page: %s", body)
+	}
+
+	// Revoking needs the session echoed in the form, not just the cookie.
+	if rec := page(http.MethodPost, url.Values{"action": {"revoke"}, "client_id": {"client-1"}}, session); rec.Code != http.StatusForbidden {
+		t.Errorf("revoke without session field: got %d", rec.Code)
+	}
+	page(http.MethodPost, url.Values{"action": {"revoke"}, "client_id": {"client-1"}, "session": {session.Value}}, session)
+	if _, _, err := o.ResolveBearer(access); err == nil {
+		t.Error("revoked client's access token still resolves")
+	}
+	if tokens, _ := o.store.RefreshTokens("a@example.com"); len(o.connectedApps("a@example.com")) != 0 || len(tokens) != 0 {
+		t.Errorf("client still connected: %+v", o.connectedApps("a@example.com"))
+	}
+	if body := page(http.MethodGet, nil, session).Body.String(); !strings.Contains(body, "No apps are connected") {
+		t.Errorf("apps page after revoke: %s", body)
+	}
+
+	// The confirmation threshold is set here, by the signed-in owner.
+	o.um.confirm = NewConfirmStore(o.db)
+	if body := page(http.MethodGet, nil, session).Body.String(); !strings.Contains(body, `name="threshold" min="0" value="1"`) {
+		t.Errorf("confirmation form: %s", body)
+	}
+	if rec := page(http.MethodPost, url.Values{"action": {"confirmation"}, "threshold": {"0"}}, session); rec.Code != http.StatusForbidden {
+		t.Errorf("threshold without session field: got %d", rec.Code)
+	}
+	if rec := page(http.MethodPost, url.Values{"action": {"confirmation"}, "threshold": {"-1"}, "session": {session.Value}}, session); rec.Code != http.StatusBadRequest {
+		t.Errorf("negative threshold: got %d", rec.Code)
+	}
+	page(http.MethodPost, url.Values{"action": {"confirmation"}, "threshold": {"5"}, "session": {session.Value}}, session)
+	if n := o.um.confirm.Threshold("a@example.com"); n != 5 {
+		t.Errorf("threshold: got %d, want 5", n)
+	}
+}
+
+func TestAppsLoginThrottle(t *testing.T) {
+	now := mustTime("2025-06-01")
+	var lt loginThrottle
+	for i := 0; i < loginAttempts; i++ {
+		if wait := lt.allow(now, "ip:1.2.3.4", "email:a@example.com"); wait != 0 {
+			t.Fatalf("attempt %d: wait %v", i+1, wait)
+		}
+	}
+	if wait := lt.allow(now, "ip:1.2.3.4"); wait != loginWindow {
+		t.Errorf("over the limit: wait %v, want %v", wait, loginWindow)
+	}
+	if wait := lt.allow(now, "ip:5.6.7.8", "email:a@example.com"); wait == 0 {
+		t.Error("another IP should still be limited by the email")
+	}
+
+	// Failures back off, doubling, until a success clears them.
+	now = now.Add(loginWindow)
+	for i := 0; i < loginFreeFailures; i++ {
+		lt.allow(now, "email:b@example.com")
+		lt.fail(now, "email:b@example.com")
+	}
+	if wait := lt.allow(now, "email:b@example.com"); wait != 0 {
+		t.Fatalf("free failures: wait %v", wait)
+	}
+	lt.fail(now, "email:b@example.com")
+	if wait := lt.allow(now, "email:b@example.com"); wait != loginBaseDelay {
+		t.Errorf("first backoff: wait %v, want %v", wait, loginBaseDelay)
+	}
+	now = now.Add(loginBaseDelay)
+	lt.allow(now, "email:b@example.com")
+	lt.fail(now, "email:b@example.com")
+	if wait := lt.allow(now, "email:b@example.com"); wait != 2*loginBaseDelay {
+		t.Errorf("second backoff: wait %v, want %v", wait, 2*loginBaseDelay)
+	}
+	lt.succeed("email:b@example.com")
+	if wait := lt.allow(now, "email:b@example.com"); wait != 0 {
+		t.Errorf("after success: wait %v", wait)
+	}
+
+	// A throttled sign-in is refused before the password is checked.
+	o, _ := newTestRevokeServer(t)
+	o.rememberCredentials("c@example.com", "pw")
+	for i := 0; i < loginAttempts; i++ {
+		o.logins.allow(time.Now(), "email:c@example.com")
+	}
+	form := url.Values{"action": {"login"}, "email": {"C@example.com"}, "password": {"pw"}}
+	req := httptest.NewRequest(http.MethodPost, "/apps", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	o.handleAppsPage(rec, req)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("throttled login: got %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
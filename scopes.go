@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	thingscloud "github.com/arthursoares/things-cloud-sdk"
+	memory "github.com/arthursoares/things-cloud-sdk/state/memory"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// ---------------------------------------------------------------------------
+// OAuth scopes
+// ---------------------------------------------------------------------------
+
+const (
+	scopeRead   = "things:read"
+	scopeWrite  = "things:write"
+	scopeDelete = "things:delete"
+	// scopeManage is the single scope issued before scopes were split up.
+	// It still grants everything.
+	scopeManage = "things:manage"
+)
+
+var allScopes = []string{scopeRead, scopeWrite, scopeDelete}
+
+// scopeDescriptions are shown on the consent page.
+var scopeDescriptions = map[string]string{
+	scopeRead:   "Read your tasks, projects, areas and tags",
+	scopeWrite:  "Create and edit items",
+	scopeDelete: "Permanently delete items",
+}
+
+// parseScopes parses a space-separated OAuth scope parameter. An empty
+// parameter and things:manage both mean every scope, so clients written
+// before scopes existed keep full access.
+func parseScopes(s string) ([]string, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return allScopes, nil
+	}
+	seen := map[string]bool{}
+	for _, f := range fields {
+		switch f {
+		case scopeManage:
+			return allScopes, nil
+		case scopeRead, scopeWrite, scopeDelete:
+			seen[f] = true
+		default:
+			return nil, fmt.Errorf("unknown scope %q (supported: %s)", f, strings.Join(allScopes, " "))
+		}
+	}
+	var out []string
+	for _, sc := range allScopes {
+		if seen[sc] {
+			out = append(out, sc)
+		}
+	}
+	return out, nil
+}
+
+// toolScope returns the scope needed to call a tool: things:read for
+// read-only tools, things:delete for destructive ones and things:write for
+// everything else.
+func toolScope(tool mcp.Tool) string {
+	a := tool.Annotations
+	if a.ReadOnlyHint != nil && *a.ReadOnlyHint {
+		return scopeRead
+	}
+	if a.DestructiveHint != nil && *a.DestructiveHint {
+		return scopeDelete
+	}
+	return scopeWrite
+}
+
+// accountTools act on the whole account rather than on items in it, so
+// tokens confined to areas cannot use them.
+var accountTools = map[string]bool{
+	"things_create_area":           true,
+	"things_create_tag":            true,
+	"things_edit_tag":              true,
+	"things_delete_tag":            true,
+	"things_diagnose":              true,
+	"things_create_calendar_feed":  true,
+	"things_revoke_calendar_feed":  true,
+	"things_create_webhook":        true,
+	"things_list_webhooks":         true,
+	"things_delete_webhook":        true,
+	"things_webhook_deliveries":    true,
+	"things_audit_log":             true,
+	"things_confirmation_settings": true,
+}
+
+// tokenGrant is what a request may do: its scopes and, when Areas is
+// non-empty, the areas it is confined to.
+type tokenGrant struct {
+	Scopes []string
+	Areas  []string
+}
+
+// allows reports why a tool is off limits for the grant, or nil.
+func (g tokenGrant) allows(tool mcp.Tool) error {
+	scope := toolScope(tool)
+	if !containsStr(g.Scopes, scope) {
+		return withCode(codeForbidden, fmt.Errorf("%s needs the %s scope, which this token was not granted", tool.Name, scope))
+	}
+	if len(g.Areas) > 0 && accountTools[tool.Name] {
+		return withCode(codeForbidden, fmt.Errorf("%s acts on the whole account and is not available to a token limited to areas", tool.Name))
+	}
+	return nil
+}
+
+// grantFromContext returns the grant of the request's credentials. Basic
+// auth carries the account password and so may do everything.
+func (um *UserManager) grantFromContext(ctx context.Context) (tokenGrant, error) {
+	info, _ := ctx.Value(userContextKey).(*UserInfo)
+	if info == nil || info.Token == "" {
+		return tokenGrant{Scopes: allScopes}, nil
+	}
+	if um.oauth == nil {
+		return tokenGrant{}, withCode(codeUnauthenticated, fmt.Errorf("Bearer token authentication not configured"))
+	}
+	claims, err := um.oauth.parseJWT(info.Token)
+	if err != nil {
+		return tokenGrant{}, withCode(codeUnauthenticated, fmt.Errorf("Bearer auth failed: invalid token: %w", err))
+	}
+	scope, _ := claims["scope"].(string)
+	scopes, err := parseScopes(scope)
+	if err != nil {
+		return tokenGrant{}, withCode(codeUnauthenticated, fmt.Errorf("Bearer auth failed: %w", err))
+	}
+	g := tokenGrant{Scopes: scopes}
+	if areas, ok := claims["areas"].([]any); ok {
+		for _, a := range areas {
+			if s, ok := a.(string); ok && s != "" {
+				g.Areas = append(g.Areas, s)
+			}
+		}
+	}
+	return g, nil
+}
+
+// scopedTools rejects calls to tools the caller's token does not grant.
+func (um *UserManager) scopedTools(tools []server.ServerTool) []server.ServerTool {
+	for i := range tools {
+		tool, next := tools[i].Tool, tools[i].Handler
+		tools[i].Handler = func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			grant, err := um.grantFromContext(ctx)
+			if err != nil {
+				return errResultFrom(err), nil
+			}
+			if err := grant.allows(tool); err != nil {
+				return errResultFrom(err), nil
+			}
+			return next(ctx, req)
+		}
+	}
+	return tools
+}
+
+// filterTools hides the tools the caller's token does not grant from
+// tools/list. Invalid tokens see every tool; their calls fail anyway.
+func (um *UserManager) filterTools(ctx context.Context, tools []mcp.Tool) []mcp.Tool {
+	grant, err := um.grantFromContext(ctx)
+	if err != nil {
+		return tools
+	}
+	var out []mcp.Tool
+	for _, tool := range tools {
+		if grant.allows(tool) == nil {
+			out = append(out, tool)
+		}
+	}
+	return out
+}
+
+// ---------------------------------------------------------------------------
+// Area confinement
+// ---------------------------------------------------------------------------
+
+// resolveAreas turns a comma-separated list of area names or UUIDs into
+// area UUIDs.
+func resolveAreas(state *memory.State, list string) ([]string, error) {
+	var out []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		uuid := ""
+		if _, ok := state.Areas[name]; ok {
+			uuid = name
+		} else {
+			for _, area := range state.Areas {
+				if strings.EqualFold(area.Title, name) {
+					uuid = area.UUID
+					break
+				}
+			}
+		}
+		if uuid == "" {
+			return nil, withCode(codeNotFound, fmt.Errorf("unknown area: %s", name))
+		}
+		if !containsStr(out, uuid) {
+			out = append(out, uuid)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// areaScope confines a ThingsMCP to some areas. The confined instance
+// reads a filtered copy of its root's state and sends syncs and writes
+// through the root, checking every write first.
+type areaScope struct {
+	root  *ThingsMCP
+	areas map[string]bool
+
+	mu        sync.Mutex
+	state     *memory.State
+	builtFrom *memory.State // root state the view was built from
+	index     int           // root history index the view was built at
+}
+
+// confinedTo returns a view of t that only sees and changes the given areas
+// and what is inside them. Tags stay visible but cannot be changed.
+func (t *ThingsMCP) confinedTo(areas []string) *ThingsMCP {
+	root := t.root()
+	s := &areaScope{root: root, areas: map[string]bool{}}
+	for _, a := range areas {
+		s.areas[a] = true
+	}
+	return &ThingsMCP{client: root.client, history: root.history, proxies: root.proxies, scope: s}
+}
+
+// root returns the unconfined instance behind t.
+func (t *ThingsMCP) root() *ThingsMCP {
+	if t.scope != nil {
+		return t.scope.root
+	}
+	return t
+}
+
+// view returns the filtered state, rebuilding it when the root has synced.
+func (s *areaScope) view() *memory.State {
+	full := s.root.getState()
+	index := s.root.history.LoadedServerIndex
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state != nil && s.builtFrom == full && s.index == index {
+		return s.state
+	}
+	view := memory.NewState()
+	if full != nil {
+		for id, area := range full.Areas {
+			if s.areas[id] {
+				view.Areas[id] = area
+			}
+		}
+		for id, tag := range full.Tags {
+			view.Tags[id] = tag
+		}
+		for id, task := range full.Tasks {
+			if s.taskInAreas(full, task) {
+				view.Tasks[id] = task
+			}
+		}
+		for id, item := range full.CheckListItems {
+			if len(item.TaskIDs) > 0 && view.Tasks[item.TaskIDs[0]] != nil {
+				view.CheckListItems[id] = item
+			}
+		}
+	}
+	s.state, s.builtFrom, s.index = view, full, index
+	return view
+}
+
+// taskInAreas reports whether a task, project or heading is in one of the
+// areas, directly or through its project.
+func (s *areaScope) taskInAreas(state *memory.State, task *thingscloud.Task) bool {
+	for _, a := range task.AreaIDs {
+		if s.areas[a] {
+			return true
+		}
+	}
+	var projectUUID string
+	if len(task.ParentTaskIDs) > 0 {
+		projectUUID = task.ParentTaskIDs[0]
+	} else if len(task.ActionGroupIDs) > 0 {
+		if heading, ok := state.Tasks[task.ActionGroupIDs[0]]; ok && len(heading.ParentTaskIDs) > 0 {
+			projectUUID = heading.ParentTaskIDs[0]
+		}
+	}
+	if project, ok := state.Tasks[projectUUID]; ok {
+		for _, a := range project.AreaIDs {
+			if s.areas[a] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// visible reports whether a change concerns an item inside the areas.
+// Deleted items are no longer in any area and so are never visible.
+func (s *areaScope) visible(ev changeEvent) bool {
+	view := s.view()
+	return view.Tasks[ev.UUID] != nil || view.Areas[ev.UUID] != nil || view.CheckListItems[ev.UUID] != nil
+}
+
+func (s *areaScope) visibleEvents(events []changeEvent) []changeEvent {
+	var out []changeEvent
+	for _, ev := range events {
+		if s.visible(ev) {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
+// checkWrite refuses items that would change anything outside the areas
+// or move anything out of them. Items created earlier in the same write
+// count as inside, so a new project can be filled in one commit.
+func (s *areaScope) checkWrite(items []thingscloud.Identifiable) error {
+	view := s.view()
+	created := map[string]bool{}
+	inside := func(uuid string) bool { return created[uuid] || view.Tasks[uuid] != nil }
+	placed := func(areas, projects, headings []string) bool {
+		switch {
+		case len(headings) > 0:
+			return inside(headings[0])
+		case len(projects) > 0:
+			return inside(projects[0])
+		case len(areas) > 0:
+			return s.areas[areas[0]]
+		}
+		return false
+	}
+
+	for _, item := range items {
+		env, ok := item.(writeEnvelope)
+		if !ok {
+			return withCode(codeForbidden, fmt.Errorf("this token is limited to some areas and cannot write %T", item))
+		}
+		raw, err := json.Marshal(env.payload)
+		if err != nil {
+			return err
+		}
+		var p map[string]any
+		json.Unmarshal(raw, &p)
+
+		switch env.kind {
+		case "Task6":
+			ar, pr, agr := payloadIDs(p, "ar"), payloadIDs(p, "pr"), payloadIDs(p, "agr")
+			if env.action == 0 {
+				if !placed(ar, pr, agr) {
+					return withCode(codeForbidden, fmt.Errorf("this token is limited to some areas: new items must be placed in one of them"))
+				}
+				created[env.id] = true
+				continue
+			}
+			task := view.Tasks[env.id]
+			if task == nil {
+				return withCode(codeForbidden, fmt.Errorf("%s is outside the areas this token is limited to", env.id))
+			}
+			_, hasAr := p["ar"]
+			_, hasPr := p["pr"]
+			_, hasAgr := p["agr"]
+			if !hasAr && !hasPr && !hasAgr {
+				continue
+			}
+			// Every container named in the payload must be inside. A new
+			// project or area replaces the heading, so the task's current
+			// heading is never consulted.
+			if hasAgr && len(agr) > 0 && !inside(agr[0]) ||
+				hasPr && len(pr) > 0 && !inside(pr[0]) ||
+				hasAr && len(ar) > 0 && !s.areas[ar[0]] {
+				return withCode(codeForbidden, fmt.Errorf("this token is limited to some areas and cannot move %s out of them", env.id))
+			}
+			if !hasAr {
+				ar = task.AreaIDs
+			}
+			if !hasPr {
+				pr = task.ParentTaskIDs
+			}
+			if !placed(ar, pr, agr) {
+				return withCode(codeForbidden, fmt.Errorf("this token is limited to some areas and cannot move %s out of them", env.id))
+			}
+		case "ChecklistItem3":
+			if env.action == 0 {
+				if ts := payloadIDs(p, "ts"); len(ts) == 0 || !inside(ts[0]) {
+					return withCode(codeForbidden, fmt.Errorf("this token is limited to some areas: the checklist's task is outside them"))
+				}
+				continue
+			}
+			if view.CheckListItems[env.id] == nil {
+				return withCode(codeForbidden, fmt.Errorf("%s is outside the areas this token is limited to", env.id))
+			}
+		case "Area3":
+			if env.action == 0 || !s.areas[env.id] {
+				return withCode(codeForbidden, fmt.Errorf("this token is limited to some areas and cannot create or change other areas"))
+			}
+		default:
+			return withCode(codeForbidden, fmt.Errorf("this token is limited to some areas and cannot change tags"))
+		}
+	}
+	return nil
+}
+
+// payloadIDs reads a UUID list field such as "ar" from a wire payload.
+func payloadIDs(p map[string]any, key string) []string {
+	list, _ := p[key].([]any)
+	var out []string
+	for _, v := range list {
+		if s, ok := v.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	thingscloud "github.com/arthursoares/things-cloud-sdk"
+	memory "github.com/arthursoares/things-cloud-sdk/state/memory"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func TestParseScopes(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want string
+	}{
+		{"", "things:read things:write things:delete"},
+		{"things:manage", "things:read things:write things:delete"},
+		{"things:write things:read", "things:read things:write"},
+		{"things:read things:read", "things:read"},
+	} {
+		got, err := parseScopes(tc.in)
+		if err != nil || strings.Join(got, " ") != tc.want {
+			t.Errorf("parseScopes(%q) = %v, %v; want %s", tc.in, got, err, tc.want)
+		}
+	}
+	if _, err := parseScopes("things:read admin"); err == nil {
+		t.Error("unknown scope should be rejected")
+	}
+}
+
+func TestToolScopes(t *testing.T) {
+	um := NewUserManager()
+	scopes := map[string]string{}
+	for _, st := range defineTools(um) {
+		scopes[st.Tool.Name] = toolScope(st.Tool)
+	}
+	for name, want := range map[string]string{
+		"things_find_tasks":            scopeRead,
+		"things_changes_since":         scopeRead,
+		"things_audit_log":             scopeRead,
+		"things_create_task":           scopeWrite,
+		"things_edit_item":             scopeWrite,
+		"things_import":                scopeWrite,
+		"things_delete_area":           scopeDelete,
+		"things_delete_checklist_item": scopeDelete,
+	} {
+		if scopes[name] != want {
+			t.Errorf("%s: got %s, want %s", name, scopes[name], want)
+		}
+	}
+}
+
+func newTestScopeServer(t *testing.T, items ...thingscloud.Item) (*UserManager, map[string]server.ServerTool) {
+	t.Helper()
+	um, _, tools, _ := newTestToolServer(t, "scope@example.com", items...)
+	newTestOAuth(t, um).rememberCredentials("scope@example.com", "testpass")
+	return um, tools
+}
+
+func bearerContext(t *testing.T, um *UserManager, scope string, areas ...string) context.Context {
+	t.Helper()
+	token, err := um.oauth.createJWT(accessClaims("https://things.example.com", "scope@example.com", "client-1", scope, areas))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return context.WithValue(context.Background(), userContextKey, &UserInfo{Token: token})
+}
+
+func listedTools(um *UserManager, ctx context.Context, tools map[string]server.ServerTool) map[string]bool {
+	var all []mcp.Tool
+	for _, st := range tools {
+		all = append(all, st.Tool)
+	}
+	listed := map[string]bool{}
+	for _, tool := range um.filterTools(ctx, all) {
+		listed[tool.Name] = true
+	}
+	return listed
+}
+
+func TestReadOnlyToken(t *testing.T) {
+	um, tools := newTestScopeServer(t, makeTaskItem("t-1", withTitle("Existing")))
+	ctx := bearerContext(t, um, "things:read")
+
+	result, _ := tools["things_find_tasks"].Handler(ctx, makeReq(nil))
+	assertNotError(t, result)
+	result, _ = tools["things_create_task"].Handler(ctx, makeReq(map[string]any{"title": "Nope"}))
+	assertIsError(t, result)
+	if text := resultText(t, result); !strings.Contains(text, "things:write") {
+		t.Errorf("error should name the missing scope: %s", text)
+	}
+
+	listed := listedTools(um, ctx, tools)
+	if !listed["things_find_tasks"] || !listed["things_audit_log"] || listed["things_create_task"] || listed["things_delete_area"] {
+		t.Errorf("listed tools: %v", listed)
+	}
+
+	// Basic auth and tokens issued before scopes keep full access.
+	basic := context.WithValue(context.Background(), userContextKey, &UserInfo{Email: "scope@example.com", Password: "testpass"})
+	if listed := listedTools(um, basic, tools); len(listed) != len(tools) {
+		t.Errorf("basic auth lists %d of %d tools", len(listed), len(tools))
+	}
+	if listed := listedTools(um, bearerContext(t, um, "things:manage"), tools); len(listed) != len(tools) {
+		t.Errorf("things:manage lists %d of %d tools", len(listed), len(tools))
+	}
+}
+
+func TestAreaConfinedToken(t *testing.T) {
+	um, tools := newTestScopeServer(t,
+		makeAreaItem("area-1", "Work"),
+		makeAreaItem("area-2", "Home"),
+		makeTagItem("tag-1", "Urgent"),
+		makeTaskItem("proj-1", withTitle("Launch"), withTaskType(thingscloud.TaskTypeProject), withArea("area-1")),
+		makeTaskItem("t-1", withTitle("Email Bob"), withArea("area-1")),
+		makeTaskItem("t-2", withTitle("Write spec"), withParent("proj-1")),
+		makeTaskItem("t-3", withTitle("Fix sink"), withArea("area-2")),
+		makeTaskItem("t-4", withTitle("Loose"), withSchedule(thingscloud.TaskScheduleInbox)),
+		makeTaskItem("head-1", withTitle("Phase 1"), withTaskType(thingscloud.TaskTypeHeading), withParent("proj-1")),
+		makeTaskItem("t-5", withTitle("Draft plan"), withActionGroup("head-1")),
+		makeTaskItem("proj-2", withTitle("Renovate"), withTaskType(thingscloud.TaskTypeProject), withArea("area-2")),
+		makeChecklistItem("cl-3", "t-3", "Buy washer"),
+	)
+	ctx := bearerContext(t, um, "", "area-1")
+	call := func(name string, args map[string]any) *mcp.CallToolResult {
+		t.Helper()
+		result, err := tools[name].Handler(ctx, makeReq(args))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	result := call("things_find_tasks", nil)
+	assertNotError(t, result)
+	var found []string
+	for _, task := range resultJSON[[]TaskOutput](t, result) {
+		found = append(found, task.UUID)
+	}
+	sort.Strings(found)
+	if strings.Join(found, ",") != "t-1,t-2,t-5" {
+		t.Errorf("find_tasks: got %v, want [t-1 t-2 t-5]", found)
+	}
+	assertIsError(t, call("things_show_task", map[string]any{"uuid": "t-3"}))
+	assertIsError(t, call("things_edit_checklist_item", map[string]any{"uuid": "cl-3", "completed": true}))
+
+	assertIsError(t, call("things_create_task", map[string]any{"title": "To the inbox"}))
+	assertNotError(t, call("things_create_task", map[string]any{"title": "In Work", "area_uuid": "area-1"}))
+	assertNotError(t, call("things_create_task", map[string]any{"title": "In Launch", "project_uuid": "proj-1"}))
+	assertNotError(t, call("things_edit_item", map[string]any{"uuid": "t-1", "title": "Email Bob today"}))
+	assertIsError(t, call("things_edit_item", map[string]any{"uuid": "t-3", "title": "Mine now"}))
+	assertIsError(t, call("things_edit_item", map[string]any{"uuid": "t-1", "area_uuid": "area-2"}))
+	// The task's current heading is inside, but the project it moves to is not.
+	assertIsError(t, call("things_edit_item", map[string]any{"uuid": "t-5", "project_uuid": "proj-2"}))
+	assertIsError(t, call("things_create_tag", map[string]any{"name": "New"}))
+	// A dry run is refused where the write would be.
+	assertIsError(t, call("things_import", map[string]any{"format": "markdown", "content": "- Inbox task", "dry_run": true}))
+	assertNotError(t, call("things_import", map[string]any{"format": "markdown", "content": "- Work task", "area_uuid": "area-1", "dry_run": true}))
+
+	assertNotError(t, call("things_item_history", map[string]any{"uuid": "t-1"}))
+	assertIsError(t, call("things_item_history", map[string]any{"uuid": "t-3"}))
+
+	listed := listedTools(um, ctx, tools)
+	if !listed["things_find_tasks"] || !listed["things_edit_area"] || listed["things_create_tag"] || listed["things_create_webhook"] {
+		t.Errorf("listed tools: %v", listed)
+	}
+
+	// The write check is the last line of defence for handlers that do
+	// not look items up first.
+	view := um.users["scope@example.com"].confinedTo([]string{"area-1"})
+	for name, env := range map[string]writeEnvelope{
+		"tag":           {id: "tag-1", action: 1, kind: "Tag4", payload: map[string]any{"tt": "x"}},
+		"other area":    {id: "area-2", action: 1, kind: "Area3", payload: map[string]any{"tt": "x"}},
+		"into inbox":    {id: "t-1", action: 1, kind: "Task6", payload: map[string]any{"ar": []string{}}},
+		"foreign task":  {id: "t-4", action: 1, kind: "Task6", payload: map[string]any{"tt": "x"}},
+		"stale heading": {id: "t-5", action: 1, kind: "Task6", payload: map[string]any{"pr": []string{"proj-2"}}},
+	} {
+		if err := view.scope.checkWrite([]thingscloud.Identifiable{env}); err == nil {
+			t.Errorf("%s: write should be refused", name)
+		}
+	}
+	if got := view.scope.visibleEvents([]changeEvent{{UUID: "t-1"}, {UUID: "t-3"}, {UUID: "tag-1"}}); len(got) != 1 || got[0].UUID != "t-1" {
+		t.Errorf("visible changes: got %+v", got)
+	}
+	newProject := writeEnvelope{id: "proj-3", kind: "Task6", payload: map[string]any{"tp": 1, "ar": []string{"area-1"}}}
+	newTask := writeEnvelope{id: "t-6", kind: "Task6", payload: map[string]any{"pr": []string{"proj-3"}}}
+	if err := view.scope.checkWrite([]thingscloud.Identifiable{newProject, newTask}); err != nil {
+		t.Errorf("filling a new project: %v", err)
+	}
+}
+
+func TestResolveAreas(t *testing.T) {
+	state := memory.NewState()
+	state.Update(makeAreaItem("area-1", "Work"), makeAreaItem("area-2", "Home"))
+	got, err := resolveAreas(state, " work, area-2,Work ")
+	if err != nil || strings.Join(got, ",") != "area-1,area-2" {
+		t.Errorf("got %v, %v", got, err)
+	}
+	if _, err := resolveAreas(state, "Work, Garden"); err == nil {
+		t.Error("unknown area should be rejected")
+	}
+}
+
+func TestTokenScopesSurviveRefresh(t *testing.T) {
+	dir := t.TempDir()
+	um := NewUserManager()
+	o := NewOAuthServer(um, dir)
+
+	verifier := "verifier-0123456789"
+	sum := sha256.Sum256([]byte(verifier))
+	o.store.SaveAuthCode(&AuthCode{
+		Code: "code-1", ClientID: "client-1", RedirectURI: "https://client.example.com/cb",
+		Email: "a@example.com", Password: "pw",
+		CodeChallenge: base64.RawURLEncoding.EncodeToString(sum[:]),
+		Scope:         "things:read", Areas: []string{"area-1"},
+		ExpiresAt: time.Now().Add(time.Minute),
+	})
+	token := func(o *OAuthServer, form url.Values) map[string]any {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		o.handleToken(rec, req)
+		var resp map[string]any
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("token: %d %v", rec.Code, resp)
+		}
+		claims, err := o.parseJWT(resp["access_token"].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp["scope"] != "things:read" || claims["scope"] != "things:read" {
+			t.Errorf("scope: response %v, claims %v", resp["scope"], claims["scope"])
+		}
+		if areas, _ := claims["areas"].([]any); len(areas) != 1 || areas[0] != "area-1" {
+			t.Errorf("areas claim: %v", claims["areas"])
+		}
+		return resp
+	}
+
+	resp := token(o, url.Values{
+		"grant_type": {"authorization_code"}, "code": {"code-1"}, "client_id": {"client-1"},
+		"redirect_uri": {"https://client.example.com/cb"}, "code_verifier": {verifier},
+	})
+	o.db.Close()
+
+	// The refresh token keeps its scope and areas across a restart.
+	o = NewOAuthServer(um, dir)
+	defer o.db.Close()
+	token(o, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {resp["refresh_token"].(string)}})
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Storage backends
+// ---------------------------------------------------------------------------
+
+// Storage persists the OAuth server's state. The server keeps none of it
+// in memory, so that instances sharing a backend also share clients,
+// sessions and revocations. Passwords are stored as given; the server
+// seals them first (see credentialCipher).
+//
+// The other stores (calendar feeds, webhooks, audit log, confirmation
+// settings) keep their tables in the same database, through DB.
+type Storage interface {
+	SaveClient(c *OAuthClient) error
+	Client(clientID string) (*OAuthClient, error)
+
+	SaveAuthCode(ac *AuthCode) error
+	AuthCode(code string) (*AuthCode, error)
+	// UseAuthCode marks a code used and reports whether it was unused
+	// before, so that only one instance redeems it.
+	UseAuthCode(code string) (bool, error)
+
+	SaveRefreshToken(rt *RefreshToken) error
+	RefreshToken(token string) (*RefreshToken, error)
+	// DeleteRefreshToken reports whether the token existed, so that only
+	// one instance rotates it.
+	DeleteRefreshToken(token string) (bool, error)
+	RefreshTokens(email string) ([]*RefreshToken, error)
+	DeleteRefreshTokens(email, clientID string) error
+
+	SaveCredentials(email, password string) error
+	Credentials(email string) (string, error)
+
+	SaveDiagnosis(token, email, reportJSON string, createdAt time.Time) error
+	Diagnosis(token string) (reportJSON, email string, createdAt time.Time, err error)
+
+	// Revoke records that the tokens matching key were revoked at at;
+	// RevokedAt returns the latest such time of any of keys, or zero.
+	Revoke(key string, at time.Time) error
+	RevokedAt(keys []string) (time.Time, error)
+
+	SaveClientUsage(email, clientID string, at time.Time) error
+	ClientUsage(email string) (map[string]time.Time, error)
+	DeleteClientUsage(email, clientID string) error
+
+	// Heartbeat records that a cluster member was alive at at; Members
+	// lists those seen since since, and LeaveCluster forgets one.
+	Heartbeat(member string, at time.Time) error
+	Members(since time.Time) ([]string, error)
+	LeaveCluster(member string) error
+
+	Get(key string) (string, error)
+	Set(key, value string) error
+	// SetDefault stores value unless key already has one, and returns the
+	// value stored, so that instances starting together agree on it.
+	SetDefault(key, value string) (string, error)
+
+	// Expire deletes what can no longer be used as of now: expired auth
+	// codes and refresh tokens, revocations older than any access token,
+	// and diagnoses past diagRetention.
+	Expire(now time.Time) error
+
+	DB() *sqlDB
+	Close() error
+}
+
+// errNotFound is returned by Storage lookups that find nothing.
+var errNotFound = errors.New("not found")
+
+// openStorage opens the PostgreSQL database named by DATABASE_URL, or the
+// SQLite file oauth.db in dataDir.
+func openStorage(dataDir string) (Storage, error) {
+	if dsn := os.Getenv("DATABASE_URL"); dsn != "" {
+		logOAuth.Info("using PostgreSQL storage")
+		return openPostgresStorage(dsn)
+	}
+	logOAuth.Info("using SQLite storage", "dir", dataDir)
+	return openSQLiteStorage(dataDir)
+}
+
+// sqlDB is a database with the SQL dialect its queries are rewritten for.
+// Queries are written for SQLite with ? placeholders, in SQL that
+// PostgreSQL understands as well.
+type sqlDB struct {
+	*sql.DB
+	rebind func(string) string
+}
+
+func (d *sqlDB) Exec(query string, args ...any) (sql.Result, error) {
+	return d.DB.Exec(d.rebind(query), args...)
+}
+
+func (d *sqlDB) Query(query string, args ...any) (*sql.Rows, error) {
+	return d.DB.Query(d.rebind(query), args...)
+}
+
+func (d *sqlDB) QueryRow(query string, args ...any) *sql.Row {
+	return d.DB.QueryRow(d.rebind(query), args...)
+}
+
+func (d *sqlDB) Begin() (*sqlTx, error) {
+	tx, err := d.DB.BeginTx(context.Background(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlTx{Tx: tx, rebind: d.rebind}, nil
+}
+
+// sqlTx is a transaction of a sqlDB.
+type sqlTx struct {
+	*sql.Tx
+	rebind func(string) string
+}
+
+func (tx *sqlTx) Exec(query string, args ...any) (sql.Result, error) {
+	return tx.Tx.Exec(tx.rebind(query), args...)
+}
+
+func (tx *sqlTx) Query(query string, args ...any) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.rebind(query), args...)
+}
+
+// sqlStorage implements Storage on a sqlDB; the backends differ only in
+// how they open the database and create the schema.
+type sqlStorage struct {
+	db *sqlDB
+}
+
+func (s *sqlStorage) DB() *sqlDB   { return s.db }
+func (s *sqlStorage) Close() error { return s.db.Close() }
+
+// migrate creates the schema. Statements in alter fail harmlessly once
+// they have been applied.
+func (s *sqlStorage) migrate(schema, alter []string) error {
+	for _, ddl := range schema {
+		if _, err := s.db.Exec(ddl); err != nil {
+			return err
+		}
+	}
+	for _, ddl := range alter {
+		s.db.Exec(ddl)
+	}
+	return nil
+}
+
+func (s *sqlStorage) SaveClient(c *OAuthClient) error {
+	redirectURIs, _ := json.Marshal(c.RedirectURIs)
+	grantTypes, _ := json.Marshal(c.GrantTypes)
+	responseTypes, _ := json.Marshal(c.ResponseTypes)
+	_, err := s.db.Exec(
+		`INSERT INTO clients (client_id, client_secret, client_name, redirect_uris, grant_types, response_types, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		c.ClientID, c.ClientSecret, c.ClientName, string(redirectURIs), string(grantTypes), string(responseTypes),
+		c.CreatedAt.UTC().Format(time.RFC3339),
+	)
+	return err
+}
+
+func (s *sqlStorage) Client(clientID string) (*OAuthClient, error) {
+	var c OAuthClient
+	var redirectURIs, grantTypes, responseTypes, createdAt string
+	err := s.db.QueryRow(
+		`SELECT client_id, client_secret, client_name, redirect_uris, grant_types, response_types, created_at
+		FROM clients WHERE client_id = ?`, clientID,
+	).Scan(&c.ClientID, &c.ClientSecret, &c.ClientName, &redirectURIs, &grantTypes, &responseTypes, &createdAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	json.Unmarshal([]byte(redirectURIs), &c.RedirectURIs)
+	json.Unmarshal([]byte(grantTypes), &c.GrantTypes)
+	json.Unmarshal([]byte(responseTypes), &c.ResponseTypes)
+	c.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+	return &c, nil
+}
+
+func (s *sqlStorage) SaveAuthCode(ac *AuthCode) error {
+	_, err := s.db.Exec(
+		`INSERT INTO auth_codes (code, client_id, redirect_uri, email, password, code_challenge, scope, areas, expires_at, used)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		ac.Code, ac.ClientID, ac.RedirectURI, ac.Email, ac.Password, ac.CodeChallenge, ac.Scope,
+		strings.Join(ac.Areas, ","), ac.ExpiresAt.UTC().Format(time.RFC3339), ac.Used,
+	)
+	return err
+}
+
+func (s *sqlStorage) AuthCode(code string) (*AuthCode, error) {
+	var ac AuthCode
+	var areas, expiresAt string
+	err := s.db.QueryRow(
+		`SELECT code, client_id, redirect_uri, email, password, code_challenge, scope, areas, expires_at, used
+		FROM auth_codes WHERE code = ?`, code,
+	).Scan(&ac.Code, &ac.ClientID, &ac.RedirectURI, &ac.Email, &ac.Password, &ac.CodeChallenge, &ac.Scope, &areas, &expiresAt, &ac.Used)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	ac.Areas = splitList(areas)
+	ac.ExpiresAt, _ = time.Parse(time.RFC3339, expiresAt)
+	return &ac, nil
+}
+
+func (s *sqlStorage) UseAuthCode(code string) (bool, error) {
+	return s.changed(s.db.Exec(`UPDATE auth_codes SET used = ? WHERE code = ? AND NOT used`, true, code))
+}
+
+func (s *sqlStorage) SaveRefreshToken(rt *RefreshToken) error {
+	_, err := s.db.Exec(
+		`INSERT INTO refresh_tokens (token, email, password, client_id, scope, areas, grant_id, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		rt.Token, rt.Email, rt.Password, rt.ClientID, rt.Scope, strings.Join(rt.Areas, ","), rt.GrantID,
+		rt.ExpiresAt.UTC().Format(time.RFC3339),
+	)
+	return err
+}
+
+const refreshTokenColumns = `token, email, password, client_id, scope, areas, grant_id, expires_at`
+
+func scanRefreshToken(scan func(...any) error) (*RefreshToken, error) {
+	var rt RefreshToken
+	var areas, expiresAt string
+	if err := scan(&rt.Token, &rt.Email, &rt.Password, &rt.ClientID, &rt.Scope, &areas, &rt.GrantID, &expiresAt); err != nil {
+		return nil, err
+	}
+	rt.Areas = splitList(areas)
+	rt.ExpiresAt, _ = time.Parse(time.RFC3339, expiresAt)
+	return &rt, nil
+}
+
+func (s *sqlStorage) RefreshToken(token string) (*RefreshToken, error) {
+	rt, err := scanRefreshToken(s.db.QueryRow(`SELECT `+refreshTokenColumns+` FROM refresh_tokens WHERE token = ?`, token).Scan)
+	return rt, notFound(err)
+}
+
+func (s *sqlStorage) DeleteRefreshToken(token string) (bool, error) {
+	return s.changed(s.db.Exec(`DELETE FROM refresh_tokens WHERE token = ?`, token))
+}
+
+func (s *sqlStorage) RefreshTokens(email string) ([]*RefreshToken, error) {
+	rows, err := s.db.Query(`SELECT `+refreshTokenColumns+` FROM refresh_tokens WHERE email = ?`, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*RefreshToken
+	for rows.Next() {
+		rt, err := scanRefreshToken(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rt)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqlStorage) DeleteRefreshTokens(email, clientID string) error {
+	_, err := s.db.Exec(`DELETE FROM refresh_tokens WHERE email = ? AND client_id = ?`, email, clientID)
+	return err
+}
+
+func (s *sqlStorage) SaveCredentials(email, password string) error {
+	_, err := s.db.Exec(
+		`INSERT INTO credentials (email, password) VALUES (?, ?)
+		ON CONFLICT (email) DO UPDATE SET password = excluded.password`,
+		email, password,
+	)
+	return err
+}
+
+func (s *sqlStorage) Credentials(email string) (string, error) {
+	var password string
+	err := s.db.QueryRow(`SELECT password FROM credentials WHERE email = ?`, email).Scan(&password)
+	return password, notFound(err)
+}
+
+func (s *sqlStorage) SaveDiagnosis(token, email, reportJSON string, createdAt time.Time) error {
+	_, err := s.db.Exec(
+		`INSERT INTO diagnoses (token, report_json, email, created_at) VALUES (?, ?, ?, ?)`,
+		token, reportJSON, email, createdAt.UTC().Format(time.RFC3339),
+	)
+	return err
+}
+
+func (s *sqlStorage) Diagnosis(token string) (reportJSON, email string, createdAt time.Time, err error) {
+	var createdAtStr string
+	err = s.db.QueryRow(
+		`SELECT report_json, email, created_at FROM diagnoses WHERE token = ?`, token,
+	).Scan(&reportJSON, &email, &createdAtStr)
+	if err != nil {
+		return "", "", time.Time{}, notFound(err)
+	}
+	createdAt, _ = time.Parse(time.RFC3339, createdAtStr)
+	return reportJSON, email, createdAt, nil
+}
+
+func (s *sqlStorage) Revoke(key string, at time.Time) error {
+	_, err := s.db.Exec(
+		`INSERT INTO revocations (key, revoked_at) VALUES (?, ?)
+		ON CONFLICT (key) DO UPDATE SET revoked_at = excluded.revoked_at`,
+		key, at.Unix(),
+	)
+	return err
+}
+
+func (s *sqlStorage) RevokedAt(keys []string) (time.Time, error) {
+	if len(keys) == 0 {
+		return time.Time{}, nil
+	}
+	args := make([]any, len(keys))
+	for i, k := range keys {
+		args[i] = k
+	}
+	var at sql.NullInt64
+	err := s.db.QueryRow(
+		`SELECT MAX(revoked_at) FROM revocations WHERE key IN (?`+strings.Repeat(", ?", len(keys)-1)+`)`, args...,
+	).Scan(&at)
+	if err != nil || !at.Valid {
+		return time.Time{}, err
+	}
+	return time.Unix(at.Int64, 0), nil
+}
+
+func (s *sqlStorage) SaveClientUsage(email, clientID string, at time.Time) error {
+	_, err := s.db.Exec(
+		`INSERT INTO client_usage (email, client_id, last_used_at) VALUES (?, ?, ?)
+		ON CONFLICT (email, client_id) DO UPDATE SET last_used_at = excluded.last_used_at`,
+		email, clientID, at.Unix(),
+	)
+	return err
+}
+
+func (s *sqlStorage) ClientUsage(email string) (map[string]time.Time, error) {
+	rows, err := s.db.Query(`SELECT client_id, last_used_at FROM client_usage WHERE email = ?`, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]time.Time{}
+	for rows.Next() {
+		var clientID string
+		var at int64
+		if err := rows.Scan(&clientID, &at); err != nil {
+			return nil, err
+		}
+		out[clientID] = time.Unix(at, 0)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqlStorage) DeleteClientUsage(email, clientID string) error {
+	_, err := s.db.Exec(`DELETE FROM client_usage WHERE email = ? AND client_id = ?`, email, clientID)
+	return err
+}
+
+func (s *sqlStorage) Heartbeat(member string, at time.Time) error {
+	_, err := s.db.Exec(
+		`INSERT INTO cluster_members (url, seen_at) VALUES (?, ?)
+		ON CONFLICT (url) DO UPDATE SET seen_at = excluded.seen_at`,
+		member, at.Unix(),
+	)
+	return err
+}
+
+func (s *sqlStorage) Members(since time.Time) ([]string, error) {
+	rows, err := s.db.Query(`SELECT url FROM cluster_members WHERE seen_at >= ? ORDER BY url`, since.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var member string
+		if err := rows.Scan(&member); err != nil {
+			return nil, err
+		}
+		out = append(out, member)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqlStorage) LeaveCluster(member string) error {
+	_, err := s.db.Exec(`DELETE FROM cluster_members WHERE url = ?`, member)
+	return err
+}
+
+func (s *sqlStorage) Get(key string) (string, error) {
+	var value string
+	err := s.db.QueryRow(`SELECT value FROM kv WHERE key = ?`, key).Scan(&value)
+	return value, notFound(err)
+}
+
+func (s *sqlStorage) Set(key, value string) error {
+	_, err := s.db.Exec(
+		`INSERT INTO kv (key, value) VALUES (?, ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value`,
+		key, value,
+	)
+	return err
+}
+
+func (s *sqlStorage) SetDefault(key, value string) (string, error) {
+	if _, err := s.db.Exec(`INSERT INTO kv (key, value) VALUES (?, ?) ON CONFLICT (key) DO NOTHING`, key, value); err != nil {
+		return "", err
+	}
+	return s.Get(key)
+}
+
+func (s *sqlStorage) Expire(now time.Time) error {
+	stamp := now.UTC().Format(time.RFC3339)
+	_, err1 := s.db.Exec(`DELETE FROM auth_codes WHERE expires_at < ?`, stamp)
+	_, err2 := s.db.Exec(`DELETE FROM refresh_tokens WHERE expires_at < ?`, stamp)
+	_, err3 := s.db.Exec(`DELETE FROM revocations WHERE revoked_at < ?`, now.Add(-accessTokenTTL).Unix())
+	_, err4 := s.db.Exec(`DELETE FROM diagnoses WHERE created_at < ?`, now.Add(-diagRetention).UTC().Format(time.RFC3339))
+	return errors.Join(err1, err2, err3, err4)
+}
+
+// changed reports whether a statement changed any row.
+func (s *sqlStorage) changed(res sql.Result, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// notFound maps sql.ErrNoRows to errNotFound.
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return errNotFound
+	}
+	return err
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// postgresSchema mirrors sqliteSchema. webhook_deliveries gets a rowid
+// column, which SQLite tables have implicitly, to order deliveries by.
+var postgresSchema = []string{
+	`CREATE TABLE IF NOT EXISTS kv (key TEXT PRIMARY KEY, value TEXT NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS clients (
+		client_id TEXT PRIMARY KEY, client_secret TEXT DEFAULT '',
+		client_name TEXT NOT NULL, redirect_uris TEXT NOT NULL,
+		grant_types TEXT NOT NULL, response_types TEXT NOT NULL,
+		created_at TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS auth_codes (
+		code TEXT PRIMARY KEY, client_id TEXT NOT NULL, redirect_uri TEXT NOT NULL,
+		email TEXT NOT NULL, password TEXT NOT NULL, code_challenge TEXT NOT NULL,
+		scope TEXT NOT NULL, areas TEXT NOT NULL, expires_at TEXT NOT NULL,
+		used BOOLEAN NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS refresh_tokens (
+		token TEXT PRIMARY KEY, email TEXT NOT NULL, password TEXT NOT NULL,
+		client_id TEXT NOT NULL, scope TEXT NOT NULL DEFAULT '',
+		areas TEXT NOT NULL DEFAULT '', grant_id TEXT NOT NULL DEFAULT '',
+		expires_at TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS refresh_tokens_email ON refresh_tokens (email, client_id)`,
+	`CREATE TABLE IF NOT EXISTS credentials (email TEXT PRIMARY KEY, password TEXT NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS diagnoses (
+		token TEXT PRIMARY KEY, report_json TEXT NOT NULL,
+		email TEXT NOT NULL, created_at TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS calendar_feeds (
+		token TEXT PRIMARY KEY, email TEXT NOT NULL, created_at TEXT NOT NULL,
+		filter TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE TABLE IF NOT EXISTS webhooks (
+		id TEXT PRIMARY KEY, email TEXT NOT NULL, url TEXT NOT NULL,
+		secret TEXT NOT NULL, events TEXT NOT NULL, created_at TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		rowid BIGSERIAL PRIMARY KEY,
+		delivery_id TEXT NOT NULL, webhook_id TEXT NOT NULL, email TEXT NOT NULL,
+		event TEXT NOT NULL, attempt INTEGER NOT NULL, status_code INTEGER NOT NULL,
+		error TEXT NOT NULL, delivered BOOLEAN NOT NULL, created_at TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS audit_log (
+		id BIGSERIAL PRIMARY KEY, email TEXT NOT NULL,
+		client_id TEXT NOT NULL, client_name TEXT NOT NULL, tool TEXT NOT NULL,
+		arguments TEXT NOT NULL, payload TEXT NOT NULL, items TEXT NOT NULL,
+		head_index BIGINT NOT NULL, latency_ms BIGINT NOT NULL,
+		error TEXT NOT NULL, created_at TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS audit_log_email ON audit_log (email, created_at)`,
+	`CREATE TABLE IF NOT EXISTS confirm_settings (email TEXT PRIMARY KEY, threshold INTEGER NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS revocations (key TEXT PRIMARY KEY, revoked_at BIGINT NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS client_usage (
+		email TEXT NOT NULL, client_id TEXT NOT NULL, last_used_at BIGINT NOT NULL,
+		PRIMARY KEY (email, client_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS cluster_members (url TEXT PRIMARY KEY, seen_at BIGINT NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS proxy_assignments (email TEXT PRIMARY KEY, proxy TEXT NOT NULL, assigned_at BIGINT NOT NULL)`,
+}
+
+// postgresAlter adds the columns added after the first release.
+var postgresAlter = []string{
+	`ALTER TABLE calendar_feeds ADD COLUMN IF NOT EXISTS filter TEXT NOT NULL DEFAULT ''`,
+}
+
+// openPostgresStorage connects to the PostgreSQL database at dsn, a URL
+// or key=value connection string, and creates the tables it lacks.
+func openPostgresStorage(dsn string) (*sqlStorage, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open PostgreSQL database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connect to PostgreSQL: %w", err)
+	}
+	s := &sqlStorage{db: &sqlDB{DB: db, rebind: rebindDollar}}
+	if err := s.migrate(postgresSchema, postgresAlter); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create tables: %w", err)
+	}
+	return s, nil
+}
+
+// rebindDollar numbers the ? placeholders of a query $1, $2, ... for
+// PostgreSQL, leaving those in quoted strings and identifiers alone.
+func rebindDollar(query string) string {
+	if !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
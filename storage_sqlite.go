@@ -0,0 +1,92 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema is the schema of oauth.db. Timestamps compared in SQL are
+// RFC 3339 text or Unix seconds; flags are 0 or 1.
+var sqliteSchema = []string{
+	`CREATE TABLE IF NOT EXISTS kv (key TEXT PRIMARY KEY, value TEXT NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS clients (
+		client_id TEXT PRIMARY KEY, client_secret TEXT DEFAULT '',
+		client_name TEXT NOT NULL, redirect_uris TEXT NOT NULL,
+		grant_types TEXT NOT NULL, response_types TEXT NOT NULL,
+		created_at TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS auth_codes (
+		code TEXT PRIMARY KEY, client_id TEXT NOT NULL, redirect_uri TEXT NOT NULL,
+		email TEXT NOT NULL, password TEXT NOT NULL, code_challenge TEXT NOT NULL,
+		scope TEXT NOT NULL, areas TEXT NOT NULL, expires_at TEXT NOT NULL,
+		used INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS refresh_tokens (
+		token TEXT PRIMARY KEY, email TEXT NOT NULL, password TEXT NOT NULL,
+		client_id TEXT NOT NULL, expires_at TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS credentials (email TEXT PRIMARY KEY, password TEXT NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS diagnoses (
+		token TEXT PRIMARY KEY, report_json TEXT NOT NULL,
+		email TEXT NOT NULL, created_at TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS calendar_feeds (
+		token TEXT PRIMARY KEY, email TEXT NOT NULL, created_at TEXT NOT NULL,
+		filter TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE TABLE IF NOT EXISTS webhooks (
+		id TEXT PRIMARY KEY, email TEXT NOT NULL, url TEXT NOT NULL,
+		secret TEXT NOT NULL, events TEXT NOT NULL, created_at TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		delivery_id TEXT NOT NULL, webhook_id TEXT NOT NULL, email TEXT NOT NULL,
+		event TEXT NOT NULL, attempt INTEGER NOT NULL, status_code INTEGER NOT NULL,
+		error TEXT NOT NULL, delivered INTEGER NOT NULL, created_at TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, email TEXT NOT NULL,
+		client_id TEXT NOT NULL, client_name TEXT NOT NULL, tool TEXT NOT NULL,
+		arguments TEXT NOT NULL, payload TEXT NOT NULL, items TEXT NOT NULL,
+		head_index INTEGER NOT NULL, latency_ms INTEGER NOT NULL,
+		error TEXT NOT NULL, created_at TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS audit_log_email ON audit_log (email, created_at)`,
+	`CREATE TABLE IF NOT EXISTS confirm_settings (email TEXT PRIMARY KEY, threshold INTEGER NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS revocations (key TEXT PRIMARY KEY, revoked_at INTEGER NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS client_usage (
+		email TEXT NOT NULL, client_id TEXT NOT NULL, last_used_at INTEGER NOT NULL,
+		PRIMARY KEY (email, client_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS cluster_members (url TEXT PRIMARY KEY, seen_at INTEGER NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS proxy_assignments (email TEXT PRIMARY KEY, proxy TEXT NOT NULL, assigned_at INTEGER NOT NULL)`,
+}
+
+// sqliteAlter adds the columns added after the first release.
+var sqliteAlter = []string{
+	`ALTER TABLE refresh_tokens ADD COLUMN scope TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE refresh_tokens ADD COLUMN areas TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE refresh_tokens ADD COLUMN grant_id TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE calendar_feeds ADD COLUMN filter TEXT NOT NULL DEFAULT ''`,
+}
+
+// openSQLiteStorage opens (creating it if needed) oauth.db in dataDir.
+// Several processes may share the file, e.g. on one host.
+func openSQLiteStorage(dataDir string) (*sqlStorage, error) {
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return nil, fmt.Errorf("create data directory: %w", err)
+	}
+	db, err := sql.Open("sqlite", filepath.Join(dataDir, "oauth.db")+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("open SQLite database: %w", err)
+	}
+	s := &sqlStorage{db: &sqlDB{DB: db, rebind: func(q string) string { return q }}}
+	if err := s.migrate(sqliteSchema, sqliteAlter); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create tables: %w", err)
+	}
+	return s, nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+// storageBackends returns the backends to test: SQLite always, and
+// PostgreSQL when TEST_DATABASE_URL names a database the test may wipe.
+func storageBackends(t *testing.T) map[string]func(t *testing.T) Storage {
+	backends := map[string]func(t *testing.T) Storage{
+		"sqlite": func(t *testing.T) Storage {
+			s, err := openSQLiteStorage(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		},
+	}
+	if dsn := os.Getenv("TEST_DATABASE_URL"); dsn != "" {
+		backends["postgres"] = func(t *testing.T) Storage {
+			s, err := openPostgresStorage(dsn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, table := range []string{"kv", "clients", "auth_codes", "refresh_tokens", "credentials", "diagnoses", "revocations", "client_usage", "cluster_members", "proxy_assignments"} {
+				if _, err := s.DB().Exec(`DELETE FROM ` + table); err != nil {
+					t.Fatal(err)
+				}
+			}
+			return s
+		}
+	}
+	return backends
+}
+
+func TestStorageBackends(t *testing.T) {
+	for name, open := range storageBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			s := open(t)
+			defer s.Close()
+			now := time.Now().Truncate(time.Second)
+
+			s.SaveClient(&OAuthClient{ClientID: "c1", ClientName: "Claude", RedirectURIs: []string{"https://a/cb"}, CreatedAt: now})
+			if c, err := s.Client("c1"); err != nil || c.ClientName != "Claude" || c.RedirectURIs[0] != "https://a/cb" || !c.CreatedAt.Equal(now) {
+				t.Errorf("client: %+v %v", c, err)
+			}
+			if _, err := s.Client("nope"); err != errNotFound {
+				t.Errorf("unknown client: %v", err)
+			}
+
+			s.SaveAuthCode(&AuthCode{Code: "ac", ClientID: "c1", Email: "a@example.com", Password: "pw", Areas: []string{"x", "y"}, ExpiresAt: now.Add(time.Minute)})
+			if ac, err := s.AuthCode("ac"); err != nil || ac.Used || ac.Password != "pw" || len(ac.Areas) != 2 {
+				t.Errorf("auth code: %+v %v", ac, err)
+			}
+			if used, err := s.UseAuthCode("ac"); !used || err != nil {
+				t.Errorf("first use: %v %v", used, err)
+			}
+			if used, _ := s.UseAuthCode("ac"); used {
+				t.Error("auth code used twice")
+			}
+			if ac, _ := s.AuthCode("ac"); !ac.Used {
+				t.Error("auth code not marked used")
+			}
+
+			s.SaveRefreshToken(&RefreshToken{Token: "rt1", Email: "a@example.com", ClientID: "c1", Scope: "things:read", ExpiresAt: now.Add(time.Hour)})
+			s.SaveRefreshToken(&RefreshToken{Token: "rt2", Email: "a@example.com", ClientID: "c2", ExpiresAt: now.Add(time.Hour)})
+			if rt, err := s.RefreshToken("rt1"); err != nil || rt.Scope != "things:read" || rt.Areas != nil {
+				t.Errorf("refresh token: %+v %v", rt, err)
+			}
+			if deleted, _ := s.DeleteRefreshToken("rt1"); !deleted {
+				t.Error("refresh token not deleted")
+			}
+			if deleted, _ := s.DeleteRefreshToken("rt1"); deleted {
+				t.Error("refresh token deleted twice")
+			}
+			s.DeleteRefreshTokens("a@example.com", "c2")
+			if tokens, err := s.RefreshTokens("a@example.com"); len(tokens) != 0 || err != nil {
+				t.Errorf("refresh tokens left: %v %v", tokens, err)
+			}
+
+			s.SaveCredentials("a@example.com", "old")
+			s.SaveCredentials("a@example.com", "new")
+			if pw, err := s.Credentials("a@example.com"); pw != "new" || err != nil {
+				t.Errorf("credentials: %q %v", pw, err)
+			}
+
+			s.SaveDiagnosis("d1", "a***@example.com", `{"steps":[]}`, now)
+			if report, email, at, err := s.Diagnosis("d1"); err != nil || report != `{"steps":[]}` || email != "a***@example.com" || !at.Equal(now) {
+				t.Errorf("diagnosis: %q %q %v %v", report, email, at, err)
+			}
+
+			s.Revoke("jti:1", now.Add(-time.Minute))
+			s.Revoke("grant:1", now)
+			if at, err := s.RevokedAt([]string{"jti:1", "grant:1", "client:x"}); !at.Equal(now) || err != nil {
+				t.Errorf("revoked at: %v %v", at, err)
+			}
+			if at, _ := s.RevokedAt([]string{"client:x"}); !at.IsZero() {
+				t.Errorf("not revoked: %v", at)
+			}
+
+			s.SaveClientUsage("a@example.com", "c1", now.Add(-time.Minute))
+			s.SaveClientUsage("a@example.com", "c1", now)
+			if usage, _ := s.ClientUsage("a@example.com"); len(usage) != 1 || !usage["c1"].Equal(now) {
+				t.Errorf("usage: %v", usage)
+			}
+			s.DeleteClientUsage("a@example.com", "c1")
+			if usage, _ := s.ClientUsage("a@example.com"); len(usage) != 0 {
+				t.Errorf("usage after delete: %v", usage)
+			}
+
+			s.Heartbeat("http://b:8080", now.Add(-time.Minute))
+			s.Heartbeat("http://a:8080", now.Add(-time.Hour))
+			s.Heartbeat("http://a:8080", now)
+			if members, err := s.Members(now.Add(-2 * time.Minute)); len(members) != 2 || members[0] != "http://a:8080" || err != nil {
+				t.Errorf("members: %v %v", members, err)
+			}
+			s.LeaveCluster("http://b:8080")
+			if members, _ := s.Members(now.Add(-2 * time.Minute)); len(members) != 1 {
+				t.Errorf("members after leaving: %v", members)
+			}
+
+			if v, err := s.SetDefault("k", "first"); v != "first" || err != nil {
+				t.Errorf("set default: %q %v", v, err)
+			}
+			if v, _ := s.SetDefault("k", "second"); v != "first" {
+				t.Errorf("set default overwrote: %q", v)
+			}
+			s.Set("k", "third")
+			if v, _ := s.Get("k"); v != "third" {
+				t.Errorf("get: %q", v)
+			}
+
+			// Expire keeps what is still usable.
+			s.SaveRefreshToken(&RefreshToken{Token: "old", Email: "a@example.com", ExpiresAt: now.Add(-time.Hour)})
+			s.SaveRefreshToken(&RefreshToken{Token: "live", Email: "a@example.com", ExpiresAt: now.Add(time.Hour)})
+			s.Revoke("jti:old", now.Add(-2*accessTokenTTL))
+			if err := s.Expire(now.Add(2 * time.Minute)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.AuthCode("ac"); err != errNotFound {
+				t.Errorf("expired auth code kept: %v", err)
+			}
+			if _, err := s.RefreshToken("old"); err != errNotFound {
+				t.Errorf("expired refresh token kept: %v", err)
+			}
+			if _, err := s.RefreshToken("live"); err != nil {
+				t.Errorf("live refresh token deleted: %v", err)
+			}
+			if at, _ := s.RevokedAt([]string{"jti:old", "grant:1"}); !at.Equal(now) {
+				t.Errorf("revocations after expiry: %v", at)
+			}
+		})
+	}
+}
+
+func TestRebindDollar(t *testing.T) {
+	for in, want := range map[string]string{
+		`SELECT 1`:                             `SELECT 1`,
+		`DELETE FROM t WHERE a = ? AND b = ?`:  `DELETE FROM t WHERE a = $1 AND b = $2`,
+		`SELECT '?' FROM t WHERE "a?" = ?`:     `SELECT '?' FROM t WHERE "a?" = $1`,
+		`INSERT INTO t VALUES (?, 'it''s', ?)`: `INSERT INTO t VALUES ($1, 'it''s', $2)`,
+	} {
+		if got := rebindDollar(in); got != want {
+			t.Errorf("rebindDollar(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// Two servers on one storage behave as one: a session started on either
+// works on both, and a code or refresh token is redeemed only once.
+func TestServersShareSessions(t *testing.T) {
+	dir := t.TempDir()
+	a := NewOAuthServer(NewUserManager(), dir)
+	defer a.store.Close()
+	b := NewOAuthServer(NewUserManager(), dir)
+	defer b.store.Close()
+	if string(a.jwtSecret) != string(b.jwtSecret) {
+		t.Fatal("servers disagree on the JWT secret")
+	}
+	a.store.SaveClient(&OAuthClient{ClientID: "client-1", ClientName: "Claude"})
+	verifier := "verifier-0123456789"
+	sum := sha256.Sum256([]byte(verifier))
+	a.saveCredentials("a@example.com", "pw")
+	a.store.SaveAuthCode(&AuthCode{
+		Code: "code-1", ClientID: "client-1", RedirectURI: "https://client.example.com/cb",
+		Email: "a@example.com", Password: "pw", Scope: "things:read",
+		CodeChallenge: base64.RawURLEncoding.EncodeToString(sum[:]),
+		ExpiresAt:     time.Now().Add(time.Minute),
+	})
+
+	// The code issued by a is redeemed on b, once.
+	grant := url.Values{
+		"grant_type": {"authorization_code"}, "code": {"code-1"}, "client_id": {"client-1"},
+		"redirect_uri": {"https://client.example.com/cb"}, "code_verifier": {verifier},
+	}
+	status, resp := postForm(b.handleToken, grant)
+	if status != http.StatusOK {
+		t.Fatalf("token on b: %d %v", status, resp)
+	}
+	if status, _ := postForm(a.handleToken, grant); status != http.StatusBadRequest {
+		t.Errorf("code redeemed again on a: %d", status)
+	}
+	access, refresh := resp["access_token"].(string), resp["refresh_token"].(string)
+	if email, password, err := a.ResolveBearer(access); err != nil || email != "a@example.com" || password != "pw" {
+		t.Errorf("access token on a: %q %q %v", email, password, err)
+	}
+
+	// The refresh token is rotated on a and gone on b.
+	refreshForm := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refresh}}
+	if status, resp := postForm(a.handleToken, refreshForm); status != http.StatusOK {
+		t.Fatalf("refresh on a: %d %v", status, resp)
+	}
+	if status, _ := postForm(b.handleToken, refreshForm); status != http.StatusBadRequest {
+		t.Errorf("old refresh token accepted on b: %d", status)
+	}
+
+	// Signing the client out on b stops its tokens on a.
+	b.revokeClient("a@example.com", "client-1")
+	if _, _, err := a.ResolveBearer(access); err == nil {
+		t.Error("revoked access token still resolves on a")
+	}
+	if apps := a.connectedApps("a@example.com"); len(apps) != 0 {
+		t.Errorf("client still connected on a: %+v", apps)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -14,6 +15,7 @@ import (
 	thingscloud "github.com/arthursoares/things-cloud-sdk"
 	memory "github.com/arthursoares/things-cloud-sdk/state/memory"
 	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
 )
 
 // ---------------------------------------------------------------------------
@@ -29,6 +31,9 @@ type fakeCloud struct {
 
 	mu        sync.Mutex
 	commitLog []json.RawMessage // captured POST bodies
+	remote    []thingscloud.Item // see pushRemote
+	racing    []thingscloud.Item // see pushRacing
+	refetch   []thingscloud.Item // racing items, served again after remote
 }
 
 func newFakeCloud(email string, items ...thingscloud.Item) *fakeCloud {
@@ -76,10 +81,16 @@ func newFakeCloud(email string, items ...thingscloud.Item) *fakeCloud {
 		startIdx := r.URL.Query().Get("start-index")
 		w.Header().Set("Content-Type", "application/json")
 
-		if startIdx == "0" && len(fc.items) > 0 {
-			// Return all items on first fetch
-			wireItems := make([]map[string]any, len(fc.items))
-			for i, item := range fc.items {
+		items := fc.items
+		if startIdx != "0" {
+			fc.mu.Lock()
+			items, fc.remote, fc.refetch = fc.remote, fc.refetch, nil
+			fc.mu.Unlock()
+		}
+		if len(items) > 0 {
+			// Return all items on first fetch, and remote changes after
+			wireItems := make([]map[string]any, len(items))
+			for i, item := range items {
 				itemData := map[string]any{
 					"p": json.RawMessage(item.P),
 					"e": item.Kind,
@@ -108,6 +119,15 @@ func newFakeCloud(email string, items ...thingscloud.Item) *fakeCloud {
 	mux.HandleFunc("POST /version/1/history/{id}/commit", func(w http.ResponseWriter, r *http.Request) {
 		body, _ := io.ReadAll(r.Body)
 		fc.mu.Lock()
+		if len(fc.racing) > 0 {
+			fc.remote = append(fc.remote, fc.racing...)
+			fc.refetch = fc.racing
+			fc.itemIndex += len(fc.racing)
+			fc.racing = nil
+			fc.mu.Unlock()
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
 		fc.commitLog = append(fc.commitLog, json.RawMessage(body))
 		fc.itemIndex++
 		fc.mu.Unlock()
@@ -126,6 +146,25 @@ func (fc *fakeCloud) Close() {
 	fc.server.Close()
 }
 
+// pushRemote simulates changes made on another device: the next
+// incremental fetch returns them.
+func (fc *fakeCloud) pushRemote(items ...thingscloud.Item) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.remote = append(fc.remote, items...)
+	fc.itemIndex += len(items)
+}
+
+// pushRacing simulates changes another device commits just before the
+// next commit: that commit is refused with 409 and the items are served as
+// remote changes twice: to the client looking for what it conflicts with,
+// and to the sync after that.
+func (fc *fakeCloud) pushRacing(items ...thingscloud.Item) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.racing = append(fc.racing, items...)
+}
+
 func (fc *fakeCloud) getCommitLog() []json.RawMessage {
 	fc.mu.Lock()
 	defer fc.mu.Unlock()
@@ -161,6 +200,37 @@ func newTestThingsMCP(t *testing.T, fc *fakeCloud) *ThingsMCP {
 	return tmcp
 }
 
+// newTestToolServer serves a fake Things Cloud account for email holding
+// items. It returns a UserManager with the account loaded, the fake, the
+// tools by name and a context signed in to the account with Basic auth.
+func newTestToolServer(t *testing.T, email string, items ...thingscloud.Item) (*UserManager, *fakeCloud, map[string]server.ServerTool, context.Context) {
+	t.Helper()
+	fc := newFakeCloud(email, items...)
+	t.Cleanup(fc.Close)
+	um := NewUserManager()
+	um.users[email] = newTestThingsMCP(t, fc)
+	ctx := context.WithValue(context.Background(), userContextKey, &UserInfo{Email: email, Password: "testpass"})
+	return um, fc, toolsByName(um), ctx
+}
+
+// newTestOAuth gives um an OAuth server on a temporary database, which the
+// stores a test attaches can share.
+func newTestOAuth(t *testing.T, um *UserManager) *OAuthServer {
+	t.Helper()
+	um.oauth = NewOAuthServer(um, t.TempDir())
+	t.Cleanup(func() { um.oauth.db.Close() })
+	return um.oauth
+}
+
+// toolsByName returns the tools of defineTools by name.
+func toolsByName(um *UserManager) map[string]server.ServerTool {
+	tools := map[string]server.ServerTool{}
+	for _, st := range defineTools(um) {
+		tools[st.Tool.Name] = st
+	}
+	return tools
+}
+
 // newTestThingsMCPDirect creates a ThingsMCP with pre-built state (no HTTP).
 // Useful for handler tests that don't need write operations.
 func newTestThingsMCPDirect(state *memory.State) *ThingsMCP {
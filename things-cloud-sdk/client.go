@@ -1,15 +1,16 @@
 package thingscloud
 
 import (
-	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"sync"
 	"time"
 
 	"golang.org/x/time/rate"
@@ -95,21 +96,47 @@ type Client struct {
 
 	client      *http.Client
 	rateLimiter *rate.Limiter
+	retry       RetryPolicy
+	hooks       Hooks
+	logger      *slog.Logger
 	common      service
 
+	mu             sync.Mutex
+	throttledUntil time.Time // set from 429 responses
+	throttledAbuse bool
+
 	Accounts *AccountService
 }
 
 // ClientOption allows customizing the things client before it is returned.
 type ClientOption func(*Client)
 
-// WithProxy configures the HTTP client to use the provided proxy URL.
+// WithProxy configures the HTTP client to use the provided proxy URL:
+// http://, https://, socks5:// or socks5h:// (resolving names on the
+// proxy), with credentials in the URL's user info.
 func WithProxy(proxyURL *url.URL) ClientOption {
+	return WithProxyFunc(http.ProxyURL(proxyURL))
+}
+
+// WithProxyFunc is WithProxy with the proxy chosen for each request, as by
+// http.Transport.Proxy, so that it can change during the client's life. A
+// nil URL sends the request directly.
+func WithProxyFunc(proxy func(*http.Request) (*url.URL, error)) ClientOption {
 	return func(c *Client) {
 		if c.client == nil {
 			c.client = &http.Client{}
 		}
-		c.client.Transport = &http.Transport{Proxy: http.ProxyURL(proxyURL)}
+		c.client.Transport = &http.Transport{Proxy: proxy}
+	}
+}
+
+// WithLogger sets the logger for request attempts, retries, throttling
+// and failed commits. Attempts are logged at debug level. Requests made
+// with a context pass it on, so a handler can add e.g. a request ID. The
+// default is slog.Default().
+func WithLogger(l *slog.Logger) ClientOption {
+	return func(c *Client) {
+		c.logger = l
 	}
 }
 
@@ -124,8 +151,10 @@ func New(endpoint, email, password string, opts ...ClientOption) *Client {
 		EMail:       email,
 		password:    password,
 		ClientInfo:  DefaultClientInfo(),
-		rateLimiter: rate.NewLimiter(rate.Every(time.Second), 1),
+		rateLimiter: newRateLimiter(time.Second, 1),
+		retry:       DefaultRetryPolicy(),
 		client:      &http.Client{},
+		logger:      slog.Default(),
 	}
 	for _, opt := range opts {
 		opt(c)
@@ -138,11 +167,14 @@ func New(endpoint, email, password string, opts ...ClientOption) *Client {
 // ThingsUserAgent is the http user-agent header set by things for mac
 const ThingsUserAgent = "ThingsMac/32209501"
 
-func (c *Client) do(req *http.Request) (*http.Response, error) {
-	if err := c.rateLimiter.Wait(context.Background()); err != nil {
-		return nil, fmt.Errorf("rate limit wait: %w", err)
-	}
+func newRateLimiter(interval time.Duration, burst int) *rate.Limiter {
+	return rate.NewLimiter(rate.Every(interval), burst)
+}
 
+// do sends a request with the headers Things.app uses. Network errors, 5xx
+// and 429 responses are retried according to the client's RetryPolicy;
+// the last response is returned as is.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
 	if req.Host == "" {
 		uri := fmt.Sprintf("%s%s", c.Endpoint, req.URL)
 		u, err := url.Parse(uri)
@@ -171,18 +203,65 @@ func (c *Client) do(req *http.Request) (*http.Response, error) {
 	}
 	req.Header.Set("Things-Client-Info", base64.StdEncoding.EncodeToString(ciJSON))
 
-	if c.Debug {
-		bs, _ := httputil.DumpRequest(req, true)
-		log.Println("REQUEST:", string(bs))
-	}
+	ctx := req.Context()
+	for attempt := 1; ; attempt++ {
+		if err := c.holdOff(ctx); err != nil {
+			return nil, err
+		}
+		queued := time.Now()
+		if err := c.rateLimiter.Wait(ctx); err != nil {
+			return nil, fmt.Errorf("rate limit wait: %w", err)
+		}
+		rateLimited := time.Since(queued)
+
+		r := req
+		if attempt > 1 {
+			if r, err = rewind(req); err != nil {
+				return nil, err
+			}
+		}
+		if c.Debug {
+			bs, _ := httputil.DumpRequest(r, true)
+			c.logger.DebugContext(ctx, "things cloud request dump", "dump", string(bs))
+		}
 
-	resp, err := c.client.Do(req)
-	if c.Debug {
-		if err == nil {
+		start := time.Now()
+		resp, err := c.client.Do(r)
+		duration := time.Since(start)
+		if c.Debug && err == nil {
 			bs, _ := httputil.DumpResponse(resp, true)
-			log.Println("RESPONSE:", string(bs))
+			c.logger.DebugContext(ctx, "things cloud response dump", "dump", string(bs))
+		}
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		attrs := []any{"method", req.Method, "path", req.URL.Path, "attempt", attempt, "status", status}
+		if err != nil {
+			attrs = append(attrs, "error", err)
+		}
+		c.logger.DebugContext(ctx, "things cloud request", append(attrs, "duration", duration)...)
+		if c.hooks.Request != nil {
+			c.hooks.Request(RequestEvent{
+				Method: req.Method, Path: req.URL.Path, Attempt: attempt, StatusCode: status,
+				Err: err, Duration: duration, RateLimited: rateLimited,
+			})
+		}
+
+		wait, retry := c.retryDelay(req, resp, err, attempt)
+		if !retry || attempt >= c.retry.MaxAttempts || wait > c.retry.MaxDelay {
+			return resp, err
+		}
+		c.logger.WarnContext(ctx, "retrying things cloud request", append(attrs, "wait", wait)...)
+		if c.hooks.Retry != nil {
+			c.hooks.Retry(RetryEvent{Method: req.Method, Path: req.URL.Path, Attempt: attempt, StatusCode: status, Err: err, Wait: wait})
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if err := sleepContext(ctx, wait); err != nil {
+			return nil, err
 		}
-		log.Println()
 	}
-	return resp, err
 }
@@ -1,11 +1,16 @@
 package thingscloud
 
 import (
+	"encoding/binary"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"strconv"
+	"sync/atomic"
 	"testing"
 )
 
@@ -73,3 +78,112 @@ func TestClient_UserAgent(t *testing.T) {
 		t.Error("things-client-info header is missing or empty")
 	}
 }
+
+// socks5Server is a minimal SOCKS5 proxy without authentication that
+// counts the connections it relays.
+func socks5Server(t *testing.T) (addr string, conns *atomic.Int32) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	conns = new(atomic.Int32)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				// Greeting: version, methods; choose "no authentication".
+				buf := make([]byte, 262)
+				if _, err := io.ReadFull(c, buf[:2]); err != nil {
+					return
+				}
+				if _, err := io.ReadFull(c, buf[:buf[1]]); err != nil {
+					return
+				}
+				c.Write([]byte{5, 0})
+				// Request: version, CONNECT, reserved, IPv4 or domain name.
+				if _, err := io.ReadFull(c, buf[:4]); err != nil {
+					return
+				}
+				var host string
+				switch buf[3] {
+				case 1:
+					io.ReadFull(c, buf[:4])
+					host = net.IP(buf[:4]).String()
+				case 3:
+					io.ReadFull(c, buf[:1])
+					n := int(buf[0])
+					io.ReadFull(c, buf[:n])
+					host = string(buf[:n])
+				default:
+					return
+				}
+				io.ReadFull(c, buf[:2])
+				port := binary.BigEndian.Uint16(buf[:2])
+				target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+				if err != nil {
+					c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer target.Close()
+				conns.Add(1)
+				c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				go io.Copy(target, c)
+				io.Copy(c, target)
+			}()
+		}
+	}()
+	return l.Addr().String(), conns
+}
+
+func TestClient_SOCKS5Proxy(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+	addr, conns := socks5Server(t)
+
+	for _, scheme := range []string{"socks5", "socks5h"} {
+		c := New(ts.URL, "test@example.com", "password", WithProxy(&url.URL{Scheme: scheme, Host: addr}))
+		req, _ := http.NewRequest("GET", "/test", nil)
+		resp, err := c.do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", scheme, err)
+		}
+		resp.Body.Close()
+	}
+	if got := conns.Load(); got != 2 {
+		t.Errorf("proxy relayed %d connections, want 2", got)
+	}
+}
+
+func TestClient_ProxyFunc(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+	addr, conns := socks5Server(t)
+
+	// The proxy is chosen per request: direct first, then through SOCKS5.
+	var proxy *url.URL
+	c := New(ts.URL, "test@example.com", "password", WithProxyFunc(func(*http.Request) (*url.URL, error) {
+		return proxy, nil
+	}))
+	for _, p := range []*url.URL{nil, {Scheme: "socks5", Host: addr}} {
+		proxy = p
+		req, _ := http.NewRequest("GET", "/test", nil)
+		resp, err := c.do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if got := conns.Load(); got != 1 {
+		t.Errorf("proxy relayed %d connections, want 1", got)
+	}
+}
@@ -0,0 +1,133 @@
+package thingscloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// maxCommitRebases bounds how often Write retries on top of commits made
+// by other clients before giving up with the conflict response.
+const maxCommitRebases = 3
+
+// CommitConflictError is returned by History.Write when another client
+// committed changes to the same fields of the same items after the
+// write's ancestor index. Nothing was written.
+type CommitConflictError struct {
+	AncestorIndex int
+	// ServerIndex is the head index the intervening items were read up to.
+	ServerIndex int
+	Conflicts   []FieldConflict
+	// Intervening holds every item committed since AncestorIndex.
+	Intervening []Item
+}
+
+// FieldConflict names the payload fields of one item that both the write
+// and an intervening commit changed. Fields is nil when either side
+// created or deleted the item.
+type FieldConflict struct {
+	UUID   string
+	Fields []string
+}
+
+func (e *CommitConflictError) Error() string {
+	var parts []string
+	for _, c := range e.Conflicts {
+		if len(c.Fields) == 0 {
+			parts = append(parts, c.UUID)
+		} else {
+			parts = append(parts, fmt.Sprintf("%s (%s)", c.UUID, strings.Join(c.Fields, ", ")))
+		}
+	}
+	return fmt.Sprintf("things cloud: commit conflict: changed by another client since index %d: %s", e.AncestorIndex, strings.Join(parts, "; "))
+}
+
+// isCommitConflict reports whether Things Cloud refused a commit because
+// its ancestor index is behind the head of the history.
+func isCommitConflict(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusPreconditionFailed
+}
+
+// itemsSince fetches every item committed after index and moves
+// LatestServerIndex to the head, leaving LoadedServerIndex alone so that
+// callers still see these items on their next sync.
+func (h *History) itemsSince(ctx context.Context, index int) ([]Item, error) {
+	var all []Item
+	start := index
+	for {
+		items, v, err := h.fetchItems(ctx, start)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, items...)
+		start += len(v.Items)
+		h.LatestServerIndex = v.CurrentItemIndex
+		if len(v.Items) == 0 || start >= v.CurrentItemIndex {
+			return all, nil
+		}
+	}
+}
+
+// overlappingWrites compares a commit body with intervening items and
+// returns the items and fields both change. Modification dates are
+// ignored, since every edit sets them.
+func overlappingWrites(body []byte, intervening []Item) []FieldConflict {
+	var ours map[string]Item
+	if err := json.Unmarshal(body, &ours); err != nil {
+		return nil
+	}
+	fields := func(item Item) map[string]bool {
+		var p map[string]json.RawMessage
+		json.Unmarshal(item.P, &p)
+		out := map[string]bool{}
+		for k := range p {
+			if k != "md" {
+				out[k] = true
+			}
+		}
+		return out
+	}
+
+	touched := map[string]map[string]bool{}
+	whole := map[string]bool{}
+	for _, theirs := range intervening {
+		mine, ok := ours[theirs.UUID]
+		if !ok {
+			continue
+		}
+		if mine.Action != ItemActionModified || theirs.Action != ItemActionModified {
+			whole[theirs.UUID] = true
+			continue
+		}
+		mineFields := fields(mine)
+		for k := range fields(theirs) {
+			if mineFields[k] {
+				if touched[theirs.UUID] == nil {
+					touched[theirs.UUID] = map[string]bool{}
+				}
+				touched[theirs.UUID][k] = true
+			}
+		}
+	}
+
+	var conflicts []FieldConflict
+	for uuid := range whole {
+		conflicts = append(conflicts, FieldConflict{UUID: uuid})
+	}
+	for uuid, keys := range touched {
+		if whole[uuid] {
+			continue
+		}
+		c := FieldConflict{UUID: uuid}
+		for k := range keys {
+			c.Fields = append(c.Fields, k)
+		}
+		sort.Strings(c.Fields)
+		conflicts = append(conflicts, c)
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].UUID < conflicts[j].UUID })
+	return conflicts
+}
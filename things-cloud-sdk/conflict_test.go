@@ -0,0 +1,83 @@
+package thingscloud
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// conflictServer refuses the first commit with 409 and serves intervening
+// as the only item committed after index 10.
+func conflictServer(intervening string, commits *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			fmt.Fprintf(w, `{"items":[%s],"current-item-index":11,"schema":301}`, intervening)
+		case http.MethodPost:
+			*commits = append(*commits, r.URL.Query().Get("ancestor-index"))
+			if len(*commits) == 1 {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			w.Write([]byte(`{"server-head-index":12}`))
+		}
+	}))
+}
+
+func TestHistory_WriteRebases(t *testing.T) {
+	title := "Renamed"
+	write := TaskActionItem{Item: Item{UUID: "task-1", Kind: ItemKindTask, Action: ItemActionModified}, P: TaskActionItemPayload{Title: &title}}
+
+	t.Run("Disjoint", func(t *testing.T) {
+		var commits []string
+		server := conflictServer(`{"task-1":{"t":1,"e":"Task6","p":{"nt":"note","md":1}}}`, &commits)
+		defer server.Close()
+
+		c := New(server.URL, "martin@example.com", "", WithRateLimit(time.Millisecond, 10))
+		h := History{Client: c, ID: "h", LoadedServerIndex: 10, LatestServerIndex: 10}
+		if err := h.Write(write); err != nil {
+			t.Fatal(err)
+		}
+		if len(commits) != 2 || commits[0] != "10" || commits[1] != "11" {
+			t.Errorf("ancestor indexes: %v", commits)
+		}
+		if h.LatestServerIndex != 12 || h.LoadedServerIndex != 10 {
+			t.Errorf("indexes: latest %d, loaded %d", h.LatestServerIndex, h.LoadedServerIndex)
+		}
+	})
+
+	t.Run("Overlapping", func(t *testing.T) {
+		var commits []string
+		server := conflictServer(`{"task-1":{"t":1,"e":"Task6","p":{"tt":"Other","md":1}}}`, &commits)
+		defer server.Close()
+
+		c := New(server.URL, "martin@example.com", "", WithRateLimit(time.Millisecond, 10))
+		h := History{Client: c, ID: "h", LoadedServerIndex: 10, LatestServerIndex: 10}
+		err := h.Write(write)
+		var conflict *CommitConflictError
+		if !errors.As(err, &conflict) {
+			t.Fatalf("got %v", err)
+		}
+		if len(conflict.Conflicts) != 1 || conflict.Conflicts[0].UUID != "task-1" || fmt.Sprint(conflict.Conflicts[0].Fields) != "[tt]" {
+			t.Errorf("conflicts: %+v", conflict.Conflicts)
+		}
+		if conflict.AncestorIndex != 10 || conflict.ServerIndex != 11 || len(conflict.Intervening) != 1 || len(commits) != 1 {
+			t.Errorf("got %+v after %d commits", conflict, len(commits))
+		}
+	})
+}
+
+func TestOverlappingWrites(t *testing.T) {
+	body := []byte(`{"a":{"t":1,"e":"Task6","p":{"tt":"x","md":1}},"b":{"t":2,"e":"Task6","p":{}}}`)
+	got := overlappingWrites(body, []Item{
+		{UUID: "a", Action: ItemActionModified, P: []byte(`{"md":2,"sr":3}`)},
+		{UUID: "b", Action: ItemActionModified, P: []byte(`{"tt":"y"}`)},
+		{UUID: "c", Action: ItemActionDeleted},
+	})
+	if len(got) != 1 || got[0].UUID != "b" || got[0].Fields != nil {
+		t.Errorf("got %+v", got)
+	}
+}
@@ -2,10 +2,10 @@ package thingscloud
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"net/http/httputil"
 	"strconv"
@@ -220,7 +220,16 @@ type Identifiable interface {
 	UUID() string
 }
 
+// Write commits items on top of LatestServerIndex. If another client
+// committed in the meantime, Write fetches the intervening items and, when
+// they touch none of the fields being written, retries on top of them. When
+// they do, it returns a *CommitConflictError.
 func (h *History) Write(items ...Identifiable) error {
+	return h.WriteContext(context.Background(), items...)
+}
+
+// WriteContext is Write with a context for its requests.
+func (h *History) WriteContext(ctx context.Context, items ...Identifiable) error {
 	m := map[string]interface{}{}
 	for _, item := range items {
 		m[item.UUID()] = item
@@ -229,7 +238,46 @@ func (h *History) Write(items ...Identifiable) error {
 	if err != nil {
 		return err
 	}
-	req, err := http.NewRequest("POST", fmt.Sprintf("/version/1/history/%s/commit", h.ID), bytes.NewReader(bs))
+	for rebases := 0; ; rebases++ {
+		ancestor := h.LatestServerIndex
+		resp, err := h.commit(ctx, bs, ancestor)
+		if err != nil {
+			return err
+		}
+		if isCommitConflict(resp) && rebases < maxCommitRebases {
+			resp.Body.Close()
+			intervening, err := h.itemsSince(ctx, ancestor)
+			if err != nil {
+				return fmt.Errorf("fetch items after conflict at ancestor index %d: %w", ancestor, err)
+			}
+			if conflicts := overlappingWrites(bs, intervening); len(conflicts) > 0 {
+				return &CommitConflictError{AncestorIndex: ancestor, ServerIndex: h.LatestServerIndex, Conflicts: conflicts, Intervening: intervening}
+			}
+			continue
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			bs, _ := httputil.DumpResponse(resp, true)
+			h.Client.logger.ErrorContext(ctx, "things cloud commit failed",
+				"status", resp.StatusCode, "ancestor_index", ancestor, "response", string(bs))
+			return newAPIError(resp)
+		}
+		rs, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		var w commitResponse
+		json.Unmarshal(rs, &w)
+		h.LatestServerIndex = w.ServerHeadIndex
+		return nil
+	}
+}
+
+func (h *History) commit(ctx context.Context, body []byte, ancestor int) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("/version/1/history/%s/commit", h.ID), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
 	req.Header.Add("Schema", "301")
 	req.Header.Add("Push-Priority", "5")
 	// Full App-Instance-Id matching Things format: {hash}-{bundleId}-{hash}
@@ -239,28 +287,8 @@ func (h *History) Write(items ...Identifiable) error {
 	req.Header.Add("Host", "cloud.culturedcode.com")
 	req.Header.Add("Accept", "application/json")
 	query := req.URL.Query()
-	query.Add("ancestor-index", strconv.Itoa(h.LatestServerIndex))
+	query.Add("ancestor-index", strconv.Itoa(ancestor))
 	query.Add("_cnt", "1")
 	req.URL.RawQuery = query.Encode()
-	if err != nil {
-		return err
-	}
-	resp, err := h.Client.do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		bs, _ := httputil.DumpResponse(resp, true)
-		log.Println(string(bs))
-		return newAPIError(resp)
-	}
-	rs, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-	var w commitResponse
-	json.Unmarshal(rs, &w)
-	h.LatestServerIndex = w.ServerHeadIndex
-	return nil
+	return h.Client.do(req)
 }
@@ -1,6 +1,7 @@
 package thingscloud
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -16,6 +17,9 @@ type Item struct {
 	P      json.RawMessage `json:"p"`
 	Kind   ItemKind        `json:"e"`
 	Action ItemAction      `json:"t"`
+	// ServerIndex is the history index of the change the item was part of.
+	// Items written in the same commit share an index.
+	ServerIndex int `json:"-"`
 }
 
 type itemsResponse struct {
@@ -37,44 +41,59 @@ type ItemsOptions struct {
 //
 // Note that if a item was changed multiple times it will be present multiple times in the result too.
 func (h *History) Items(opts ItemsOptions) ([]Item, bool, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("/version/1/history/%s/items", h.ID), nil)
-
-	values := req.URL.Query()
-	values.Set("start-index", strconv.Itoa(opts.StartIndex))
-	req.URL.RawQuery = values.Encode()
+	return h.ItemsContext(context.Background(), opts)
+}
 
+// ItemsContext is Items with a context for its request.
+func (h *History) ItemsContext(ctx context.Context, opts ItemsOptions) ([]Item, bool, error) {
+	items, v, err := h.fetchItems(ctx, opts.StartIndex)
 	if err != nil {
 		return nil, false, err
 	}
+	h.LoadedServerIndex = h.LoadedServerIndex + len(v.Items)
+	h.LatestServerIndex = v.CurrentItemIndex
+	h.EndTotalContentSize = v.EndTotalContentSize
+	h.LatestTotalContentSize = v.LatestTotalContentSize
+	hasMoreItems := h.LoadedServerIndex < h.LatestServerIndex
+	return items, hasMoreItems, nil
+}
+
+// fetchItems fetches one page of changes starting at startIndex without
+// touching the history's indexes.
+func (h *History) fetchItems(ctx context.Context, startIndex int) ([]Item, itemsResponse, error) {
+	var v itemsResponse
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("/version/1/history/%s/items", h.ID), nil)
+	if err != nil {
+		return nil, v, err
+	}
+	values := req.URL.Query()
+	values.Set("start-index", strconv.Itoa(startIndex))
+	req.URL.RawQuery = values.Encode()
+
 	resp, err := h.Client.do(req)
 	if err != nil {
-		return nil, false, err
+		return nil, v, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, false, newAPIError(resp)
+		return nil, v, newAPIError(resp)
 	}
 
 	bs, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, false, err
+		return nil, v, err
 	}
-	var v itemsResponse
 	if err := json.Unmarshal(bs, &v); err != nil {
-		return nil, false, err
+		return nil, v, err
 	}
 	var items = []Item{}
-	for _, m := range v.Items {
+	for i, m := range v.Items {
 		for id, item := range m {
 			item.UUID = id
+			item.ServerIndex = startIndex + i
 			items = append(items, item)
 		}
 	}
-	h.LoadedServerIndex = h.LoadedServerIndex + len(v.Items)
-	h.LatestServerIndex = v.CurrentItemIndex
-	h.EndTotalContentSize = v.EndTotalContentSize
-	h.LatestTotalContentSize = v.LatestTotalContentSize
-	hasMoreItems := h.LoadedServerIndex < h.LatestServerIndex
-	return items, hasMoreItems, nil
+	return items, v, nil
 }
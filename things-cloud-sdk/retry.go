@@ -0,0 +1,248 @@
+package thingscloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how the client retries failed requests.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts per request; 1 disables
+	// retries.
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry. It doubles with each
+	// further attempt, plus up to 50% jitter.
+	BaseDelay time.Duration
+	// MaxDelay caps a single wait, including one asked for with
+	// Retry-After. When Things Cloud asks for a longer pause the request
+	// fails instead, and later requests fail with a *ThrottledError until
+	// the pause is over.
+	MaxDelay time.Duration
+	// AbuseDelay is how long the client holds off after an abuse
+	// prevention response that carries no Retry-After.
+	AbuseDelay time.Duration
+	// RetryPOST also retries POST requests after 5xx responses and network
+	// errors. A commit may have been applied even though its response was
+	// lost, so this is off by default. 429 responses are always retried,
+	// since the request was refused.
+	RetryPOST bool
+}
+
+// DefaultRetryPolicy returns the policy clients use unless configured
+// otherwise.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    30 * time.Second,
+		AbuseDelay:  5 * time.Minute,
+	}
+}
+
+// WithRetryPolicy configures how failed requests are retried.
+func WithRetryPolicy(p RetryPolicy) ClientOption {
+	return func(c *Client) {
+		c.retry = p
+	}
+}
+
+// WithRateLimit allows one request per interval on average, with bursts of
+// up to burst requests. The default is one request per second.
+func WithRateLimit(interval time.Duration, burst int) ClientOption {
+	return func(c *Client) {
+		c.rateLimiter = newRateLimiter(interval, burst)
+	}
+}
+
+// WithHooks registers callbacks that observe the client's requests.
+func WithHooks(h Hooks) ClientOption {
+	return func(c *Client) {
+		c.hooks = h
+	}
+}
+
+// Hooks observe requests made by a Client, e.g. to export metrics. Any of
+// them may be nil. They are called synchronously and must not block.
+type Hooks struct {
+	// Request is called after every attempt.
+	Request func(RequestEvent)
+	// Retry is called before the client waits to retry a request.
+	Retry func(RetryEvent)
+	// Throttled is called when Things Cloud answers 429.
+	Throttled func(ThrottleEvent)
+}
+
+// RequestEvent describes one attempt at a request.
+type RequestEvent struct {
+	Method     string
+	Path       string
+	Attempt    int
+	StatusCode int // 0 when the request failed without a response
+	Err        error
+	Duration   time.Duration
+	// RateLimited is how long the attempt waited for the client's own rate
+	// limiter.
+	RateLimited time.Duration
+}
+
+// RetryEvent describes a retry the client is about to make.
+type RetryEvent struct {
+	Method     string
+	Path       string
+	Attempt    int // the attempt that failed
+	StatusCode int
+	Err        error
+	Wait       time.Duration
+}
+
+// ThrottleEvent describes a 429 response from Things Cloud.
+type ThrottleEvent struct {
+	Path            string
+	AbusePrevention bool
+	// Until is when the client will send requests again.
+	Until time.Time
+}
+
+// ThrottledError is returned without contacting Things Cloud while the
+// client is holding off after a 429 for longer than its retry policy waits.
+type ThrottledError struct {
+	Until           time.Time
+	AbusePrevention bool
+}
+
+func (e *ThrottledError) Error() string {
+	reason := "rate limited"
+	if e.AbusePrevention {
+		reason = "blocked by abuse prevention"
+	}
+	return fmt.Sprintf("things cloud: %s until %s; try again later", reason, e.Until.Format(time.RFC3339))
+}
+
+// IsTransient reports whether err is worth trying again later: a network
+// error, a 5xx or 429 response, or a *ThrottledError.
+func IsTransient(err error) bool {
+	var apiErr *APIError
+	var throttled *ThrottledError
+	switch {
+	case err == nil, errors.Is(err, ErrUnauthorized):
+		return false
+	case errors.As(err, &throttled):
+		return true
+	case errors.As(err, &apiErr):
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+	var netErr interface{ Timeout() bool }
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// holdOff waits out a pause Things Cloud asked for, or fails with a
+// *ThrottledError when the pause is longer than the retry policy waits.
+func (c *Client) holdOff(ctx context.Context) error {
+	c.mu.Lock()
+	until, abuse := c.throttledUntil, c.throttledAbuse
+	c.mu.Unlock()
+	wait := time.Until(until)
+	if wait <= 0 {
+		return nil
+	}
+	if wait > c.retry.MaxDelay {
+		return &ThrottledError{Until: until, AbusePrevention: abuse}
+	}
+	return sleepContext(ctx, wait)
+}
+
+// retryDelay decides whether an attempt should be retried and how long to
+// wait first. A 429 also pauses every other request of the client.
+func (c *Client) retryDelay(req *http.Request, resp *http.Response, err error, attempt int) (time.Duration, bool) {
+	backoff := c.retry.BaseDelay << (attempt - 1)
+	if backoff > 0 {
+		backoff += time.Duration(rand.Int63n(int64(backoff)/2 + 1))
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return 0, false
+	}
+	if err != nil {
+		if req.Context().Err() != nil || (req.Method == http.MethodPost && !c.retry.RetryPOST) {
+			return 0, false
+		}
+		return backoff, true
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		apiErr := newAPIError(resp)
+		wait, ok := retryAfter(resp)
+		if !ok && apiErr.IsAbusePrevention() {
+			wait = c.retry.AbuseDelay
+		} else if !ok {
+			wait = backoff
+		}
+		until := time.Now().Add(wait)
+		c.mu.Lock()
+		if until.After(c.throttledUntil) {
+			c.throttledUntil, c.throttledAbuse = until, apiErr.IsAbusePrevention()
+		}
+		c.mu.Unlock()
+		c.logger.WarnContext(req.Context(), "things cloud throttled",
+			"path", req.URL.Path, "abuse_prevention", apiErr.IsAbusePrevention(), "until", until)
+		if c.hooks.Throttled != nil {
+			c.hooks.Throttled(ThrottleEvent{Path: req.URL.Path, AbusePrevention: apiErr.IsAbusePrevention(), Until: until})
+		}
+		return wait, true
+	case resp.StatusCode >= 500:
+		if req.Method == http.MethodPost && !c.retry.RetryPOST {
+			return 0, false
+		}
+		if wait, ok := retryAfter(resp); ok {
+			return wait, true
+		}
+		return backoff, true
+	}
+	return 0, false
+}
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP
+// date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		return max(time.Until(at), 0), true
+	}
+	return 0, false
+}
+
+// rewind returns a copy of req with a fresh body for another attempt.
+func rewind(req *http.Request) (*http.Request, error) {
+	r := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+	return r, nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
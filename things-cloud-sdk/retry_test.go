@@ -0,0 +1,147 @@
+package thingscloud
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func fastRetries() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Second, AbuseDelay: time.Hour}
+}
+
+func TestClient_RetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bs, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(bs))
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	var retries []RetryEvent
+	c := New(ts.URL, "test@example.com", "password",
+		WithRetryPolicy(fastRetries()),
+		WithRateLimit(time.Millisecond, 10),
+		WithHooks(Hooks{Retry: func(e RetryEvent) { retries = append(retries, e) }}),
+	)
+	req, _ := http.NewRequest("PUT", "/version/1/account/test@example.com", bytes.NewReader([]byte(`{"a":1}`)))
+	resp, err := c.do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || calls.Load() != 3 || len(retries) != 2 || retries[0].StatusCode != http.StatusBadGateway {
+		t.Errorf("status %d after %d calls, retries %+v", resp.StatusCode, calls.Load(), retries)
+	}
+	for _, body := range bodies {
+		if body != `{"a":1}` {
+			t.Errorf("body not replayed: %q", body)
+		}
+	}
+
+	// Commits are not retried after a 5xx: they may have been applied.
+	calls.Store(0)
+	req, _ = http.NewRequest("POST", "/version/1/history/h/commit", bytes.NewReader([]byte(`{}`)))
+	resp, err = c.do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway || calls.Load() != 1 {
+		t.Errorf("POST: status %d after %d calls", resp.StatusCode, calls.Load())
+	}
+}
+
+func TestClient_HonoursRetryAfter(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	var throttled []ThrottleEvent
+	c := New(ts.URL, "test@example.com", "password",
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 2, MaxDelay: 2 * time.Second}),
+		WithRateLimit(time.Millisecond, 10),
+		WithHooks(Hooks{Throttled: func(e ThrottleEvent) { throttled = append(throttled, e) }}),
+	)
+	start := time.Now()
+	req, _ := http.NewRequest("POST", "/version/1/history/h/commit", bytes.NewReader([]byte(`{}`)))
+	resp, err := c.do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || time.Since(start) < time.Second {
+		t.Errorf("status %d after %v", resp.StatusCode, time.Since(start))
+	}
+	if len(throttled) != 1 || throttled[0].AbusePrevention {
+		t.Errorf("throttle events: %+v", throttled)
+	}
+}
+
+func TestClient_AbusePreventionHoldsOff(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Things-Response", "AbusePrevention")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+
+	c := New(ts.URL, "test@example.com", "password", WithRetryPolicy(fastRetries()), WithRateLimit(time.Millisecond, 10))
+	req, _ := http.NewRequest("GET", "/version/1/history/h", nil)
+	resp, err := c.do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if !newAPIError(resp).IsAbusePrevention() || calls.Load() != 1 {
+		t.Fatalf("status %d after %d calls", resp.StatusCode, calls.Load())
+	}
+
+	// Until the block is over, requests fail without reaching the server.
+	req, _ = http.NewRequest("GET", "/version/1/history/h", nil)
+	_, err = c.do(req)
+	var throttled *ThrottledError
+	if !errors.As(err, &throttled) || !throttled.AbusePrevention || time.Until(throttled.Until) < 59*time.Minute {
+		t.Errorf("got %v", err)
+	}
+	if calls.Load() != 1 || !IsTransient(err) {
+		t.Errorf("calls %d, transient %v", calls.Load(), IsTransient(err))
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{ErrUnauthorized, false},
+		{&APIError{StatusCode: http.StatusServiceUnavailable}, true},
+		{&APIError{StatusCode: http.StatusNotFound}, false},
+		{&ThrottledError{Until: time.Now()}, true},
+		{errors.New("decode items"), false},
+	} {
+		if got := IsTransient(tc.err); got != tc.want {
+			t.Errorf("IsTransient(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
@@ -6,6 +6,30 @@ import (
 	things "github.com/arthursoares/things-cloud-sdk"
 )
 
+// DetectTaskChanges classifies the difference between two versions of a task.
+// Pass a nil old for a created task and a nil new for a deleted one. It lets
+// callers that keep their own state (such as state/memory) produce the same
+// Change values as Syncer.
+func DetectTaskChanges(old, new *things.Task, serverIndex int, ts time.Time) []Change {
+	return detectTaskChanges(old, new, serverIndex, ts)
+}
+
+// DetectAreaChanges is the area counterpart of DetectTaskChanges.
+func DetectAreaChanges(old, new *things.Area, serverIndex int, ts time.Time) []Change {
+	return detectAreaChanges(old, new, serverIndex, ts)
+}
+
+// DetectTagChanges is the tag counterpart of DetectTaskChanges.
+func DetectTagChanges(old, new *things.Tag, serverIndex int, ts time.Time) []Change {
+	return detectTagChanges(old, new, serverIndex, ts)
+}
+
+// DetectChecklistChanges is the checklist item counterpart of
+// DetectTaskChanges; task is the item's parent, if known.
+func DetectChecklistChanges(old, new *things.CheckListItem, task *things.Task, serverIndex int, ts time.Time) []Change {
+	return detectChecklistChanges(old, new, task, serverIndex, ts)
+}
+
 // detectTaskChanges compares old and new task state and returns semantic changes
 func detectTaskChanges(old, new *things.Task, serverIndex int, ts time.Time) []Change {
 	var changes []Change
@@ -93,8 +117,12 @@ func detectTaskChanges(old, new *things.Task, serverIndex int, ts time.Time) []C
 
 	// Schedule/location changed (only for regular tasks)
 	if new.Type == things.TaskTypeTask {
-		oldLoc := taskLocation(old)
-		newLoc := taskLocation(new)
+		at := ts
+		if at.IsZero() {
+			at = time.Now()
+		}
+		oldLoc := taskLocationAt(old, at)
+		newLoc := taskLocationAt(new, at)
 		if oldLoc != newLoc {
 			tc := taskChange{baseChange: base, Task: new}
 			switch newLoc {
@@ -127,11 +155,44 @@ func detectTaskChanges(old, new *things.Task, serverIndex int, ts time.Time) []C
 		changes = append(changes, TaskTagsChanged{taskChange: taskChange{baseChange: base, Task: new}, Added: added, Removed: removed})
 	}
 
+	// Project/area changed (only for regular tasks). The detector has no
+	// state to look containers up in, so they carry just their UUID.
+	if new.Type == things.TaskTypeTask {
+		if oldID, newID := firstID(old.ParentTaskIDs), firstID(new.ParentTaskIDs); oldID != newID && newID != "" {
+			c := TaskAssignedToProject{taskChange: taskChange{baseChange: base, Task: new}, Project: &things.Task{UUID: newID}}
+			if oldID != "" {
+				c.OldProject = &things.Task{UUID: oldID}
+			}
+			changes = append(changes, c)
+		}
+		if oldID, newID := firstID(old.AreaIDs), firstID(new.AreaIDs); oldID != newID && newID != "" {
+			c := TaskAssignedToArea{taskChange: taskChange{baseChange: base, Task: new}, Area: &things.Area{UUID: newID}}
+			if oldID != "" {
+				c.OldArea = &things.Area{UUID: oldID}
+			}
+			changes = append(changes, c)
+		}
+	}
+
 	return changes
 }
 
+func firstID(ids []string) string {
+	if len(ids) == 0 {
+		return ""
+	}
+	return ids[0]
+}
+
 // taskLocation determines where a task lives based on schedule and dates
 func taskLocation(t *things.Task) TaskLocation {
+	return taskLocationAt(t, time.Now())
+}
+
+// taskLocationAt is taskLocation as of a given moment, so replayed history
+// sees "today" as the day the change was made. Anytime tasks whose start
+// date has passed stay in Today, as they do in the apps.
+func taskLocationAt(t *things.Task, now time.Time) TaskLocation {
 	if t == nil {
 		return LocationUnknown
 	}
@@ -139,12 +200,12 @@ func taskLocation(t *things.Task) TaskLocation {
 	case things.TaskScheduleInbox:
 		return LocationInbox
 	case things.TaskScheduleAnytime:
-		if t.ScheduledDate != nil && isToday(t.ScheduledDate) {
+		if t.ScheduledDate != nil && onOrBeforeDay(*t.ScheduledDate, now) {
 			return LocationToday
 		}
 		return LocationAnytime
 	case things.TaskScheduleSomeday:
-		if t.ScheduledDate != nil && t.ScheduledDate.After(time.Now()) {
+		if t.ScheduledDate != nil && t.ScheduledDate.After(now) {
 			return LocationUpcoming
 		}
 		return LocationSomeday
@@ -156,8 +217,15 @@ func isToday(t *time.Time) bool {
 	if t == nil {
 		return false
 	}
-	now := time.Now()
-	return t.Year() == now.Year() && t.YearDay() == now.YearDay()
+	return sameDay(*t, time.Now())
+}
+
+func sameDay(a, b time.Time) bool {
+	return a.Year() == b.Year() && a.YearDay() == b.YearDay()
+}
+
+func onOrBeforeDay(a, b time.Time) bool {
+	return a.Year() < b.Year() || (a.Year() == b.Year() && a.YearDay() <= b.YearDay())
 }
 
 func timeEqual(a, b *time.Time) bool {
@@ -545,6 +545,120 @@ func TestDetectTaskChanges(t *testing.T) {
 		}
 	})
 
+	t.Run("task assigned to project", func(t *testing.T) {
+		t.Parallel()
+		old := &things.Task{UUID: "t1", Title: "Task"}
+		new := &things.Task{UUID: "t1", Title: "Task", ParentTaskIDs: []string{"p1"}}
+		changes := detectTaskChanges(old, new, 1, now)
+
+		if len(changes) != 1 {
+			t.Fatalf("expected 1 change, got %d", len(changes))
+		}
+		c, ok := changes[0].(TaskAssignedToProject)
+		if !ok {
+			t.Fatalf("expected TaskAssignedToProject, got %T", changes[0])
+		}
+		if c.Project.UUID != "p1" || c.OldProject != nil {
+			t.Errorf("expected project p1 and no old project, got %v and %v", c.Project, c.OldProject)
+		}
+	})
+
+	t.Run("task moved between projects", func(t *testing.T) {
+		t.Parallel()
+		old := &things.Task{UUID: "t1", Title: "Task", ParentTaskIDs: []string{"p1"}}
+		new := &things.Task{UUID: "t1", Title: "Task", ParentTaskIDs: []string{"p2"}}
+		changes := detectTaskChanges(old, new, 1, now)
+
+		if len(changes) != 1 {
+			t.Fatalf("expected 1 change, got %d", len(changes))
+		}
+		c, ok := changes[0].(TaskAssignedToProject)
+		if !ok {
+			t.Fatalf("expected TaskAssignedToProject, got %T", changes[0])
+		}
+		if c.Project.UUID != "p2" || c.OldProject == nil || c.OldProject.UUID != "p1" {
+			t.Errorf("expected move from p1 to p2, got %v from %v", c.Project, c.OldProject)
+		}
+	})
+
+	t.Run("task moved out of project", func(t *testing.T) {
+		t.Parallel()
+		old := &things.Task{UUID: "t1", Title: "Task", ParentTaskIDs: []string{"p1"}}
+		new := &things.Task{UUID: "t1", Title: "Task"}
+		changes := detectTaskChanges(old, new, 1, now)
+
+		// There is no change type for leaving a project.
+		if len(changes) != 0 {
+			t.Fatalf("expected 0 changes, got %d: %v", len(changes), changes)
+		}
+	})
+
+	t.Run("task moved from project to area", func(t *testing.T) {
+		t.Parallel()
+		old := &things.Task{UUID: "t1", Title: "Task", ParentTaskIDs: []string{"p1"}}
+		new := &things.Task{UUID: "t1", Title: "Task", AreaIDs: []string{"a1"}}
+		changes := detectTaskChanges(old, new, 1, now)
+
+		if len(changes) != 1 {
+			t.Fatalf("expected 1 change, got %d", len(changes))
+		}
+		c, ok := changes[0].(TaskAssignedToArea)
+		if !ok {
+			t.Fatalf("expected TaskAssignedToArea, got %T", changes[0])
+		}
+		if c.Area.UUID != "a1" || c.OldArea != nil {
+			t.Errorf("expected area a1 and no old area, got %v and %v", c.Area, c.OldArea)
+		}
+	})
+
+	t.Run("task moved between areas", func(t *testing.T) {
+		t.Parallel()
+		old := &things.Task{UUID: "t1", Title: "Task", AreaIDs: []string{"a1"}}
+		new := &things.Task{UUID: "t1", Title: "Task", AreaIDs: []string{"a2"}}
+		changes := detectTaskChanges(old, new, 1, now)
+
+		if len(changes) != 1 {
+			t.Fatalf("expected 1 change, got %d", len(changes))
+		}
+		c, ok := changes[0].(TaskAssignedToArea)
+		if !ok {
+			t.Fatalf("expected TaskAssignedToArea, got %T", changes[0])
+		}
+		if c.Area.UUID != "a2" || c.OldArea == nil || c.OldArea.UUID != "a1" {
+			t.Errorf("expected move from a1 to a2, got %v from %v", c.Area, c.OldArea)
+		}
+	})
+
+	t.Run("project moved between areas", func(t *testing.T) {
+		t.Parallel()
+		old := &things.Task{UUID: "p1", Title: "Project", Type: things.TaskTypeProject, AreaIDs: []string{"a1"}}
+		new := &things.Task{UUID: "p1", Title: "Project", Type: things.TaskTypeProject, AreaIDs: []string{"a2"}}
+		changes := detectTaskChanges(old, new, 1, now)
+
+		if len(changes) != 0 {
+			t.Fatalf("expected 0 changes for a project, got %d: %v", len(changes), changes)
+		}
+	})
+
+	t.Run("anytime task reaching its start date", func(t *testing.T) {
+		t.Parallel()
+		start := now.AddDate(0, 0, -2)
+		old := &things.Task{UUID: "t1", Title: "Task", Schedule: things.TaskScheduleSomeday, ScheduledDate: &start}
+		new := &things.Task{UUID: "t1", Title: "Task", Schedule: things.TaskScheduleAnytime, ScheduledDate: &start}
+		changes := detectTaskChanges(old, new, 1, now)
+
+		if len(changes) != 1 {
+			t.Fatalf("expected 1 change, got %d", len(changes))
+		}
+		c, ok := changes[0].(TaskMovedToToday)
+		if !ok {
+			t.Fatalf("expected TaskMovedToToday, got %T", changes[0])
+		}
+		if c.From != LocationSomeday {
+			t.Errorf("expected From Someday, got %v", c.From)
+		}
+	})
+
 	t.Run("no changes when identical", func(t *testing.T) {
 		t.Parallel()
 		old := &things.Task{UUID: "t1", Title: "Task", Status: things.TaskStatusPending}
@@ -1049,6 +1163,38 @@ func TestTaskLocation(t *testing.T) {
 		}
 	})
 
+	t.Run("anytime schedule with past date", func(t *testing.T) {
+		t.Parallel()
+		pastDate := time.Now().AddDate(0, 0, -7)
+		task := &things.Task{Schedule: things.TaskScheduleAnytime, ScheduledDate: &pastDate}
+		loc := taskLocation(task)
+		if loc != LocationToday {
+			t.Errorf("expected LocationToday (past start date), got %v", loc)
+		}
+	})
+
+	t.Run("anytime schedule with future date", func(t *testing.T) {
+		t.Parallel()
+		futureDate := time.Now().AddDate(0, 0, 7)
+		task := &things.Task{Schedule: things.TaskScheduleAnytime, ScheduledDate: &futureDate}
+		loc := taskLocation(task)
+		if loc != LocationAnytime {
+			t.Errorf("expected LocationAnytime (future start date), got %v", loc)
+		}
+	})
+
+	t.Run("anytime schedule as of a past day", func(t *testing.T) {
+		t.Parallel()
+		start := time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)
+		task := &things.Task{Schedule: things.TaskScheduleAnytime, ScheduledDate: &start}
+		if loc := taskLocationAt(task, start.AddDate(0, 0, -1)); loc != LocationAnytime {
+			t.Errorf("expected LocationAnytime the day before, got %v", loc)
+		}
+		if loc := taskLocationAt(task, start.Add(9*time.Hour)); loc != LocationToday {
+			t.Errorf("expected LocationToday on the day, got %v", loc)
+		}
+	})
+
 	t.Run("someday schedule without date", func(t *testing.T) {
 		t.Parallel()
 		task := &things.Task{Schedule: things.TaskScheduleSomeday}
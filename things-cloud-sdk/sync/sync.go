@@ -4,18 +4,12 @@ package sync
 
 import (
 	"database/sql"
-	"strings"
 	"time"
 
 	things "github.com/arthursoares/things-cloud-sdk"
 	_ "modernc.org/sqlite"
 )
 
-const (
-	maxRetries    = 3
-	retryBaseWait = 2 * time.Second
-)
-
 // dbExecutor is the interface for database operations (satisfied by *sql.DB and *sql.Tx)
 type dbExecutor interface {
 	Query(query string, args ...any) (*sql.Rows, error)
@@ -63,18 +57,6 @@ func (s *Syncer) Close() error {
 	return s.rawDB.Close()
 }
 
-// isRetryableError returns true if the error is a temporary server error worth retrying.
-func isRetryableError(err error) bool {
-	if err == nil {
-		return false
-	}
-	errStr := err.Error()
-	return strings.Contains(errStr, "500") ||
-		strings.Contains(errStr, "502") ||
-		strings.Contains(errStr, "503") ||
-		strings.Contains(errStr, "504")
-}
-
 // Sync fetches new items from Things Cloud, updates local state,
 // and returns the list of changes in order
 func (s *Syncer) Sync() ([]Change, error) {
@@ -91,19 +73,9 @@ func (s *Syncer) Sync() ([]Change, error) {
 			// Use stored history ID directly - no network call needed
 			s.history = s.client.HistoryWithID(storedHistoryID)
 		} else {
-			// First sync - need to fetch history ID from server
-			var h *things.History
-			var err error
-			for attempt := 0; attempt < maxRetries; attempt++ {
-				h, err = s.client.OwnHistory()
-				if err == nil {
-					break
-				}
-				if !isRetryableError(err) {
-					return nil, err
-				}
-				time.Sleep(retryBaseWait * time.Duration(1<<attempt))
-			}
+			// First sync - need to fetch history ID from server.
+			// The client retries transient errors itself.
+			h, err := s.client.OwnHistory()
 			if err != nil {
 				return nil, err
 			}
@@ -133,20 +105,7 @@ func (s *Syncer) Sync() ([]Change, error) {
 	hasMore := true
 
 	for hasMore {
-		// Fetch with retry for transient errors
-		var items []things.Item
-		var more bool
-		var fetchErr error
-		for attempt := 0; attempt < maxRetries; attempt++ {
-			items, more, fetchErr = s.history.Items(things.ItemsOptions{StartIndex: startIndex})
-			if fetchErr == nil {
-				break
-			}
-			if !isRetryableError(fetchErr) {
-				return nil, fetchErr
-			}
-			time.Sleep(retryBaseWait * time.Duration(1<<attempt))
-		}
+		items, more, fetchErr := s.history.Items(things.ItemsOptions{StartIndex: startIndex})
 		if fetchErr != nil {
 			return nil, fetchErr
 		}
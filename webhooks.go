@@ -0,0 +1,606 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// ---------------------------------------------------------------------------
+// Outgoing webhooks
+// ---------------------------------------------------------------------------
+
+const (
+	webhookMaxAttempts = 5
+	webhookRetention   = 7 * 24 * time.Hour
+)
+
+// WebhookStore persists per-user webhook subscriptions and their delivery
+// log, and delivers change events to them. Retries happen in memory;
+// shutdown waits for them until its deadline, and deliveries still
+// pending then are lost.
+type WebhookStore struct {
+	db      *sqlDB
+	client  *http.Client
+	backoff time.Duration // wait before the first retry; doubles each attempt
+	wg      sync.WaitGroup
+
+	// abandon cuts short the deliveries in progress; see Drain.
+	abandoned context.Context
+	abandon   context.CancelFunc
+
+	stopPolling context.CancelFunc // set by startPollingWebhooks
+	polling     sync.WaitGroup
+}
+
+func NewWebhookStore(db *sqlDB) *WebhookStore {
+	ws := &WebhookStore{
+		db:      db,
+		client:  newWebhookClient(),
+		backoff: 2 * time.Second,
+	}
+	ws.abandoned, ws.abandon = context.WithCancel(context.Background())
+	return ws
+}
+
+// errNotPublic is returned when a webhook URL leads to an address on the
+// server's own network.
+var errNotPublic = errors.New("webhook address is not public")
+
+// newWebhookClient returns a client that only connects to public
+// addresses, so a webhook cannot make the server reach loopback, private
+// or link-local services such as a cloud metadata endpoint. The check runs
+// on the address actually dialed, after DNS resolution, and redirects are
+// not followed.
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: dialPublicOnly}
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			ForceAttemptHTTP2:   true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func dialPublicOnly(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !isPublicAddr(ip) {
+		return fmt.Errorf("%w: %s", errNotPublic, ip)
+	}
+	return nil
+}
+
+// nonPublicPrefixes are ranges the netip predicates leave out: this
+// network, shared address space (CGNAT), IETF protocol assignments and
+// benchmarking.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+}
+
+func isPublicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, p := range nonPublicPrefixes {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// isPublicHost rejects URL hosts that are known not to be public without a
+// DNS lookup; names are checked again when dialed.
+func isPublicHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return isPublicAddr(ip)
+	}
+	return true
+}
+
+type webhook struct {
+	ID        string   `json:"id"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	Secret    string   `json:"secret,omitempty"`
+	CreatedAt string   `json:"createdAt"`
+}
+
+type webhookDelivery struct {
+	DeliveryID string `json:"deliveryId"`
+	WebhookID  string `json:"webhookId"`
+	Event      string `json:"event"`
+	Attempt    int    `json:"attempt"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Delivered  bool   `json:"delivered"`
+	CreatedAt  string `json:"createdAt"`
+}
+
+func (ws *WebhookStore) Create(email, rawURL string, events []string) (*webhook, error) {
+	wh := &webhook{
+		ID:        uuid.New().String(),
+		URL:       rawURL,
+		Events:    events,
+		Secret:    randomString(32),
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	_, err := ws.db.Exec(
+		`INSERT INTO webhooks (id, email, url, secret, events, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		wh.ID, email, wh.URL, wh.Secret, strings.Join(events, ","), wh.CreatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("store webhook: %w", err)
+	}
+	return wh, nil
+}
+
+// List returns the user's webhooks; secrets are only included on creation.
+func (ws *WebhookStore) List(email string) ([]webhook, error) {
+	hooks, err := ws.query(`SELECT id, url, secret, events, created_at FROM webhooks WHERE email = ? ORDER BY created_at`, email)
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	return hooks, err
+}
+
+func (ws *WebhookStore) Delete(email, id string) (bool, error) {
+	res, err := ws.db.Exec(`DELETE FROM webhooks WHERE email = ? AND id = ?`, email, id)
+	if err != nil {
+		return false, fmt.Errorf("delete webhook: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// Emails returns every account with at least one webhook.
+func (ws *WebhookStore) Emails() ([]string, error) {
+	rows, err := ws.db.Query(`SELECT DISTINCT email FROM webhooks`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var emails []string
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err == nil {
+			emails = append(emails, email)
+		}
+	}
+	return emails, rows.Err()
+}
+
+func (ws *WebhookStore) query(q string, args ...any) ([]webhook, error) {
+	rows, err := ws.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hooks := []webhook{}
+	for rows.Next() {
+		var wh webhook
+		var events string
+		if err := rows.Scan(&wh.ID, &wh.URL, &wh.Secret, &events, &wh.CreatedAt); err != nil {
+			continue
+		}
+		wh.Events = []string{}
+		if events != "" {
+			wh.Events = strings.Split(events, ",")
+		}
+		hooks = append(hooks, wh)
+	}
+	return hooks, rows.Err()
+}
+
+// Deliveries returns the newest delivery attempts for the user's webhooks.
+func (ws *WebhookStore) Deliveries(email, webhookID string, failedOnly bool, limit int) ([]webhookDelivery, error) {
+	q := `SELECT delivery_id, webhook_id, event, attempt, status_code, error, delivered, created_at
+		FROM webhook_deliveries WHERE email = ?`
+	args := []any{email}
+	if webhookID != "" {
+		q += ` AND webhook_id = ?`
+		args = append(args, webhookID)
+	}
+	if failedOnly {
+		q += ` AND NOT delivered`
+	}
+	q += ` ORDER BY rowid DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := ws.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []webhookDelivery{}
+	for rows.Next() {
+		var d webhookDelivery
+		if err := rows.Scan(&d.DeliveryID, &d.WebhookID, &d.Event, &d.Attempt, &d.StatusCode, &d.Error, &d.Delivered, &d.CreatedAt); err != nil {
+			continue
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+func (ws *WebhookStore) logAttempt(email string, d webhookDelivery) {
+	ws.db.Exec(
+		`INSERT INTO webhook_deliveries (delivery_id, webhook_id, email, event, attempt, status_code, error, delivered, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.DeliveryID, d.WebhookID, email, d.Event, d.Attempt, d.StatusCode, d.Error, d.Delivered, d.CreatedAt,
+	)
+	cutoff := time.Now().UTC().Add(-webhookRetention).Format(time.RFC3339)
+	ws.db.Exec(`DELETE FROM webhook_deliveries WHERE created_at < ?`, cutoff)
+}
+
+// Dispatch sends each event to the user's webhooks that subscribe to its
+// type. It returns immediately; each webhook gets its events in order on
+// its own goroutine.
+func (ws *WebhookStore) Dispatch(email string, events []changeEvent) {
+	hooks, err := ws.query(`SELECT id, url, secret, events, created_at FROM webhooks WHERE email = ?`, email)
+	if err != nil {
+		logWebhooks.Error("load subscriptions failed", "email", email, "error", err)
+		return
+	}
+	for _, wh := range hooks {
+		var matched []changeEvent
+		for _, ev := range events {
+			if len(wh.Events) == 0 || containsStr(wh.Events, ev.Type) {
+				matched = append(matched, ev)
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+		ws.wg.Add(1)
+		go func(wh webhook) {
+			defer ws.wg.Done()
+			for _, ev := range matched {
+				ws.deliver(email, wh, ev)
+			}
+		}(wh)
+	}
+}
+
+// Wait blocks until in-flight deliveries, including their retries, finish.
+func (ws *WebhookStore) Wait() {
+	ws.wg.Wait()
+}
+
+// Drain waits for in-flight deliveries, including their retries, until ctx
+// is done. Deliveries still running then are cut short, and Drain returns
+// once they have stopped, so the delivery log is no longer written to.
+func (ws *WebhookStore) Drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		ws.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	ws.abandon()
+	<-done
+	return ctx.Err()
+}
+
+type webhookPayload struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	WebhookID string      `json:"webhookId"`
+	CreatedAt string      `json:"createdAt"`
+	Change    changeEvent `json:"change"`
+}
+
+// deliver POSTs one event, retrying network errors, 429s and 5xx responses
+// with exponential backoff. Every attempt is written to the delivery log.
+func (ws *WebhookStore) deliver(email string, wh webhook, ev changeEvent) {
+	payload := webhookPayload{
+		ID:        uuid.New().String(),
+		Type:      ev.Type,
+		WebhookID: wh.ID,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Change:    ev,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	mac := hmac.New(sha256.New, []byte(wh.Secret))
+	mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	wait := ws.backoff
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		d := webhookDelivery{
+			DeliveryID: payload.ID,
+			WebhookID:  wh.ID,
+			Event:      ev.Type,
+			Attempt:    attempt,
+			CreatedAt:  time.Now().UTC().Format(time.RFC3339),
+		}
+		retry := true
+		req, err := http.NewRequestWithContext(ws.abandoned, http.MethodPost, wh.URL, bytes.NewReader(body))
+		if err != nil {
+			d.Error = err.Error()
+			retry = false
+		} else {
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("User-Agent", "things-cloud-mcp-webhooks")
+			req.Header.Set("X-Things-Event", ev.Type)
+			req.Header.Set("X-Things-Delivery", payload.ID)
+			req.Header.Set("X-Things-Signature-256", signature)
+			resp, err := ws.client.Do(req)
+			if err != nil {
+				d.Error = err.Error()
+				retry = !errors.Is(err, errNotPublic)
+			} else {
+				io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+				resp.Body.Close()
+				d.StatusCode = resp.StatusCode
+				d.Delivered = resp.StatusCode >= 200 && resp.StatusCode < 300
+				retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+				if !d.Delivered {
+					d.Error = resp.Status
+				}
+			}
+		}
+		ws.logAttempt(email, d)
+		if d.Delivered || !retry {
+			return
+		}
+		if attempt < webhookMaxAttempts {
+			select {
+			case <-time.After(wait):
+			case <-ws.abandoned.Done():
+			}
+			wait *= 2
+		}
+		if ws.abandoned.Err() != nil {
+			logWebhooks.Warn("delivery abandoned at shutdown", "event", ev.Type, "email", email, "webhook", wh.ID, "attempts", attempt)
+			return
+		}
+	}
+	logWebhooks.Warn("giving up on delivery", "event", ev.Type, "email", email, "webhook", wh.ID, "attempts", webhookMaxAttempts)
+}
+
+// startPollingWebhooks runs pollWebhooks until shutdown stops it.
+func (um *UserManager) startPollingWebhooks(interval time.Duration) {
+	ws := um.webhooks
+	stop, cancel := context.WithCancel(context.Background())
+	ws.stopPolling = cancel
+	ws.polling.Add(1)
+	go func() {
+		defer ws.polling.Done()
+		um.pollWebhooks(stop, interval)
+	}()
+}
+
+// StopPolling stops the poller, cancelling a sync in progress, and waits
+// until ctx is done for it to return.
+func (ws *WebhookStore) StopPolling(ctx context.Context) error {
+	if ws.stopPolling == nil {
+		return nil
+	}
+	ws.stopPolling()
+	done := make(chan struct{})
+	go func() {
+		ws.polling.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pollWebhooks keeps the state of every account with webhooks fresh, so
+// changes made in the Things apps are noticed without an MCP call. It
+// returns when stop is done.
+func (um *UserManager) pollWebhooks(stop context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop.Done():
+			return
+		case <-ticker.C:
+		}
+		// Each round gets a request ID of its own to correlate its logs, and
+		// stopping cancels it.
+		ctx := context.WithValue(stop, requestIDKey{}, newRequestID())
+		emails, err := um.webhooks.Emails()
+		if err != nil {
+			logWebhooks.ErrorContext(ctx, "list accounts failed", "error", err)
+			continue
+		}
+		for _, email := range emails {
+			if stop.Err() != nil {
+				return
+			}
+			if !um.ownsAccount(email) {
+				continue // polled by the cluster member that owns it
+			}
+			password, ok := um.oauth.storedPassword(email)
+			if !ok {
+				continue
+			}
+			t, err := um.GetOrCreateUser(ctx, email, password)
+			if err != nil {
+				logWebhooks.ErrorContext(ctx, "load account failed", "email", email, "error", err)
+				continue
+			}
+			if err := t.syncFor(ctx); err != nil {
+				logWebhooks.WarnContext(ctx, "sync failed", "email", email, "error", err)
+			}
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Tools
+// ---------------------------------------------------------------------------
+
+func parseWebhookEvents(csv string) ([]string, error) {
+	events := []string{}
+	for _, e := range strings.Split(csv, ",") {
+		e = strings.TrimSpace(e)
+		if e == "" || e == "*" {
+			continue
+		}
+		if !containsStr(changeTypes, e) {
+			return nil, fmt.Errorf("unknown event type: %s (valid: %s)", e, strings.Join(changeTypes, ", "))
+		}
+		events = appendUnique(events, e)
+	}
+	return events, nil
+}
+
+// webhookCreatedOutput is what things_create_webhook returns, the only
+// time the secret is shown.
+type webhookCreatedOutput struct {
+	Status  string   `json:"status"`
+	Webhook *webhook `json:"webhook"`
+	Note    string   `json:"note"`
+}
+
+type webhookDeletedOutput struct {
+	Status string `json:"status"`
+	ID     string `json:"id"`
+}
+
+func (um *UserManager) handleCreateWebhook(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	if um.webhooks == nil || um.oauth == nil {
+		return errResultCode(codeUnavailable, "webhooks are not available on this server"), nil
+	}
+	email, password, err := extractCredentials(ctx, um)
+	if err != nil {
+		return errResultFrom(err), nil
+	}
+	rawURL, err := req.RequireString("url")
+	if err != nil {
+		return errResult("url is required"), nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return errResult("url must be an absolute https:// URL"), nil
+	}
+	if !isPublicHost(u.Hostname()) {
+		return errResult("url must point to a public address, not a loopback, private or link-local one"), nil
+	}
+	events, err := parseWebhookEvents(req.GetString("events", ""))
+	if err != nil {
+		return errResultFrom(err), nil
+	}
+	if _, err := getUserFromContext(ctx, um); err != nil {
+		return errResultFrom(err), nil
+	}
+	wh, err := um.webhooks.Create(email, u.String(), events)
+	if err != nil {
+		return errResultFrom(err), nil
+	}
+	// The poller syncs this account without an Authorization header.
+	um.oauth.rememberCredentials(email, password)
+	return jsonResult(webhookCreatedOutput{
+		Status:  "created",
+		Webhook: wh,
+		Note:    "Verify deliveries by comparing X-Things-Signature-256 with sha256=HMAC-SHA256(secret, body). The secret is not shown again.",
+	}), nil
+}
+
+func (um *UserManager) handleListWebhooks(ctx context.Context, _ mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	if um.webhooks == nil {
+		return errResultCode(codeUnavailable, "webhooks are not available on this server"), nil
+	}
+	email, _, err := extractCredentials(ctx, um)
+	if err != nil {
+		return errResultFrom(err), nil
+	}
+	hooks, err := um.webhooks.List(email)
+	if err != nil {
+		return errResultFrom(err), nil
+	}
+	return jsonResult(hooks), nil
+}
+
+func (um *UserManager) handleDeleteWebhook(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	if um.webhooks == nil {
+		return errResultCode(codeUnavailable, "webhooks are not available on this server"), nil
+	}
+	email, _, err := extractCredentials(ctx, um)
+	if err != nil {
+		return errResultFrom(err), nil
+	}
+	id, err := req.RequireString("id")
+	if err != nil {
+		return errResult("id is required"), nil
+	}
+	ok, err := um.webhooks.Delete(email, id)
+	if err != nil {
+		return errResultFrom(err), nil
+	}
+	if !ok {
+		return errResultCode(codeNotFound, "webhook not found"), nil
+	}
+	return jsonResult(webhookDeletedOutput{Status: "deleted", ID: id}), nil
+}
+
+func (um *UserManager) handleWebhookDeliveries(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	if um.webhooks == nil {
+		return errResultCode(codeUnavailable, "webhooks are not available on this server"), nil
+	}
+	email, _, err := extractCredentials(ctx, um)
+	if err != nil {
+		return errResultFrom(err), nil
+	}
+	limit := req.GetInt("limit", 50)
+	if limit < 1 || limit > 500 {
+		return errResult("limit must be between 1 and 500"), nil
+	}
+	deliveries, err := um.webhooks.Deliveries(email, req.GetString("webhook_id", ""), req.GetBool("failed_only", false), limit)
+	if err != nil {
+		return errResultFrom(err), nil
+	}
+	return jsonResult(deliveries), nil
+}
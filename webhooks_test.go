@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestWebhookStore(t *testing.T) (*UserManager, context.Context) {
+	t.Helper()
+	um := NewUserManager()
+	um.webhooks = NewWebhookStore(newTestOAuth(t, um).db)
+	um.webhooks.backoff = time.Millisecond
+	ctx := context.WithValue(context.Background(), userContextKey, &UserInfo{Email: "hooks@example.com", Password: "testpass"})
+	return um, ctx
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	um, _ := newTestWebhookStore(t)
+	const email = "hooks@example.com"
+
+	var mu sync.Mutex
+	var received [][]byte
+	var signatures []string
+	calls := 0
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, body)
+		signatures = append(signatures, r.Header.Get("X-Things-Signature-256"))
+	}))
+	defer srv.Close()
+	um.webhooks.client = srv.Client()
+
+	wh, err := um.webhooks.Create(email, srv.URL, []string{"TaskCompleted"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	um.webhooks.Dispatch(email, []changeEvent{
+		{Type: "TaskCreated", EntityType: "Task", UUID: "t-1"},
+		{Type: "TaskCompleted", EntityType: "Task", UUID: "t-1", Title: "Ship it"},
+	})
+	um.webhooks.Dispatch("someone-else@example.com", []changeEvent{{Type: "TaskCompleted", UUID: "t-9"}})
+	um.webhooks.Wait()
+
+	if calls != 2 || len(received) != 1 {
+		t.Fatalf("calls: got %d (received %d), want one retry then success", calls, len(received))
+	}
+	var payload webhookPayload
+	if err := json.Unmarshal(received[0], &payload); err != nil {
+		t.Fatalf("payload: %v", err)
+	}
+	if payload.Type != "TaskCompleted" || payload.WebhookID != wh.ID || payload.Change.Title != "Ship it" {
+		t.Errorf("payload: got %+v", payload)
+	}
+	mac := hmac.New(sha256.New, []byte(wh.Secret))
+	mac.Write(received[0])
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); signatures[0] != want {
+		t.Errorf("signature: got %q, want %q", signatures[0], want)
+	}
+
+	attempts, err := um.webhooks.Deliveries(email, "", false, 10)
+	if err != nil {
+		t.Fatalf("Deliveries: %v", err)
+	}
+	if len(attempts) != 2 || !attempts[0].Delivered || attempts[0].Attempt != 2 || attempts[1].Delivered || attempts[1].StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("delivery log: got %+v", attempts)
+	}
+	failed, _ := um.webhooks.Deliveries(email, wh.ID, true, 10)
+	if len(failed) != 1 || failed[0].Attempt != 1 {
+		t.Errorf("failed only: got %+v", failed)
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	um, _ := newTestWebhookStore(t)
+	const email = "hooks@example.com"
+
+	var calls, status atomic.Int32
+	status.Store(http.StatusBadRequest)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer srv.Close()
+	um.webhooks.client = srv.Client()
+
+	um.webhooks.Create(email, srv.URL, nil)
+	um.webhooks.Dispatch(email, []changeEvent{{Type: "AreaCreated", UUID: "a-1"}})
+	um.webhooks.Wait()
+	if n := calls.Load(); n != 1 {
+		t.Errorf("a 4xx response should not be retried, got %d calls", n)
+	}
+
+	status.Store(http.StatusInternalServerError)
+	calls.Store(0)
+	um.webhooks.Dispatch(email, []changeEvent{{Type: "AreaCreated", UUID: "a-2"}})
+	um.webhooks.Wait()
+	if n := calls.Load(); n != webhookMaxAttempts {
+		t.Errorf("5xx: got %d calls, want %d", n, webhookMaxAttempts)
+	}
+}
+
+func TestWebhookStaysOffPrivateNetworks(t *testing.T) {
+	um, _ := newTestWebhookStore(t)
+	const email = "hooks@example.com"
+
+	var calls atomic.Int32
+	target := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer target.Close()
+	redirect := httptest.NewTLSServer(http.RedirectHandler(target.URL, http.StatusFound))
+	defer redirect.Close()
+
+	// The test servers listen on loopback, which the real client refuses
+	// to dial, even though the URL got past creation.
+	um.webhooks.client = newWebhookClient()
+	wh, _ := um.webhooks.Create(email, target.URL, nil)
+	um.webhooks.Dispatch(email, []changeEvent{{Type: "AreaCreated", UUID: "a-1"}})
+	um.webhooks.Wait()
+	attempts, _ := um.webhooks.Deliveries(email, wh.ID, false, 10)
+	if calls.Load() != 0 || len(attempts) != 1 || !strings.Contains(attempts[0].Error, "not public") {
+		t.Errorf("loopback delivery: %d calls, attempts %+v", calls.Load(), attempts)
+	}
+	um.webhooks.Delete(email, wh.ID)
+
+	// Redirects are not followed, wherever they lead.
+	client := newWebhookClient()
+	client.Transport = redirect.Client().Transport
+	um.webhooks.client = client
+	wh, _ = um.webhooks.Create(email, redirect.URL, nil)
+	um.webhooks.Dispatch(email, []changeEvent{{Type: "AreaCreated", UUID: "a-2"}})
+	um.webhooks.Wait()
+	attempts, _ = um.webhooks.Deliveries(email, wh.ID, false, 10)
+	if calls.Load() != 0 || len(attempts) != 1 || attempts[0].StatusCode != http.StatusFound || attempts[0].Delivered {
+		t.Errorf("redirected delivery: %d calls, attempts %+v", calls.Load(), attempts)
+	}
+}
+
+func TestWebhookTools(t *testing.T) {
+	um, ctx := newTestWebhookStore(t)
+
+	for _, args := range []map[string]any{
+		{},
+		{"url": "http://example.com/hook"},
+		{"url": "https://127.0.0.1/hook"},
+		{"url": "https://169.254.169.254/latest/meta-data"},
+		{"url": "https://10.1.2.3/hook"},
+		{"url": "https://[::1]/hook"},
+		{"url": "https://localhost:8443/hook"},
+		{"url": "https://example.com/hook", "events": "TaskExploded"},
+	} {
+		result, _ := um.handleCreateWebhook(ctx, makeReq(args))
+		assertIsError(t, result)
+	}
+
+	// Creation needs a Things login; seed the user so no network is used.
+	fc := newFakeCloud("hooks@example.com")
+	defer fc.Close()
+	um.users["hooks@example.com"] = newTestThingsMCP(t, fc)
+
+	result, _ := um.handleCreateWebhook(ctx, makeReq(map[string]any{
+		"url":    "https://example.com/hook",
+		"events": "TaskCompleted, ProjectCompleted",
+	}))
+	assertNotError(t, result)
+	created := resultJSON[struct {
+		Webhook webhook `json:"webhook"`
+	}](t, result)
+	if created.Webhook.Secret == "" || len(created.Webhook.Events) != 2 {
+		t.Errorf("created: got %+v", created.Webhook)
+	}
+	if _, ok := um.oauth.storedPassword("hooks@example.com"); !ok {
+		t.Error("credentials not stored for polling")
+	}
+
+	result, _ = um.handleListWebhooks(ctx, makeReq(nil))
+	hooks := resultJSON[[]webhook](t, result)
+	if len(hooks) != 1 || hooks[0].Secret != "" || hooks[0].ID != created.Webhook.ID {
+		t.Errorf("list: got %+v", hooks)
+	}
+
+	result, _ = um.handleDeleteWebhook(ctx, makeReq(map[string]any{"id": created.Webhook.ID}))
+	assertNotError(t, result)
+	result, _ = um.handleDeleteWebhook(ctx, makeReq(map[string]any{"id": created.Webhook.ID}))
+	assertIsError(t, result)
+
+	result, _ = um.handleWebhookDeliveries(ctx, makeReq(map[string]any{"limit": 0}))
+	assertIsError(t, result)
+}